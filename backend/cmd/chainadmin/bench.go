@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/models"
+	"backend/services"
+)
+
+// powBenchResult is the outcome of mining blocks with one worker count
+type powBenchResult struct {
+	Workers  int     `json:"workers"`
+	Blocks   int     `json:"blocks"`
+	Attempts uint64  `json:"attempts"`
+	Seconds  float64 `json:"seconds"`
+	Hashrate float64 `json:"hashrate"`
+	Speedup  float64 `json:"speedup"` // hashrate relative to the first worker count
+}
+
+// benchPoW mines synthetic blocks with each requested worker count and
+// prints the hashrate and speedup of each
+func benchPoW(args []string) {
+	flags := flag.NewFlagSet("bench-pow", flag.ExitOnError)
+	difficulty := flags.Int("difficulty", 5, "leading zero hex digits required")
+	blocks := flags.Int("blocks", 5, "blocks to mine per worker count")
+	workerList := flags.String("workers", defaultWorkerList(), "comma-separated worker counts")
+	flags.Parse(args)
+
+	var counts []int
+	for _, field := range strings.Split(*workerList, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 1 {
+			log.Fatalf("invalid worker count %q", field)
+		}
+		counts = append(counts, n)
+	}
+
+	blockchainService := services.NewBlockchainService(nil, services.DefaultDifficultyConfig(), services.DefaultSubsidyConfig(), services.DefaultBlockLimits(), services.DefaultMempoolConfig(), services.DefaultNetworkConfig())
+	ctx := context.Background()
+
+	var results []powBenchResult
+	for _, workers := range counts {
+		cfg := services.DefaultPoWConfig()
+		cfg.Workers = workers
+
+		result := powBenchResult{Workers: workers, Blocks: *blocks}
+		start := time.Now()
+		for i := 0; i < *blocks; i++ {
+			block := benchBlock(int64(i), *difficulty)
+			stats, err := blockchainService.ProofOfWork(ctx, block, cfg, nil)
+			if err != nil {
+				log.Fatal("Proof of work failed:", err)
+			}
+			result.Attempts += stats.Attempts
+		}
+		result.Seconds = time.Since(start).Seconds()
+		result.Hashrate = float64(result.Attempts) / result.Seconds
+		if len(results) > 0 {
+			result.Speedup = result.Hashrate / results[0].Hashrate
+		} else {
+			result.Speedup = 1
+		}
+		results = append(results, result)
+
+		fmt.Printf("workers=%-3d blocks=%d attempts=%d time=%.2fs hashrate=%.0f H/s speedup=%.2fx\n",
+			result.Workers, result.Blocks, result.Attempts, result.Seconds, result.Hashrate, result.Speedup)
+	}
+
+	printJSON(results)
+}
+
+// benchBlock returns a block whose header differs for every i
+func benchBlock(i int64, difficulty int) *models.Block {
+	return &models.Block{
+		Version:      services.CurrentBlockVersion,
+		Index:        i + 1,
+		Timestamp:    time.Unix(1700000000+i, 0),
+		PreviousHash: strings.Repeat("0", 64),
+		MerkleRoot:   fmt.Sprintf("%064x", i),
+		Difficulty:   difficulty,
+	}
+}
+
+// defaultWorkerList doubles the worker count up to the number of CPUs
+func defaultWorkerList() string {
+	var counts []string
+	for n := 1; n < runtime.NumCPU(); n *= 2 {
+		counts = append(counts, strconv.Itoa(n))
+	}
+	counts = append(counts, strconv.Itoa(runtime.NumCPU()))
+	return strings.Join(counts, ",")
+}
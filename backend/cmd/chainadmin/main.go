@@ -0,0 +1,212 @@
+// Command chainadmin runs maintenance tasks against the chain store
+// configured for the server (CHAIN_STORE, MONGODB_URI, ...).
+//
+// Usage:
+//
+//	chainadmin validate    replay the chain and print a validation report
+//	chainadmin recover     repair blocks left half applied by a crash
+//	chainadmin reindex [-apply]
+//	                       rebuild the UTXO set and cached balances from blocks
+//	chainadmin restore-wallet -email <email>
+//	                       rebuild a user's wallet from the recovery phrase
+//	                       and optional passphrase read from stdin, one per line
+//	chainadmin new-master-key
+//	                       add a master key to KEYRING_FILE and make it current
+//	chainadmin rotate-keys rewrap every wallet key under the current master
+//	                       key; POST /api/admin/keys/rotate does this online
+//	chainadmin bench-pow   measure proof-of-work hashrate per worker count
+//	chainadmin migrate-amounts
+//	                       rewrite float amounts as fixed-point base units
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/services"
+	"backend/storage"
+
+	"github.com/joho/godotenv"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: chainadmin <command>")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  validate    replay the chain and print a validation report")
+	fmt.Fprintln(os.Stderr, "  recover     repair blocks left half applied by a crash")
+	fmt.Fprintln(os.Stderr, "  reindex [-apply]")
+	fmt.Fprintln(os.Stderr, "              rebuild the UTXO set and cached balances from blocks")
+	fmt.Fprintln(os.Stderr, "  restore-wallet -email <email>")
+	fmt.Fprintln(os.Stderr, "              rebuild a user's wallet from the recovery phrase")
+	fmt.Fprintln(os.Stderr, "              and optional passphrase read from stdin, one per line")
+	fmt.Fprintln(os.Stderr, "  new-master-key")
+	fmt.Fprintln(os.Stderr, "              add a master key to KEYRING_FILE and make it current")
+	fmt.Fprintln(os.Stderr, "  rotate-keys rewrap every wallet key under the current master")
+	fmt.Fprintln(os.Stderr, "              key; POST /api/admin/keys/rotate does this online")
+	fmt.Fprintln(os.Stderr, "  bench-pow   measure proof-of-work hashrate per worker count")
+	fmt.Fprintln(os.Stderr, "  migrate-amounts")
+	fmt.Fprintln(os.Stderr, "              rewrite float amounts as fixed-point base units")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	godotenv.Load()
+
+	// Benchmarks run in memory and need no chain store
+	if os.Args[1] == "bench-pow" {
+		benchPoW(os.Args[2:])
+		return
+	}
+	if os.Args[1] == "new-master-key" {
+		path := services.KeyConfigFromEnv().KeyringFile
+		version, err := services.AddMasterKey(path)
+		if err != nil {
+			log.Fatal("Failed to add master key:", err)
+		}
+		fmt.Printf("master key %d added to %s; run rotate-keys to move existing keys to it\n", version, path)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	store, err := config.OpenChainStore(ctx)
+	if err != nil {
+		log.Fatal("Failed to open chain store:", err)
+	}
+	defer store.Close(context.Background())
+
+	network, err := services.NetworkConfigFromEnv()
+	if err != nil {
+		log.Fatal("Invalid network configuration:", err)
+	}
+
+	blockchainService := services.NewBlockchainService(store, services.DifficultyConfigFromEnv(), services.SubsidyConfigFromEnv(), services.BlockLimitsFromEnv(), services.MempoolConfigFromEnv(), network)
+
+	switch os.Args[1] {
+	case "validate":
+		report, err := blockchainService.ValidateChain(ctx)
+		if err != nil {
+			log.Fatal("Validation failed:", err)
+		}
+		printJSON(report)
+		if !report.Valid {
+			store.Close(context.Background())
+			os.Exit(1)
+		}
+	case "recover":
+		report, err := blockchainService.RecoverChain(ctx)
+		if err != nil {
+			log.Fatal("Recovery failed:", err)
+		}
+		printJSON(report)
+	case "reindex":
+		flags := flag.NewFlagSet("reindex", flag.ExitOnError)
+		apply := flags.Bool("apply", false, "write the corrections instead of only reporting them")
+		flags.Parse(os.Args[2:])
+
+		report, err := blockchainService.ReindexUTXOs(ctx, *apply)
+		if err != nil {
+			log.Fatal("Reindex failed:", err)
+		}
+		printJSON(report)
+		if !report.Consistent() && !report.Applied {
+			store.Close(context.Background())
+			os.Exit(1)
+		}
+	case "restore-wallet":
+		flags := flag.NewFlagSet("restore-wallet", flag.ExitOnError)
+		email := flags.String("email", "", "email of the user whose wallet to restore")
+		flags.Parse(os.Args[2:])
+		if *email == "" {
+			usage()
+		}
+
+		// The phrase is read from stdin so that it stays out of the shell history
+		scanner := bufio.NewScanner(os.Stdin)
+		var lines []string
+		for len(lines) < 2 && scanner.Scan() {
+			lines = append(lines, strings.TrimSpace(scanner.Text()))
+		}
+		if len(lines) == 0 || lines[0] == "" {
+			log.Fatal("Restore failed: no recovery phrase on stdin")
+		}
+		passphrase := ""
+		if len(lines) > 1 {
+			passphrase = lines[1]
+		}
+
+		user, err := store.GetUserByEmail(ctx, *email)
+		if err != nil {
+			log.Fatal("Restore failed:", err)
+		}
+		walletService := newWalletService(store, blockchainService)
+		report, err := walletService.RestoreWallet(ctx, user.ID, lines[0], passphrase)
+		if err != nil {
+			log.Fatal("Restore failed:", err)
+		}
+		addresses, balance, err := walletService.GetAddressBalances(ctx, user.ID)
+		if err != nil {
+			log.Fatal("Restore failed:", err)
+		}
+		printJSON(map[string]interface{}{
+			"scan":      report,
+			"addresses": addresses,
+			"balance":   balance,
+		})
+	case "rotate-keys":
+		report, err := newWalletService(store, blockchainService).RotateKeys(ctx)
+		if err != nil {
+			log.Fatal("Rotation failed:", err)
+		}
+		printJSON(report)
+		if len(report.Skipped) > 0 {
+			store.Close(context.Background())
+			os.Exit(1)
+		}
+	case "migrate-amounts":
+		// The memory and file stores decode legacy floats as they load
+		mongoStore, ok := store.(*storage.MongoStore)
+		if !ok {
+			fmt.Println("chain store needs no amount migration")
+			return
+		}
+		report, err := mongoStore.MigrateAmounts(ctx)
+		if err != nil {
+			log.Fatal("Migration failed:", err)
+		}
+		printJSON(report)
+	default:
+		usage()
+	}
+}
+
+// newWalletService opens the key vault the server is configured with
+func newWalletService(store storage.ChainStore, blockchainService *services.BlockchainService) *services.WalletService {
+	keyVault, err := services.OpenKeyVault(services.KeyConfigFromEnv())
+	if err != nil {
+		log.Fatal("Failed to open key vault:", err)
+	}
+	return services.NewWalletService(store, blockchainService, keyVault, services.HDConfigFromEnv())
+}
+
+func printJSON(v interface{}) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(out))
+}
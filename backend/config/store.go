@@ -0,0 +1,32 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"backend/storage"
+)
+
+// OpenChainStore opens the storage backend selected by CHAIN_STORE.
+// Supported values are "mongo" (default), "memory" and "file".
+func OpenChainStore(ctx context.Context) (storage.ChainStore, error) {
+	switch backend := os.Getenv("CHAIN_STORE"); backend {
+	case "", "mongo":
+		db, err := ConnectDB(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return storage.NewMongoStore(db), nil
+	case "memory":
+		return storage.NewMemoryStore(), nil
+	case "file":
+		path := os.Getenv("CHAIN_STORE_PATH")
+		if path == "" {
+			path = "chain.db"
+		}
+		return storage.OpenFileStore(ctx, path)
+	default:
+		return nil, fmt.Errorf("unknown CHAIN_STORE backend %q", backend)
+	}
+}
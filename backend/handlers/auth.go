@@ -24,9 +24,13 @@ func NewAuthHandler(authService *services.AuthService, logService *services.LogS
 }
 
 type RegisterRequest struct {
-	Email    string `json:"email" binding:"required,email"`
-	FullName string `json:"fullName" binding:"required"`
-	CNIC     string `json:"cnic" binding:"required"`
+	Email         string `json:"email" binding:"required,email"`
+	FullName      string `json:"fullName" binding:"required"`
+	CNIC          string `json:"cnic" binding:"required"`
+	Mnemonic      bool   `json:"mnemonic"`                                                 // derive the wallet from a recovery phrase returned once
+	MnemonicWords int    `json:"mnemonicWords" binding:"omitempty,oneof=12 15 18 21 24"`   // phrase length, 12 by default
+	Passphrase    string `json:"passphrase"`                                               // optional, extends the phrase
+	KeyType       string `json:"keyType" binding:"omitempty,oneof=p256 secp256k1 ed25519"` // signature scheme, P-256 by default
 }
 
 type LoginRequest struct {
@@ -50,10 +54,28 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	keyType, err := services.ParseKeyType(req.KeyType)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	user, err := h.authService.Register(ctx, req.Email, req.FullName, req.CNIC)
+	mnemonic := ""
+	if req.Mnemonic {
+		words := req.MnemonicWords
+		if words == 0 {
+			words = services.DefaultMnemonicWords
+		}
+		if mnemonic, err = services.NewMnemonic(words); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create recovery phrase"})
+			return
+		}
+	}
+
+	user, err := h.authService.Register(ctx, req.Email, req.FullName, req.CNIC, mnemonic, req.Passphrase, keyType)
 	if err != nil {
 		h.logService.LogSystemEvent(ctx, "register_failed", "", "", err.Error(), c.ClientIP(), "failed")
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -62,10 +84,17 @@ func (h *AuthHandler) Register(c *gin.Context) {
 
 	h.logService.LogSystemEvent(ctx, "user_registered", user.ID.Hex(), user.WalletID, "User registered successfully", c.ClientIP(), "success")
 
-	c.JSON(http.StatusCreated, gin.H{
+	response := gin.H{
 		"message":  "Registration successful. OTP sent to email.",
 		"walletId": user.WalletID,
-	})
+		"address":  h.authService.Address(user.WalletID),
+		"keyType":  keyType.String(),
+	}
+	// The phrase is not stored, so this is the only time it is shown
+	if mnemonic != "" {
+		response["mnemonic"] = mnemonic
+	}
+	c.JSON(http.StatusCreated, response)
 }
 
 func (h *AuthHandler) Login(c *gin.Context) {
@@ -118,6 +147,7 @@ func (h *AuthHandler) VerifyOTP(c *gin.Context) {
 			"email":     user.Email,
 			"fullName":  user.FullName,
 			"walletId":  user.WalletID,
+			"address":   h.authService.Address(user.WalletID),
 			"publicKey": user.PublicKey,
 		},
 	})
@@ -134,18 +164,24 @@ func (h *AuthHandler) GetProfile(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
+	// Stored key types are always valid; empty is P-256
+	keyType, _ := services.ParseKeyType(user.KeyType)
 
 	c.JSON(http.StatusOK, gin.H{
-		"id":            user.ID.Hex(),
-		"email":         user.Email,
-		"fullName":      user.FullName,
-		"cnic":          user.CNIC,
-		"walletId":      user.WalletID,
-		"publicKey":     user.PublicKey,
-		"beneficiaries": user.Beneficiaries,
-		"zakatTracking": user.ZakatTracking,
-		"isVerified":    user.IsVerified,
-		"createdAt":     user.CreatedAt,
+		"id":                  user.ID.Hex(),
+		"email":               user.Email,
+		"fullName":            user.FullName,
+		"cnic":                user.CNIC,
+		"walletId":            user.WalletID,
+		"address":             h.authService.Address(user.WalletID),
+		"publicKey":           user.PublicKey,
+		"keyType":             keyType.String(),
+		"beneficiaries":       user.Beneficiaries,
+		"zakatTracking":       user.ZakatTracking,
+		"isVerified":          user.IsVerified,
+		"mnemonicBackup":      user.MnemonicBackup,
+		"passphraseProtected": user.PassphraseKDF != "",
+		"createdAt":           user.CreatedAt,
 	})
 }
 
@@ -2,10 +2,12 @@ package handlers
 
 import (
 	"context"
+	"encoding/hex"
 	"net/http"
 	"time"
 
 	"backend/services"
+	"backend/storage"
 
 	"github.com/gin-gonic/gin"
 )
@@ -63,3 +65,156 @@ func (h *BlockHandler) GetBlockByHash(c *gin.Context) {
 
 	c.JSON(http.StatusOK, block)
 }
+
+func (h *BlockHandler) ValidateChain(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	report, err := h.blockchainService.ValidateChain(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate chain"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// ReindexUTXOs rebuilds the UTXO set and cached balances from the chain.
+// It only reports discrepancies unless called with ?apply=true.
+func (h *BlockHandler) ReindexUTXOs(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	report, err := h.blockchainService.ReindexUTXOs(ctx, c.Query("apply") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reindex UTXOs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+func (h *BlockHandler) GetRawBlock(c *gin.Context) {
+	hash := c.Param("hash")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	block, err := h.blockchainService.GetBlockByHash(ctx, hash)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Block not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"hash":    block.Hash,
+		"version": block.Version,
+		"header":  hex.EncodeToString(services.EncodeBlockHeader(block)),
+		"raw":     hex.EncodeToString(services.EncodeBlock(block)),
+	})
+}
+
+func (h *BlockHandler) GetMerkleProof(c *gin.Context) {
+	hash := c.Param("hash")
+	txID := c.Param("txId")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	proof, err := h.blockchainService.GetMerkleProof(ctx, hash, txID)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Block not found"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, proof)
+}
+
+func (h *BlockHandler) VerifyMerkleProof(c *gin.Context) {
+	var proof services.MerkleProof
+	if err := c.ShouldBindJSON(&proof); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := h.blockchainService.VerifyMerkleProof(ctx, &proof); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"valid": false,
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"valid":     true,
+		"blockHash": proof.BlockHash,
+		"txId":      proof.TxID,
+	})
+}
+
+func (h *BlockHandler) GetForks(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stale, orphans, err := h.blockchainService.GetForkBlocks(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch fork blocks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"stale":   stale,
+		"orphans": orphans,
+	})
+}
+
+func (h *BlockHandler) SubmitBlock(c *gin.Context) {
+	var req struct {
+		Raw string `json:"raw" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	raw, err := hex.DecodeString(req.Raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid block encoding"})
+		return
+	}
+
+	block, err := services.DecodeBlock(raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := h.blockchainService.AddBlock(ctx, block); err != nil {
+		if err == services.ErrBlockKnown {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A reorganization may have moved the block onto the main chain
+	if stored, err := h.blockchainService.GetBlockByHash(ctx, block.Hash); err == nil {
+		block = stored
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"hash":   block.Hash,
+		"status": block.Status,
+	})
+}
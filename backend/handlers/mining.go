@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"time"
 
@@ -26,39 +27,110 @@ func NewMiningHandler(miningService *services.MiningService, logService *service
 func (h *MiningHandler) Mine(c *gin.Context) {
 	userID := c.MustGet("userID").(primitive.ObjectID)
 	walletID := c.MustGet("walletID").(string)
+	clientIP := c.ClientIP()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	job, err := h.miningService.StartMiningJob(walletID, func(job services.MiningJob) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		switch job.Status {
+		case services.MiningJobCompleted:
+			h.logService.LogSystemEvent(ctx, "mining_success", userID.Hex(), walletID, "Block mined: "+job.BlockHash, clientIP, "success")
+		case services.MiningJobCancelled:
+			h.logService.LogSystemEvent(ctx, "mining_cancelled", userID.Hex(), walletID, "Mining job "+job.ID+" cancelled", clientIP, "success")
+		default:
+			h.logService.LogSystemEvent(ctx, "mining_failed", userID.Hex(), walletID, job.Error, clientIP, "failed")
+		}
+	})
+	if err != nil {
+		if err == services.ErrMiningInProgress {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Mining failed: " + err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+	h.logService.LogSystemEvent(ctx, "mining_started", userID.Hex(), walletID, "Mining job "+job.ID+" started", clientIP, "success")
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Mining started",
+		"jobId":   job.ID,
+		"job":     job,
+	})
+}
+
+// ownJob looks up a mining job started by the requesting wallet
+func (h *MiningHandler) ownJob(c *gin.Context) (services.MiningJob, bool) {
+	walletID := c.MustGet("walletID").(string)
+
+	job, err := h.miningService.GetMiningJob(c.Param("id"))
+	if err != nil || job.MinerWalletID != walletID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mining job not found"})
+		return services.MiningJob{}, false
+	}
+	return job, true
+}
+
+func (h *MiningHandler) GetJob(c *gin.Context) {
+	job, ok := h.ownJob(c)
+	if !ok {
+		return
+	}
 
-	h.logService.LogSystemEvent(ctx, "mining_started", userID.Hex(), walletID, "Mining initiated", c.ClientIP(), "success")
+	c.JSON(http.StatusOK, job)
+}
+
+func (h *MiningHandler) StreamJob(c *gin.Context) {
+	job, ok := h.ownJob(c)
+	if !ok {
+		return
+	}
 
-	block, err := h.miningService.MineBlock(ctx, walletID)
+	updates, unsubscribe, err := h.miningService.SubscribeMiningJob(job.ID)
 	if err != nil {
-		h.logService.LogSystemEvent(ctx, "mining_failed", userID.Hex(), walletID, err.Error(), c.ClientIP(), "failed")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Mining failed: " + err.Error()})
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mining job not found"})
 		return
 	}
+	defer unsubscribe()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case job, ok := <-updates:
+			if !ok {
+				return false
+			}
+			if job.Done() {
+				c.SSEvent("done", job)
+				return false
+			}
+			c.SSEvent("progress", job)
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
 
-	if block == nil {
-		c.JSON(http.StatusOK, gin.H{
-			"message": "No pending transactions to mine or mining already in progress",
-		})
+func (h *MiningHandler) CancelJob(c *gin.Context) {
+	job, ok := h.ownJob(c)
+	if !ok {
 		return
 	}
 
-	h.logService.LogSystemEvent(ctx, "mining_success", userID.Hex(), walletID, "Block mined: "+block.Hash, c.ClientIP(), "success")
+	job, err := h.miningService.CancelMiningJob(job.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mining job not found"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Block mined successfully",
-		"block": gin.H{
-			"index":        block.Index,
-			"hash":         block.Hash,
-			"previousHash": block.PreviousHash,
-			"nonce":        block.Nonce,
-			"merkleRoot":   block.MerkleRoot,
-			"transactions": len(block.Transactions),
-			"timestamp":    block.Timestamp,
-		},
+		"message": "Cancellation requested",
+		"job":     job,
 	})
 }
 
@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -13,32 +15,39 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-const MinimumTransferAmount = 0.01 // Minimum amount that can be transferred
+const MinimumTransferAmount = models.Coin / 100 // Minimum amount that can be transferred
 
 type TransactionHandler struct {
 	transactionService *services.TransactionService
 	walletService      *services.WalletService
+	signingService     *services.SigningService
 	logService         *services.LogService
 }
 
-func NewTransactionHandler(transactionService *services.TransactionService, walletService *services.WalletService, logService *services.LogService) *TransactionHandler {
+func NewTransactionHandler(transactionService *services.TransactionService, walletService *services.WalletService, signingService *services.SigningService, logService *services.LogService) *TransactionHandler {
 	return &TransactionHandler{
 		transactionService: transactionService,
 		walletService:      walletService,
+		signingService:     signingService,
 		logService:         logService,
 	}
 }
 
 type SendMoneyRequest struct {
-	ReceiverWalletID string  `json:"receiverWalletId" binding:"required"`
-	Amount           float64 `json:"amount" binding:"required,gt=0"`
-	Note             string  `json:"note"`
-	Signature        string  `json:"signature" binding:"required"`
+	FromAddress      string        `json:"fromAddress"`                         // one of the sender's addresses, empty for the primary one
+	ReceiverWalletID string        `json:"receiverWalletId" binding:"required"` // address or wallet ID
+	Amount           models.Amount `json:"amount" binding:"required,gt=0"`
+	Note             string        `json:"note"`
+	Fee              models.Amount `json:"fee" binding:"gte=0"`
+	CoinSelection    string        `json:"coinSelection"` // strategy, empty for the server default
+	Timestamp        int64         `json:"timestamp"`     // unix milliseconds, as signed
+	Nonce            int64         `json:"nonce" binding:"gte=0"`
+	Signature        string        `json:"signature"`
+	Passphrase       string        `json:"passphrase"` // signing passphrase; the server signs instead of the client
 }
 
 func (h *TransactionHandler) SendMoney(c *gin.Context) {
 	userID := c.MustGet("userID").(primitive.ObjectID)
-	walletID := c.MustGet("walletID").(string)
 
 	var req SendMoneyRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -46,154 +55,313 @@ func (h *TransactionHandler) SendMoney(c *gin.Context) {
 		return
 	}
 
-	if req.Amount < MinimumTransferAmount {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": fmt.Sprintf("Minimum transfer amount is %.2f", MinimumTransferAmount),
-		})
-		return
-	}
-
-	if walletID == req.ReceiverWalletID {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot send to your own wallet"})
+	if req.Passphrase == "" && (req.Timestamp == 0 || req.Nonce == 0 || req.Signature == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Signature, timestamp and nonce are required unless the server signs with your passphrase"})
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// Validate receiver wallet exists
-	if !h.walletService.ValidateWalletExists(ctx, req.ReceiverWalletID) {
-		h.logService.LogSystemEvent(ctx, "invalid_wallet_id_attempt", userID.Hex(), walletID, "Attempted to send to invalid wallet", c.ClientIP(), "failed")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid receiver Wallet ID"})
+	walletID, ok := h.senderWallet(ctx, c, req.FromAddress)
+	if !ok {
 		return
 	}
 
-	// Get sender's wallet
-	senderWallet, err := h.walletService.GetWalletByWalletID(ctx, walletID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get wallet"})
+	receiverWalletID, ok := h.checkTransfer(ctx, c, walletID, req.ReceiverWalletID, req.Amount)
+	if !ok {
 		return
 	}
 
-	// Get available UTXOs
-	utxos, err := h.walletService.GetUTXOsForWallet(ctx, walletID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get UTXOs"})
+	// Pick inputs and outputs, then sign or apply what the client signed
+	unsigned, ok := h.buildTransfer(ctx, c, services.TransferRequest{
+		SenderWalletID:   walletID,
+		ReceiverWalletID: receiverWalletID,
+		Amount:           req.Amount,
+		Fee:              req.Fee,
+		Note:             req.Note,
+		CoinSelection:    req.CoinSelection,
+		SignedFee:        req.Passphrase == "",
+	})
+	if !ok {
 		return
 	}
-
-	var totalAvailable float64
-	for _, utxo := range utxos {
-		totalAvailable += utxo.Amount
+	tx := unsigned.Transaction
+	tx.Version = services.ReplayProtectedTransactionVersion
+	if req.Passphrase != "" {
+		if !h.signTransfer(ctx, c, tx, req.Passphrase) {
+			return
+		}
+	} else {
+		tx.Timestamp = time.UnixMilli(req.Timestamp)
+		tx.Nonce = req.Nonce
+		tx.Signature = req.Signature
+		tx.TxID = services.ComputeTxID(tx)
 	}
+	txID := tx.TxID
 
-	if totalAvailable < req.Amount {
-		h.logService.LogSystemEvent(ctx, "insufficient_balance", userID.Hex(), walletID,
-			fmt.Sprintf("Insufficient balance: has %.4f, needs %.4f", totalAvailable, req.Amount),
-			c.ClientIP(), "failed")
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": fmt.Sprintf("Insufficient balance. Available: %.4f, Required: %.4f", totalAvailable, req.Amount),
-		})
+	if err := h.transactionService.CreateTransaction(ctx, tx); err != nil {
+		h.logService.LogSystemEvent(ctx, "transaction_rejected", userID.Hex(), walletID, err.Error(), c.ClientIP(), "failed")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Select UTXOs for transaction
-	var inputUTXOs []models.UTXOInput
-	var totalInput float64
-	for _, utxo := range utxos {
-		inputUTXOs = append(inputUTXOs, models.UTXOInput{
-			TxID:        utxo.TxID,
-			OutputIndex: utxo.OutputIndex,
-			Amount:      utxo.Amount,
-		})
-		totalInput += utxo.Amount
-		if totalInput >= req.Amount {
-			break
-		}
-	}
+	h.logService.LogTransaction(ctx, txID, "sent", walletID, req.Amount, "", "pending", req.Note, c.ClientIP())
+	h.logService.LogTransaction(ctx, txID, "received", receiverWalletID, req.Amount, "", "pending", req.Note, c.ClientIP())
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":       "Transaction created successfully",
+		"transactionId": txID,
+		"status":        "pending",
+	})
+}
+
+type BuildTransactionRequest struct {
+	FromAddress      string        `json:"fromAddress"`                         // one of the sender's addresses, empty for the primary one
+	ReceiverWalletID string        `json:"receiverWalletId" binding:"required"` // address or wallet ID
+	Amount           models.Amount `json:"amount" binding:"required,gt=0"`
+	Note             string        `json:"note"`
+	Fee              models.Amount `json:"fee" binding:"gte=0"`
+	FeeRate          float64       `json:"feeRate" binding:"gte=0"` // per byte, overrides fee
+	CoinSelection    string        `json:"coinSelection"`           // strategy, empty for the server default
+}
 
-	if totalInput < req.Amount {
-		h.logService.LogSystemEvent(ctx, "insufficient_balance", userID.Hex(), walletID, "Insufficient balance for transaction", c.ClientIP(), "failed")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient balance"})
+// BuildTransaction returns an unsigned transfer from one of the
+// requester's addresses and the bytes to sign, for clients that hold their
+// own keys
+func (h *TransactionHandler) BuildTransaction(c *gin.Context) {
+	var req BuildTransactionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Calculate change
-	change := totalInput - req.Amount
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	// Create output UTXOs
-	outputUTXOs := []models.UTXOOutput{
-		{WalletID: req.ReceiverWalletID, Amount: req.Amount, Index: 0},
-	}
-	if change > 0 {
-		outputUTXOs = append(outputUTXOs, models.UTXOOutput{
-			WalletID: walletID,
-			Amount:   change,
-			Index:    1,
-		})
+	walletID, ok := h.senderWallet(ctx, c, req.FromAddress)
+	if !ok {
+		return
 	}
 
-	// Create transaction
-	timestamp := time.Now()
-	txID := h.transactionService.GenerateTxID(walletID, req.ReceiverWalletID, req.Amount, timestamp)
+	receiverWalletID, ok := h.checkTransfer(ctx, c, walletID, req.ReceiverWalletID, req.Amount)
+	if !ok {
+		return
+	}
 
-	tx := &models.Transaction{
-		ID:               primitive.NewObjectID(),
-		TxID:             txID,
+	unsigned, ok := h.buildTransfer(ctx, c, services.TransferRequest{
 		SenderWalletID:   walletID,
-		ReceiverWalletID: req.ReceiverWalletID,
+		ReceiverWalletID: receiverWalletID,
 		Amount:           req.Amount,
+		Fee:              req.Fee,
+		FeeRate:          req.FeeRate,
 		Note:             req.Note,
-		Timestamp:        timestamp,
-		SenderPublicKey:  senderWallet.PublicKey,
-		Signature:        req.Signature,
-		InputUTXOs:       inputUTXOs,
-		OutputUTXOs:      outputUTXOs,
-		Type:             "transfer",
-		Status:           "pending",
-		Fee:              0,
+		CoinSelection:    req.CoinSelection,
+	})
+	if !ok {
+		return
 	}
 
-	if err := h.transactionService.CreateTransaction(ctx, tx); err != nil {
+	c.JSON(http.StatusOK, unsigned)
+}
+
+type SubmitTransactionRequest struct {
+	Raw         string `json:"raw" binding:"required"` // hex canonical encoding
+	Signature   string `json:"signature"`              // hex, when raw is the unsigned encoding
+	FromAddress string `json:"fromAddress"`            // the sending address, empty for the primary one
+}
+
+// SubmitTransaction accepts a transfer signed offline over its canonical encoding
+func (h *TransactionHandler) SubmitTransaction(c *gin.Context) {
+	userID := c.MustGet("userID").(primitive.ObjectID)
+
+	var req SubmitTransactionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	raw, err := hex.DecodeString(req.Raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction encoding"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	walletID, ok := h.senderWallet(ctx, c, req.FromAddress)
+	if !ok {
+		return
+	}
+
+	tx, err := h.transactionService.SubmitRawTransaction(ctx, raw, req.Signature, walletID)
+	if err != nil {
 		h.logService.LogSystemEvent(ctx, "transaction_rejected", userID.Hex(), walletID, err.Error(), c.ClientIP(), "failed")
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	h.logService.LogTransaction(ctx, txID, "sent", walletID, req.Amount, "", "pending", req.Note, c.ClientIP())
-	h.logService.LogTransaction(ctx, txID, "received", req.ReceiverWalletID, req.Amount, "", "pending", req.Note, c.ClientIP())
+	h.logService.LogTransaction(ctx, tx.TxID, "sent", walletID, tx.Amount, "", "pending", tx.Note, c.ClientIP())
+	h.logService.LogTransaction(ctx, tx.TxID, "received", tx.ReceiverWalletID, tx.Amount, "", "pending", tx.Note, c.ClientIP())
 
 	c.JSON(http.StatusCreated, gin.H{
-		"message":       "Transaction created successfully",
-		"transactionId": txID,
+		"message":       "Transaction submitted successfully",
+		"transactionId": tx.TxID,
 		"status":        "pending",
 	})
 }
 
+// signTransfer signs a transfer with the sender's key, unlocked by the
+// user's signing passphrase, writing an error response when it cannot
+func (h *TransactionHandler) signTransfer(ctx context.Context, c *gin.Context, tx *models.Transaction, passphrase string) bool {
+	userID := c.MustGet("userID").(primitive.ObjectID)
+
+	info, err := h.transactionService.GetSigningInfo(ctx, tx.SenderWalletID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get signing info"})
+		return false
+	}
+	tx.Timestamp = time.UnixMilli(info.ServerTime)
+	tx.Nonce = info.NextNonce
+
+	if err := h.signingService.SignTransaction(ctx, userID, tx, passphrase); err != nil {
+		switch {
+		case errors.Is(err, services.ErrWrongPassphrase):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Wrong signing passphrase"})
+		case errors.Is(err, services.ErrNoPassphrase):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign transaction"})
+		}
+		return false
+	}
+	return true
+}
+
+// senderWallet resolves the address a user sends from, which must be one
+// of theirs, writing an error response when it is not. An empty address
+// stands for the user's primary wallet.
+func (h *TransactionHandler) senderWallet(ctx context.Context, c *gin.Context, fromAddress string) (string, bool) {
+	if fromAddress == "" {
+		return c.MustGet("walletID").(string), true
+	}
+
+	// The sender must be the user's own wallet, so an ID they still have
+	// from before addresses is safe to accept
+	walletID, err := h.walletService.ResolveLegacyAddress(fromAddress)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sender address: " + err.Error()})
+		return "", false
+	}
+
+	userID := c.MustGet("userID").(primitive.ObjectID)
+	if !h.walletService.OwnsWallet(ctx, userID, walletID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Sender address does not belong to you"})
+		return "", false
+	}
+	return walletID, true
+}
+
+// checkTransfer applies the transfer rules that do not depend on funds and
+// resolves the receiver's address to its wallet ID, writing an error
+// response when a rule is broken
+func (h *TransactionHandler) checkTransfer(ctx context.Context, c *gin.Context, walletID, receiver string, amount models.Amount) (string, bool) {
+	if amount < MinimumTransferAmount {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Minimum transfer amount is %s", MinimumTransferAmount),
+		})
+		return "", false
+	}
+
+	receiverWalletID, err := h.walletService.ResolveAddress(receiver)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid receiver address: " + err.Error()})
+		return "", false
+	}
+
+	if walletID == receiverWalletID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot send to your own wallet"})
+		return "", false
+	}
+
+	// Validate receiver wallet exists
+	if !h.walletService.ValidateWalletExists(ctx, receiverWalletID) {
+		userID := c.MustGet("userID").(primitive.ObjectID)
+		h.logService.LogSystemEvent(ctx, "invalid_wallet_id_attempt", userID.Hex(), walletID, "Attempted to send to invalid wallet", c.ClientIP(), "failed")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid receiver Wallet ID"})
+		return "", false
+	}
+
+	return receiverWalletID, true
+}
+
+// buildTransfer builds an unsigned transfer, writing an error response
+// when the sender cannot fund it
+func (h *TransactionHandler) buildTransfer(ctx context.Context, c *gin.Context, req services.TransferRequest) (*services.UnsignedTransaction, bool) {
+	unsigned, err := h.transactionService.BuildTransaction(ctx, req)
+	if err != nil {
+		var funds *services.InsufficientFundsError
+		if errors.As(err, &funds) {
+			userID := c.MustGet("userID").(primitive.ObjectID)
+			h.logService.LogSystemEvent(ctx, "insufficient_balance", userID.Hex(), req.SenderWalletID,
+				fmt.Sprintf("Insufficient balance: has %s, needs %s", funds.Available, funds.Required),
+				c.ClientIP(), "failed")
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Insufficient balance. Available: %s, Required: %s", funds.Available, funds.Required),
+			})
+			return nil, false
+		}
+		if errors.Is(err, services.ErrUnknownCoinSelection) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build transaction"})
+		return nil, false
+	}
+	return unsigned, true
+}
+
+// GetHistory returns the transactions of every address of the user
 func (h *TransactionHandler) GetHistory(c *gin.Context) {
-	walletID := c.MustGet("walletID").(string)
+	userID := c.MustGet("userID").(primitive.ObjectID)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	transactions, err := h.transactionService.GetTransactionHistory(ctx, walletID, 100)
+	walletIDs, err := h.walletService.GetWalletIDs(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transaction history"})
+		return
+	}
+	owned := make(map[string]bool)
+	for _, walletID := range walletIDs {
+		owned[walletID] = true
+	}
+
+	transactions, err := h.transactionService.GetHistoryForWallets(ctx, walletIDs, 100)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transaction history"})
 		return
 	}
 
-	// Add direction info
+	// Add direction info; transfers between the user's own addresses are internal
 	var history []gin.H
 	for _, tx := range transactions {
 		direction := "received"
-		if tx.SenderWalletID == walletID {
+		if owned[tx.SenderWalletID] {
 			direction = "sent"
+			if owned[tx.ReceiverWalletID] {
+				direction = "internal"
+			}
 		}
 		history = append(history, gin.H{
 			"id":               tx.ID,
 			"txId":             tx.TxID,
 			"senderWalletId":   tx.SenderWalletID,
 			"receiverWalletId": tx.ReceiverWalletID,
+			"senderAddress":    h.walletService.Address(tx.SenderWalletID),
+			"receiverAddress":  h.walletService.Address(tx.ReceiverWalletID),
 			"amount":           tx.Amount,
 			"note":             tx.Note,
 			"timestamp":        tx.Timestamp,
@@ -225,7 +393,36 @@ func (h *TransactionHandler) GetPending(c *gin.Context) {
 	})
 }
 
-// Helper to create signed payload
-func CreateSignedPayload(senderID, receiverID string, amount float64, timestamp time.Time, note string) string {
-	return fmt.Sprintf("%s%s%.8f%s%s", senderID, receiverID, amount, timestamp.Format(time.RFC3339), note)
+func (h *TransactionHandler) EstimateFees(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	estimate, err := h.transactionService.EstimateFees(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to estimate fees"})
+		return
+	}
+
+	c.JSON(http.StatusOK, estimate)
+}
+
+// GetSigningInfo returns what a client needs to sign a transfer from the
+// address in the from query parameter, which must be one of the user's,
+// or from their primary wallet
+func (h *TransactionHandler) GetSigningInfo(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	walletID, ok := h.senderWallet(ctx, c, c.Query("from"))
+	if !ok {
+		return
+	}
+
+	info, err := h.transactionService.GetSigningInfo(ctx, walletID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get signing info"})
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
 }
@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -12,14 +14,16 @@ import (
 )
 
 type WalletHandler struct {
-	walletService *services.WalletService
-	logService    *services.LogService
+	walletService  *services.WalletService
+	signingService *services.SigningService
+	logService     *services.LogService
 }
 
-func NewWalletHandler(walletService *services.WalletService, logService *services.LogService) *WalletHandler {
+func NewWalletHandler(walletService *services.WalletService, signingService *services.SigningService, logService *services.LogService) *WalletHandler {
 	return &WalletHandler{
-		walletService: walletService,
-		logService:    logService,
+		walletService:  walletService,
+		signingService: signingService,
+		logService:     logService,
 	}
 }
 
@@ -38,12 +42,113 @@ func (h *WalletHandler) GetWallet(c *gin.Context) {
 	c.JSON(http.StatusOK, wallet)
 }
 
+// GetBalance returns the balance of every address of the user and their total
 func (h *WalletHandler) GetBalance(c *gin.Context) {
+	userID := c.MustGet("userID").(primitive.ObjectID)
+	walletID := c.MustGet("walletID").(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addresses, balance, err := h.walletService.GetAddressBalances(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate balance"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"walletId":  walletID,
+		"address":   h.walletService.Address(walletID),
+		"balance":   balance,
+		"addresses": addresses,
+	})
+}
+
+// GetAddresses lists the user's receive addresses, the primary one first
+func (h *WalletHandler) GetAddresses(c *gin.Context) {
+	userID := c.MustGet("userID").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addresses, _, err := h.walletService.GetAddressBalances(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch addresses"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"addresses": addresses,
+		"count":     len(addresses),
+	})
+}
+
+// NewAddress derives the user's next receive address
+func (h *WalletHandler) NewAddress(c *gin.Context) {
+	userID := c.MustGet("userID").(primitive.ObjectID)
 	walletID := c.MustGet("walletID").(string)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	wallet, err := h.walletService.NewAddress(ctx, userID)
+	if err != nil {
+		h.logService.LogSystemEvent(ctx, "address_derivation", userID.Hex(), walletID, err.Error(), c.ClientIP(), "failed")
+		if errors.Is(err, services.ErrSigningLocked) {
+			// Ed25519 addresses derive from the seed, which the passphrase locks
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Ed25519 wallets cannot derive addresses while locked by a signing passphrase"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to derive address"})
+		return
+	}
+
+	h.logService.LogSystemEvent(ctx, "address_derivation", userID.Hex(), wallet.WalletID, "Derived address "+wallet.DerivationPath, c.ClientIP(), "success")
+	c.JSON(http.StatusCreated, wallet)
+}
+
+// ScanAddresses looks past the user's addresses for ones that have
+// received funds
+func (h *WalletHandler) ScanAddresses(c *gin.Context) {
+	userID := c.MustGet("userID").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	report, err := h.walletService.ScanAddresses(ctx, userID)
+	if err != nil {
+		if errors.Is(err, services.ErrNoSeed) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Wallet has no seed to scan; derive an address first"})
+			return
+		}
+		if errors.Is(err, services.ErrSigningLocked) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Ed25519 wallets cannot scan addresses while locked by a signing passphrase"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan addresses"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// LookupAddress validates an address or wallet ID for the explorer and
+// returns the wallet it stands for
+func (h *WalletHandler) LookupAddress(c *gin.Context) {
+	walletID, err := h.walletService.ResolveLegacyAddress(c.Param("address"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if !h.walletService.ValidateWalletExists(ctx, walletID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Wallet not found"})
+		return
+	}
+
 	balance, err := h.walletService.CalculateBalance(ctx, walletID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate balance"})
@@ -51,18 +156,122 @@ func (h *WalletHandler) GetBalance(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
+		"address":  h.walletService.Address(walletID),
 		"walletId": walletID,
 		"balance":  balance,
 	})
 }
 
+// GetUTXOs returns the unspent outputs of every address of the user
+type RestoreWalletRequest struct {
+	Mnemonic   string `json:"mnemonic" binding:"required"`
+	Passphrase string `json:"passphrase"`
+}
+
+// RestoreWallet rebuilds the user's keys and addresses from their recovery
+// phrase and rescans the chain for their funds
+func (h *WalletHandler) RestoreWallet(c *gin.Context) {
+	userID := c.MustGet("userID").(primitive.ObjectID)
+	walletID := c.MustGet("walletID").(string)
+
+	var req RestoreWalletRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	report, err := h.walletService.RestoreWallet(ctx, userID, req.Mnemonic, req.Passphrase)
+	if err != nil {
+		h.logService.LogSystemEvent(ctx, "wallet_restore", userID.Hex(), walletID, err.Error(), c.ClientIP(), "failed")
+		if errors.Is(err, services.ErrInvalidMnemonic) || errors.Is(err, services.ErrMnemonicChecksum) || errors.Is(err, services.ErrMnemonicMismatch) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore wallet"})
+		return
+	}
+
+	addresses, balance, err := h.walletService.GetAddressBalances(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate balance"})
+		return
+	}
+
+	h.logService.LogSystemEvent(ctx, "wallet_restore", userID.Hex(), walletID, fmt.Sprintf("Restored %d addresses", len(addresses)), c.ClientIP(), "success")
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Wallet restored",
+		"scan":      report,
+		"addresses": addresses,
+		"balance":   balance,
+	})
+}
+
+type SetPassphraseRequest struct {
+	CurrentPassphrase string `json:"currentPassphrase"` // required when changing a passphrase
+	Passphrase        string `json:"passphrase" binding:"required"`
+}
+
+// SetPassphrase locks the user's keys with a signing passphrase, which the
+// server then needs to sign transfers for them
+func (h *WalletHandler) SetPassphrase(c *gin.Context) {
+	userID := c.MustGet("userID").(primitive.ObjectID)
+	walletID := c.MustGet("walletID").(string)
+
+	var req SetPassphraseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := h.signingService.SetPassphrase(ctx, userID, req.CurrentPassphrase, req.Passphrase); err != nil {
+		h.logService.LogSystemEvent(ctx, "signing_passphrase", userID.Hex(), walletID, err.Error(), c.ClientIP(), "failed")
+		switch {
+		case errors.Is(err, services.ErrWrongPassphrase):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Current signing passphrase is wrong"})
+		case errors.Is(err, services.ErrWeakPassphrase):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set signing passphrase"})
+		}
+		return
+	}
+
+	h.logService.LogSystemEvent(ctx, "signing_passphrase", userID.Hex(), walletID, "Signing passphrase set", c.ClientIP(), "success")
+	c.JSON(http.StatusOK, gin.H{"message": "Signing passphrase set"})
+}
+
+// RotateKeys moves every stored wallet key to the current master key
+func (h *WalletHandler) RotateKeys(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	report, err := h.walletService.RotateKeys(ctx)
+	if err != nil {
+		h.logService.LogSystemEvent(ctx, "key_rotation", "", "", err.Error(), c.ClientIP(), "failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate keys"})
+		return
+	}
+
+	h.logService.LogSystemEvent(ctx, "key_rotation", "", "",
+		fmt.Sprintf("Rewrapped %d keys under master key %d, %d users skipped", report.Rewrapped, report.KeyVersion, len(report.Skipped)),
+		c.ClientIP(), "success")
+	c.JSON(http.StatusOK, report)
+}
+
 func (h *WalletHandler) GetUTXOs(c *gin.Context) {
+	userID := c.MustGet("userID").(primitive.ObjectID)
 	walletID := c.MustGet("walletID").(string)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	utxos, err := h.walletService.GetUTXOsForWallet(ctx, walletID)
+	utxos, err := h.walletService.GetUTXOsForUser(ctx, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch UTXOs"})
 		return
@@ -75,7 +284,7 @@ func (h *WalletHandler) GetUTXOs(c *gin.Context) {
 }
 
 type AddBeneficiaryRequest struct {
-	WalletID string `json:"walletId" binding:"required"`
+	WalletID string `json:"walletId" binding:"required"` // address or wallet ID
 	Name     string `json:"name" binding:"required"`
 }
 
@@ -92,8 +301,14 @@ func (h *WalletHandler) AddBeneficiary(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	beneficiaryWalletID, err := h.walletService.ResolveAddress(req.WalletID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid address: " + err.Error()})
+		return
+	}
+
 	// Validate beneficiary wallet exists
-	if !h.walletService.ValidateWalletExists(ctx, req.WalletID) {
+	if !h.walletService.ValidateWalletExists(ctx, beneficiaryWalletID) {
 		h.logService.LogSystemEvent(ctx, "invalid_wallet_id_attempt", userID.Hex(), walletID, "Attempted to add invalid wallet as beneficiary", c.ClientIP(), "failed")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Wallet ID"})
 		return
@@ -23,30 +23,66 @@ func main() {
 		log.Println("No .env file found, using system environment variables")
 	}
 
-	// Connect to MongoDB
+	// Open chain storage (MongoDB by default)
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	db, err := config.ConnectDB(ctx)
+	store, err := config.OpenChainStore(ctx)
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		log.Fatal("Failed to open chain store:", err)
+	}
+	defer store.Close(context.Background())
+
+	// Open the master keys wallet keys are encrypted under
+	keyVault, err := services.OpenKeyVault(services.KeyConfigFromEnv())
+	if err != nil {
+		log.Fatal("Failed to open key vault:", err)
+	}
+
+	// Identify the network, refusing to guess one outside a dev build
+	network, err := services.NetworkConfigFromEnv()
+	if err != nil {
+		log.Fatal("Invalid network configuration:", err)
 	}
-	defer config.DisconnectDB(ctx)
 
 	// Initialize services
-	blockchainService := services.NewBlockchainService(db)
-	walletService := services.NewWalletService(db)
-	transactionService := services.NewTransactionService(db, blockchainService)
-	miningService := services.NewMiningService(db, blockchainService, transactionService)
-	authService := services.NewAuthService(db, walletService)
-	zakatService := services.NewZakatService(db, transactionService, blockchainService)
-	logService := services.NewLogService(db)
+	blockchainService := services.NewBlockchainService(store, services.DifficultyConfigFromEnv(), services.SubsidyConfigFromEnv(), services.BlockLimitsFromEnv(), services.MempoolConfigFromEnv(), network)
+	walletService := services.NewWalletService(store, blockchainService, keyVault, services.HDConfigFromEnv())
+	transactionService := services.NewTransactionService(store, blockchainService, services.CoinSelectionConfigFromEnv())
+	miningService := services.NewMiningService(store, blockchainService, transactionService, services.PoWConfigFromEnv())
+	faucetService := services.NewFaucetService(store, transactionService, blockchainService, services.FaucetKeyFromEnv())
+	signingService := services.NewSigningService(store, walletService)
+	authService := services.NewAuthService(store, walletService, faucetService)
+	zakatService := services.NewZakatService(store, transactionService, blockchainService)
+	logService := services.NewLogService(store)
 
 	// Initialize genesis block if blockchain is empty
 	if err := blockchainService.InitializeGenesisBlock(ctx); err != nil {
 		log.Println("Genesis block initialization:", err)
 	}
 
+	// Record cumulative work on blocks stored before fork tracking
+	if err := blockchainService.BackfillChainWork(ctx); err != nil {
+		log.Println("Chain work backfill:", err)
+	}
+
+	// Repair blocks a crash left half applied to the UTXO set
+	if report, err := blockchainService.RecoverChain(ctx); err != nil {
+		log.Println("Chain recovery:", err)
+	} else {
+		for _, block := range report.Blocks {
+			log.Printf("Chain recovery: %s of block %d %s", block.Op, block.Index, block.Action)
+		}
+		if !report.Atomic {
+			log.Println("Chain store does not support transactions; blocks will be recovered on restart if interrupted")
+		}
+	}
+
+	// Load pending transactions into the mempool
+	if err := blockchainService.RebuildMempool(ctx); err != nil {
+		log.Println("Mempool rebuild:", err)
+	}
+
 	// Setup Zakat scheduler (runs monthly on the 1st at midnight)
 	c := cron.New()
 	c.AddFunc("0 0 1 * *", func() {
@@ -59,8 +95,8 @@ func main() {
 
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(authService, logService)
-	walletHandler := handlers.NewWalletHandler(walletService, logService)
-	transactionHandler := handlers.NewTransactionHandler(transactionService, walletService, logService)
+	walletHandler := handlers.NewWalletHandler(walletService, signingService, logService)
+	transactionHandler := handlers.NewTransactionHandler(transactionService, walletService, signingService, logService)
 	miningHandler := handlers.NewMiningHandler(miningService, logService)
 	blockHandler := handlers.NewBlockHandler(blockchainService)
 	zakatHandler := handlers.NewZakatHandler(zakatService)
@@ -73,7 +109,7 @@ func main() {
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:5173", "http://localhost:3000"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-Admin-Token", "X-Peer-Token"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
@@ -99,6 +135,11 @@ func main() {
 			wallet.GET("", walletHandler.GetWallet)
 			wallet.GET("/balance", walletHandler.GetBalance)
 			wallet.GET("/utxos", walletHandler.GetUTXOs)
+			wallet.GET("/addresses", walletHandler.GetAddresses)
+			wallet.POST("/addresses", walletHandler.NewAddress)
+			wallet.POST("/addresses/scan", walletHandler.ScanAddresses)
+			wallet.POST("/restore", walletHandler.RestoreWallet)
+			wallet.POST("/passphrase", walletHandler.SetPassphrase)
 			wallet.POST("/beneficiaries", walletHandler.AddBeneficiary)
 			wallet.DELETE("/beneficiaries/:id", walletHandler.RemoveBeneficiary)
 			wallet.GET("/beneficiaries", walletHandler.GetBeneficiaries)
@@ -109,8 +150,12 @@ func main() {
 		transactions.Use(middleware.AuthMiddleware())
 		{
 			transactions.POST("/send", transactionHandler.SendMoney)
+			transactions.POST("/build", transactionHandler.BuildTransaction)
+			transactions.POST("/submit", transactionHandler.SubmitTransaction)
 			transactions.GET("/history", transactionHandler.GetHistory)
 			transactions.GET("/pending", transactionHandler.GetPending)
+			transactions.GET("/fee-estimate", transactionHandler.EstimateFees)
+			transactions.GET("/signing-info", transactionHandler.GetSigningInfo)
 		}
 
 		// Mining routes (protected)
@@ -119,16 +164,27 @@ func main() {
 		{
 			mining.POST("/mine", miningHandler.Mine)
 			mining.GET("/status", miningHandler.GetStatus)
+			mining.GET("/jobs/:id", miningHandler.GetJob)
+			mining.GET("/jobs/:id/stream", miningHandler.StreamJob)
+			mining.DELETE("/jobs/:id", miningHandler.CancelJob)
 		}
 
-		// Block explorer routes (public)
+		// Block explorer routes (public; submission is for peers only)
 		blocks := api.Group("/blocks")
 		{
 			blocks.GET("", blockHandler.GetAllBlocks)
 			blocks.GET("/latest", blockHandler.GetLatestBlock)
+			blocks.GET("/forks", blockHandler.GetForks)
+			blocks.POST("", middleware.PeerMiddleware(), blockHandler.SubmitBlock)
 			blocks.GET("/:hash", blockHandler.GetBlockByHash)
+			blocks.GET("/:hash/raw", blockHandler.GetRawBlock)
+			blocks.GET("/:hash/proof/:txId", blockHandler.GetMerkleProof)
+			blocks.POST("/verify-proof", blockHandler.VerifyMerkleProof)
 		}
 
+		// Address lookup for the explorer (public)
+		api.GET("/address/:address", walletHandler.LookupAddress)
+
 		// Zakat routes (protected)
 		zakat := api.Group("/zakat")
 		zakat.Use(middleware.AuthMiddleware())
@@ -137,6 +193,15 @@ func main() {
 			zakat.POST("/process", zakatHandler.ProcessZakat)
 		}
 
+		// Admin routes (ADMIN_TOKEN)
+		admin := api.Group("/admin")
+		admin.Use(middleware.AdminMiddleware())
+		{
+			admin.GET("/validate", blockHandler.ValidateChain)
+			admin.POST("/reindex", blockHandler.ReindexUTXOs)
+			admin.POST("/keys/rotate", walletHandler.RotateKeys)
+		}
+
 		// Log routes (protected)
 		logs := api.Group("/logs")
 		logs.Use(middleware.AuthMiddleware())
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminMiddleware admits requests carrying the ADMIN_TOKEN in the
+// X-Admin-Token header. Admin routes are disabled when it is unset.
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		token := os.Getenv("ADMIN_TOKEN")
+		if token == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin routes are disabled"})
+			c.Abort()
+			return
+		}
+
+		given := c.GetHeader("X-Admin-Token")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin token"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
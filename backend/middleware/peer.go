@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PeerMiddleware admits requests from peer nodes, which carry the
+// PEER_TOKEN in the X-Peer-Token header. Peer routes are disabled when it
+// is unset.
+func PeerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		token := os.Getenv("PEER_TOKEN")
+		if token == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Block submission is disabled"})
+			c.Abort()
+			return
+		}
+
+		given := c.GetHeader("X-Peer-Token")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid peer token"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// Amount is a quantity of coins in base units. A coin is 10^8 base units,
+// so amounts add, subtract and compare exactly.
+//
+// Amounts are stored in BSON as int64 and written in JSON as decimal coin
+// numbers such as 1.50000000. Documents written before amounts were fixed
+// point hold doubles; those are rounded to the nearest base unit on load.
+type Amount int64
+
+// AmountDecimals is the number of decimal places in a coin
+const AmountDecimals = 8
+
+// Coin is one coin in base units
+const Coin Amount = 100000000
+
+// MaxAmount is the largest representable amount
+const MaxAmount Amount = math.MaxInt64
+
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// AmountFromFloat rounds a coin value to the nearest base unit
+func AmountFromFloat(coins float64) Amount {
+	return Amount(math.Round(coins * float64(Coin)))
+}
+
+// ParseAmount parses a decimal coin value such as "12.5" exactly. Values
+// with more than AmountDecimals decimal places are rejected.
+func ParseAmount(s string) (Amount, error) {
+	value, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return 0, ErrInvalidAmount
+	}
+	value.Mul(value, new(big.Rat).SetInt64(int64(Coin)))
+	if !value.IsInt() {
+		return 0, fmt.Errorf("%w: more than %d decimal places", ErrInvalidAmount, AmountDecimals)
+	}
+	if !value.Num().IsInt64() {
+		return 0, fmt.Errorf("%w: out of range", ErrInvalidAmount)
+	}
+	return Amount(value.Num().Int64()), nil
+}
+
+// Float64 returns the amount in coins. It is for display and fee rate
+// arithmetic only; sums of amounts must stay in base units.
+func (a Amount) Float64() float64 {
+	return float64(a) / float64(Coin)
+}
+
+// String formats the amount in coins with all decimal places
+func (a Amount) String() string {
+	sign := ""
+	units := uint64(a)
+	if a < 0 {
+		sign = "-"
+		units = -units
+	}
+	return fmt.Sprintf("%s%d.%08d", sign, units/uint64(Coin), units%uint64(Coin))
+}
+
+// MulFrac returns a*num/den rounded down, without overflowing on the way
+func (a Amount) MulFrac(num, den int64) Amount {
+	product := new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(num))
+	return Amount(product.Div(product, big.NewInt(den)).Int64())
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a quoted decimal string
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	parsed, err := ParseAmount(strings.Trim(s, `"`))
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+func (a Amount) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bson.TypeInt64, bsoncore.AppendInt64(nil, int64(a)), nil
+}
+
+// UnmarshalBSONValue reads an int64 amount, or a legacy double in coins
+func (a *Amount) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	value := bsoncore.Value{Type: t, Data: data}
+	switch t {
+	case bson.TypeInt64:
+		*a = Amount(value.Int64())
+	case bson.TypeInt32:
+		*a = Amount(value.Int32())
+	case bson.TypeDouble:
+		*a = AmountFromFloat(value.Double())
+	case bson.TypeNull:
+		*a = 0
+	default:
+		return fmt.Errorf("cannot decode %s into an amount", t)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+		err  bool
+	}{
+		{in: "1", want: Coin},
+		{in: "12.5", want: 12*Coin + Coin/2},
+		{in: " 0.00000001 ", want: 1},
+		{in: "0.1", want: Coin / 10},
+		{in: "-1.5", want: -(Coin + Coin/2)},
+		{in: "92233720368.54775807", want: MaxAmount},
+		{in: "0.000000001", err: true},
+		{in: "1.123456789", err: true},
+		{in: "92233720368.54775808", err: true},
+		{in: "one", err: true},
+		{in: "", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmount(tt.in)
+		if tt.err {
+			if !errors.Is(err, ErrInvalidAmount) {
+				t.Errorf("ParseAmount(%q) = %v, %v; want ErrInvalidAmount", tt.in, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseAmount(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestAmountFromFloat(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want Amount
+	}{
+		{0.1, Coin / 10},
+		{0.1 + 0.2, 3 * Coin / 10},
+		{2.675, 267500000},
+		{0.000000014, 1},
+		{0.000000016, 2},
+		{-0.1, -Coin / 10},
+	}
+	for _, tt := range tests {
+		if got := AmountFromFloat(tt.in); got != tt.want {
+			t.Errorf("AmountFromFloat(%v) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	tests := []struct {
+		in   Amount
+		want string
+	}{
+		{0, "0.00000000"},
+		{1, "0.00000001"},
+		{Coin + Coin/2, "1.50000000"},
+		{-1, "-0.00000001"},
+		{-(Coin + Coin/2), "-1.50000000"},
+		{MaxAmount, "92233720368.54775807"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Amount(%d).String() = %q, want %q", int64(tt.in), got, tt.want)
+		}
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	type body struct {
+		Amount Amount `json:"amount"`
+	}
+	for _, in := range []string{`{"amount":1.5}`, `{"amount":"1.5"}`, `{"amount":1.50000000}`} {
+		var got body
+		if err := json.Unmarshal([]byte(in), &got); err != nil || got.Amount != Coin+Coin/2 {
+			t.Errorf("Unmarshal(%s) = %v, %v; want 1.5 coins", in, got.Amount, err)
+		}
+	}
+	for _, in := range []string{`{"amount":1.123456789}`, `{"amount":"abc"}`} {
+		var got body
+		if err := json.Unmarshal([]byte(in), &got); err == nil {
+			t.Errorf("Unmarshal(%s) = %v, want an error", in, got.Amount)
+		}
+	}
+
+	kept := body{Amount: 7}
+	if err := json.Unmarshal([]byte(`{"amount":null}`), &kept); err != nil || kept.Amount != 7 {
+		t.Errorf("null amount = %v, %v; want the value kept", kept.Amount, err)
+	}
+
+	out, err := json.Marshal(body{Amount: -(Coin + 1)})
+	if err != nil || string(out) != `{"amount":-1.00000001}` {
+		t.Errorf("Marshal = %s, %v", out, err)
+	}
+}
+
+func TestAmountBSON(t *testing.T) {
+	type doc struct {
+		Amount Amount `bson:"amount"`
+	}
+	tests := []struct {
+		name string
+		in   bson.M
+		want Amount
+	}{
+		{"int64", bson.M{"amount": int64(Coin)}, Coin},
+		{"int32", bson.M{"amount": int32(5)}, 5},
+		{"null", bson.M{"amount": nil}, 0},
+		{"legacy double", bson.M{"amount": 0.1}, Coin / 10},
+		{"legacy double rounded to a base unit", bson.M{"amount": 1.000000014}, Coin + 1},
+		{"negative legacy double", bson.M{"amount": -2.5}, -(2*Coin + Coin/2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := bson.Marshal(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got doc
+			if err := bson.Unmarshal(raw, &got); err != nil || got.Amount != tt.want {
+				t.Fatalf("decoded %v, %v; want %v", got.Amount, err, tt.want)
+			}
+		})
+	}
+
+	raw, err := bson.Marshal(bson.M{"amount": "1.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bad doc
+	if err := bson.Unmarshal(raw, &bad); err == nil {
+		t.Fatal("decoded a string amount")
+	}
+
+	raw, err = bson.Marshal(doc{Amount: -Coin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := bson.Raw(raw).Lookup("amount"); value.Type != bson.TypeInt64 || value.Int64() != -int64(Coin) {
+		t.Fatalf("encoded %v, want int64 base units", value)
+	}
+}
+
+func TestTransactionLegacyAmounts(t *testing.T) {
+	legacy := func(amount float64) []byte {
+		raw, err := bson.Marshal(bson.M{
+			"tx_id":        "legacy",
+			"amount":       amount,
+			"fee":          0.0,
+			"input_utxos":  bson.A{bson.M{"tx_id": "funding", "output_index": 0, "amount": 1.0}},
+			"output_utxos": bson.A{bson.M{"wallet_id": "bob", "index": 0, "amount": amount}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+
+	// Amounts that base units hold exactly need no legacy copy
+	var exact Transaction
+	if err := bson.Unmarshal(legacy(0.5), &exact); err != nil {
+		t.Fatal(err)
+	}
+	if exact.Amount != Coin/2 || exact.LegacyAmounts != nil {
+		t.Fatalf("amount = %v, legacy = %v; want 0.5 coins and no legacy amounts", exact.Amount, exact.LegacyAmounts)
+	}
+
+	var lossy Transaction
+	if err := bson.Unmarshal(legacy(0.123456789), &lossy); err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{0.123456789, 0, 1, 0.123456789}
+	if lossy.Amount != 12345679 || len(lossy.LegacyAmounts) != len(want) {
+		t.Fatalf("amount = %d, legacy = %v; want 12345679 and %v", lossy.Amount, lossy.LegacyAmounts, want)
+	}
+	for i := range want {
+		if lossy.LegacyAmounts[i] != want[i] {
+			t.Fatalf("legacy = %v, want %v", lossy.LegacyAmounts, want)
+		}
+	}
+}
@@ -6,8 +6,25 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Block statuses. Blocks stored before fork tracking have no status
+// and are treated as part of the main chain.
+const (
+	BlockStatusMain    = "main"
+	BlockStatusStale   = "stale"   // valid block on a side branch
+	BlockStatusOrphan  = "orphan"  // parent not yet known
+	BlockStatusInvalid = "invalid" // failed to connect
+)
+
+// Block operations recorded while a block is being applied to the UTXO
+// set, so that one interrupted part way can be finished on restart
+const (
+	BlockOpConnect    = "connect"
+	BlockOpDisconnect = "disconnect"
+)
+
 type Block struct {
 	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Version      int                `bson:"version" json:"version"`
 	Index        int64              `bson:"index" json:"index"`
 	Timestamp    time.Time          `bson:"timestamp" json:"timestamp"`
 	Transactions []Transaction      `bson:"transactions" json:"transactions"`
@@ -17,5 +34,8 @@ type Block struct {
 	MerkleRoot   string             `bson:"merkle_root" json:"merkleRoot"`
 	Difficulty   int                `bson:"difficulty" json:"difficulty"`
 	Miner        string             `bson:"miner" json:"miner"`
+	ChainWork    string             `bson:"chain_work" json:"chainWork"` // hex cumulative work up to this block
+	Status       string             `bson:"status" json:"status"`         // main, stale, orphan, invalid
+	PendingOp    string             `bson:"pending_op,omitempty" json:"pendingOp,omitempty"` // connect or disconnect, until it completes
 	CreatedAt    time.Time          `bson:"created_at" json:"createdAt"`
 }
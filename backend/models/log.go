@@ -22,7 +22,7 @@ type TransactionLog struct {
 	TxID      string             `bson:"tx_id" json:"txId"`
 	Action    string             `bson:"action" json:"action"` // sent, received, mined, zakat_deducted
 	WalletID  string             `bson:"wallet_id" json:"walletId"`
-	Amount    float64            `bson:"amount" json:"amount"`
+	Amount    Amount             `bson:"amount" json:"amount"`
 	BlockHash string             `bson:"block_hash" json:"blockHash"`
 	Status    string             `bson:"status" json:"status"`
 	Note      string             `bson:"note" json:"note"`
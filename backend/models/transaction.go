@@ -3,15 +3,17 @@ package models
 import (
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Transaction struct {
 	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Version         int                `bson:"version" json:"version"`
 	TxID            string             `bson:"tx_id" json:"txId"`
 	SenderWalletID  string             `bson:"sender_wallet_id" json:"senderWalletId"`
 	ReceiverWalletID string            `bson:"receiver_wallet_id" json:"receiverWalletId"`
-	Amount          float64            `bson:"amount" json:"amount"`
+	Amount          Amount             `bson:"amount" json:"amount"`
 	Note            string             `bson:"note" json:"note"`
 	Timestamp       time.Time          `bson:"timestamp" json:"timestamp"`
 	SenderPublicKey string             `bson:"sender_public_key" json:"senderPublicKey"`
@@ -21,17 +23,73 @@ type Transaction struct {
 	Type            string             `bson:"type" json:"type"` // transfer, zakat_deduction, mining_reward
 	Status          string             `bson:"status" json:"status"` // pending, confirmed, rejected
 	BlockHash       string             `bson:"block_hash,omitempty" json:"blockHash,omitempty"`
-	Fee             float64            `bson:"fee" json:"fee"`
+	Fee             Amount             `bson:"fee" json:"fee"`
+	Nonce           int64              `bson:"nonce" json:"nonce"`
+	ChainID         string             `bson:"chain_id,omitempty" json:"chainId,omitempty"`
+	// LegacyAmounts holds the exact float amounts of a transaction made
+	// before amounts were fixed point, when rounding them to base units lost
+	// precision: amount, fee, then each input and output. Its ID and
+	// signature are computed over these.
+	LegacyAmounts   []float64          `bson:"legacy_amounts,omitempty" json:"-"`
+}
+
+type transactionDocument Transaction
+
+// UnmarshalBSON decodes a transaction, keeping the exact values of legacy
+// float amounts that do not round trip through base units
+func (tx *Transaction) UnmarshalBSON(data []byte) error {
+	var doc transactionDocument
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	*tx = Transaction(doc)
+	if tx.LegacyAmounts == nil {
+		tx.LegacyAmounts = legacyAmounts(bson.Raw(data))
+	}
+	return nil
+}
+
+// legacyAmounts returns the amounts of a raw transaction document in
+// encoding order if any of them is a double that base units cannot hold
+func legacyAmounts(raw bson.Raw) []float64 {
+	var amounts []float64
+	lossy := false
+	add := func(value bson.RawValue) {
+		if f, ok := value.DoubleOK(); ok {
+			amounts = append(amounts, f)
+			lossy = lossy || AmountFromFloat(f).Float64() != f
+			return
+		}
+		units, _ := value.AsInt64OK()
+		amounts = append(amounts, Amount(units).Float64())
+	}
+
+	add(raw.Lookup("amount"))
+	add(raw.Lookup("fee"))
+	for _, key := range []string{"input_utxos", "output_utxos"} {
+		items, _ := raw.Lookup(key).ArrayOK()
+		values, _ := items.Values()
+		for _, item := range values {
+			if doc, ok := item.DocumentOK(); ok {
+				add(doc.Lookup("amount"))
+			}
+		}
+	}
+
+	if !lossy {
+		return nil
+	}
+	return amounts
 }
 
 type UTXOInput struct {
 	TxID        string  `bson:"tx_id" json:"txId"`
 	OutputIndex int     `bson:"output_index" json:"outputIndex"`
-	Amount      float64 `bson:"amount" json:"amount"`
+	Amount      Amount  `bson:"amount" json:"amount"`
 }
 
 type UTXOOutput struct {
 	WalletID string  `bson:"wallet_id" json:"walletId"`
-	Amount   float64 `bson:"amount" json:"amount"`
+	Amount   Amount  `bson:"amount" json:"amount"`
 	Index    int     `bson:"index" json:"index"`
 }
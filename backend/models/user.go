@@ -13,7 +13,13 @@ type User struct {
 	CNIC              string             `bson:"cnic" json:"cnic"`
 	WalletID          string             `bson:"wallet_id" json:"walletId"`
 	PublicKey         string             `bson:"public_key" json:"publicKey"`
+	KeyType           string             `bson:"key_type,omitempty" json:"keyType,omitempty"` // signature scheme of the wallet's keys; empty for P-256
 	EncryptedPrivKey  string             `bson:"encrypted_priv_key" json:"-"`
+	EncryptedSeed     string             `bson:"encrypted_seed,omitempty" json:"-"` // HD master seed; empty for wallets created before HD
+	AddressCount      int                `bson:"address_count" json:"addressCount"` // receive addresses derived from the seed so far
+	AccountPublicKey  string             `bson:"account_public_key,omitempty" json:"-"` // extended public key receive addresses derive from
+	MnemonicBackup    bool               `bson:"mnemonic_backup" json:"mnemonicBackup"` // the seed derives from a recovery phrase the user holds
+	PassphraseKDF     string             `bson:"passphrase_kdf,omitempty" json:"-"` // parameters and salt of the signing passphrase; empty when none is set
 	Beneficiaries     []Beneficiary      `bson:"beneficiaries" json:"beneficiaries"`
 	ZakatTracking     []ZakatRecord      `bson:"zakat_tracking" json:"zakatTracking"`
 	OTP               string             `bson:"otp" json:"-"`
@@ -31,7 +37,7 @@ type Beneficiary struct {
 }
 
 type ZakatRecord struct {
-	Amount    float64   `bson:"amount" json:"amount"`
+	Amount    Amount    `bson:"amount" json:"amount"`
 	Date      time.Time `bson:"date" json:"date"`
 	BlockHash string    `bson:"block_hash" json:"blockHash"`
 	TxID      string    `bson:"tx_id" json:"txId"`
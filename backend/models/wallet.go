@@ -7,13 +7,16 @@ import (
 )
 
 type Wallet struct {
-	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID        primitive.ObjectID `bson:"user_id" json:"userId"`
-	WalletID      string             `bson:"wallet_id" json:"walletId"`
-	PublicKey     string             `bson:"public_key" json:"publicKey"`
-	CachedBalance float64            `bson:"cached_balance" json:"cachedBalance"`
-	CreatedAt     time.Time          `bson:"created_at" json:"createdAt"`
-	UpdatedAt     time.Time          `bson:"updated_at" json:"updatedAt"`
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"userId"`
+	WalletID       string             `bson:"wallet_id" json:"walletId"`
+	Address        string             `bson:"-" json:"address,omitempty"` // WalletID encoded for the node's network
+	PublicKey      string             `bson:"public_key" json:"publicKey"`
+	DerivationPath string             `bson:"derivation_path,omitempty" json:"derivationPath,omitempty"` // empty for keys not derived from a seed
+	CachedBalance  Amount             `bson:"cached_balance" json:"cachedBalance"`
+	Nonce          int64              `bson:"nonce" json:"nonce"` // last nonce signed by the wallet
+	CreatedAt      time.Time          `bson:"created_at" json:"createdAt"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updatedAt"`
 }
 
 type UTXO struct {
@@ -21,7 +24,7 @@ type UTXO struct {
 	TxID        string             `bson:"tx_id" json:"txId"`
 	OutputIndex int                `bson:"output_index" json:"outputIndex"`
 	WalletID    string             `bson:"wallet_id" json:"walletId"`
-	Amount      float64            `bson:"amount" json:"amount"`
+	Amount      Amount             `bson:"amount" json:"amount"`
 	IsSpent     bool               `bson:"is_spent" json:"isSpent"`
 	SpentInTx   string             `bson:"spent_in_tx,omitempty" json:"spentInTx,omitempty"`
 	BlockHash   string             `bson:"block_hash" json:"blockHash"`
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Addresses are the wallet IDs users see. An address is Bech32m encoded:
+// the network's prefix, the separator "1", a version and the 20-byte key
+// hash, then a 6-character checksum that catches any typo of up to four
+// characters. Version 0 holds the key hash that wallet IDs are the hex
+// form of, so every existing wallet ID has exactly one address per network.
+
+// AddressVersion is the version of the addresses this node creates
+const AddressVersion = 0
+
+// walletIDLength is the length in bytes of the key hash a wallet ID holds
+const walletIDLength = 20
+
+const (
+	bech32Charset  = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	bech32mConst   = 0x2bc830a3
+	maxAddressSize = 90
+)
+
+var (
+	ErrInvalidAddress  = errors.New("invalid address")
+	ErrAddressChecksum = errors.New("address checksum does not match; check it for typos")
+	ErrAddressNetwork  = errors.New("address belongs to another network")
+	ErrBareWalletID    = errors.New("bare wallet IDs carry no network or checksum; use the wallet's address")
+)
+
+// EncodeAddress returns the address of a wallet ID on the network with
+// the given prefix
+func EncodeAddress(prefix, walletID string) (string, error) {
+	hash, err := hex.DecodeString(walletID)
+	if err != nil || len(hash) != walletIDLength {
+		return "", ErrInvalidAddress
+	}
+
+	data := append([]byte{AddressVersion}, convertBits(hash, 8, 5, true)...)
+	return encodeBech32m(prefix, data), nil
+}
+
+// ParseAddress returns the wallet ID an address stands for, after
+// checking its checksum, version and network. Bare wallet IDs are refused;
+// see ParseLegacyAddress.
+func ParseAddress(prefix, address string) (string, error) {
+	address = strings.TrimSpace(address)
+	if IsWalletID(address) {
+		return "", ErrBareWalletID
+	}
+
+	hrp, data, err := decodeBech32m(address)
+	if err != nil {
+		return "", err
+	}
+	if hrp != prefix {
+		return "", ErrAddressNetwork
+	}
+
+	if len(data) == 0 || data[0] != AddressVersion {
+		return "", ErrInvalidAddress
+	}
+	hash := convertBits(data[1:], 5, 8, false)
+	if hash == nil || len(hash) != walletIDLength {
+		return "", ErrInvalidAddress
+	}
+	return hex.EncodeToString(hash), nil
+}
+
+// ParseLegacyAddress is ParseAddress that also accepts bare wallet IDs, as
+// issued before addresses. A bare ID cannot be checked for typos or for
+// its network, so it is only for looking wallets up, never for choosing
+// where coins go.
+func ParseLegacyAddress(prefix, address string) (string, error) {
+	address = strings.TrimSpace(address)
+	if IsWalletID(address) {
+		return strings.ToLower(address), nil
+	}
+	return ParseAddress(prefix, address)
+}
+
+// IsWalletID reports whether s is a bare wallet ID: 40 hex characters
+func IsWalletID(s string) bool {
+	if len(s) != 2*walletIDLength {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// Address returns the address of a wallet ID on this node's network, or
+// "" for IDs that are not key hashes, such as the zakat pool's
+func (s *BlockchainService) Address(walletID string) string {
+	address, err := EncodeAddress(s.network.AddressPrefix, walletID)
+	if err != nil {
+		return ""
+	}
+	return address
+}
+
+// ParseAddress returns the wallet ID an address on this node's network
+// stands for
+func (s *BlockchainService) ParseAddress(address string) (string, error) {
+	return ParseAddress(s.network.AddressPrefix, address)
+}
+
+// ParseLegacyAddress returns the wallet ID an address on this node's
+// network, or a bare wallet ID, stands for
+func (s *BlockchainService) ParseLegacyAddress(address string) (string, error) {
+	return ParseLegacyAddress(s.network.AddressPrefix, address)
+}
+
+// encodeBech32m returns the Bech32m string of a human-readable part and
+// 5-bit data values
+func encodeBech32m(hrp string, data []byte) string {
+	var b strings.Builder
+	b.WriteString(hrp)
+	b.WriteByte('1')
+	for _, d := range append(data, bech32Checksum(hrp, data)...) {
+		b.WriteByte(bech32Charset[d])
+	}
+	return b.String()
+}
+
+// decodeBech32m splits a Bech32m string into its lower-case human-readable
+// part and its 5-bit data values, checksum removed
+func decodeBech32m(s string) (string, []byte, error) {
+	if len(s) > maxAddressSize || (strings.ToLower(s) != s && strings.ToUpper(s) != s) {
+		return "", nil, ErrInvalidAddress
+	}
+	s = strings.ToLower(s)
+
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || len(s)-sep-1 < 6 {
+		return "", nil, ErrInvalidAddress
+	}
+	hrp := s[:sep]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, ErrInvalidAddress
+		}
+	}
+
+	data := make([]byte, 0, len(s)-sep-1)
+	for _, c := range s[sep+1:] {
+		d := strings.IndexRune(bech32Charset, c)
+		if d < 0 {
+			return "", nil, ErrInvalidAddress
+		}
+		data = append(data, byte(d))
+	}
+	if bech32Polymod(append(bech32HRPExpand(hrp), data...)) != bech32mConst {
+		return "", nil, ErrAddressChecksum
+	}
+	return hrp, data[:len(data)-6], nil
+}
+
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	expanded := make([]byte, 0, 2*len(hrp)+1)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	return expanded
+}
+
+func bech32Checksum(hrp string, data []byte) []byte {
+	values := append(bech32HRPExpand(hrp), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+	mod := bech32Polymod(values) ^ bech32mConst
+	checksum := make([]byte, 6)
+	for i := range checksum {
+		checksum[i] = byte(mod>>(5*(5-i))) & 31
+	}
+	return checksum
+}
+
+// convertBits regroups data from fromBits-bit to toBits-bit values. It
+// returns nil when unpadded input leaves non-zero bits over.
+func convertBits(data []byte, fromBits, toBits uint, pad bool) []byte {
+	var acc, bits uint
+	maxv := uint(1)<<toBits - 1
+	out := make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+	for _, v := range data {
+		acc = acc<<fromBits | uint(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBech32mVectors(t *testing.T) {
+	// Valid Bech32m strings from BIP-350
+	valid := []string{
+		"A1LQFN3A",
+		"a1lqfn3a",
+		"an83characterlonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11sg7hg6",
+		"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx",
+		"11llllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllludsr8",
+		"split1checkupstagehandshakeupstreamerranterredcaperredlc445v",
+		"?1v759aa",
+	}
+	for _, s := range valid {
+		hrp, data, err := decodeBech32m(s)
+		if err != nil {
+			t.Fatalf("decode %q: %v", s, err)
+		}
+		if got := encodeBech32m(hrp, data); got != strings.ToLower(s) {
+			t.Fatalf("encode %q = %q", s, got)
+		}
+	}
+
+	// Invalid Bech32m strings from BIP-350, and a valid Bech32 string,
+	// whose checksum must not pass as Bech32m
+	invalid := []struct {
+		s    string
+		want error
+	}{
+		{"\x201xj0phk", ErrInvalidAddress},
+		{"\x7f1g6xzxy", ErrInvalidAddress},
+		{"\x801vctc34", ErrInvalidAddress},
+		{"an84characterslonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11d6pts4", ErrInvalidAddress},
+		{"qyrz8wqd2c9m", ErrInvalidAddress},
+		{"1qyrz8wqd2c9m", ErrInvalidAddress},
+		{"y1b0jsk6g", ErrInvalidAddress},
+		{"lt1igcx5c0", ErrInvalidAddress},
+		{"in1muywd", ErrInvalidAddress},
+		{"mm1crxm3i", ErrInvalidAddress},
+		{"au1s5cgom", ErrInvalidAddress},
+		{"M1VUXWEZ", ErrAddressChecksum},
+		{"16plkw9", ErrInvalidAddress},
+		{"1p2gdwpf", ErrInvalidAddress},
+		{"a12uel5l", ErrAddressChecksum},
+	}
+	for _, tt := range invalid {
+		if _, _, err := decodeBech32m(tt.s); err != tt.want {
+			t.Fatalf("decode %q: err = %v, want %v", tt.s, err, tt.want)
+		}
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	walletID := newTestWallet(t).walletID
+	address, err := EncodeAddress("zk", walletID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(address, "zk1") {
+		t.Fatalf("address %q lacks the network prefix", address)
+	}
+
+	// A typo in any one character must be caught by the checksum
+	typo := []byte(address)
+	last := len(typo) - 1
+	typo[last] = bech32Charset[(strings.IndexByte(bech32Charset, typo[last])+1)%len(bech32Charset)]
+	devnet, err := EncodeAddress("zkdev", walletID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		address string
+		want    error
+	}{
+		{"address", address, nil},
+		{"upper case", strings.ToUpper(address), nil},
+		{"surrounding space", " " + address + "\n", nil},
+		{"mixed case", "ZK" + address[2:], ErrInvalidAddress},
+		{"typo", string(typo), ErrAddressChecksum},
+		{"another network", devnet, ErrAddressNetwork},
+		{"bare wallet ID", walletID, ErrBareWalletID},
+		{"upper-case bare wallet ID", strings.ToUpper(walletID), ErrBareWalletID},
+		{"other version", encodeBech32m("zk", append([]byte{1}, convertBits(make([]byte, walletIDLength), 8, 5, true)...)), ErrInvalidAddress},
+		{"short hash", encodeBech32m("zk", append([]byte{AddressVersion}, convertBits(make([]byte, 19), 8, 5, true)...)), ErrInvalidAddress},
+		{"no data", encodeBech32m("zk", nil), ErrInvalidAddress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAddress("zk", tt.address)
+			if err != tt.want {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if err == nil && got != walletID {
+				t.Fatalf("wallet ID = %q, want %q", got, walletID)
+			}
+		})
+	}
+}
+
+func TestParseLegacyAddress(t *testing.T) {
+	walletID := newTestWallet(t).walletID
+	address, err := EncodeAddress("zk", walletID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{walletID, strings.ToUpper(walletID), address} {
+		got, err := ParseLegacyAddress("zk", s)
+		if err != nil || got != walletID {
+			t.Fatalf("ParseLegacyAddress(%q) = %q, %v; want %q", s, got, err, walletID)
+		}
+	}
+	if _, err := ParseLegacyAddress("zkdev", address); err != ErrAddressNetwork {
+		t.Fatalf("address of another network: err = %v, want %v", err, ErrAddressNetwork)
+	}
+}
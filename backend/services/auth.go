@@ -9,32 +9,41 @@ import (
 	"time"
 
 	"backend/models"
+	"backend/storage"
 
 	"github.com/golang-jwt/jwt/v5"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type AuthService struct {
-	db            *mongo.Database
+	store         storage.ChainStore
 	walletService *WalletService
+	faucet        *FaucetService
 }
 
-func NewAuthService(db *mongo.Database, walletService *WalletService) *AuthService {
+func NewAuthService(store storage.ChainStore, walletService *WalletService, faucet *FaucetService) *AuthService {
 	return &AuthService{
-		db:            db,
+		store:         store,
 		walletService: walletService,
+		faucet:        faucet,
 	}
 }
 
-// Register creates a new user account
-func (s *AuthService) Register(ctx context.Context, email, fullName, cnic string) (*models.User, error) {
-	collection := s.db.Collection("users")
+// Register creates a new user account whose wallet signs with keyType.
+// With a recovery phrase, the wallet's keys derive from it and the
+// passphrase; without one they derive from a random seed kept only on the
+// server.
+func (s *AuthService) Register(ctx context.Context, email, fullName, cnic, mnemonic, passphrase string, keyType KeyType) (*models.User, error) {
+	var seed []byte
+	if mnemonic != "" {
+		if err := ValidateMnemonic(mnemonic); err != nil {
+			return nil, err
+		}
+		seed = MnemonicToSeed(mnemonic, passphrase)
+	}
 
 	// Check if email already exists
-	var existingUser models.User
-	err := collection.FindOne(ctx, bson.M{"email": email}).Decode(&existingUser)
+	_, err := s.store.GetUserByEmail(ctx, email)
 	if err == nil {
 		return nil, errors.New("email already registered")
 	}
@@ -56,22 +65,25 @@ func (s *AuthService) Register(ctx context.Context, email, fullName, cnic string
 		CreatedAt:     time.Now(),
 		UpdatedAt:     time.Now(),
 	}
+	if keyType != KeyTypeP256 {
+		user.KeyType = keyType.String()
+	}
 
 	// Create wallet
-	wallet, encryptedPrivKey, err := s.walletService.CreateWallet(ctx, user.ID)
+	wallet, err := s.walletService.CreateWallet(ctx, user, seed)
 	if err != nil {
 		return nil, err
 	}
+	user.MnemonicBackup = seed != nil
 
-	user.WalletID = wallet.WalletID
-	user.PublicKey = wallet.PublicKey
-	user.EncryptedPrivKey = encryptedPrivKey
-
-	_, err = collection.InsertOne(ctx, user)
-	if err != nil {
+	if err := s.store.InsertUser(ctx, user); err != nil {
 		return nil, err
 	}
 
+	// Grant the network's starting amount; failures are logged by the
+	// faucet and do not fail the registration
+	s.faucet.Allocate(ctx, wallet.WalletID)
+
 	// In production, send OTP via email
 	// For now, we'll log it (stub implementation)
 	fmt.Printf("OTP for %s: %s\n", email, otp)
@@ -79,14 +91,16 @@ func (s *AuthService) Register(ctx context.Context, email, fullName, cnic string
 	return user, nil
 }
 
+// Address returns the address of a user's wallet ID
+func (s *AuthService) Address(walletID string) string {
+	return s.walletService.Address(walletID)
+}
+
 // Login initiates login and sends OTP
 func (s *AuthService) Login(ctx context.Context, email string) (*models.User, error) {
-	collection := s.db.Collection("users")
-
-	var user models.User
-	err := collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	user, err := s.store.GetUserByEmail(ctx, email)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if err == storage.ErrNotFound {
 			return nil, errors.New("user not found")
 		}
 		return nil, err
@@ -97,13 +111,10 @@ func (s *AuthService) Login(ctx context.Context, email string) (*models.User, er
 	user.OTP = otp
 	user.OTPExpiry = time.Now().Add(10 * time.Minute)
 
-	_, err = collection.UpdateOne(ctx,
-		bson.M{"_id": user.ID},
-		bson.M{"$set": bson.M{
-			"otp":        otp,
-			"otp_expiry": user.OTPExpiry,
-		}},
-	)
+	err = s.store.UpdateUser(ctx, user.ID, map[string]interface{}{
+		"otp":        otp,
+		"otp_expiry": user.OTPExpiry,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -111,15 +122,12 @@ func (s *AuthService) Login(ctx context.Context, email string) (*models.User, er
 	// In production, send OTP via email
 	fmt.Printf("OTP for %s: %s\n", email, otp)
 
-	return &user, nil
+	return user, nil
 }
 
 // VerifyOTP verifies OTP and returns JWT token
 func (s *AuthService) VerifyOTP(ctx context.Context, email, otp string) (string, *models.User, error) {
-	collection := s.db.Collection("users")
-
-	var user models.User
-	err := collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	user, err := s.store.GetUserByEmail(ctx, email)
 	if err != nil {
 		return "", nil, errors.New("user not found")
 	}
@@ -133,58 +141,38 @@ func (s *AuthService) VerifyOTP(ctx context.Context, email, otp string) (string,
 	}
 
 	// Mark as verified and clear OTP
-	_, err = collection.UpdateOne(ctx,
-		bson.M{"_id": user.ID},
-		bson.M{"$set": bson.M{
-			"is_verified": true,
-			"otp":         "",
-			"updated_at":  time.Now(),
-		}},
-	)
+	err = s.store.UpdateUser(ctx, user.ID, map[string]interface{}{
+		"is_verified": true,
+		"otp":         "",
+		"updated_at":  time.Now(),
+	})
 	if err != nil {
 		return "", nil, err
 	}
 
 	// Generate JWT
-	token, err := s.generateJWT(&user)
+	token, err := s.generateJWT(user)
 	if err != nil {
 		return "", nil, err
 	}
 
-	return token, &user, nil
+	return token, user, nil
 }
 
 // GetUserByID retrieves user by ID
 func (s *AuthService) GetUserByID(ctx context.Context, userID primitive.ObjectID) (*models.User, error) {
-	collection := s.db.Collection("users")
-
-	var user models.User
-	err := collection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
-	if err != nil {
-		return nil, err
-	}
-
-	return &user, nil
+	return s.store.GetUserByID(ctx, userID)
 }
 
 // UpdateUser updates user profile
 func (s *AuthService) UpdateUser(ctx context.Context, userID primitive.ObjectID, updates map[string]interface{}) error {
-	collection := s.db.Collection("users")
-
 	updates["updated_at"] = time.Now()
 
-	_, err := collection.UpdateOne(ctx,
-		bson.M{"_id": userID},
-		bson.M{"$set": updates},
-	)
-
-	return err
+	return s.store.UpdateUser(ctx, userID, updates)
 }
 
 // AddBeneficiary adds a beneficiary to user
 func (s *AuthService) AddBeneficiary(ctx context.Context, userID primitive.ObjectID, walletID, name string) error {
-	collection := s.db.Collection("users")
-
 	beneficiary := models.Beneficiary{
 		ID:       primitive.NewObjectID(),
 		WalletID: walletID,
@@ -192,30 +180,12 @@ func (s *AuthService) AddBeneficiary(ctx context.Context, userID primitive.Objec
 		AddedAt:  time.Now(),
 	}
 
-	_, err := collection.UpdateOne(ctx,
-		bson.M{"_id": userID},
-		bson.M{
-			"$push": bson.M{"beneficiaries": beneficiary},
-			"$set":  bson.M{"updated_at": time.Now()},
-		},
-	)
-
-	return err
+	return s.store.AddBeneficiary(ctx, userID, beneficiary)
 }
 
 // RemoveBeneficiary removes a beneficiary from user
 func (s *AuthService) RemoveBeneficiary(ctx context.Context, userID, beneficiaryID primitive.ObjectID) error {
-	collection := s.db.Collection("users")
-
-	_, err := collection.UpdateOne(ctx,
-		bson.M{"_id": userID},
-		bson.M{
-			"$pull": bson.M{"beneficiaries": bson.M{"_id": beneficiaryID}},
-			"$set":  bson.M{"updated_at": time.Now()},
-		},
-	)
-
-	return err
+	return s.store.RemoveBeneficiary(ctx, userID, beneficiaryID)
 }
 
 // generateOTP generates a 6-digit OTP
@@ -2,38 +2,43 @@ package services
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
-	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"backend/models"
+	"backend/storage"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type BlockchainService struct {
-	db         *mongo.Database
-	difficulty int
+	store      storage.ChainStore
+	difficulty DifficultyConfig
+	subsidy    SubsidyConfig
+	limits     BlockLimits
+	network    NetworkConfig
+	crypto     *CryptoService
+	mempool    *Mempool
+	chainMu    sync.Mutex // serializes changes to the main chain
 }
 
-func NewBlockchainService(db *mongo.Database) *BlockchainService {
+func NewBlockchainService(store storage.ChainStore, difficulty DifficultyConfig, subsidy SubsidyConfig, limits BlockLimits, mempool MempoolConfig, network NetworkConfig) *BlockchainService {
 	return &BlockchainService{
-		db:         db,
-		difficulty: 5, // Hash must start with 5 zeros
+		store:      store,
+		difficulty: difficulty,
+		subsidy:    subsidy,
+		limits:     limits,
+		network:    network,
+		crypto:     NewCryptoService(),
+		mempool:    NewMempool(mempool),
 	}
 }
 
 // InitializeGenesisBlock creates the genesis block if not exists
 func (s *BlockchainService) InitializeGenesisBlock(ctx context.Context) error {
-	collection := s.db.Collection("blocks")
-	
-	count, err := collection.CountDocuments(ctx, bson.M{})
+	count, err := s.store.CountBlocks(ctx)
 	if err != nil {
 		return err
 	}
@@ -44,166 +49,110 @@ func (s *BlockchainService) InitializeGenesisBlock(ctx context.Context) error {
 
 	genesis := models.Block{
 		ID:           primitive.NewObjectID(),
+		Version:      CurrentBlockVersion,
 		Index:        0,
 		Timestamp:    time.Now(),
 		Transactions: []models.Transaction{},
 		PreviousHash: "0",
 		Nonce:        0,
 		Hash:         "",
-		MerkleRoot:   "0",
-		Difficulty:   s.difficulty,
+		MerkleRoot:   EmptyMerkleRoot,
+		Difficulty:   s.difficulty.InitialDifficulty,
 		Miner:        "system",
+		Status:       models.BlockStatusMain,
 		CreatedAt:    time.Now(),
 	}
 
 	// Calculate genesis hash
 	genesis.Hash = s.CalculateHash(&genesis)
+	genesis.ChainWork = BlockWork(genesis.Difficulty).Text(16)
 
-	_, err = collection.InsertOne(ctx, genesis)
-	return err
+	return s.store.InsertBlock(ctx, &genesis)
 }
 
 // CalculateHash computes SHA-256 hash of block
 func (s *BlockchainService) CalculateHash(block *models.Block) string {
-	data := fmt.Sprintf("%d%s%s%s%d%s",
-		block.Index,
-		block.Timestamp.Format(time.RFC3339),
-		block.PreviousHash,
-		block.MerkleRoot,
-		block.Nonce,
-		s.transactionsToString(block.Transactions),
-	)
-	hash := sha256.Sum256([]byte(data))
-	return hex.EncodeToString(hash[:])
+	return BlockHash(block)
 }
 
 // CalculateMerkleRoot computes merkle root of transactions
 func (s *BlockchainService) CalculateMerkleRoot(transactions []models.Transaction) string {
-	if len(transactions) == 0 {
-		return "0"
-	}
-	
-	var hashes []string
+	var txIDs []string
 	for _, tx := range transactions {
-		hashes = append(hashes, tx.TxID)
-	}
-	
-	for len(hashes) > 1 {
-		var newHashes []string
-		for i := 0; i < len(hashes); i += 2 {
-			if i+1 < len(hashes) {
-				combined := hashes[i] + hashes[i+1]
-				hash := sha256.Sum256([]byte(combined))
-				newHashes = append(newHashes, hex.EncodeToString(hash[:]))
-			} else {
-				newHashes = append(newHashes, hashes[i])
-			}
-		}
-		hashes = newHashes
+		txIDs = append(txIDs, tx.TxID)
 	}
-	
-	return hashes[0]
+	return MerkleRoot(txIDs)
 }
 
-// ProofOfWork performs mining with difficulty
-func (s *BlockchainService) ProofOfWork(block *models.Block) {
-	prefix := strings.Repeat("0", s.difficulty)
-	for {
-		block.Hash = s.CalculateHash(block)
-		if strings.HasPrefix(block.Hash, prefix) {
-			break
-		}
-		block.Nonce++
-	}
+// MeetsDifficulty reports whether hash starts with difficulty zeros
+func MeetsDifficulty(hash string, difficulty int) bool {
+	return strings.HasPrefix(hash, strings.Repeat("0", difficulty))
 }
 
 // GetLatestBlock returns the most recent block
 func (s *BlockchainService) GetLatestBlock(ctx context.Context) (*models.Block, error) {
-	collection := s.db.Collection("blocks")
-	
-	opts := options.FindOne().SetSort(bson.D{{Key: "index", Value: -1}})
-	var block models.Block
-	err := collection.FindOne(ctx, bson.M{}, opts).Decode(&block)
+	return s.store.GetLatestBlock(ctx)
+}
+
+// ValidateBlockHeader checks a block's link, hash, proof-of-work and
+// timestamp against its parent. Failures are returned as *RuleError.
+func (s *BlockchainService) ValidateBlockHeader(ctx context.Context, block, parent *models.Block) error {
+	if block.PreviousHash != parent.Hash || block.Index != parent.Index+1 {
+		return ruleError(RuleChainLink, "", "invalid chain link")
+	}
+
+	if block.Version < parent.Version || block.Version > CurrentBlockVersion {
+		return ruleError(RuleBlockVersion, "", fmt.Sprintf("invalid block version %d", block.Version))
+	}
+
+	if block.Hash != s.CalculateHash(block) {
+		return ruleError(RuleBlockHash, "", "invalid block hash")
+	}
+
+	expected, err := s.requiredDifficulty(ctx, block, parent)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if block.Difficulty != expected {
+		return ruleError(RuleDifficulty, "", fmt.Sprintf("invalid difficulty: expected %d, got %d", expected, block.Difficulty))
 	}
-	
-	return &block, nil
-}
 
-// AddBlock adds a new block to the chain
-func (s *BlockchainService) AddBlock(ctx context.Context, block *models.Block) error {
-	collection := s.db.Collection("blocks")
-	_, err := collection.InsertOne(ctx, block)
-	return err
+	if !MeetsDifficulty(block.Hash, block.Difficulty) {
+		return ruleError(RuleProofOfWork, "", "block hash does not meet difficulty")
+	}
+
+	if err := s.checkTimestamp(ctx, block, parent); err != nil {
+		return err
+	}
+
+	return nil
 }
 
 // GetAllBlocks returns all blocks in the chain
 func (s *BlockchainService) GetAllBlocks(ctx context.Context) ([]models.Block, error) {
-	collection := s.db.Collection("blocks")
-	
-	opts := options.Find().SetSort(bson.D{{Key: "index", Value: -1}})
-	cursor, err := collection.Find(ctx, bson.M{}, opts)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(ctx)
-	
-	var blocks []models.Block
-	if err := cursor.All(ctx, &blocks); err != nil {
-		return nil, err
-	}
-	
-	return blocks, nil
+	return s.store.GetAllBlocks(ctx)
 }
 
 // GetBlockByHash returns a block by its hash
 func (s *BlockchainService) GetBlockByHash(ctx context.Context, hash string) (*models.Block, error) {
-	collection := s.db.Collection("blocks")
-	
-	var block models.Block
-	err := collection.FindOne(ctx, bson.M{"hash": hash}).Decode(&block)
-	if err != nil {
-		return nil, err
-	}
-	
-	return &block, nil
+	return s.store.GetBlockByHash(ctx, hash)
 }
 
-// ValidateChain validates the entire blockchain
-func (s *BlockchainService) ValidateChain(ctx context.Context) (bool, error) {
-	blocks, err := s.GetAllBlocks(ctx)
+// GetDifficulty returns the difficulty required of the next block
+func (s *BlockchainService) GetDifficulty(ctx context.Context) (int, error) {
+	latest, err := s.GetLatestBlock(ctx)
 	if err != nil {
-		return false, err
+		return 0, err
 	}
-	
-	for i := len(blocks) - 1; i > 0; i-- {
-		currentBlock := blocks[i-1]
-		previousBlock := blocks[i]
-		
-		// Verify hash
-		if currentBlock.Hash != s.CalculateHash(&currentBlock) {
-			return false, errors.New("invalid block hash")
-		}
-		
-		// Verify chain link
-		if currentBlock.PreviousHash != previousBlock.Hash {
-			return false, errors.New("invalid chain link")
-		}
-	}
-	
-	return true, nil
+	return s.NextDifficulty(ctx, latest)
 }
 
-// GetDifficulty returns current mining difficulty
-func (s *BlockchainService) GetDifficulty() int {
+// GetDifficultyConfig returns the retargeting configuration
+func (s *BlockchainService) GetDifficultyConfig() DifficultyConfig {
 	return s.difficulty
 }
 
-func (s *BlockchainService) transactionsToString(txs []models.Transaction) string {
-	var result string
-	for _, tx := range txs {
-		result += tx.TxID
-	}
-	return result
+// GetBlockLimits returns the block size limits
+func (s *BlockchainService) GetBlockLimits() BlockLimits {
+	return s.limits
 }
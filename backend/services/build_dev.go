@@ -0,0 +1,7 @@
+//go:build dev
+
+package services
+
+// devBuild is set by building with -tags dev, which lets a node start on
+// the devnet without a chain ID or faucet key
+const devBuild = true
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TransferType is the type of a wallet-to-wallet payment
+const TransferType = "transfer"
+
+// signaturePlaceholder stands in for a signature when sizing a transaction
+var signaturePlaceholder = strings.Repeat("0", 128)
+
+var ErrRawTransactionType = errors.New("only signed transfers can be submitted")
+
+// InsufficientFundsError reports a transfer the sender's spendable outputs cannot cover
+type InsufficientFundsError struct {
+	Available models.Amount
+	Required  models.Amount
+}
+
+func (e *InsufficientFundsError) Error() string {
+	return fmt.Sprintf("insufficient balance: available %s, required %s", e.Available, e.Required)
+}
+
+// TransferRequest describes a payment to build. When FeeRate is set, the
+// fee is FeeRate times the signed transaction's size; otherwise it is Fee.
+// CoinSelection names the strategy, empty for the default. SignedFee is set
+// when the client has already signed Fee, which must then not change.
+type TransferRequest struct {
+	SenderWalletID   string
+	ReceiverWalletID string
+	Amount           models.Amount
+	Fee              models.Amount
+	FeeRate          float64 // coins per byte
+	Note             string
+	CoinSelection    string
+	SignedFee        bool
+}
+
+// UnsignedTransaction is a built transaction and what its sender must sign
+type UnsignedTransaction struct {
+	Transaction    *models.Transaction `json:"transaction"`
+	Raw            string              `json:"raw"`            // hex canonical encoding, signature empty
+	SigningPayload string              `json:"signingPayload"` // hex bytes to sign
+	SigningHash    string              `json:"signingHash"`    // hex SHA-256 of the payload
+}
+
+// BuildTransaction selects spendable outputs of the sender to pay the
+// receiver, adds change back to the sender and returns the transaction
+// ready for signing. It uses the sender's next nonce and the current time.
+func (s *TransactionService) BuildTransaction(ctx context.Context, req TransferRequest) (*UnsignedTransaction, error) {
+	sender, err := s.store.GetWalletByWalletID(ctx, req.SenderWalletID)
+	if err != nil {
+		return nil, errors.New("invalid sender wallet ID")
+	}
+
+	utxos, err := s.GetSpendableUTXOs(ctx, req.SenderWalletID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := &models.Transaction{
+		ID:               primitive.NewObjectID(),
+		Version:          CurrentTransactionVersion,
+		SenderWalletID:   req.SenderWalletID,
+		ReceiverWalletID: req.ReceiverWalletID,
+		Amount:           req.Amount,
+		Note:             req.Note,
+		Timestamp:        time.UnixMilli(time.Now().UnixMilli()),
+		SenderPublicKey:  sender.PublicKey,
+		Type:             TransferType,
+		Status:           "pending",
+		Fee:              req.Fee,
+		Nonce:            sender.Nonce + 1,
+		ChainID:          s.blockchain.GetNetworkConfig().ChainID,
+	}
+
+	if err := s.fundTransaction(tx, utxos, req.CoinSelection, req.FeeRate, req.SignedFee); err != nil {
+		return nil, err
+	}
+
+	tx.TxID = ComputeTxID(tx)
+	return newUnsignedTransaction(tx), nil
+}
+
+// typicalInputSize is the encoded size of an input spending a version 1
+// or later transaction, whose ID is 64 hex characters
+const typicalInputSize = 1 + 64 + 4 + 8
+
+// outputSize returns the encoded size of an output
+func outputSize(output models.UTXOOutput) int {
+	return uvarintSize(len(output.WalletID)) + len(output.WalletID) + 8 + 4
+}
+
+// fundTransaction selects inputs for tx's amount with the named coin
+// selection strategy, or the default, and sets its outputs and fee. With a
+// fee rate the fee covers the signed transaction's size; otherwise tx.Fee
+// is kept. Change up to the dust threshold is added to the fee, unless
+// keepFee is set because the fee is already signed.
+func (s *TransactionService) fundTransaction(tx *models.Transaction, utxos []models.UTXO, strategy string, feeRate float64, keepFee bool) error {
+	if strategy == "" {
+		strategy = s.coins.Strategy
+	}
+	selector, err := NewCoinSelector(strategy)
+	if err != nil {
+		return err
+	}
+
+	payment := models.UTXOOutput{WalletID: tx.ReceiverWalletID, Amount: tx.Amount, Index: 0}
+	change := models.UTXOOutput{WalletID: tx.SenderWalletID, Index: 1}
+
+	// Size the transaction as signed, with change but without inputs
+	draft := *tx
+	draft.InputUTXOs = nil
+	draft.OutputUTXOs = []models.UTXOOutput{payment, change}
+	if RequiresSignature(tx) {
+		draft.Signature = signaturePlaceholder
+	}
+
+	params := SelectionParams{Target: tx.Amount + tx.Fee, DustThreshold: s.coins.DustThreshold}
+	if keepFee {
+		params.DustThreshold = 0
+	}
+	if feeRate > 0 {
+		params.Target = tx.Amount + FeeForSize(feeRate, TransactionSize(&draft))
+		params.InputCost = FeeForSize(feeRate, typicalInputSize)
+		params.ChangeCost = FeeForSize(feeRate, outputSize(change))
+	}
+
+	var available models.Amount
+	for _, utxo := range utxos {
+		available += utxo.Amount
+	}
+
+	// Selection estimates input sizes; raise the target and select again
+	// if the fee of the transaction actually built is not covered
+	for attempt := 0; attempt < 3; attempt++ {
+		selected, err := selector.SelectCoins(utxos, params)
+		if err == errSelectionShort {
+			return &InsufficientFundsError{Available: available, Required: params.Target}
+		}
+		if err != nil {
+			return err
+		}
+
+		var total models.Amount
+		draft.InputUTXOs = nil
+		for _, utxo := range selected {
+			draft.InputUTXOs = append(draft.InputUTXOs, models.UTXOInput{
+				TxID:        utxo.TxID,
+				OutputIndex: utxo.OutputIndex,
+				Amount:      utxo.Amount,
+			})
+			total += utxo.Amount
+		}
+
+		fee := tx.Fee
+		if feeRate > 0 {
+			fee = FeeForSize(feeRate, TransactionSize(&draft))
+		}
+		remainder := total - tx.Amount - fee
+		if remainder < 0 {
+			params.Target -= remainder
+			continue
+		}
+
+		tx.InputUTXOs = draft.InputUTXOs
+		tx.OutputUTXOs = []models.UTXOOutput{payment}
+		if remainder > params.DustThreshold {
+			change.Amount = remainder
+			tx.OutputUTXOs = append(tx.OutputUTXOs, change)
+			tx.Fee = fee
+		} else {
+			// Not worth an output; the miner keeps it
+			tx.Fee = total - tx.Amount
+		}
+		return nil
+	}
+
+	return &InsufficientFundsError{Available: available, Required: params.Target}
+}
+
+func newUnsignedTransaction(tx *models.Transaction) *UnsignedTransaction {
+	return &UnsignedTransaction{
+		Transaction:    tx,
+		Raw:            hex.EncodeToString(EncodeTransaction(tx)),
+		SigningPayload: hex.EncodeToString([]byte(TransactionSigningPayload(tx))),
+		SigningHash:    hex.EncodeToString(TransactionSigningHash(tx)),
+	}
+}
+
+// SubmitRawTransaction decodes a transfer from senderWalletID signed over
+// its canonical encoding and admits it like any other. signature, if not
+// empty, is attached to the decoded transaction first, so clients may send
+// back the unsigned encoding from BuildTransaction with their signature.
+func (s *TransactionService) SubmitRawTransaction(ctx context.Context, raw []byte, signature, senderWalletID string) (*models.Transaction, error) {
+	tx, err := DecodeTransaction(raw)
+	if err != nil {
+		return nil, err
+	}
+	if signature != "" {
+		tx.Signature = signature
+		tx.TxID = ComputeTxID(tx)
+	}
+
+	if tx.Type != TransferType || !RequiresSignature(tx) {
+		return nil, ErrRawTransactionType
+	}
+	if tx.SenderWalletID != senderWalletID {
+		return nil, errors.New("transaction is not from your wallet")
+	}
+	if tx.Version < SignedEncodingTransactionVersion {
+		return nil, errors.New("raw transactions must be signed over their encoding")
+	}
+
+	tx.ID = primitive.NewObjectID()
+	tx.Status = "pending"
+	if err := s.CreateTransaction(ctx, tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
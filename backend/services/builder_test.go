@@ -0,0 +1,46 @@
+package services
+
+import (
+	"testing"
+
+	"backend/models"
+	"backend/storage"
+)
+
+func TestFundTransactionDust(t *testing.T) {
+	s := NewTransactionService(storage.NewMemoryStore(), nil, DefaultCoinSelectionConfig())
+	fee := models.Coin / 1000
+	dust := s.coins.DustThreshold / 2
+	utxos := []models.UTXO{{TxID: "funding", OutputIndex: 0, WalletID: "alice", Amount: models.Coin + fee + dust}}
+
+	tests := []struct {
+		name       string
+		keepFee    bool
+		wantFee    models.Amount
+		wantChange models.Amount
+	}{
+		{"dust is added to the fee", false, fee + dust, 0},
+		{"signed fee is kept", true, fee, dust},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := &models.Transaction{SenderWalletID: "alice", ReceiverWalletID: "bob", Amount: models.Coin, Fee: fee}
+			if err := s.fundTransaction(tx, utxos, CoinSelectLargestFirst, 0, tt.keepFee); err != nil {
+				t.Fatal(err)
+			}
+			if tx.Fee != tt.wantFee {
+				t.Fatalf("fee = %s, want %s", tx.Fee, tt.wantFee)
+			}
+
+			var change models.Amount
+			for _, output := range tx.OutputUTXOs {
+				if output.WalletID == "alice" {
+					change += output.Amount
+				}
+			}
+			if change != tt.wantChange {
+				t.Fatalf("change = %s, want %s", change, tt.wantChange)
+			}
+		})
+	}
+}
@@ -0,0 +1,597 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"backend/models"
+	"backend/storage"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrBlockKnown is returned when a block has already been stored
+var ErrBlockKnown = errors.New("block already known")
+
+// BlockWork returns the expected number of hashes needed to mine a block
+// of the given difficulty, 16^difficulty
+func BlockWork(difficulty int) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(4*difficulty))
+}
+
+// parseChainWork reads a block's hex cumulative work
+func parseChainWork(block *models.Block) *big.Int {
+	work, ok := new(big.Int).SetString(block.ChainWork, 16)
+	if !ok {
+		return new(big.Int)
+	}
+	return work
+}
+
+// chainWorkAfter returns the cumulative work of a child of parent
+func chainWorkAfter(parent *models.Block, difficulty int) string {
+	work := parseChainWork(parent)
+	return work.Add(work, BlockWork(difficulty)).Text(16)
+}
+
+// AddBlock accepts a new block. Blocks extending the main chain are
+// connected directly; blocks on a side branch are stored as stale and
+// trigger a reorganization once their branch has more cumulative work;
+// blocks whose parent is unknown are kept as orphans until it arrives.
+func (s *BlockchainService) AddBlock(ctx context.Context, block *models.Block) error {
+	s.chainMu.Lock()
+	defer s.chainMu.Unlock()
+
+	if _, err := s.store.GetBlockByHash(ctx, block.Hash); err == nil {
+		return ErrBlockKnown
+	}
+
+	// Blocks decoded from the wire carry no storage identity
+	if block.ID.IsZero() {
+		block.ID = primitive.NewObjectID()
+		block.CreatedAt = time.Now()
+	}
+
+	parent, err := s.store.GetBlockByHash(ctx, block.PreviousHash)
+	if err == storage.ErrNotFound {
+		return s.storeOrphan(ctx, block)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch parent.Status {
+	case models.BlockStatusInvalid:
+		return errors.New("block builds on an invalid block")
+	case models.BlockStatusOrphan:
+		return s.storeOrphan(ctx, block)
+	}
+
+	if err := s.acceptBlock(ctx, block, parent); err != nil {
+		return err
+	}
+
+	return s.connectOrphans(ctx, block)
+}
+
+// storeOrphan keeps a block whose parent is unknown. Only context-free
+// checks are possible until the parent arrives, so the block must at
+// least carry the work the chain could next require, and the pool of
+// orphans is bounded by count and age.
+func (s *BlockchainService) storeOrphan(ctx context.Context, block *models.Block) error {
+	if block.Hash != s.CalculateHash(block) {
+		return errors.New("invalid block hash")
+	}
+	minimum, err := s.minOrphanDifficulty(ctx)
+	if err != nil {
+		return err
+	}
+	if block.Difficulty < minimum {
+		return fmt.Errorf("orphan block difficulty %d is below the minimum of %d", block.Difficulty, minimum)
+	}
+	if !MeetsDifficulty(block.Hash, block.Difficulty) {
+		return errors.New("block hash does not meet difficulty")
+	}
+	if err := s.checkFutureTimestamp(block); err != nil {
+		return err
+	}
+
+	if err := s.pruneOrphans(ctx); err != nil {
+		return err
+	}
+
+	block.Status = models.BlockStatusOrphan
+	block.ChainWork = ""
+	return s.store.InsertBlock(ctx, block)
+}
+
+// minOrphanDifficulty returns the lowest difficulty an orphan may claim:
+// what the block after the tip requires, less one retarget step down, and
+// never below the configured minimum
+func (s *BlockchainService) minOrphanDifficulty(ctx context.Context) (int, error) {
+	minimum := s.difficulty.MinDifficulty
+	tip, err := s.store.GetLatestBlock(ctx)
+	if err == storage.ErrNotFound {
+		return minimum, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	next, err := s.NextDifficulty(ctx, tip)
+	if err != nil {
+		return 0, err
+	}
+	if next-s.difficulty.MaxStepDown > minimum {
+		minimum = next - s.difficulty.MaxStepDown
+	}
+	return minimum, nil
+}
+
+// pruneOrphans drops expired orphans, then the oldest ones until there is
+// room for another
+func (s *BlockchainService) pruneOrphans(ctx context.Context) error {
+	orphans, err := s.store.GetBlocksByStatus(ctx, models.BlockStatusOrphan)
+	if err != nil {
+		return err
+	}
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].CreatedAt.Before(orphans[j].CreatedAt)
+	})
+
+	expired := time.Now().Add(-s.limits.OrphanExpiry)
+	for i, orphan := range orphans {
+		keep := len(orphans) - i
+		fresh := s.limits.OrphanExpiry <= 0 || orphan.CreatedAt.After(expired)
+		if fresh && (s.limits.MaxOrphans <= 0 || keep < s.limits.MaxOrphans) {
+			break
+		}
+		if err := s.store.DeleteBlock(ctx, orphan.Hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// connectOrphans accepts stored orphans that build on block, recursively
+func (s *BlockchainService) connectOrphans(ctx context.Context, block *models.Block) error {
+	children, err := s.store.GetBlocksByPreviousHash(ctx, block.Hash)
+	if err != nil {
+		return err
+	}
+
+	for i := range children {
+		child := children[i]
+		if child.Status != models.BlockStatusOrphan {
+			continue
+		}
+
+		if err := s.acceptBlock(ctx, &child, block); err != nil {
+			if err := s.store.UpdateBlock(ctx, child.Hash, map[string]interface{}{"status": models.BlockStatusInvalid}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.connectOrphans(ctx, &child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// acceptBlock validates block against its known parent and places it on
+// the main chain or a side branch. Orphans being connected are updated in
+// place, new blocks are inserted.
+func (s *BlockchainService) acceptBlock(ctx context.Context, block, parent *models.Block) error {
+	if err := s.ValidateBlockHeader(ctx, block, parent); err != nil {
+		return err
+	}
+
+	wasOrphan := block.Status == models.BlockStatusOrphan
+	block.ChainWork = chainWorkAfter(parent, block.Difficulty)
+	block.Status = models.BlockStatusStale
+
+	save := func(ctx context.Context) error {
+		if wasOrphan {
+			return s.store.UpdateBlock(ctx, block.Hash, map[string]interface{}{
+				"status":     block.Status,
+				"chain_work": block.ChainWork,
+				"pending_op": block.PendingOp,
+			})
+		}
+		return s.store.InsertBlock(ctx, block)
+	}
+
+	tip, err := s.store.GetLatestBlock(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Extends the main chain. The block is saved along with its effects.
+	if parent.Hash == tip.Hash {
+		if err := s.checkBlockTransactions(ctx, block); err != nil {
+			return err
+		}
+		block.Status = models.BlockStatusMain
+		block.PendingOp = models.BlockOpConnect
+		return s.connectBlock(ctx, block, save)
+	}
+
+	if err := save(ctx); err != nil {
+		return err
+	}
+
+	// Side branch with more work than the main chain
+	if parseChainWork(block).Cmp(parseChainWork(tip)) > 0 {
+		return s.reorganize(ctx, tip, block)
+	}
+
+	return nil
+}
+
+// reorganize switches the main chain from oldTip to newTip. Blocks back to
+// the fork point are disconnected and the new branch is connected. If any
+// new block fails to connect, it is marked invalid and the old chain is
+// restored.
+func (s *BlockchainService) reorganize(ctx context.Context, oldTip, newTip *models.Block) error {
+	var connect, disconnect []*models.Block
+
+	a, b := newTip, oldTip
+	var err error
+	for a.Hash != b.Hash {
+		if a.Index >= b.Index {
+			connect = append([]*models.Block{a}, connect...)
+			if a, err = s.store.GetBlockByHash(ctx, a.PreviousHash); err != nil {
+				return err
+			}
+		} else {
+			disconnect = append(disconnect, b)
+			if b, err = s.store.GetBlockByHash(ctx, b.PreviousHash); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, block := range disconnect {
+		if err := s.disconnectBlock(ctx, block); err != nil {
+			return err
+		}
+	}
+
+	for i, block := range connect {
+		err := s.checkBlockTransactions(ctx, block)
+		if err == nil {
+			err = s.connectBlock(ctx, block, nil)
+		}
+		if err != nil {
+			for j := i - 1; j >= 0; j-- {
+				if rerr := s.disconnectBlock(ctx, connect[j]); rerr != nil {
+					return rerr
+				}
+			}
+			for j := len(disconnect) - 1; j >= 0; j-- {
+				if rerr := s.connectBlock(ctx, disconnect[j], nil); rerr != nil {
+					return rerr
+				}
+			}
+			for _, bad := range connect[i:] {
+				if rerr := s.store.UpdateBlock(ctx, bad.Hash, map[string]interface{}{"status": models.BlockStatusInvalid}); rerr != nil {
+					return rerr
+				}
+			}
+			return fmt.Errorf("reorganization aborted at block %d: %w", block.Index, err)
+		}
+	}
+
+	// Transactions only in the abandoned branch go back to pending,
+	// unless the new chain has spent their inputs
+	return s.rejectConflictingPending(ctx, disconnect)
+}
+
+// loadSpentOutputs reads the stored outputs spent by transactions into a
+// replay UTXO set
+func (s *BlockchainService) loadSpentOutputs(ctx context.Context, txs []models.Transaction) (map[string]*replayOutput, error) {
+	utxos := make(map[string]*replayOutput)
+	for _, tx := range txs {
+		for _, input := range tx.InputUTXOs {
+			utxo, err := s.store.GetUTXO(ctx, input.TxID, input.OutputIndex)
+			if err == storage.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			utxos[outpointKey(utxo.TxID, utxo.OutputIndex)] = &replayOutput{
+				walletID: utxo.WalletID,
+				amount:   utxo.Amount,
+				spent:    utxo.IsSpent,
+			}
+		}
+	}
+	return utxos, nil
+}
+
+// checkBlockTransactions applies the consensus rules used by ValidateChain
+// to a block about to be connected, starting from the stored UTXO set
+func (s *BlockchainService) checkBlockTransactions(ctx context.Context, block *models.Block) error {
+	for _, tx := range block.Transactions {
+		stored, err := s.store.GetTransaction(ctx, tx.TxID)
+		if err == nil && stored.Status == "confirmed" {
+			return ruleError(RuleDuplicateTx, tx.TxID, "transaction already included in an earlier block")
+		}
+		if err != nil && err != storage.ErrNotFound {
+			return err
+		}
+	}
+
+	utxos, err := s.loadSpentOutputs(ctx, block.Transactions)
+	if err != nil {
+		return err
+	}
+	allocations, err := s.loadAllocations(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.replayBlock(block, utxos, make(map[string]bool), allocations)
+}
+
+// connectBlock applies a block's transactions to the UTXO set and marks
+// them confirmed. Transactions first seen in this block are stored. save,
+// if not nil, stores the block itself first. The store writes are
+// committed atomically where the store supports it; otherwise the block
+// is marked as being connected until they complete, for RecoverChain.
+func (s *BlockchainService) connectBlock(ctx context.Context, block *models.Block, save func(context.Context) error) error {
+	err := s.store.RunAtomically(ctx, func(ctx context.Context) error {
+		if save != nil {
+			if err := save(ctx); err != nil {
+				return err
+			}
+		}
+		return s.writeConnectBlock(ctx, block)
+	})
+	if err != nil {
+		return err
+	}
+	block.Status = models.BlockStatusMain
+	block.PendingOp = ""
+
+	// Pending spends of the outputs this block consumed can never confirm
+	return s.setRejected(ctx, s.mempool.Confirm(block.Transactions))
+}
+
+func (s *BlockchainService) writeConnectBlock(ctx context.Context, block *models.Block) error {
+	if err := s.store.UpdateBlock(ctx, block.Hash, map[string]interface{}{"pending_op": models.BlockOpConnect}); err != nil {
+		return err
+	}
+
+	var txIDs []string
+	for i := range block.Transactions {
+		tx := block.Transactions[i]
+		txIDs = append(txIDs, tx.TxID)
+
+		if _, err := s.store.GetTransaction(ctx, tx.TxID); err == storage.ErrNotFound {
+			tx.ID = primitive.NewObjectID()
+			tx.Status = "confirmed"
+			tx.BlockHash = block.Hash
+			if err := s.store.InsertTransaction(ctx, &tx); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+
+		if err := s.applyTransaction(ctx, &tx, block.Hash); err != nil {
+			return err
+		}
+	}
+
+	if len(txIDs) > 0 {
+		if err := s.store.SetTransactionStatus(ctx, txIDs, "confirmed", block.Hash); err != nil {
+			return err
+		}
+	}
+
+	return s.store.UpdateBlock(ctx, block.Hash, map[string]interface{}{
+		"status":     models.BlockStatusMain,
+		"pending_op": "",
+	})
+}
+
+// disconnectBlock reverses connectBlock, newest transaction first, and
+// returns the block's transactions to the mempool
+func (s *BlockchainService) disconnectBlock(ctx context.Context, block *models.Block) error {
+	err := s.store.RunAtomically(ctx, func(ctx context.Context) error {
+		return s.writeDisconnectBlock(ctx, block)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mempool.Restore(block.Transactions)
+	return nil
+}
+
+func (s *BlockchainService) writeDisconnectBlock(ctx context.Context, block *models.Block) error {
+	if err := s.store.UpdateBlock(ctx, block.Hash, map[string]interface{}{"pending_op": models.BlockOpDisconnect}); err != nil {
+		return err
+	}
+
+	var txIDs []string
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+		txIDs = append(txIDs, tx.TxID)
+		if err := s.revertTransaction(ctx, &tx); err != nil {
+			return err
+		}
+	}
+
+	if len(txIDs) > 0 {
+		if err := s.store.SetTransactionStatus(ctx, txIDs, "pending", ""); err != nil {
+			return err
+		}
+	}
+
+	return s.store.UpdateBlock(ctx, block.Hash, map[string]interface{}{
+		"status":     models.BlockStatusStale,
+		"pending_op": "",
+	})
+}
+
+// applyTransaction spends a transaction's inputs, creates its outputs and
+// updates cached balances
+func (s *BlockchainService) applyTransaction(ctx context.Context, tx *models.Transaction, blockHash string) error {
+	// Mark input UTXOs as spent
+	for _, input := range tx.InputUTXOs {
+		if err := s.store.MarkUTXOSpent(ctx, input.TxID, input.OutputIndex, tx.TxID); err != nil {
+			return err
+		}
+	}
+
+	// Create output UTXOs
+	for _, output := range tx.OutputUTXOs {
+		if err := s.store.InsertUTXO(ctx, newOutputUTXO(tx, output, blockHash)); err != nil {
+			return err
+		}
+
+		// Update cached balance
+		if err := s.store.IncrementWalletBalance(ctx, output.WalletID, output.Amount); err != nil {
+			return err
+		}
+	}
+
+	// Update sender's cached balance (subtract spent amount)
+	if tx.SenderWalletID != "system" {
+		if err := s.store.IncrementWalletBalance(ctx, tx.SenderWalletID, -inputTotal(tx)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newOutputUTXO returns the unspent output created by a confirmed transaction
+func newOutputUTXO(tx *models.Transaction, output models.UTXOOutput, blockHash string) *models.UTXO {
+	return &models.UTXO{
+		ID:          primitive.NewObjectID(),
+		TxID:        tx.TxID,
+		OutputIndex: output.Index,
+		WalletID:    output.WalletID,
+		Amount:      output.Amount,
+		IsSpent:     false,
+		BlockHash:   blockHash,
+		CreatedAt:   time.Now(),
+	}
+}
+
+// revertTransaction undoes applyTransaction
+func (s *BlockchainService) revertTransaction(ctx context.Context, tx *models.Transaction) error {
+	for _, output := range tx.OutputUTXOs {
+		if err := s.store.IncrementWalletBalance(ctx, output.WalletID, -output.Amount); err != nil {
+			return err
+		}
+	}
+	if err := s.store.DeleteUTXOsByTx(ctx, tx.TxID); err != nil {
+		return err
+	}
+
+	for _, input := range tx.InputUTXOs {
+		if err := s.store.MarkUTXOUnspent(ctx, input.TxID, input.OutputIndex); err != nil {
+			return err
+		}
+	}
+
+	if tx.SenderWalletID != "system" {
+		if err := s.store.IncrementWalletBalance(ctx, tx.SenderWalletID, inputTotal(tx)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rejectConflictingPending marks transactions from disconnected blocks as
+// rejected when the new main chain has already spent one of their inputs.
+// Coinbases of disconnected blocks are always rejected.
+func (s *BlockchainService) rejectConflictingPending(ctx context.Context, disconnected []*models.Block) error {
+	var rejected []string
+	for _, block := range disconnected {
+		for _, tx := range block.Transactions {
+			stored, err := s.store.GetTransaction(ctx, tx.TxID)
+			if err != nil || stored.Status != "pending" {
+				continue
+			}
+			// A coinbase is only valid in the block that created it
+			if IsCoinbase(&tx) {
+				rejected = append(rejected, tx.TxID)
+				continue
+			}
+			for _, input := range tx.InputUTXOs {
+				utxo, err := s.store.GetUTXO(ctx, input.TxID, input.OutputIndex)
+				if err != nil || utxo.IsSpent {
+					rejected = append(rejected, tx.TxID)
+					break
+				}
+			}
+		}
+	}
+
+	return s.rejectTransactions(ctx, rejected)
+}
+
+// inputTotal sums the amounts of a transaction's inputs
+func inputTotal(tx *models.Transaction) models.Amount {
+	var total models.Amount
+	for _, input := range tx.InputUTXOs {
+		total += input.Amount
+	}
+	return total
+}
+
+// GetForkBlocks returns stale side-branch blocks and orphans for the explorer
+func (s *BlockchainService) GetForkBlocks(ctx context.Context) (stale, orphans []models.Block, err error) {
+	stale, err = s.store.GetBlocksByStatus(ctx, models.BlockStatusStale)
+	if err != nil {
+		return nil, nil, err
+	}
+	orphans, err = s.store.GetBlocksByStatus(ctx, models.BlockStatusOrphan)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stale, orphans, nil
+}
+
+// BackfillChainWork records status and cumulative work on main-chain blocks
+// stored before fork tracking existed
+func (s *BlockchainService) BackfillChainWork(ctx context.Context) error {
+	blocks, err := s.store.GetAllBlocks(ctx)
+	if err != nil {
+		return err
+	}
+
+	var parent *models.Block
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := &blocks[i]
+		if block.ChainWork == "" {
+			if parent == nil {
+				block.ChainWork = BlockWork(block.Difficulty).Text(16)
+			} else {
+				block.ChainWork = chainWorkAfter(parent, block.Difficulty)
+			}
+			err := s.store.UpdateBlock(ctx, block.Hash, map[string]interface{}{
+				"status":     models.BlockStatusMain,
+				"chain_work": block.ChainWork,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		parent = block
+	}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"backend/models"
+	"backend/storage"
+)
+
+// newTestChain returns a blockchain on an in-memory store with its
+// genesis block, mining at the lowest difficulty
+func newTestChain(t *testing.T) (*BlockchainService, storage.ChainStore) {
+	t.Helper()
+	store := storage.NewMemoryStore()
+	cfg := DefaultDifficultyConfig()
+	cfg.InitialDifficulty = 1
+	bc := NewBlockchainService(store, cfg, DefaultSubsidyConfig(), DefaultBlockLimits(), DefaultMempoolConfig(), DefaultNetworkConfig())
+	if err := bc.InitializeGenesisBlock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return bc, store
+}
+
+// nextBlock builds and mines a block holding txs after a coinbase paying
+// the subsidy and their fees to miner
+func nextBlock(t *testing.T, bc *BlockchainService, parent *models.Block, miner string, timestamp time.Time, txs ...models.Transaction) *models.Block {
+	t.Helper()
+	ctx := context.Background()
+	difficulty, err := bc.NextDifficulty(ctx, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := &models.Block{
+		Version:      CurrentBlockVersion,
+		Index:        parent.Index + 1,
+		Timestamp:    timestamp,
+		PreviousHash: parent.Hash,
+		Difficulty:   difficulty,
+		Miner:        miner,
+	}
+	var fees models.Amount
+	for _, tx := range txs {
+		fees += tx.Fee
+	}
+	block.Transactions = append([]models.Transaction{*bc.NewCoinbase(block.Index, miner, fees)}, txs...)
+	block.MerkleRoot = bc.CalculateMerkleRoot(block.Transactions)
+	if _, err := bc.ProofOfWork(ctx, block, PoWConfig{Workers: 1, NonceSpace: 1 << 20}, nil); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestAddBlockRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	bc, store := newTestChain(t)
+
+	genesis, err := bc.GetLatestBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := nextBlock(t, bc, genesis, "miner", genesis.Timestamp.Add(time.Minute))
+	if err := bc.AddBlock(ctx, block); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.GetBlockByHash(ctx, block.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Index != 1 || got.PreviousHash != genesis.Hash || got.MerkleRoot != block.MerkleRoot || got.Status != models.BlockStatusMain {
+		t.Fatalf("stored block = %+v, want %+v on the main chain", got, block)
+	}
+	if latest, err := bc.GetLatestBlock(ctx); err != nil || latest.Hash != block.Hash {
+		t.Fatalf("GetLatestBlock = %v, %v; want the new block", latest, err)
+	}
+
+	coinbase := block.Transactions[0]
+	if tx, err := store.GetTransaction(ctx, coinbase.TxID); err != nil || tx.Status != "confirmed" || tx.BlockHash != block.Hash {
+		t.Fatalf("coinbase = %+v, %v; want confirmed in the block", tx, err)
+	}
+	utxos, err := store.GetUnspentUTXOs(ctx, "miner")
+	if err != nil || len(utxos) != 1 || utxos[0].Amount != bc.GetSubsidyConfig().BlockSubsidy(1) {
+		t.Fatalf("miner outputs = %+v, %v; want the block subsidy", utxos, err)
+	}
+
+	if err := bc.AddBlock(ctx, block); err != ErrBlockKnown {
+		t.Fatalf("adding the block again: err = %v, want ErrBlockKnown", err)
+	}
+	report, err := bc.ValidateChain(ctx)
+	if err != nil || !report.Valid {
+		t.Fatalf("ValidateChain = %+v, %v", report, err)
+	}
+}
+
+func TestValidateBlockHeaderTimestamps(t *testing.T) {
+	ctx := context.Background()
+	bc, _ := newTestChain(t)
+
+	// Three blocks a minute apart after genesis
+	parent, err := bc.GetLatestBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis := parent.Timestamp
+	for i := 1; i <= 3; i++ {
+		block := nextBlock(t, bc, parent, "miner", genesis.Add(time.Duration(i)*time.Minute))
+		if err := bc.AddBlock(ctx, block); err != nil {
+			t.Fatal(err)
+		}
+		parent = block
+	}
+	median, err := bc.MedianTimePast(ctx, parent)
+	if err != nil || median.UnixMilli() != genesis.Add(2*time.Minute).UnixMilli() {
+		t.Fatalf("MedianTimePast = %v, %v; want the third of four timestamps", median, err)
+	}
+
+	tests := []struct {
+		name      string
+		timestamp time.Time
+		rule      string
+	}{
+		{"after the median", median.Add(time.Millisecond), ""},
+		{"older than the parent but after the median", parent.Timestamp.Add(-30 * time.Second), ""},
+		{"at the median", median, RuleTimestamp},
+		{"before the median", median.Add(-time.Minute), RuleTimestamp},
+		{"too far in the future", time.Now().Add(DefaultDifficultyConfig().MaxFutureDrift + time.Minute), RuleTimestamp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := nextBlock(t, bc, parent, "miner", tt.timestamp)
+			if got := ruleOf(bc.ValidateBlockHeader(ctx, block, parent)); got != tt.rule {
+				t.Fatalf("rule = %q, want %q", got, tt.rule)
+			}
+		})
+	}
+}
+
+func TestLegacyChainPredatesRetargeting(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	cfg := DefaultDifficultyConfig()
+	cfg.InitialDifficulty = 1
+	cfg.LegacyDifficulty = 1
+	bc := NewBlockchainService(store, cfg, DefaultSubsidyConfig(), DefaultBlockLimits(), DefaultMempoolConfig(), DefaultNetworkConfig())
+
+	// Version 0 blocks a second apart, faster than any retarget allows,
+	// with one timestamp repeated
+	start := time.Unix(1600000000, 0).UTC()
+	var parent *models.Block
+	for i := int64(0); i <= cfg.AdjustmentWindow+2; i++ {
+		block := &models.Block{
+			Index:        i,
+			Timestamp:    start.Add(time.Duration(i) * time.Second),
+			PreviousHash: "0",
+			Difficulty:   cfg.LegacyDifficulty,
+			Miner:        "miner",
+			Status:       models.BlockStatusMain,
+		}
+		if parent != nil {
+			block.PreviousHash = parent.Hash
+			block.ChainWork = chainWorkAfter(parent, block.Difficulty)
+		} else {
+			block.ChainWork = BlockWork(block.Difficulty).Text(16)
+		}
+		if i == 5 {
+			block.Timestamp = parent.Timestamp
+		}
+		block.MerkleRoot = BlockMerkleRoot(block)
+		for block.Hash = BlockHash(block); !MeetsDifficulty(block.Hash, block.Difficulty); block.Hash = BlockHash(block) {
+			block.Nonce++
+		}
+		if parent != nil && block.Index == cfg.AdjustmentWindow {
+			if next, err := bc.NextDifficulty(ctx, parent); err != nil || next == block.Difficulty {
+				t.Fatalf("NextDifficulty = %d, %v; want a retarget the legacy block does not match", next, err)
+			}
+		}
+		if err := store.InsertBlock(ctx, block); err != nil {
+			t.Fatal(err)
+		}
+		parent = block
+	}
+
+	report, err := bc.ValidateChain(ctx)
+	if err != nil || !report.Valid {
+		t.Fatalf("ValidateChain = %+v, %v; want the legacy chain valid", report, err)
+	}
+
+	// Blocks of the current version follow the new rules
+	block := nextBlock(t, bc, parent, "miner", parent.Timestamp.Add(time.Minute))
+	if err := bc.AddBlock(ctx, block); err != nil {
+		t.Fatal(err)
+	}
+	stale := nextBlock(t, bc, block, "miner", start)
+	if got := ruleOf(bc.ValidateBlockHeader(ctx, stale, block)); got != RuleTimestamp {
+		t.Fatalf("rule = %q, want %q", got, RuleTimestamp)
+	}
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"sort"
+
+	"backend/models"
+)
+
+// Coin selection strategies
+const (
+	CoinSelectLargestFirst   = "largest-first"
+	CoinSelectSmallestFirst  = "smallest-first"
+	CoinSelectBranchAndBound = "branch-and-bound"
+	CoinSelectPrivacy        = "privacy"
+)
+
+// bnbMaxTries bounds the branch-and-bound search
+const bnbMaxTries = 100000
+
+var (
+	ErrUnknownCoinSelection = errors.New("unknown coin selection strategy")
+	errSelectionShort       = errors.New("outputs do not cover the target")
+	errNoExactMatch         = errors.New("no input set matches the target without change")
+)
+
+// CoinSelectionConfig sets the default strategy and the change below which
+// no change output is made
+type CoinSelectionConfig struct {
+	Strategy      string
+	DustThreshold models.Amount // change up to this amount is added to the fee
+}
+
+// DefaultCoinSelectionConfig returns the coin selection defaults
+func DefaultCoinSelectionConfig() CoinSelectionConfig {
+	return CoinSelectionConfig{
+		Strategy:      CoinSelectBranchAndBound,
+		DustThreshold: models.Coin / 10000,
+	}
+}
+
+// CoinSelectionConfigFromEnv returns the defaults overridden by environment variables
+func CoinSelectionConfigFromEnv() CoinSelectionConfig {
+	cfg := DefaultCoinSelectionConfig()
+	if strategy := os.Getenv("COIN_SELECTION_STRATEGY"); strategy != "" {
+		cfg.Strategy = strategy
+	}
+	cfg.DustThreshold = models.AmountFromFloat(envFloat("COIN_SELECTION_DUST_THRESHOLD", cfg.DustThreshold.Float64()))
+	return cfg
+}
+
+// SelectionParams describes what a selection must pay for. An output's
+// effective value is its amount less InputCost, the fee its input adds.
+type SelectionParams struct {
+	Target        models.Amount // amount plus the fee of the transaction without inputs
+	InputCost     models.Amount // fee added by each input
+	ChangeCost    models.Amount // fee added by a change output
+	DustThreshold models.Amount // change up to this amount is not worth an output
+}
+
+// CoinSelector chooses which unspent outputs fund a payment
+type CoinSelector interface {
+	SelectCoins(utxos []models.UTXO, params SelectionParams) ([]models.UTXO, error)
+}
+
+// NewCoinSelector returns the selector for a strategy name
+func NewCoinSelector(strategy string) (CoinSelector, error) {
+	switch strategy {
+	case CoinSelectLargestFirst:
+		return LargestFirstSelector{}, nil
+	case CoinSelectSmallestFirst:
+		return SmallestFirstSelector{}, nil
+	case CoinSelectBranchAndBound:
+		return BranchAndBoundSelector{Fallback: LargestFirstSelector{}}, nil
+	case CoinSelectPrivacy:
+		return PrivacySelector{}, nil
+	}
+	return nil, ErrUnknownCoinSelection
+}
+
+// LargestFirstSelector spends the biggest outputs first, using few inputs
+type LargestFirstSelector struct{}
+
+// SelectCoins implements CoinSelector
+func (LargestFirstSelector) SelectCoins(utxos []models.UTXO, params SelectionParams) ([]models.UTXO, error) {
+	sorted := append([]models.UTXO(nil), utxos...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Amount > sorted[j].Amount })
+	return accumulate(sorted, params)
+}
+
+// SmallestFirstSelector spends the smallest outputs first, consolidating
+// fragmented wallets at the cost of larger transactions
+type SmallestFirstSelector struct{}
+
+// SelectCoins implements CoinSelector
+func (SmallestFirstSelector) SelectCoins(utxos []models.UTXO, params SelectionParams) ([]models.UTXO, error) {
+	sorted := append([]models.UTXO(nil), utxos...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Amount < sorted[j].Amount })
+	return accumulate(sorted, params)
+}
+
+// BranchAndBoundSelector searches for inputs that pay the target exactly,
+// give or take what a change output would cost plus the dust threshold,
+// so that no change output is needed. Among matches it keeps the one that
+// overpays least. Without a match it defers to Fallback.
+type BranchAndBoundSelector struct {
+	Fallback CoinSelector
+}
+
+// SelectCoins implements CoinSelector
+func (b BranchAndBoundSelector) SelectCoins(utxos []models.UTXO, params SelectionParams) ([]models.UTXO, error) {
+	selected, err := branchAndBound(utxos, params)
+	if err == errNoExactMatch && b.Fallback != nil {
+		return b.Fallback.SelectCoins(utxos, params)
+	}
+	return selected, err
+}
+
+func branchAndBound(utxos []models.UTXO, params SelectionParams) ([]models.UTXO, error) {
+	// Only outputs worth more than they cost to spend can help
+	var coins []models.UTXO
+	var remaining models.Amount
+	for _, utxo := range utxos {
+		if value := utxo.Amount - params.InputCost; value > 0 {
+			coins = append(coins, utxo)
+			remaining += value
+		}
+	}
+	sort.SliceStable(coins, func(i, j int) bool { return coins[i].Amount > coins[j].Amount })
+
+	upper := params.Target + params.ChangeCost + params.DustThreshold
+	var current, best []int
+	bestWaste := models.MaxAmount
+	tries := 0
+
+	var explore func(i int, value, remaining models.Amount)
+	explore = func(i int, value, remaining models.Amount) {
+		tries++
+		if tries > bnbMaxTries || value > upper {
+			return
+		}
+		if value >= params.Target {
+			// Adding coins only overpays more
+			if waste := value - params.Target; waste < bestWaste {
+				bestWaste = waste
+				best = append(best[:0], current...)
+			}
+			return
+		}
+		if i == len(coins) || value+remaining < params.Target {
+			return
+		}
+
+		coinValue := coins[i].Amount - params.InputCost
+		current = append(current, i)
+		explore(i+1, value+coinValue, remaining-coinValue)
+		current = current[:len(current)-1]
+		explore(i+1, value, remaining-coinValue)
+	}
+	explore(0, 0, remaining)
+
+	if best == nil {
+		return nil, errNoExactMatch
+	}
+	selected := make([]models.UTXO, 0, len(best))
+	for _, i := range best {
+		selected = append(selected, coins[i])
+	}
+	return selected, nil
+}
+
+// PrivacySelector avoids linking a wallet's outputs together. It spends a
+// single output when one covers the target, the smallest that does, and
+// otherwise adds outputs in random order until the target is covered.
+type PrivacySelector struct{}
+
+// SelectCoins implements CoinSelector
+func (PrivacySelector) SelectCoins(utxos []models.UTXO, params SelectionParams) ([]models.UTXO, error) {
+	var single *models.UTXO
+	for i := range utxos {
+		if utxos[i].Amount-params.InputCost >= params.Target {
+			if single == nil || utxos[i].Amount < single.Amount {
+				single = &utxos[i]
+			}
+		}
+	}
+	if single != nil {
+		return []models.UTXO{*single}, nil
+	}
+
+	shuffled := append([]models.UTXO(nil), utxos...)
+	rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	return accumulate(shuffled, params)
+}
+
+// accumulate takes outputs in order until their effective value covers the target
+func accumulate(utxos []models.UTXO, params SelectionParams) ([]models.UTXO, error) {
+	var selected []models.UTXO
+	var value models.Amount
+	for _, utxo := range utxos {
+		if value >= params.Target {
+			break
+		}
+		if utxo.Amount <= params.InputCost {
+			continue // costs more to spend than it is worth
+		}
+		selected = append(selected, utxo)
+		value += utxo.Amount - params.InputCost
+	}
+	if value < params.Target {
+		return nil, errSelectionShort
+	}
+	return selected, nil
+}
@@ -1,74 +1,21 @@
 package services
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/sha256"
-	"encoding/base64"
 	"encoding/hex"
 	"errors"
-	"io"
-	"math/big"
-	"os"
+	"fmt"
+	"time"
+
+	"backend/models"
 )
 
-type CryptoService struct {
-	aesKey []byte
-}
+// CryptoService verifies transactions with the signature scheme of their
+// sender's key. Keys are encrypted for storage by a KeyVault.
+type CryptoService struct{}
 
 func NewCryptoService() *CryptoService {
-	key := os.Getenv("AES_KEY")
-	if len(key) < 32 {
-		key = "default-32-byte-key-for-aes-enc!"
-	}
-	return &CryptoService{aesKey: []byte(key[:32])}
-}
-
-// GenerateKeyPair generates ECDSA key pair
-func (s *CryptoService) GenerateKeyPair() (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
-	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
-		return nil, nil, err
-	}
-	return privateKey, &privateKey.PublicKey, nil
-}
-
-// PublicKeyToString converts public key to hex string
-func (s *CryptoService) PublicKeyToString(pub *ecdsa.PublicKey) string {
-	return hex.EncodeToString(elliptic.Marshal(pub.Curve, pub.X, pub.Y))
-}
-
-// PrivateKeyToString converts private key to hex string
-func (s *CryptoService) PrivateKeyToString(priv *ecdsa.PrivateKey) string {
-	return hex.EncodeToString(priv.D.Bytes())
-}
-
-// StringToPublicKey converts hex string to public key
-func (s *CryptoService) StringToPublicKey(pubStr string) (*ecdsa.PublicKey, error) {
-	pubBytes, err := hex.DecodeString(pubStr)
-	if err != nil {
-		return nil, err
-	}
-	x, y := elliptic.Unmarshal(elliptic.P256(), pubBytes)
-	if x == nil {
-		return nil, errors.New("invalid public key")
-	}
-	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
-}
-
-// StringToPrivateKey converts hex string to private key
-func (s *CryptoService) StringToPrivateKey(privStr string, pubKey *ecdsa.PublicKey) (*ecdsa.PrivateKey, error) {
-	privBytes, err := hex.DecodeString(privStr)
-	if err != nil {
-		return nil, err
-	}
-	priv := new(ecdsa.PrivateKey)
-	priv.PublicKey = *pubKey
-	priv.D = new(big.Int).SetBytes(privBytes)
-	return priv, nil
+	return &CryptoService{}
 }
 
 // GenerateWalletID creates wallet ID from public key hash
@@ -77,78 +24,84 @@ func (s *CryptoService) GenerateWalletID(publicKey string) string {
 	return hex.EncodeToString(hash[:])[:40] // 40 char wallet ID
 }
 
-// SignData signs data with private key
-func (s *CryptoService) SignData(privateKey *ecdsa.PrivateKey, data string) (string, error) {
-	hash := sha256.Sum256([]byte(data))
-	r, ss, err := ecdsa.Sign(rand.Reader, privateKey, hash[:])
-	if err != nil {
-		return "", err
+// TransactionSigningPayload returns the string a sender signs for a
+// transaction. From version 2 the payload separates its fields and covers
+// the chain ID, fee, nonce and the client's timestamp in milliseconds.
+// From version 3 it is the canonical encoding without the signature.
+func TransactionSigningPayload(tx *models.Transaction) string {
+	if tx.Version >= SignedEncodingTransactionVersion {
+		unsigned := *tx
+		unsigned.Signature = ""
+		return string(EncodeTransaction(&unsigned))
 	}
-	signature := append(r.Bytes(), ss.Bytes()...)
-	return hex.EncodeToString(signature), nil
-}
-
-// VerifySignature verifies signature with public key
-func (s *CryptoService) VerifySignature(publicKey *ecdsa.PublicKey, data string, signatureHex string) bool {
-	hash := sha256.Sum256([]byte(data))
-	sigBytes, err := hex.DecodeString(signatureHex)
-	if err != nil || len(sigBytes) != 64 {
-		return false
+	if tx.Version >= ReplayProtectedTransactionVersion {
+		return fmt.Sprintf("%s|%d|%s|%s|%s|%s|%d|%d|%s",
+			tx.ChainID,
+			tx.Version,
+			tx.SenderWalletID,
+			tx.ReceiverWalletID,
+			payloadAmount(tx, 0, tx.Amount),
+			payloadAmount(tx, 1, tx.Fee),
+			tx.Nonce,
+			tx.Timestamp.UnixMilli(),
+			tx.Note,
+		)
 	}
-	r := new(big.Int).SetBytes(sigBytes[:32])
-	ss := new(big.Int).SetBytes(sigBytes[32:])
-	return ecdsa.Verify(publicKey, hash[:], r, ss)
+	return fmt.Sprintf("%s%s%s%s%s",
+		tx.SenderWalletID,
+		tx.ReceiverWalletID,
+		payloadAmount(tx, 0, tx.Amount),
+		tx.Timestamp.Format(time.RFC3339),
+		tx.Note,
+	)
 }
 
-// EncryptPrivateKey encrypts private key with AES
-func (s *CryptoService) EncryptPrivateKey(privateKey string) (string, error) {
-	block, err := aes.NewCipher(s.aesKey)
-	if err != nil {
-		return "", err
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return "", err
-	}
-
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return "", err
+// payloadAmount formats the i-th amount of a text signing payload with 8
+// decimal places, from the exact float a legacy transaction signed
+func payloadAmount(tx *models.Transaction, i int, amount models.Amount) string {
+	if tx.LegacyAmounts != nil {
+		return fmt.Sprintf("%.8f", legacyAmount(tx, i, amount))
 	}
+	return amount.String()
+}
 
-	ciphertext := gcm.Seal(nonce, nonce, []byte(privateKey), nil)
-	return base64.StdEncoding.EncodeToString(ciphertext), nil
+// TransactionSigningHash returns the SHA-256 digest that a sender's
+// signature commits to
+func TransactionSigningHash(tx *models.Transaction) []byte {
+	hash := sha256.Sum256([]byte(TransactionSigningPayload(tx)))
+	return hash[:]
 }
 
-// DecryptPrivateKey decrypts private key with AES
-func (s *CryptoService) DecryptPrivateKey(encrypted string) (string, error) {
-	ciphertext, err := base64.StdEncoding.DecodeString(encrypted)
-	if err != nil {
-		return "", err
-	}
+// RequiresSignature reports whether a transaction must carry a sender
+// signature. Only coinbases, which blocks may carry solely as their first
+// transaction, and zakat deductions, which consensus limits to the zakat
+// on their inputs, do not.
+func RequiresSignature(tx *models.Transaction) bool {
+	return !IsCoinbase(tx) && !IsZakatDeduction(tx)
+}
 
-	block, err := aes.NewCipher(s.aesKey)
-	if err != nil {
-		return "", err
+// VerifyTransactionSignature checks the sender's signature on a transaction
+func (s *CryptoService) VerifyTransactionSignature(tx *models.Transaction) error {
+	if !RequiresSignature(tx) {
+		return nil
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	// The public key names its scheme, and the wallet ID commits to the key
+	pubKey, err := PublicKeyFromHex(tx.SenderPublicKey)
 	if err != nil {
-		return "", err
+		return errors.New("invalid public key")
 	}
 
-	if len(ciphertext) < gcm.NonceSize() {
-		return "", errors.New("ciphertext too short")
+	if s.GenerateWalletID(tx.SenderPublicKey) != tx.SenderWalletID {
+		return errors.New("public key does not match sender wallet")
 	}
 
-	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
-	if err != nil {
-		return "", err
+	signature, err := hex.DecodeString(tx.Signature)
+	if err != nil || !pubKey.Verify([]byte(TransactionSigningPayload(tx)), signature) {
+		return errors.New("invalid digital signature")
 	}
 
-	return string(plaintext), nil
+	return nil
 }
 
 // HashSHA256 computes SHA-256 hash
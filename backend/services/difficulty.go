@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"backend/models"
+)
+
+// DifficultyConfig controls proof-of-work difficulty retargeting.
+// Difficulty is the number of leading hex zeros a block hash must have,
+// so every step up makes mining 16 times harder.
+type DifficultyConfig struct {
+	InitialDifficulty int           // difficulty of genesis and of the first window
+	MinDifficulty     int           // lower bound after retargeting
+	MaxDifficulty     int           // upper bound after retargeting
+	TargetBlockTime   time.Duration // desired interval between blocks
+	AdjustmentWindow  int64         // retarget every this many blocks
+	MaxStepUp         int           // largest increase per retarget
+	MaxStepDown       int           // largest decrease per retarget
+	MedianTimeSpan    int64         // blocks whose median timestamp a new block must exceed
+	MaxFutureDrift    time.Duration // how far ahead of the clock a block's timestamp may be
+	LegacyDifficulty  int           // fixed difficulty of version 0 blocks, mined before retargeting
+}
+
+// DefaultDifficultyConfig returns the retargeting defaults
+func DefaultDifficultyConfig() DifficultyConfig {
+	return DifficultyConfig{
+		InitialDifficulty: 5, // Hash must start with 5 zeros
+		MinDifficulty:     1,
+		MaxDifficulty:     16,
+		TargetBlockTime:   time.Minute,
+		AdjustmentWindow:  10,
+		MaxStepUp:         1,
+		MaxStepDown:       1,
+		MedianTimeSpan:    11,
+		MaxFutureDrift:    10 * time.Minute,
+		LegacyDifficulty:  5,
+	}
+}
+
+// DifficultyConfigFromEnv returns the defaults overridden by environment variables
+func DifficultyConfigFromEnv() DifficultyConfig {
+	cfg := DefaultDifficultyConfig()
+	cfg.InitialDifficulty = envInt("DIFFICULTY_INITIAL", cfg.InitialDifficulty)
+	cfg.MinDifficulty = envInt("DIFFICULTY_MIN", cfg.MinDifficulty)
+	cfg.MaxDifficulty = envInt("DIFFICULTY_MAX", cfg.MaxDifficulty)
+	cfg.TargetBlockTime = time.Duration(envInt("DIFFICULTY_TARGET_BLOCK_SECONDS", int(cfg.TargetBlockTime/time.Second))) * time.Second
+	cfg.AdjustmentWindow = int64(envInt("DIFFICULTY_WINDOW", int(cfg.AdjustmentWindow)))
+	cfg.MaxStepUp = envInt("DIFFICULTY_MAX_STEP_UP", cfg.MaxStepUp)
+	cfg.MaxStepDown = envInt("DIFFICULTY_MAX_STEP_DOWN", cfg.MaxStepDown)
+	cfg.MedianTimeSpan = int64(envInt("DIFFICULTY_MEDIAN_TIME_BLOCKS", int(cfg.MedianTimeSpan)))
+	cfg.MaxFutureDrift = time.Duration(envInt("DIFFICULTY_MAX_FUTURE_SECONDS", int(cfg.MaxFutureDrift/time.Second))) * time.Second
+	cfg.LegacyDifficulty = envInt("DIFFICULTY_LEGACY", cfg.LegacyDifficulty)
+	return cfg
+}
+
+// envInt reads an integer environment variable, falling back to def
+func envInt(key string, def int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// NextDifficulty returns the difficulty required of the block built on parent.
+// Difficulty only changes at multiples of the adjustment window, by comparing
+// how long the last window took against the target interval.
+func (s *BlockchainService) NextDifficulty(ctx context.Context, parent *models.Block) (int, error) {
+	cfg := s.difficulty
+	current := parent.Difficulty
+	if current == 0 {
+		current = cfg.InitialDifficulty
+	}
+
+	height := parent.Index + 1
+	if cfg.AdjustmentWindow <= 0 || height%cfg.AdjustmentWindow != 0 {
+		return current, nil
+	}
+
+	// Walk back along the chain to the start of the window
+	first := parent
+	for i := int64(0); i < cfg.AdjustmentWindow && first.Index > 0; i++ {
+		prev, err := s.store.GetBlockByHash(ctx, first.PreviousHash)
+		if err != nil {
+			return 0, err
+		}
+		first = prev
+	}
+
+	intervals := parent.Index - first.Index
+	if intervals <= 0 {
+		return current, nil
+	}
+
+	return s.retarget(current, parent.Timestamp.Sub(first.Timestamp), intervals), nil
+}
+
+// requiredDifficulty returns the difficulty block must carry. Version 0
+// blocks predate retargeting and were all mined at LegacyDifficulty.
+func (s *BlockchainService) requiredDifficulty(ctx context.Context, block, parent *models.Block) (int, error) {
+	if block.Version < BinaryHeaderBlockVersion {
+		return s.difficulty.LegacyDifficulty, nil
+	}
+	return s.NextDifficulty(ctx, parent)
+}
+
+// MedianTimePast returns the median timestamp of parent and the blocks
+// before it, up to MedianTimeSpan of them. A block built on parent must be
+// newer, so a miner cannot hold timestamps back to lower the difficulty.
+func (s *BlockchainService) MedianTimePast(ctx context.Context, parent *models.Block) (time.Time, error) {
+	times := []time.Time{parent.Timestamp}
+	block := parent
+	for int64(len(times)) < s.difficulty.MedianTimeSpan && block.Index > 0 {
+		prev, err := s.store.GetBlockByHash(ctx, block.PreviousHash)
+		if err != nil {
+			return time.Time{}, err
+		}
+		block = prev
+		times = append(times, block.Timestamp)
+	}
+
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times[len(times)/2], nil
+}
+
+// checkTimestamp rejects blocks not newer than the median time past, and
+// blocks dated further ahead of the clock than MaxFutureDrift. Version 0
+// blocks predate both rules.
+func (s *BlockchainService) checkTimestamp(ctx context.Context, block, parent *models.Block) error {
+	if block.Version < BinaryHeaderBlockVersion {
+		return nil
+	}
+	if err := s.checkFutureTimestamp(block); err != nil {
+		return err
+	}
+
+	median, err := s.MedianTimePast(ctx, parent)
+	if err != nil {
+		return err
+	}
+	// Headers carry timestamps to the millisecond
+	if block.Timestamp.UnixMilli() <= median.UnixMilli() {
+		return ruleError(RuleTimestamp, "", fmt.Sprintf("block timestamp %s is not after the median time past %s", block.Timestamp.UTC().Format(time.RFC3339), median.UTC().Format(time.RFC3339)))
+	}
+	return nil
+}
+
+// checkFutureTimestamp rejects blocks dated further ahead of the clock
+// than MaxFutureDrift
+func (s *BlockchainService) checkFutureTimestamp(block *models.Block) error {
+	if limit := time.Now().Add(s.difficulty.MaxFutureDrift); block.Timestamp.After(limit) {
+		return ruleError(RuleTimestamp, "", fmt.Sprintf("block timestamp %s is too far in the future", block.Timestamp.UTC().Format(time.RFC3339)))
+	}
+	return nil
+}
+
+// retarget adjusts difficulty by the base-16 log of expected/actual time,
+// rounded and clamped to the configured steps and bounds
+func (s *BlockchainService) retarget(current int, actual time.Duration, intervals int64) int {
+	cfg := s.difficulty
+	if actual < time.Second {
+		actual = time.Second
+	}
+	expected := cfg.TargetBlockTime * time.Duration(intervals)
+
+	step := int(math.Round(math.Log(float64(expected)/float64(actual)) / math.Log(16)))
+	if step > cfg.MaxStepUp {
+		step = cfg.MaxStepUp
+	}
+	if step < -cfg.MaxStepDown {
+		step = -cfg.MaxStepDown
+	}
+
+	next := current + step
+	if next < cfg.MinDifficulty {
+		next = cfg.MinDifficulty
+	}
+	if next > cfg.MaxDifficulty {
+		next = cfg.MaxDifficulty
+	}
+	return next
+}
@@ -0,0 +1,447 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"backend/models"
+)
+
+// Block and transaction format versions.
+//
+// Version 0 is the legacy format: block hashes are computed over a
+// fmt.Sprintf concatenation of the header fields and tx IDs are random.
+// Existing version 0 blocks stay valid under their original rules, but a
+// chain may not go back to version 0 once a version 1 block is accepted.
+//
+// Version 1 hashes a canonical, length-prefixed binary encoding and derives
+// tx IDs from the encoded transaction.
+//
+// Version 2 builds the merkle root with domain-separated leaf and inner
+// node hashes, which makes inclusion proofs safe to verify.
+//
+// Version 2 transactions also encode the sender's nonce and the chain ID,
+// and sign both along with the client's timestamp.
+//
+// Version 3 transactions are signed over their canonical encoding with the
+// signature left empty, so the signature also covers inputs and outputs.
+//
+// Version 4 transactions encode amounts as int64 base units. Earlier
+// versions encode them as float64 coins, using the transaction's legacy
+// amounts where rounding to base units lost precision.
+const (
+	LegacyBlockVersion                = 0
+	BinaryHeaderBlockVersion          = 1
+	TaggedMerkleBlockVersion          = 2
+	CurrentBlockVersion               = TaggedMerkleBlockVersion
+	LegacyTransactionVersion          = 0
+	BinaryTransactionVersion          = 1
+	ReplayProtectedTransactionVersion = 2
+	SignedEncodingTransactionVersion  = 3
+	FixedPointTransactionVersion      = 4
+	CurrentTransactionVersion         = FixedPointTransactionVersion
+)
+
+// maxEncodedItems bounds list lengths read by the decoder
+const maxEncodedItems = 1 << 20
+
+var errMalformedEncoding = errors.New("malformed encoding")
+
+// encoder writes fixed-width big-endian integers and uvarint
+// length-prefixed strings so that fields can never run together
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) uint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) int64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) float64(v float64) {
+	e.int64(int64(math.Float64bits(v)))
+}
+
+func (e *encoder) time(t time.Time) {
+	e.int64(t.UnixMilli())
+}
+
+func (e *encoder) length(n int) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf.Write(b[:binary.PutUvarint(b[:], uint64(n))])
+}
+
+func (e *encoder) bytes(v []byte) {
+	e.length(len(v))
+	e.buf.Write(v)
+}
+
+func (e *encoder) string(v string) {
+	e.bytes([]byte(v))
+}
+
+// decoder is the inverse of encoder. The first error sticks and
+// every later read returns zero values.
+type decoder struct {
+	r   *bytes.Reader
+	err error
+}
+
+func newDecoder(data []byte) *decoder {
+	return &decoder{r: bytes.NewReader(data)}
+}
+
+func (d *decoder) read(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > d.r.Len() {
+		d.err = errMalformedEncoding
+		return nil
+	}
+	b := make([]byte, n)
+	d.r.Read(b)
+	return b
+}
+
+func (d *decoder) uint32() uint32 {
+	b := d.read(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *decoder) int64() int64 {
+	b := d.read(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (d *decoder) float64() float64 {
+	return math.Float64frombits(uint64(d.int64()))
+}
+
+func (d *decoder) time() time.Time {
+	return time.UnixMilli(d.int64()).UTC()
+}
+
+func (d *decoder) length() int {
+	if d.err != nil {
+		return 0
+	}
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil || n > uint64(d.r.Len()) {
+		d.err = errMalformedEncoding
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) count() int {
+	n := d.length()
+	if n > maxEncodedItems {
+		d.err = errMalformedEncoding
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) bytes() []byte {
+	return d.read(d.length())
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+// finish reports any decoding error, including unread trailing bytes
+func (d *decoder) finish() error {
+	if d.err == nil && d.r.Len() != 0 {
+		d.err = errMalformedEncoding
+	}
+	return d.err
+}
+
+// EncodeBlockHeader returns the canonical header encoding that is hashed.
+// The nonce is always the final 8 bytes so a miner can rewrite it in place.
+func EncodeBlockHeader(block *models.Block) []byte {
+	var e encoder
+	e.uint32(uint32(block.Version))
+	e.int64(block.Index)
+	e.time(block.Timestamp)
+	e.string(block.PreviousHash)
+	e.string(block.MerkleRoot)
+	e.uint32(uint32(block.Difficulty))
+	e.string(block.Miner)
+	e.int64(block.Nonce)
+	return e.buf.Bytes()
+}
+
+// DecodeBlockHeader parses a canonical header into a block without transactions
+func DecodeBlockHeader(data []byte) (*models.Block, error) {
+	d := newDecoder(data)
+	block := decodeHeader(d)
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+func decodeHeader(d *decoder) *models.Block {
+	return &models.Block{
+		Version:      int(d.uint32()),
+		Index:        d.int64(),
+		Timestamp:    d.time(),
+		PreviousHash: d.string(),
+		MerkleRoot:   d.string(),
+		Difficulty:   int(d.uint32()),
+		Miner:        d.string(),
+		Nonce:        d.int64(),
+	}
+}
+
+// EncodeTransaction returns the canonical encoding of a transaction.
+// Storage-only fields (status, block hash) and the tx ID itself are excluded.
+func EncodeTransaction(tx *models.Transaction) []byte {
+	var e encoder
+	encodeTransaction(&e, tx)
+	return e.buf.Bytes()
+}
+
+// legacyAmount returns the float value encoded for the i-th amount of a
+// transaction before version 4, in the order of models.Transaction.LegacyAmounts
+func legacyAmount(tx *models.Transaction, i int, amount models.Amount) float64 {
+	if len(tx.LegacyAmounts) == 2+len(tx.InputUTXOs)+len(tx.OutputUTXOs) {
+		return tx.LegacyAmounts[i]
+	}
+	return amount.Float64()
+}
+
+func encodeTransaction(e *encoder, tx *models.Transaction) {
+	n := 0
+	amount := func(a models.Amount) {
+		if tx.Version >= FixedPointTransactionVersion {
+			e.int64(int64(a))
+		} else {
+			e.float64(legacyAmount(tx, n, a))
+		}
+		n++
+	}
+
+	e.uint32(uint32(tx.Version))
+	e.string(tx.Type)
+	e.string(tx.SenderWalletID)
+	e.string(tx.ReceiverWalletID)
+	amount(tx.Amount)
+	amount(tx.Fee)
+	e.string(tx.Note)
+	e.time(tx.Timestamp)
+	e.string(tx.SenderPublicKey)
+	e.string(tx.Signature)
+
+	e.length(len(tx.InputUTXOs))
+	for _, input := range tx.InputUTXOs {
+		e.string(input.TxID)
+		e.uint32(uint32(input.OutputIndex))
+		amount(input.Amount)
+	}
+
+	e.length(len(tx.OutputUTXOs))
+	for _, output := range tx.OutputUTXOs {
+		e.string(output.WalletID)
+		amount(output.Amount)
+		e.uint32(uint32(output.Index))
+	}
+
+	if tx.Version >= ReplayProtectedTransactionVersion {
+		e.int64(tx.Nonce)
+		e.string(tx.ChainID)
+	}
+}
+
+// DecodeTransaction parses a canonical transaction encoding. The tx ID is
+// derived from the encoding for version 1 and later transactions and left
+// empty for legacy ones.
+func DecodeTransaction(data []byte) (*models.Transaction, error) {
+	d := newDecoder(data)
+	tx := decodeTransaction(d)
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	if tx.Version >= BinaryTransactionVersion {
+		tx.TxID = ComputeTxID(tx)
+	}
+	return tx, nil
+}
+
+func decodeTransaction(d *decoder) *models.Transaction {
+	version := int(d.uint32())
+	var floats []float64
+	lossy := false
+	amount := func() models.Amount {
+		if version >= FixedPointTransactionVersion {
+			return models.Amount(d.int64())
+		}
+		f := d.float64()
+		a := models.AmountFromFloat(f)
+		floats = append(floats, f)
+		lossy = lossy || a.Float64() != f
+		return a
+	}
+
+	tx := &models.Transaction{
+		Version:          version,
+		Type:             d.string(),
+		SenderWalletID:   d.string(),
+		ReceiverWalletID: d.string(),
+		Amount:           amount(),
+		Fee:              amount(),
+		Note:             d.string(),
+		Timestamp:        d.time(),
+		SenderPublicKey:  d.string(),
+		Signature:        d.string(),
+	}
+
+	inputs := d.count()
+	tx.InputUTXOs = make([]models.UTXOInput, 0, inputs)
+	for i := 0; i < inputs && d.err == nil; i++ {
+		tx.InputUTXOs = append(tx.InputUTXOs, models.UTXOInput{
+			TxID:        d.string(),
+			OutputIndex: int(d.uint32()),
+			Amount:      amount(),
+		})
+	}
+
+	outputs := d.count()
+	tx.OutputUTXOs = make([]models.UTXOOutput, 0, outputs)
+	for i := 0; i < outputs && d.err == nil; i++ {
+		tx.OutputUTXOs = append(tx.OutputUTXOs, models.UTXOOutput{
+			WalletID: d.string(),
+			Amount:   amount(),
+			Index:    int(d.uint32()),
+		})
+	}
+
+	if tx.Version >= ReplayProtectedTransactionVersion {
+		tx.Nonce = d.int64()
+		tx.ChainID = d.string()
+	}
+	if lossy {
+		tx.LegacyAmounts = floats
+	}
+
+	return tx
+}
+
+// ComputeTxID derives a transaction ID from its canonical encoding
+func ComputeTxID(tx *models.Transaction) string {
+	hash := sha256.Sum256(EncodeTransaction(tx))
+	return hex.EncodeToString(hash[:])
+}
+
+// EncodeBlock returns the wire encoding of a block: the header followed by
+// each transaction ID and transaction, every part length-prefixed.
+// Tx IDs travel with the block because legacy IDs cannot be derived.
+func EncodeBlock(block *models.Block) []byte {
+	var e encoder
+	e.bytes(EncodeBlockHeader(block))
+	e.length(len(block.Transactions))
+	for i := range block.Transactions {
+		e.string(block.Transactions[i].TxID)
+		e.bytes(EncodeTransaction(&block.Transactions[i]))
+	}
+	return e.buf.Bytes()
+}
+
+// DecodeBlock parses the wire encoding of a block. The block hash is
+// recomputed, version 1 tx IDs are checked against their encoding and
+// confirmation fields on transactions are filled in.
+func DecodeBlock(data []byte) (*models.Block, error) {
+	d := newDecoder(data)
+
+	block, err := DecodeBlockHeader(d.bytes())
+	if d.err != nil {
+		return nil, d.err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	count := d.count()
+	block.Transactions = make([]models.Transaction, 0, count)
+	for i := 0; i < count && d.err == nil; i++ {
+		txID := d.string()
+		tx, err := DecodeTransaction(d.bytes())
+		if d.err != nil {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if tx.TxID != "" && tx.TxID != txID {
+			return nil, errors.New("transaction ID does not match its encoding")
+		}
+		tx.TxID = txID
+		tx.Status = "confirmed"
+		block.Transactions = append(block.Transactions, *tx)
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+
+	block.Hash = BlockHash(block)
+	for i := range block.Transactions {
+		block.Transactions[i].BlockHash = block.Hash
+	}
+	return block, nil
+}
+
+// BlockHash computes a block's hash using the rules of its version
+func BlockHash(block *models.Block) string {
+	if block.Version == LegacyBlockVersion {
+		return legacyBlockHash(block)
+	}
+	return hashHeader(EncodeBlockHeader(block))
+}
+
+// hashHeader returns the hex SHA-256 of an encoded header
+func hashHeader(header []byte) string {
+	hash := sha256.Sum256(header)
+	return hex.EncodeToString(hash[:])
+}
+
+// legacyBlockHash reproduces the version 0 hash so existing blocks still verify
+func legacyBlockHash(block *models.Block) string {
+	var txIDs strings.Builder
+	for _, tx := range block.Transactions {
+		txIDs.WriteString(tx.TxID)
+	}
+
+	data := fmt.Sprintf("%d%s%s%s%d%s",
+		block.Index,
+		block.Timestamp.Format(time.RFC3339),
+		block.PreviousHash,
+		block.MerkleRoot,
+		block.Nonce,
+		txIDs.String(),
+	)
+	hash := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"backend/models"
+	"backend/storage"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AllocationTransactionType marks the faucet's grant to a new wallet
+const AllocationTransactionType = "allocation"
+
+var (
+	ErrAllocationsDisabled  = errors.New("wallet allocations are disabled on this network")
+	ErrAlreadyAllocated     = errors.New("wallet has already received an allocation")
+	ErrAllocationsExhausted = errors.New("the network's allocation supply is exhausted")
+)
+
+// IsAllocation reports whether tx is a faucet allocation
+func IsAllocation(tx *models.Transaction) bool {
+	return tx.Type == AllocationTransactionType && len(tx.InputUTXOs) == 0
+}
+
+// FaucetKeyFromEnv returns the faucet private key from FAUCET_PRIVATE_KEY.
+// Nodes without it verify allocations but cannot grant them.
+func FaucetKeyFromEnv() string {
+	return os.Getenv("FAUCET_PRIVATE_KEY")
+}
+
+// parseFaucetKey decodes a hex private key of any key type
+func parseFaucetKey(privStr string) (Signer, error) {
+	signer, err := ParsePrivateKey(privStr)
+	if err != nil {
+		return nil, errors.New("invalid faucet key")
+	}
+	return signer, nil
+}
+
+// faucetPublicKey returns the public key of a hex private key, or "" if
+// it is invalid
+func faucetPublicKey(privStr string) string {
+	signer, err := parseFaucetKey(privStr)
+	if err != nil {
+		return ""
+	}
+	return PublicKeyHex(signer.Public())
+}
+
+// allocationLedger records the allocations already on a chain
+type allocationLedger struct {
+	wallets map[string]bool
+	total   models.Amount
+}
+
+func newAllocationLedger() *allocationLedger {
+	return &allocationLedger{wallets: make(map[string]bool)}
+}
+
+func (l *allocationLedger) add(tx *models.Transaction) {
+	l.wallets[tx.ReceiverWalletID] = true
+	l.total += tx.Amount
+}
+
+// loadAllocations returns the ledger of allocations on the main chain
+func (s *BlockchainService) loadAllocations(ctx context.Context) (*allocationLedger, error) {
+	txs, err := s.store.GetTransactionsByType(ctx, AllocationTransactionType, "confirmed")
+	if err != nil {
+		return nil, err
+	}
+
+	ledger := newAllocationLedger()
+	for i := range txs {
+		if IsAllocation(&txs[i]) {
+			ledger.add(&txs[i])
+		}
+	}
+	return ledger, nil
+}
+
+// checkAllocation enforces the network's allocation policy: allocations
+// must be enabled, signed by the faucet and pay exactly the allocation
+// amount to a receiver that has had none, without the chain's allocations
+// exceeding their total. The signature itself is verified with every
+// other transaction's.
+func (s *BlockchainService) checkAllocation(tx *models.Transaction, allocations *allocationLedger) error {
+	cfg := s.network.Allocation
+	if !cfg.Enabled {
+		return ruleError(RuleAllocation, tx.TxID, "allocations are disabled on this network")
+	}
+	if cfg.FaucetPublicKey == "" || tx.SenderPublicKey != cfg.FaucetPublicKey {
+		return ruleError(RuleAllocation, tx.TxID, "allocation is not signed by the network faucet")
+	}
+	if len(tx.OutputUTXOs) != 1 || tx.OutputUTXOs[0].WalletID != tx.ReceiverWalletID {
+		return ruleError(RuleAllocation, tx.TxID, "allocation must pay a single output to its receiver")
+	}
+	if tx.Amount != cfg.Amount || tx.OutputUTXOs[0].Amount != cfg.Amount {
+		return ruleError(RuleAllocation, tx.TxID, fmt.Sprintf("allocation pays %s, the network grants %s", tx.OutputUTXOs[0].Amount, cfg.Amount))
+	}
+	if allocations.wallets[tx.ReceiverWalletID] {
+		return ruleError(RuleAllocation, tx.TxID, ErrAlreadyAllocated.Error())
+	}
+	if allocations.total+tx.Amount > cfg.MaxTotal {
+		return ruleError(RuleAllocation, tx.TxID, fmt.Sprintf("allocation would exceed the network's total of %s", cfg.MaxTotal))
+	}
+	return nil
+}
+
+type FaucetService struct {
+	store       storage.ChainStore
+	transaction *TransactionService
+	blockchain  *BlockchainService
+	crypto      *CryptoService
+	key         string
+}
+
+func NewFaucetService(store storage.ChainStore, transaction *TransactionService, blockchain *BlockchainService, key string) *FaucetService {
+	return &FaucetService{
+		store:       store,
+		transaction: transaction,
+		blockchain:  blockchain,
+		crypto:      NewCryptoService(),
+		key:         key,
+	}
+}
+
+// Allocate signs an allocation of the network's starting amount to a
+// wallet and adds it to the mempool. The wallet's balance includes it
+// once it is mined. Failures are recorded in the system log.
+func (s *FaucetService) Allocate(ctx context.Context, walletID string) (*models.Transaction, error) {
+	cfg := s.blockchain.GetNetworkConfig().Allocation
+	if !cfg.Enabled || cfg.Amount <= 0 {
+		return nil, ErrAllocationsDisabled
+	}
+
+	tx, err := s.allocate(ctx, walletID, cfg)
+	if err != nil {
+		s.logAllocation(ctx, walletID, "Wallet allocation failed: "+err.Error(), "failed")
+		return nil, err
+	}
+	s.logAllocation(ctx, walletID, fmt.Sprintf("Allocated %s in transaction %s", cfg.Amount, tx.TxID), "success")
+	return tx, nil
+}
+
+func (s *FaucetService) allocate(ctx context.Context, walletID string, cfg AllocationConfig) (*models.Transaction, error) {
+	signer, err := parseFaucetKey(s.key)
+	if err != nil {
+		return nil, err
+	}
+	pubKeyStr := PublicKeyHex(signer.Public())
+	if pubKeyStr != cfg.FaucetPublicKey {
+		return nil, errors.New("faucet key does not match the network's faucet public key")
+	}
+
+	allocations, err := s.blockchain.loadAllocations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if allocations.wallets[walletID] {
+		return nil, ErrAlreadyAllocated
+	}
+	if allocations.total+cfg.Amount > cfg.MaxTotal {
+		return nil, ErrAllocationsExhausted
+	}
+
+	tx := &models.Transaction{
+		ID:               primitive.NewObjectID(),
+		Version:          CurrentTransactionVersion,
+		SenderWalletID:   s.crypto.GenerateWalletID(pubKeyStr),
+		ReceiverWalletID: walletID,
+		Amount:           cfg.Amount,
+		Note:             "Initial wallet allocation",
+		Timestamp:        time.Now(),
+		SenderPublicKey:  pubKeyStr,
+		InputUTXOs:       []models.UTXOInput{},
+		OutputUTXOs: []models.UTXOOutput{
+			{WalletID: walletID, Amount: cfg.Amount, Index: 0},
+		},
+		Type:    AllocationTransactionType,
+		Status:  "pending",
+		Fee:     0,
+		ChainID: s.blockchain.GetNetworkConfig().ChainID,
+	}
+
+	signature, err := signer.Sign([]byte(TransactionSigningPayload(tx)))
+	if err != nil {
+		return nil, err
+	}
+	tx.Signature = hex.EncodeToString(signature)
+	tx.TxID = ComputeTxID(tx)
+
+	if err := s.transaction.addPending(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// logAllocation records the outcome of an allocation
+func (s *FaucetService) logAllocation(ctx context.Context, walletID, details, status string) {
+	log := models.SystemLog{
+		ID:        primitive.NewObjectID(),
+		Action:    "wallet_allocation",
+		WalletID:  walletID,
+		Details:   details,
+		Status:    status,
+		Timestamp: time.Now(),
+	}
+
+	s.store.InsertSystemLog(ctx, &log)
+}
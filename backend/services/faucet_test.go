@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"backend/models"
+	"backend/storage"
+)
+
+func TestAllocate(t *testing.T) {
+	ctx := context.Background()
+	faucet, alice, bob, carol := newTestWallet(t), newTestWallet(t), newTestWallet(t), newTestWallet(t)
+
+	store := storage.NewMemoryStore()
+	difficulty := DefaultDifficultyConfig()
+	difficulty.InitialDifficulty = 1
+	network := DefaultNetworkConfig()
+	network.Allocation = AllocationConfig{Enabled: true, Amount: 100 * models.Coin, MaxTotal: 200 * models.Coin, FaucetPublicKey: faucet.pubKey}
+	bc := NewBlockchainService(store, difficulty, DefaultSubsidyConfig(), DefaultBlockLimits(), DefaultMempoolConfig(), network)
+	if err := bc.InitializeGenesisBlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s := NewFaucetService(store, NewTransactionService(store, bc, DefaultCoinSelectionConfig()), bc, hex.EncodeToString(faucet.signer.Bytes()))
+
+	tip, err := bc.GetLatestBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine := func(txs ...models.Transaction) error {
+		block := nextBlock(t, bc, tip, "miner", tip.Timestamp.Add(time.Minute), txs...)
+		if err := bc.AddBlock(ctx, block); err != nil {
+			return err
+		}
+		tip = block
+		return nil
+	}
+
+	granted, err := s.Allocate(ctx, alice.walletID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mine(*granted); err != nil {
+		t.Fatal(err)
+	}
+	if tx, err := store.GetTransaction(ctx, granted.TxID); err != nil || tx.Status != "confirmed" {
+		t.Fatalf("allocation = %+v, %v; want it confirmed", tx, err)
+	}
+	if utxos := mustUnspent(t, store, alice.walletID); len(utxos) != 1 || utxos[0].Amount != 100*models.Coin {
+		t.Fatalf("alice's outputs = %+v, want the allocation", utxos)
+	}
+
+	if _, err := s.Allocate(ctx, alice.walletID); err != ErrAlreadyAllocated {
+		t.Fatalf("second allocation: err = %v, want %v", err, ErrAlreadyAllocated)
+	}
+	granted, err = s.Allocate(ctx, bob.walletID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mine(*granted); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Allocate(ctx, carol.walletID); err != ErrAllocationsExhausted {
+		t.Fatalf("allocation past the total: err = %v, want %v", err, ErrAllocationsExhausted)
+	}
+
+	// A faucet that skips its own checks is still held to them by the chain
+	for name, tx := range map[string]models.Transaction{
+		"second allocation":         allocation(t, faucet, alice.walletID, 100*models.Coin),
+		"allocation past the total": allocation(t, faucet, carol.walletID, 100*models.Coin),
+	} {
+		if err := mine(tx); ruleOf(err) != RuleAllocation {
+			t.Fatalf("block with a %s: err = %v, want rule %q", name, err, RuleAllocation)
+		}
+	}
+
+	logs, err := store.GetSystemLogs(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := 0
+	for _, log := range logs {
+		if log.Action == "wallet_allocation" && log.Status == "failed" {
+			failed++
+		}
+	}
+	if failed != 2 {
+		t.Fatalf("%d failed allocations logged, want 2", failed)
+	}
+}
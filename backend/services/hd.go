@@ -0,0 +1,332 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// Wallet keys are derived from a master seed following SLIP-0010, for the
+// curve of the wallet's key type. Receive addresses are the children of
+// an account branch, so one seed recovers every address a user was ever
+// given.
+
+// HDReceivePath is the account branch that ECDSA receive addresses derive
+// from
+const HDReceivePath = "m/44'/1'/0'/0"
+
+// HDEd25519ReceivePath is the account branch of Ed25519 receive
+// addresses. SLIP-0010 derives only hardened Ed25519 keys, so the branch
+// and the addresses below it are hardened.
+const HDEd25519ReceivePath = "m/44'/1'/0'/0'"
+
+// HardenedKeyStart is the first hardened child index
+const HardenedKeyStart = 0x80000000
+
+// hdSeedLength is the length in bytes of the seeds wallets are created with
+const hdSeedLength = 32
+
+// hdMasterKeys are the HMAC keys master keys are derived with, per key type
+var hdMasterKeys = map[KeyType][]byte{
+	KeyTypeP256:      []byte("Nist256p1 seed"),
+	KeyTypeSecp256k1: []byte("Bitcoin seed"),
+	KeyTypeEd25519:   []byte("ed25519 seed"),
+}
+
+var (
+	ErrInvalidDerivationPath = errors.New("invalid derivation path")
+	ErrNoSeed                = errors.New("wallet has no HD seed")
+	ErrHardenedOnly          = errors.New("Ed25519 keys only derive hardened children")
+)
+
+// HDConfig controls address derivation
+type HDConfig struct {
+	GapLimit int // consecutive unused addresses a scan looks past before it stops
+}
+
+// DefaultHDConfig returns the derivation defaults
+func DefaultHDConfig() HDConfig {
+	return HDConfig{GapLimit: 20}
+}
+
+// HDConfigFromEnv returns the defaults overridden by environment variables
+func HDConfigFromEnv() HDConfig {
+	cfg := DefaultHDConfig()
+	cfg.GapLimit = envInt("HD_GAP_LIMIT", cfg.GapLimit)
+	if cfg.GapLimit < 1 {
+		cfg.GapLimit = 1
+	}
+	return cfg
+}
+
+// ExtendedKey is a private key together with the chain code its children
+// are derived with
+type ExtendedKey struct {
+	keyType   KeyType
+	key       []byte // the scalar of ECDSA keys, the seed of Ed25519 ones
+	chainCode []byte
+}
+
+// NewMasterKey returns the root key of a seed for a key type
+func NewMasterKey(keyType KeyType, seed []byte) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, errors.New("seed must be between 16 and 64 bytes")
+	}
+	hmacKey, ok := hdMasterKeys[keyType]
+	if !ok {
+		return nil, ErrUnknownKeyType
+	}
+
+	curve := hdCurve(keyType)
+	sum := hmacSHA512(hmacKey, seed)
+	for {
+		if curve == nil || validScalar(curve, sum[:32]) {
+			return &ExtendedKey{keyType: keyType, key: sum[:32], chainCode: sum[32:]}, nil
+		}
+		sum = hmacSHA512(hmacKey, sum)
+	}
+}
+
+// Child derives the child key at index; indexes from HardenedKeyStart
+// are hardened
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	curve := hdCurve(k.keyType)
+	if curve == nil {
+		if index < HardenedKeyStart {
+			return nil, ErrHardenedOnly
+		}
+		data := binary.BigEndian.AppendUint32(append([]byte{0}, k.key...), index)
+		sum := hmacSHA512(k.chainCode, data)
+		return &ExtendedKey{keyType: k.keyType, key: sum[:32], chainCode: sum[32:]}, nil
+	}
+	n := curve.Params().N
+
+	var data []byte
+	if index >= HardenedKeyStart {
+		data = append([]byte{0}, k.key...)
+	} else {
+		x, y := curve.ScalarBaseMult(k.key)
+		data = elliptic.MarshalCompressed(curve, x, y)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	sum := hmacSHA512(k.chainCode, data)
+	for {
+		tweak := new(big.Int).SetBytes(sum[:32])
+		if tweak.Cmp(n) < 0 {
+			child := tweak.Add(tweak, new(big.Int).SetBytes(k.key))
+			child.Mod(child, n)
+			if child.Sign() != 0 {
+				return &ExtendedKey{keyType: k.keyType, key: child.FillBytes(make([]byte, 32)), chainCode: sum[32:]}, nil
+			}
+		}
+		// An invalid key is skipped by deriving again from the right half
+		retry := append([]byte{1}, sum[32:]...)
+		sum = hmacSHA512(k.chainCode, binary.BigEndian.AppendUint32(retry, index))
+	}
+}
+
+// Derive follows a path such as "m/44'/1'/0'/0/3" from k
+func (k *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+	indexes, err := ParseDerivationPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key := k
+	for _, index := range indexes {
+		if key, err = key.Child(index); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// KeyType returns the signature scheme of the key
+func (k *ExtendedKey) KeyType() KeyType {
+	return k.keyType
+}
+
+// Signer returns the signing key
+func (k *ExtendedKey) Signer() (Signer, error) {
+	return NewSigner(k.keyType, k.key)
+}
+
+// Public returns the extended public key of k. Ed25519 keys have none, as
+// their public keys cannot derive children.
+func (k *ExtendedKey) Public() (*ExtendedPublicKey, error) {
+	curve := hdCurve(k.keyType)
+	if curve == nil {
+		return nil, ErrHardenedOnly
+	}
+	x, y := curve.ScalarBaseMult(k.key)
+	return &ExtendedPublicKey{keyType: k.keyType, x: x, y: y, chainCode: k.chainCode}, nil
+}
+
+// ChainCode returns the chain code children are derived with
+func (k *ExtendedKey) ChainCode() []byte {
+	return append([]byte(nil), k.chainCode...)
+}
+
+// ExtendedPublicKey is an ECDSA public key together with the chain code
+// its non-hardened children are derived with. It derives the same
+// addresses as the private key it comes from without being able to sign
+// for them.
+type ExtendedPublicKey struct {
+	keyType   KeyType
+	x, y      *big.Int
+	chainCode []byte
+}
+
+// ParseExtendedPublicKey decodes the hex key and chain code written by
+// String
+func ParseExtendedPublicKey(s string) (*ExtendedPublicKey, error) {
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid extended public key")
+	}
+
+	switch {
+	case len(raw) == 33+32:
+		x, y := elliptic.UnmarshalCompressed(elliptic.P256(), raw[:33])
+		if x != nil {
+			return &ExtendedPublicKey{keyType: KeyTypeP256, x: x, y: y, chainCode: raw[33:]}, nil
+		}
+	case len(raw) == 1+33+32 && raw[0] == keyTagSecp256k1:
+		pub, err := secp256k1.ParsePubKey(raw[1:34])
+		if err == nil {
+			return &ExtendedPublicKey{keyType: KeyTypeSecp256k1, x: pub.X(), y: pub.Y(), chainCode: raw[34:]}, nil
+		}
+	}
+	return nil, errors.New("invalid extended public key")
+}
+
+// String returns the compressed key followed by the chain code, in hex.
+// Keys other than P-256 start with their key type's tag.
+func (k *ExtendedPublicKey) String() string {
+	raw := elliptic.MarshalCompressed(hdCurve(k.keyType), k.x, k.y)
+	if k.keyType == KeyTypeSecp256k1 {
+		raw = append([]byte{keyTagSecp256k1}, raw...)
+	}
+	return hex.EncodeToString(append(raw, k.chainCode...))
+}
+
+// Child derives the non-hardened child public key at index
+func (k *ExtendedPublicKey) Child(index uint32) (*ExtendedPublicKey, error) {
+	if index >= HardenedKeyStart {
+		return nil, errors.New("hardened children cannot be derived from a public key")
+	}
+
+	curve := hdCurve(k.keyType)
+	n := curve.Params().N
+
+	data := binary.BigEndian.AppendUint32(elliptic.MarshalCompressed(curve, k.x, k.y), index)
+	sum := hmacSHA512(k.chainCode, data)
+	for {
+		tweak := new(big.Int).SetBytes(sum[:32])
+		if tweak.Cmp(n) < 0 {
+			tx, ty := curve.ScalarBaseMult(sum[:32])
+			x, y := curve.Add(tx, ty, k.x, k.y)
+			if x.Sign() != 0 || y.Sign() != 0 {
+				return &ExtendedPublicKey{keyType: k.keyType, x: x, y: y, chainCode: sum[32:]}, nil
+			}
+		}
+		retry := append([]byte{1}, sum[32:]...)
+		sum = hmacSHA512(k.chainCode, binary.BigEndian.AppendUint32(retry, index))
+	}
+}
+
+// Verifier returns the verifying key
+func (k *ExtendedPublicKey) Verifier() (Verifier, error) {
+	if k.keyType == KeyTypeP256 {
+		return &p256Verifier{pub: &ecdsa.PublicKey{Curve: elliptic.P256(), X: k.x, Y: k.y}}, nil
+	}
+	pub, err := secp256k1.ParsePubKey(elliptic.MarshalCompressed(secp256k1.S256(), k.x, k.y))
+	if err != nil {
+		return nil, err
+	}
+	return &secp256k1Verifier{pub: pub}, nil
+}
+
+// hdCurve returns the curve of an ECDSA key type, or nil for Ed25519
+func hdCurve(keyType KeyType) elliptic.Curve {
+	switch keyType {
+	case KeyTypeP256:
+		return elliptic.P256()
+	case KeyTypeSecp256k1:
+		return secp256k1.S256()
+	}
+	return nil
+}
+
+// validScalar reports whether a 32-byte key is a valid private key on curve
+func validScalar(curve elliptic.Curve, key []byte) bool {
+	d := new(big.Int).SetBytes(key)
+	return d.Sign() != 0 && d.Cmp(curve.Params().N) < 0
+}
+
+// ParseDerivationPath parses a path of child indexes below "m", where a
+// trailing ' or h marks a hardened index
+func ParseDerivationPath(path string) ([]uint32, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "m" {
+		return nil, ErrInvalidDerivationPath
+	}
+
+	indexes := make([]uint32, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h")
+		if hardened {
+			part = part[:len(part)-1]
+		}
+		index, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || index >= HardenedKeyStart {
+			return nil, ErrInvalidDerivationPath
+		}
+		if hardened {
+			index += HardenedKeyStart
+		}
+		indexes = append(indexes, uint32(index))
+	}
+	return indexes, nil
+}
+
+// AccountPath returns the branch receive addresses of a key type derive from
+func AccountPath(keyType KeyType) string {
+	if keyType == KeyTypeEd25519 {
+		return HDEd25519ReceivePath
+	}
+	return HDReceivePath
+}
+
+// receiveIndex returns the child index of a user's index-th receive address
+func receiveIndex(keyType KeyType, index int) uint32 {
+	if keyType == KeyTypeEd25519 {
+		return uint32(index) + HardenedKeyStart
+	}
+	return uint32(index)
+}
+
+// ReceivePath returns the derivation path of a user's index-th receive address
+func ReceivePath(keyType KeyType, index int) string {
+	if keyType == KeyTypeEd25519 {
+		return fmt.Sprintf("%s/%d'", HDEd25519ReceivePath, index)
+	}
+	return fmt.Sprintf("%s/%d", HDReceivePath, index)
+}
+
+func hmacSHA512(key, data []byte) []byte {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
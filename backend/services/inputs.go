@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"backend/models"
+	"backend/storage"
+)
+
+// ResolvedInput is a transaction input together with the stored output it spends
+type ResolvedInput struct {
+	Input models.UTXOInput
+	UTXO  *models.UTXO
+}
+
+// ResolveInputs loads the outputs a transaction spends and checks that each
+// exists, is unspent, is spent only once, belongs to the sender and holds
+// the amount the input claims. The returned sum is taken from storage, never
+// from the transaction. Consensus failures are returned as *RuleError;
+// storage failures are returned as is.
+func (s *TransactionService) ResolveInputs(ctx context.Context, tx *models.Transaction) ([]ResolvedInput, models.Amount, error) {
+	if len(tx.InputUTXOs) == 0 && !IsCoinbase(tx) {
+		return nil, 0, ruleError(RuleUnfundedIssuance, tx.TxID, "transaction has no inputs")
+	}
+
+	var inputSum models.Amount
+	resolved := make([]ResolvedInput, 0, len(tx.InputUTXOs))
+	spending := make(map[string]bool)
+	for _, input := range tx.InputUTXOs {
+		key := outpointKey(input.TxID, input.OutputIndex)
+		if spending[key] {
+			return nil, 0, ruleError(RuleDoubleSpend, tx.TxID, fmt.Sprintf("input %s is spent twice", key))
+		}
+		spending[key] = true
+
+		utxo, err := s.store.GetUTXO(ctx, input.TxID, input.OutputIndex)
+		if err == storage.ErrNotFound {
+			return nil, 0, ruleError(RuleMissingInput, tx.TxID, fmt.Sprintf("input %s does not exist", key))
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if utxo.IsSpent {
+			return nil, 0, ruleError(RuleDoubleSpend, tx.TxID, fmt.Sprintf("input %s is already spent", key))
+		}
+		if utxo.WalletID != tx.SenderWalletID {
+			return nil, 0, ruleError(RuleInputOwner, tx.TxID, fmt.Sprintf("input %s is not owned by the sender", key))
+		}
+		if utxo.Amount != input.Amount {
+			return nil, 0, ruleError(RuleInputAmount, tx.TxID, fmt.Sprintf("input %s claims %s, output holds %s", key, input.Amount, utxo.Amount))
+		}
+
+		resolved = append(resolved, ResolvedInput{Input: input, UTXO: utxo})
+		inputSum += utxo.Amount
+	}
+
+	return resolved, inputSum, nil
+}
+
+// checkOutputs rejects non-positive outputs and reused output indexes,
+// returning the total paid out
+func checkOutputs(tx *models.Transaction) (models.Amount, error) {
+	var outputSum models.Amount
+	indexes := make(map[int]bool)
+	for _, output := range tx.OutputUTXOs {
+		if output.Amount <= 0 {
+			return 0, ruleError(RuleOutputAmount, tx.TxID, "output amounts must be positive")
+		}
+		if indexes[output.Index] {
+			return 0, ruleError(RuleOutputAmount, tx.TxID, fmt.Sprintf("output index %d is used twice", output.Index))
+		}
+		indexes[output.Index] = true
+		outputSum += output.Amount
+	}
+	return outputSum, nil
+}
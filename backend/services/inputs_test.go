@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"backend/models"
+	"backend/storage"
+)
+
+// testWallet is a key pair and the wallet ID it controls
+type testWallet struct {
+	signer   Signer
+	pubKey   string
+	walletID string
+}
+
+func newTestWallet(t *testing.T) testWallet {
+	t.Helper()
+	signer, err := GenerateSigner(KeyTypeP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey := PublicKeyHex(signer.Public())
+	return testWallet{signer: signer, pubKey: pubKey, walletID: NewCryptoService().GenerateWalletID(pubKey)}
+}
+
+// transfer builds a transaction paying amount to receiver from inputs and
+// leaving the rest as fee. It names from as its sender and is signed by
+// signer, which need not control from.
+func transfer(t *testing.T, from string, signer testWallet, receiver string, inputs []models.UTXOInput, amount models.Amount) models.Transaction {
+	t.Helper()
+	tx := models.Transaction{
+		Version:          CurrentTransactionVersion,
+		SenderWalletID:   from,
+		ReceiverWalletID: receiver,
+		Amount:           amount,
+		Timestamp:        time.UnixMilli(time.Now().UnixMilli()),
+		SenderPublicKey:  signer.pubKey,
+		InputUTXOs:       inputs,
+		OutputUTXOs:      []models.UTXOOutput{{WalletID: receiver, Amount: amount, Index: 0}},
+		Type:             "transfer",
+		ChainID:          DevnetChainID,
+		Nonce:            1,
+	}
+	for _, input := range inputs {
+		tx.Fee += input.Amount
+	}
+	tx.Fee -= amount
+
+	sign(t, &tx, signer)
+	return tx
+}
+
+// sign signs tx with signer's key and sets its ID
+func sign(t *testing.T, tx *models.Transaction, signer testWallet) {
+	t.Helper()
+	signature, err := signer.signer.Sign([]byte(TransactionSigningPayload(tx)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Signature = hex.EncodeToString(signature)
+	tx.TxID = ComputeTxID(tx)
+}
+
+func ruleOf(err error) string {
+	var rerr *RuleError
+	if errors.As(err, &rerr) {
+		return rerr.Rule
+	}
+	return ""
+}
+
+func TestResolveInputs(t *testing.T) {
+	ctx := context.Background()
+	alice, mallory := newTestWallet(t), newTestWallet(t)
+
+	store := storage.NewMemoryStore()
+	for _, utxo := range []models.UTXO{
+		{TxID: "funding", OutputIndex: 0, WalletID: alice.walletID, Amount: 10 * models.Coin},
+		{TxID: "funding", OutputIndex: 1, WalletID: mallory.walletID, Amount: models.Coin},
+		{TxID: "spent", OutputIndex: 0, WalletID: mallory.walletID, Amount: models.Coin, IsSpent: true},
+	} {
+		utxo := utxo
+		if err := store.InsertUTXO(ctx, &utxo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := NewTransactionService(store, nil, DefaultCoinSelectionConfig())
+
+	alices := models.UTXOInput{TxID: "funding", OutputIndex: 0, Amount: 10 * models.Coin}
+	mallorys := models.UTXOInput{TxID: "funding", OutputIndex: 1, Amount: models.Coin}
+
+	tests := []struct {
+		name    string
+		sender  string
+		inputs  []models.UTXOInput
+		rule    string
+		wantSum models.Amount
+	}{
+		{"own output", mallory.walletID, []models.UTXOInput{mallorys}, "", models.Coin},
+		{"someone else's output", mallory.walletID, []models.UTXOInput{alices}, RuleInputOwner, 0},
+		{"mixed with someone else's output", mallory.walletID, []models.UTXOInput{mallorys, alices}, RuleInputOwner, 0},
+		{"inflated amount", mallory.walletID, []models.UTXOInput{{TxID: "funding", OutputIndex: 1, Amount: 10 * models.Coin}}, RuleInputAmount, 0},
+		{"missing output", mallory.walletID, []models.UTXOInput{{TxID: "nowhere", OutputIndex: 0, Amount: models.Coin}}, RuleMissingInput, 0},
+		{"spent output", mallory.walletID, []models.UTXOInput{{TxID: "spent", OutputIndex: 0, Amount: models.Coin}}, RuleDoubleSpend, 0},
+		{"same output twice", mallory.walletID, []models.UTXOInput{mallorys, mallorys}, RuleDoubleSpend, 0},
+		{"no inputs", mallory.walletID, nil, RuleUnfundedIssuance, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := &models.Transaction{SenderWalletID: tt.sender, InputUTXOs: tt.inputs, Type: "transfer"}
+			_, sum, err := s.ResolveInputs(ctx, tx)
+			if got := ruleOf(err); got != tt.rule {
+				t.Fatalf("rule = %q (%v), want %q", got, err, tt.rule)
+			}
+			if tt.rule == "" && sum != tt.wantSum {
+				t.Fatalf("sum = %s, want %s", sum, tt.wantSum)
+			}
+		})
+	}
+}
+
+func TestReplayBlockInputAttacks(t *testing.T) {
+	alice, bob, mallory := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	bc := NewBlockchainService(storage.NewMemoryStore(), DefaultDifficultyConfig(), DefaultSubsidyConfig(), DefaultBlockLimits(), DefaultMempoolConfig(), DefaultNetworkConfig())
+
+	alices := models.UTXOInput{TxID: "funding", OutputIndex: 0, Amount: 10 * models.Coin}
+	mallorys := models.UTXOInput{TxID: "funding", OutputIndex: 1, Amount: models.Coin}
+	in := func(inputs ...models.UTXOInput) []models.UTXOInput { return inputs }
+
+	tests := []struct {
+		name string
+		txs  []models.Transaction
+		rule string
+	}{
+		{
+			name: "own output",
+			txs:  []models.Transaction{transfer(t, mallory.walletID, mallory, bob.walletID, in(mallorys), models.Coin)},
+		},
+		{
+			name: "someone else's output",
+			txs:  []models.Transaction{transfer(t, mallory.walletID, mallory, mallory.walletID, in(alices), 10*models.Coin)},
+			rule: RuleInputOwner,
+		},
+		{
+			name: "forged owner",
+			txs:  []models.Transaction{transfer(t, alice.walletID, mallory, mallory.walletID, in(alices), 10*models.Coin)},
+			rule: RuleSignature,
+		},
+		{
+			name: "double spend within a block",
+			txs: []models.Transaction{
+				transfer(t, alice.walletID, alice, bob.walletID, in(alices), 10*models.Coin),
+				transfer(t, alice.walletID, alice, mallory.walletID, in(alices), 9*models.Coin),
+			},
+			rule: RuleDoubleSpend,
+		},
+		{
+			name: "same output twice in one transaction",
+			txs:  []models.Transaction{transfer(t, mallory.walletID, mallory, bob.walletID, in(mallorys, mallorys), 2*models.Coin)},
+			rule: RuleDoubleSpend,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			utxos := map[string]*replayOutput{
+				outpointKey("funding", 0): {walletID: alice.walletID, amount: 10 * models.Coin},
+				outpointKey("funding", 1): {walletID: mallory.walletID, amount: models.Coin},
+			}
+			block := &models.Block{Index: 1, Transactions: tt.txs}
+			block.MerkleRoot = BlockMerkleRoot(block)
+
+			err := bc.replayBlock(block, utxos, make(map[string]bool), newAllocationLedger())
+			if got := ruleOf(err); got != tt.rule {
+				t.Fatalf("rule = %q (%v), want %q", got, err, tt.rule)
+			}
+		})
+	}
+}
@@ -0,0 +1,362 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Keyring is the file of master keys the local key provider reads
+type Keyring struct {
+	Current uint32      `json:"current"`
+	Keys    []MasterKey `json:"keys"`
+}
+
+// MasterKey is one version of the local master key
+type MasterKey struct {
+	Version   uint32    `json:"version"`
+	Key       string    `json:"key"` // base64 AES-256 key
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// LocalKeyProvider wraps data keys with AES-256 master keys kept in a
+// keyring file. The file is read again when it changes, so a key added
+// while the server runs is picked up by the next rotation.
+type LocalKeyProvider struct {
+	path    string
+	mu      sync.Mutex
+	info    os.FileInfo // of the keyring when it was last read
+	keys    map[uint32][]byte
+	current uint32
+}
+
+// NewLocalKeyProvider loads the keyring at path
+func NewLocalKeyProvider(path string) (*LocalKeyProvider, error) {
+	p := &LocalKeyProvider{path: path}
+	if err := p.load(); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("keyring %s not found; create it with chainadmin new-master-key", path)
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// CurrentVersion returns the keyring's current master key version
+func (p *LocalKeyProvider) CurrentVersion(ctx context.Context) (uint32, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.load(); err != nil {
+		return 0, err
+	}
+	return p.current, nil
+}
+
+// WrapKey encrypts a data key with AES-GCM under a master key version
+func (p *LocalKeyProvider) WrapKey(ctx context.Context, version uint32, dataKey []byte) ([]byte, error) {
+	key, err := p.key(version)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, dataKey, versionBytes(version)), nil
+}
+
+// UnwrapKey decrypts a data key wrapped under a master key version
+func (p *LocalKeyProvider) UnwrapKey(ctx context.Context, version uint32, wrapped []byte) ([]byte, error) {
+	key, err := p.key(version)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, ErrInvalidEnvelope
+	}
+	return gcm.Open(nil, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():], versionBytes(version))
+}
+
+func (p *LocalKeyProvider) key(version uint32) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, ok := p.keys[version]
+	if !ok {
+		// The key may have been added since the keyring was read
+		if err := p.load(); err != nil {
+			return nil, err
+		}
+		if key, ok = p.keys[version]; !ok {
+			return nil, ErrUnknownKeyVersion
+		}
+	}
+	return key, nil
+}
+
+// load reads the keyring if it changed since it was last read
+func (p *LocalKeyProvider) load() error {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+	// AddMasterKey renames a new file over the keyring, which changes its
+	// inode even when the modification time has not ticked
+	if p.info != nil && os.SameFile(info, p.info) && info.ModTime().Equal(p.info.ModTime()) && info.Size() == p.info.Size() {
+		return nil
+	}
+
+	ring, err := ReadKeyring(p.path)
+	if err != nil {
+		return err
+	}
+	keys := make(map[uint32][]byte, len(ring.Keys))
+	for _, master := range ring.Keys {
+		key, err := base64.StdEncoding.DecodeString(master.Key)
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("keyring %s: master key %d is not a base64 32-byte key", p.path, master.Version)
+		}
+		keys[master.Version] = key
+	}
+	if _, ok := keys[ring.Current]; !ok {
+		return fmt.Errorf("keyring %s: current master key %d is missing", p.path, ring.Current)
+	}
+
+	p.keys, p.current, p.info = keys, ring.Current, info
+	return nil
+}
+
+// ReadKeyring reads a keyring file
+func ReadKeyring(path string) (*Keyring, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ring Keyring
+	if err := json.Unmarshal(raw, &ring); err != nil {
+		return nil, fmt.Errorf("keyring %s: %v", path, err)
+	}
+	return &ring, nil
+}
+
+// AddMasterKey adds a random master key to the keyring at path, creating
+// it if needed, and makes the new key current. Earlier keys are kept to
+// unwrap the data keys that still use them until they are rotated.
+func AddMasterKey(path string) (uint32, error) {
+	ring, err := ReadKeyring(path)
+	if errors.Is(err, os.ErrNotExist) {
+		ring, err = &Keyring{}, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return 0, err
+	}
+	version := uint32(1)
+	for _, master := range ring.Keys {
+		if master.Version >= version {
+			version = master.Version + 1
+		}
+	}
+	ring.Keys = append(ring.Keys, MasterKey{
+		Version:   version,
+		Key:       base64.StdEncoding.EncodeToString(key),
+		CreatedAt: time.Now(),
+	})
+	ring.Current = version
+
+	raw, err := json.MarshalIndent(ring, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	// Write a new file and rename it over the old one, so that a reader
+	// never sees a partial keyring
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".keyring-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(raw, '\n')); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	return version, os.Rename(tmp.Name(), path)
+}
+
+func versionBytes(version uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, version)
+}
+
+// KMSClient is the part of a key management service the KMS provider
+// needs: encrypting and decrypting small payloads under a named key that
+// never leaves the service
+type KMSClient interface {
+	Encrypt(ctx context.Context, keyID string, plaintext []byte) ([]byte, error)
+	Decrypt(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error)
+}
+
+// KMSKeyProvider wraps data keys with master keys held by a KMS, each
+// master key version naming a KMS key
+type KMSKeyProvider struct {
+	client  KMSClient
+	keyIDs  map[uint32]string
+	current uint32
+}
+
+// NewKMSKeyProvider returns a provider for the KMS keys of keyIDs. A zero
+// current selects the highest version.
+func NewKMSKeyProvider(client KMSClient, keyIDs map[uint32]string, current uint32) (*KMSKeyProvider, error) {
+	if current == 0 {
+		for version := range keyIDs {
+			if version > current {
+				current = version
+			}
+		}
+	}
+	if _, ok := keyIDs[current]; !ok {
+		return nil, fmt.Errorf("KMS key for master key version %d is not configured", current)
+	}
+	return &KMSKeyProvider{client: client, keyIDs: keyIDs, current: current}, nil
+}
+
+// CurrentVersion returns the version new data keys are wrapped under
+func (p *KMSKeyProvider) CurrentVersion(ctx context.Context) (uint32, error) {
+	return p.current, nil
+}
+
+// WrapKey encrypts a data key with the KMS key of a version
+func (p *KMSKeyProvider) WrapKey(ctx context.Context, version uint32, dataKey []byte) ([]byte, error) {
+	keyID, ok := p.keyIDs[version]
+	if !ok {
+		return nil, ErrUnknownKeyVersion
+	}
+	return p.client.Encrypt(ctx, keyID, dataKey)
+}
+
+// UnwrapKey decrypts a data key with the KMS key of a version
+func (p *KMSKeyProvider) UnwrapKey(ctx context.Context, version uint32, wrapped []byte) ([]byte, error) {
+	keyID, ok := p.keyIDs[version]
+	if !ok {
+		return nil, ErrUnknownKeyVersion
+	}
+	return p.client.Decrypt(ctx, keyID, wrapped)
+}
+
+// parseKMSKeys parses master key versions and KMS key names written as
+// "1=name,2=name"
+func parseKMSKeys(s string) (map[uint32]string, error) {
+	keyIDs := make(map[uint32]string)
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		version, keyID, ok := strings.Cut(entry, "=")
+		n, err := strconv.ParseUint(version, 10, 32)
+		if !ok || err != nil || n == 0 || keyID == "" {
+			return nil, fmt.Errorf("invalid KMS_KEYS entry %q", entry)
+		}
+		keyIDs[uint32(n)] = keyID
+	}
+	if len(keyIDs) == 0 {
+		return nil, errors.New("KMS_KEYS is not set")
+	}
+	return keyIDs, nil
+}
+
+// TransitKMSClient is a KMSClient for the transit secrets engine of
+// HashiCorp Vault
+type TransitKMSClient struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+func NewTransitKMSClient(addr, token string) *TransitKMSClient {
+	return &TransitKMSClient{
+		addr:   strings.TrimRight(addr, "/"),
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Encrypt encrypts plaintext under a transit key
+func (c *TransitKMSClient) Encrypt(ctx context.Context, keyID string, plaintext []byte) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	err := c.post(ctx, "encrypt/"+keyID, map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(plaintext),
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(resp.Data.Ciphertext), nil
+}
+
+// Decrypt decrypts a ciphertext returned by Encrypt
+func (c *TransitKMSClient) Decrypt(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	err := c.post(ctx, "decrypt/"+keyID, map[string]string{
+		"ciphertext": string(ciphertext),
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+}
+
+func (c *TransitKMSClient) post(ctx context.Context, path string, body interface{}, out interface{}) error {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+"/v1/transit/"+path, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", c.token)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("KMS %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
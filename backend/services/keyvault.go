@@ -0,0 +1,287 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Private keys and seeds are stored with envelope encryption: each value
+// is encrypted under its own random data key, and the data key is wrapped
+// by a master key held by a KeyProvider. The envelope records the master
+// key version, so master keys can be rotated by rewrapping data keys
+// without touching the values themselves.
+
+// envelopePrefix starts every envelope, which is
+// "ev1:<key version>:<wrapped data key>:<nonce and ciphertext>" with both
+// binary parts in base64. Values encrypted before envelopes are plain
+// base64, which never contains ':'.
+const envelopePrefix = "ev1:"
+
+// dataKeyLength is the length in bytes of the per-value AES-256 keys
+const dataKeyLength = 32
+
+// legacyDefaultKey is the key values were encrypted with before envelopes
+// when AES_KEY was unset. It is only ever used to decrypt such values, so
+// that rotation can move them to a master key.
+const legacyDefaultKey = "default-32-byte-key-for-aes-enc!"
+
+var (
+	ErrUnknownKeyVersion = errors.New("unknown master key version")
+	ErrInvalidEnvelope   = errors.New("invalid encrypted value")
+)
+
+// KeyProvider holds the versioned master keys data keys are wrapped with
+type KeyProvider interface {
+	// CurrentVersion returns the version new data keys are wrapped under
+	CurrentVersion(ctx context.Context) (uint32, error)
+	// WrapKey encrypts a data key under a master key version
+	WrapKey(ctx context.Context, version uint32, dataKey []byte) ([]byte, error)
+	// UnwrapKey decrypts a data key wrapped under a master key version
+	UnwrapKey(ctx context.Context, version uint32, wrapped []byte) ([]byte, error)
+}
+
+// KeyConfig selects and configures the key provider
+type KeyConfig struct {
+	Provider      string // "local" or "kms"
+	KeyringFile   string // master keys of the local provider
+	KMSAddr       string // transit endpoint of the KMS provider
+	KMSToken      string
+	KMSKeys       string // master key versions and KMS key names, as "1=name,2=name"
+	KMSCurrentKey uint32 // version new data keys are wrapped under; 0 for the highest
+	LegacyKey     string // AES_KEY values were encrypted with before envelopes
+}
+
+// DefaultKeyConfig returns the key provider defaults
+func DefaultKeyConfig() KeyConfig {
+	return KeyConfig{
+		Provider:    "local",
+		KeyringFile: "keyring.json",
+	}
+}
+
+// KeyConfigFromEnv returns the defaults overridden by environment variables
+func KeyConfigFromEnv() KeyConfig {
+	cfg := DefaultKeyConfig()
+	if provider := os.Getenv("KEY_PROVIDER"); provider != "" {
+		cfg.Provider = provider
+	}
+	if file := os.Getenv("KEYRING_FILE"); file != "" {
+		cfg.KeyringFile = file
+	}
+	cfg.KMSAddr = os.Getenv("KMS_ADDR")
+	cfg.KMSToken = os.Getenv("KMS_TOKEN")
+	cfg.KMSKeys = os.Getenv("KMS_KEYS")
+	cfg.KMSCurrentKey = uint32(envInt("KMS_CURRENT_KEY", 0))
+	cfg.LegacyKey = os.Getenv("AES_KEY")
+	return cfg
+}
+
+// KeyVault encrypts private keys and seeds for storage
+type KeyVault struct {
+	provider  KeyProvider
+	legacyKey []byte
+}
+
+// NewKeyVault returns a vault wrapping data keys with provider. legacyKey
+// decrypts values stored before envelopes; when it is shorter than 32
+// bytes they are taken to use the old built-in default.
+func NewKeyVault(provider KeyProvider, legacyKey string) *KeyVault {
+	if len(legacyKey) < 32 {
+		legacyKey = legacyDefaultKey
+	}
+	return &KeyVault{provider: provider, legacyKey: []byte(legacyKey[:32])}
+}
+
+// OpenKeyVault opens the key provider cfg selects
+func OpenKeyVault(cfg KeyConfig) (*KeyVault, error) {
+	var provider KeyProvider
+	switch cfg.Provider {
+	case "local":
+		local, err := NewLocalKeyProvider(cfg.KeyringFile)
+		if err != nil {
+			return nil, err
+		}
+		provider = local
+	case "kms":
+		if cfg.KMSAddr == "" {
+			return nil, errors.New("KMS_ADDR is not set")
+		}
+		keyIDs, err := parseKMSKeys(cfg.KMSKeys)
+		if err != nil {
+			return nil, err
+		}
+		kms, err := NewKMSKeyProvider(NewTransitKMSClient(cfg.KMSAddr, cfg.KMSToken), keyIDs, cfg.KMSCurrentKey)
+		if err != nil {
+			return nil, err
+		}
+		provider = kms
+	default:
+		return nil, fmt.Errorf("unknown key provider %q", cfg.Provider)
+	}
+	return NewKeyVault(provider, cfg.LegacyKey), nil
+}
+
+// Encrypt seals plaintext under a new data key wrapped by the current
+// master key
+func (v *KeyVault) Encrypt(ctx context.Context, plaintext string) (string, error) {
+	version, err := v.provider.CurrentVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	dataKey := make([]byte, dataKeyLength)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	wrapped, err := v.provider.WrapKey(ctx, version, dataKey)
+	if err != nil {
+		return "", err
+	}
+	body, err := aesSeal(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+
+	return formatEnvelope(version, wrapped, body), nil
+}
+
+// Decrypt opens a value sealed by Encrypt, or encrypted before envelopes
+// with the legacy key
+func (v *KeyVault) Decrypt(ctx context.Context, value string) (string, error) {
+	if !IsEnvelope(value) {
+		ciphertext, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return "", err
+		}
+		plaintext, err := aesOpen(v.legacyKey, ciphertext)
+		return string(plaintext), err
+	}
+
+	version, wrapped, body, err := parseEnvelope(value)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := v.provider.UnwrapKey(ctx, version, wrapped)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := aesOpen(dataKey, body)
+	return string(plaintext), err
+}
+
+// Rewrap moves a value to the current master key. Envelopes only have
+// their data key rewrapped, once the data key is checked to open them;
+// values from before envelopes are encrypted again. It reports whether
+// the value changed.
+func (v *KeyVault) Rewrap(ctx context.Context, value string) (string, bool, error) {
+	version, err := v.provider.CurrentVersion(ctx)
+	if err != nil {
+		return "", false, err
+	}
+
+	if !IsEnvelope(value) {
+		plaintext, err := v.Decrypt(ctx, value)
+		if err != nil {
+			return "", false, err
+		}
+		sealed, err := v.Encrypt(ctx, plaintext)
+		return sealed, err == nil, err
+	}
+
+	old, wrapped, body, err := parseEnvelope(value)
+	if err != nil {
+		return "", false, err
+	}
+	if old == version {
+		return value, false, nil
+	}
+	dataKey, err := v.provider.UnwrapKey(ctx, old, wrapped)
+	if err != nil {
+		return "", false, err
+	}
+	// A damaged value must not be moved to the new key as if it were sound
+	if _, err := aesOpen(dataKey, body); err != nil {
+		return "", false, err
+	}
+	if wrapped, err = v.provider.WrapKey(ctx, version, dataKey); err != nil {
+		return "", false, err
+	}
+	return formatEnvelope(version, wrapped, body), true, nil
+}
+
+// CurrentVersion returns the master key version values are encrypted under
+func (v *KeyVault) CurrentVersion(ctx context.Context) (uint32, error) {
+	return v.provider.CurrentVersion(ctx)
+}
+
+// IsEnvelope reports whether a stored value is envelope encrypted
+func IsEnvelope(value string) bool {
+	return strings.HasPrefix(value, envelopePrefix)
+}
+
+func formatEnvelope(version uint32, wrapped, body []byte) string {
+	return fmt.Sprintf("%s%d:%s:%s", envelopePrefix, version,
+		base64.StdEncoding.EncodeToString(wrapped), base64.StdEncoding.EncodeToString(body))
+}
+
+func parseEnvelope(value string) (uint32, []byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(value, envelopePrefix), ":")
+	if len(parts) != 3 {
+		return 0, nil, nil, ErrInvalidEnvelope
+	}
+	version, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, nil, nil, ErrInvalidEnvelope
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return 0, nil, nil, ErrInvalidEnvelope
+	}
+	body, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return 0, nil, nil, ErrInvalidEnvelope
+	}
+	return uint32(version), wrapped, body, nil
+}
+
+// aesSeal encrypts with AES-GCM, returning the nonce followed by the
+// ciphertext
+func aesSeal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// aesOpen decrypts the output of aesSeal
+func aesOpen(key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
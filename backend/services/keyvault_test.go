@@ -0,0 +1,238 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mockKMS is a KMSClient holding a random AES key per key ID
+type mockKMS struct {
+	keys map[string][]byte
+}
+
+func newMockKMS(t *testing.T, keyIDs ...string) *mockKMS {
+	t.Helper()
+	kms := &mockKMS{keys: make(map[string][]byte)}
+	for _, keyID := range keyIDs {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			t.Fatal(err)
+		}
+		kms.keys[keyID] = key
+	}
+	return kms
+}
+
+func (k *mockKMS) Encrypt(ctx context.Context, keyID string, plaintext []byte) ([]byte, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, errors.New("no such KMS key")
+	}
+	return aesSeal(key, plaintext)
+}
+
+func (k *mockKMS) Decrypt(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, errors.New("no such KMS key")
+	}
+	return aesOpen(key, ciphertext)
+}
+
+// envelopeVersion returns the master key version an envelope names
+func envelopeVersion(t *testing.T, value string) uint32 {
+	t.Helper()
+	version, _, _, err := parseEnvelope(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestKeyVaultRotation(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	if version, err := AddMasterKey(path); err != nil || version != 1 {
+		t.Fatalf("AddMasterKey = %d, %v; want version 1", version, err)
+	}
+	provider, err := NewLocalKeyProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault := NewKeyVault(provider, "")
+
+	v1, err := vault.Encrypt(ctx, "first secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEnvelope(v1) || envelopeVersion(t, v1) != 1 {
+		t.Fatalf("Encrypt = %q, want an envelope under version 1", v1)
+	}
+	if got, err := vault.Decrypt(ctx, v1); err != nil || got != "first secret" {
+		t.Fatalf("Decrypt = %q, %v", got, err)
+	}
+
+	// The running provider picks up a key added to its keyring
+	if version, err := AddMasterKey(path); err != nil || version != 2 {
+		t.Fatalf("AddMasterKey = %d, %v; want version 2", version, err)
+	}
+	if version, err := vault.CurrentVersion(ctx); err != nil || version != 2 {
+		t.Fatalf("CurrentVersion = %d, %v; want 2", version, err)
+	}
+	v2, err := vault.Encrypt(ctx, "second secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelopeVersion(t, v2) != 2 {
+		t.Fatalf("Encrypt = %q, want an envelope under version 2", v2)
+	}
+	for value, want := range map[string]string{v1: "first secret", v2: "second secret"} {
+		if got, err := vault.Decrypt(ctx, value); err != nil || got != want {
+			t.Fatalf("Decrypt after rotation = %q, %v; want %q", got, err, want)
+		}
+	}
+
+	rewrapped, changed, err := vault.Rewrap(ctx, v1)
+	if err != nil || !changed || envelopeVersion(t, rewrapped) != 2 {
+		t.Fatalf("Rewrap of version 1 = %q, %v, %v; want it moved to version 2", rewrapped, changed, err)
+	}
+	if got, err := vault.Decrypt(ctx, rewrapped); err != nil || got != "first secret" {
+		t.Fatalf("Decrypt after Rewrap = %q, %v", got, err)
+	}
+	// Only the data key is rewrapped, so the ciphertext is unchanged
+	if v1[strings.LastIndexByte(v1, ':'):] != rewrapped[strings.LastIndexByte(rewrapped, ':'):] {
+		t.Fatal("Rewrap encrypted the value again")
+	}
+	if same, changed, err := vault.Rewrap(ctx, v2); err != nil || changed || same != v2 {
+		t.Fatalf("Rewrap of version 2 = %q, %v, %v; want it unchanged", same, changed, err)
+	}
+
+	// A restarted provider reads both versions from the keyring
+	reopened, err := NewLocalKeyProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := NewKeyVault(reopened, "").Decrypt(ctx, v1); err != nil || got != "first secret" {
+		t.Fatalf("Decrypt after restart = %q, %v", got, err)
+	}
+}
+
+func TestKeyVaultRejectsTampering(t *testing.T) {
+	ctx := context.Background()
+	kms := newMockKMS(t, "old", "new")
+	keyIDs := map[uint32]string{1: "old", 2: "new"}
+	before, err := NewKMSKeyProvider(kms, keyIDs, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := NewKMSKeyProvider(kms, keyIDs, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault := NewKeyVault(after, "")
+
+	value, err := NewKeyVault(before, "").Encrypt(ctx, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := vault.Decrypt(ctx, value); err != nil || got != "secret" {
+		t.Fatalf("Decrypt = %q, %v", got, err)
+	}
+
+	_, wrapped, body, err := parseEnvelope(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flip := func(b []byte) []byte {
+		b = append([]byte(nil), b...)
+		b[len(b)-1] ^= 1
+		return b
+	}
+
+	tests := []struct {
+		name   string
+		value  string
+		want   error // nil for any error
+		rewrap bool  // Rewrap leaves values under the current version alone
+	}{
+		{"another key version", formatEnvelope(2, wrapped, body), nil, false},
+		{"unknown key version", formatEnvelope(9, wrapped, body), ErrUnknownKeyVersion, true},
+		{"altered wrapped key", formatEnvelope(1, flip(wrapped), body), nil, true},
+		{"altered ciphertext", formatEnvelope(1, wrapped, flip(body)), nil, true},
+		{"missing part", strings.Join(strings.Split(value, ":")[:3], ":"), ErrInvalidEnvelope, true},
+		{"non-numeric version", strings.Replace(value, "ev1:1:", "ev1:one:", 1), ErrInvalidEnvelope, true},
+		{"bad encoding", strings.Replace(value, "ev1:1:", "ev1:1:!", 1), ErrInvalidEnvelope, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := vault.Decrypt(ctx, tt.value)
+			if err == nil {
+				t.Fatalf("tampered value decrypted to %q", got)
+			}
+			if tt.want != nil && err != tt.want {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if _, _, err := vault.Rewrap(ctx, tt.value); tt.rewrap && err == nil {
+				t.Fatal("tampered value rewrapped")
+			}
+		})
+	}
+}
+
+func TestKeyVaultLegacyValues(t *testing.T) {
+	ctx := context.Background()
+	legacy := func(key, plaintext string) string {
+		sealed, err := aesSeal([]byte(key), []byte(plaintext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(sealed)
+	}
+	provider, err := NewKMSKeyProvider(newMockKMS(t, "master"), map[uint32]string{1: "master"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aesKey := "0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		name      string
+		legacyKey string // AES_KEY
+		value     string
+		ok        bool
+	}{
+		{"built-in default key", "", legacy(legacyDefaultKey, "secret"), true},
+		{"short AES_KEY falls back to the default", "short", legacy(legacyDefaultKey, "secret"), true},
+		{"AES_KEY", aesKey, legacy(aesKey, "secret"), true},
+		{"default key when AES_KEY was set", aesKey, legacy(legacyDefaultKey, "secret"), false},
+		{"AES_KEY when it is no longer set", "", legacy(aesKey, "secret"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vault := NewKeyVault(provider, tt.legacyKey)
+			got, err := vault.Decrypt(ctx, tt.value)
+			if !tt.ok {
+				if err == nil {
+					t.Fatalf("decrypted with the wrong legacy key to %q", got)
+				}
+				return
+			}
+			if err != nil || got != "secret" {
+				t.Fatalf("Decrypt = %q, %v", got, err)
+			}
+
+			// Rotation moves legacy values into envelopes
+			rewrapped, changed, err := vault.Rewrap(ctx, tt.value)
+			if err != nil || !changed || !IsEnvelope(rewrapped) || envelopeVersion(t, rewrapped) != 1 {
+				t.Fatalf("Rewrap = %q, %v, %v; want an envelope under version 1", rewrapped, changed, err)
+			}
+			if got, err := vault.Decrypt(ctx, rewrapped); err != nil || got != "secret" {
+				t.Fatalf("Decrypt after Rewrap = %q, %v", got, err)
+			}
+		})
+	}
+}
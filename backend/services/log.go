@@ -5,25 +5,21 @@ import (
 	"time"
 
 	"backend/models"
+	"backend/storage"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type LogService struct {
-	db *mongo.Database
+	store storage.ChainStore
 }
 
-func NewLogService(db *mongo.Database) *LogService {
-	return &LogService{db: db}
+func NewLogService(store storage.ChainStore) *LogService {
+	return &LogService{store: store}
 }
 
 // LogSystemEvent logs a system event
 func (s *LogService) LogSystemEvent(ctx context.Context, action, userID, walletID, details, ipAddress, status string) error {
-	collection := s.db.Collection("system_logs")
-
 	log := models.SystemLog{
 		ID:        primitive.NewObjectID(),
 		Action:    action,
@@ -35,14 +31,11 @@ func (s *LogService) LogSystemEvent(ctx context.Context, action, userID, walletI
 		Timestamp: time.Now(),
 	}
 
-	_, err := collection.InsertOne(ctx, log)
-	return err
+	return s.store.InsertSystemLog(ctx, &log)
 }
 
 // LogTransaction logs a transaction event
-func (s *LogService) LogTransaction(ctx context.Context, txID, action, walletID string, amount float64, blockHash, status, note, ipAddress string) error {
-	collection := s.db.Collection("transaction_logs")
-
+func (s *LogService) LogTransaction(ctx context.Context, txID, action, walletID string, amount models.Amount, blockHash, status, note, ipAddress string) error {
 	log := models.TransactionLog{
 		ID:        primitive.NewObjectID(),
 		TxID:      txID,
@@ -56,50 +49,15 @@ func (s *LogService) LogTransaction(ctx context.Context, txID, action, walletID
 		Timestamp: time.Now(),
 	}
 
-	_, err := collection.InsertOne(ctx, log)
-	return err
+	return s.store.InsertTransactionLog(ctx, &log)
 }
 
 // GetSystemLogs returns system logs
 func (s *LogService) GetSystemLogs(ctx context.Context, limit int64) ([]models.SystemLog, error) {
-	collection := s.db.Collection("system_logs")
-
-	opts := options.Find().
-		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
-		SetLimit(limit)
-
-	cursor, err := collection.Find(ctx, bson.M{}, opts)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(ctx)
-
-	var logs []models.SystemLog
-	if err := cursor.All(ctx, &logs); err != nil {
-		return nil, err
-	}
-
-	return logs, nil
+	return s.store.GetSystemLogs(ctx, limit)
 }
 
 // GetTransactionLogs returns transaction logs for a wallet
 func (s *LogService) GetTransactionLogs(ctx context.Context, walletID string, limit int64) ([]models.TransactionLog, error) {
-	collection := s.db.Collection("transaction_logs")
-
-	opts := options.Find().
-		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
-		SetLimit(limit)
-
-	cursor, err := collection.Find(ctx, bson.M{"wallet_id": walletID}, opts)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(ctx)
-
-	var logs []models.TransactionLog
-	if err := cursor.All(ctx, &logs); err != nil {
-		return nil, err
-	}
-
-	return logs, nil
+	return s.store.GetTransactionLogs(ctx, walletID, limit)
 }
@@ -0,0 +1,594 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"backend/models"
+)
+
+// BlockLimits bounds the size of a block. Blocks over either limit are
+// invalid, and block templates are filled up to them.
+type BlockLimits struct {
+	MaxBlockSize         int           // encoded block size in bytes
+	MaxBlockTransactions int           // transactions per block, coinbase included
+	MinFeeRate           float64       // lowest fee per byte accepted into the mempool
+	MaxOrphans           int           // orphan blocks kept while their parent is unknown
+	OrphanExpiry         time.Duration // orphans older than this are dropped
+}
+
+// DefaultBlockLimits returns the block limit defaults
+func DefaultBlockLimits() BlockLimits {
+	return BlockLimits{
+		MaxBlockSize:         1000000,
+		MaxBlockTransactions: 2000,
+		MinFeeRate:           0,
+		MaxOrphans:           100,
+		OrphanExpiry:         time.Hour,
+	}
+}
+
+// BlockLimitsFromEnv returns the defaults overridden by environment variables
+func BlockLimitsFromEnv() BlockLimits {
+	cfg := DefaultBlockLimits()
+	cfg.MaxBlockSize = envInt("BLOCK_MAX_SIZE", cfg.MaxBlockSize)
+	cfg.MaxBlockTransactions = envInt("BLOCK_MAX_TRANSACTIONS", cfg.MaxBlockTransactions)
+	cfg.MinFeeRate = envFloat("MEMPOOL_MIN_FEE_RATE", cfg.MinFeeRate)
+	cfg.MaxOrphans = envInt("BLOCK_MAX_ORPHANS", cfg.MaxOrphans)
+	cfg.OrphanExpiry = time.Duration(envInt("BLOCK_ORPHAN_EXPIRY_MINUTES", int(cfg.OrphanExpiry/time.Minute))) * time.Minute
+	return cfg
+}
+
+// TypicalTransactionSize is the encoded size of a one-input, two-output
+// transfer, used to turn fee rates into fees
+const TypicalTransactionSize = 400
+
+// Confirmation targets, in blocks, reported by fee estimation
+var feeEstimateTargets = map[string]int{
+	"fast":   1,
+	"normal": 3,
+	"slow":   6,
+}
+
+// TransactionSize returns the space a transaction takes in an encoded block
+func TransactionSize(tx *models.Transaction) int {
+	encoded := len(EncodeTransaction(tx))
+	return uvarintSize(len(tx.TxID)) + len(tx.TxID) + uvarintSize(encoded) + encoded
+}
+
+func uvarintSize(n int) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], uint64(n))
+}
+
+// FeeRate returns a transaction's fee per encoded byte
+func FeeRate(tx *models.Transaction) float64 {
+	return tx.Fee.Float64() / float64(TransactionSize(tx))
+}
+
+// FeeForSize returns the fee paying rate per byte for size bytes, rounded
+// up to a whole base unit
+func FeeForSize(rate float64, size int) models.Amount {
+	// Allow for float error so that exact products are not rounded up
+	return models.Amount(math.Ceil(rate*float64(size)*float64(models.Coin) - 1e-6))
+}
+
+// SortByFeeRate orders transactions by fee rate, highest first. Ties keep
+// the oldest transaction first.
+func SortByFeeRate(txs []models.Transaction) {
+	sort.SliceStable(txs, func(i, j int) bool {
+		ri, rj := FeeRate(&txs[i]), FeeRate(&txs[j])
+		if ri != rj {
+			return ri > rj
+		}
+		return txs[i].Timestamp.Before(txs[j].Timestamp)
+	})
+}
+
+// checkBlockLimits rejects blocks over the size or transaction count limit
+func (s *BlockchainService) checkBlockLimits(block *models.Block) error {
+	if s.limits.MaxBlockTransactions > 0 && len(block.Transactions) > s.limits.MaxBlockTransactions {
+		return ruleError(RuleBlockSize, "", "block has too many transactions")
+	}
+	if s.limits.MaxBlockSize > 0 && len(EncodeBlock(block)) > s.limits.MaxBlockSize {
+		return ruleError(RuleBlockSize, "", "block exceeds the maximum size")
+	}
+	return nil
+}
+
+// checkFee verifies that a transaction's declared fee is what its inputs
+// leave over after paying its outputs
+func checkFee(tx *models.Transaction, inputSum, outputSum models.Amount) error {
+	if tx.Fee < 0 {
+		return ruleError(RuleFee, tx.TxID, "fee must not be negative")
+	}
+	if len(tx.InputUTXOs) == 0 {
+		if tx.Fee != 0 {
+			return ruleError(RuleFee, tx.TxID, "transaction without inputs cannot pay a fee")
+		}
+		return nil
+	}
+	if diff := inputSum - outputSum - tx.Fee; diff > roundingTolerance(tx) || -diff > roundingTolerance(tx) {
+		return ruleError(RuleFee, tx.TxID, "fee does not equal inputs minus outputs")
+	}
+	return nil
+}
+
+// SelectBlockTransactions fills a block template from candidates, which
+// should already be sorted by fee rate. reserved bytes and one transaction
+// slot are kept free for the coinbase. Candidates spending outputs of
+// other candidates are retried once their parent is selected; candidates
+// that break a consensus rule are returned as invalid.
+func (s *BlockchainService) SelectBlockTransactions(ctx context.Context, candidates []models.Transaction, reserved int) (selected, invalid []models.Transaction, err error) {
+	utxos, err := s.loadSpentOutputs(ctx, candidates)
+	if err != nil {
+		return nil, nil, err
+	}
+	allocations, err := s.loadAllocations(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	size := reserved
+	seen := make(map[string]bool)
+	remaining := candidates
+	for progress := true; progress && len(remaining) > 0; {
+		progress = false
+		var deferred []models.Transaction
+		for i := range remaining {
+			tx := &remaining[i]
+
+			if s.limits.MaxBlockTransactions > 0 && len(selected)+1 >= s.limits.MaxBlockTransactions {
+				return selected, invalid, nil
+			}
+			txSize := TransactionSize(tx)
+			if s.limits.MaxBlockSize > 0 && size+txSize > s.limits.MaxBlockSize {
+				continue // a smaller transaction may still fit
+			}
+
+			if _, err := s.replayChecked(tx, utxos, seen, allocations); err != nil {
+				var rerr *RuleError
+				if errors.As(err, &rerr) && rerr.Rule == RuleMissingInput {
+					deferred = append(deferred, *tx)
+					continue
+				}
+				invalid = append(invalid, *tx)
+				continue
+			}
+
+			selected = append(selected, *tx)
+			size += txSize
+			progress = true
+		}
+		remaining = deferred
+	}
+
+	// Inputs that no candidate will ever create
+	candidateIDs := make(map[string]bool)
+	for _, tx := range candidates {
+		candidateIDs[tx.TxID] = true
+	}
+	for _, tx := range remaining {
+		for _, input := range tx.InputUTXOs {
+			if _, ok := utxos[outpointKey(input.TxID, input.OutputIndex)]; !ok && !candidateIDs[input.TxID] {
+				invalid = append(invalid, tx)
+				break
+			}
+		}
+	}
+
+	return selected, invalid, nil
+}
+
+// FeeEstimate suggests fee rates for confirmation within a number of blocks
+type FeeEstimate struct {
+	FeeRates     map[string]float64       `json:"feeRates"` // per byte
+	Fees         map[string]models.Amount `json:"fees"`     // for a typical transfer
+	Targets      map[string]int           `json:"targets"`  // blocks
+	TypicalSize  int                      `json:"typicalSize"`
+	MinFeeRate   float64                  `json:"minFeeRate"`
+	MempoolSize  int                      `json:"mempoolSize"`
+	MempoolBytes int                      `json:"mempoolBytes"`
+	MaxBlockSize int                      `json:"maxBlockSize"`
+}
+
+// EstimateFees simulates filling the next blocks from the mempool, highest
+// fee rate first. The estimate for a target is the rate of the last
+// transaction that fits within that many blocks, or the minimum fee rate
+// when the mempool would be cleared sooner.
+func (s *TransactionService) EstimateFees(ctx context.Context) (*FeeEstimate, error) {
+	pending, err := s.GetPendingTransactions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := s.blockchain.GetBlockLimits()
+	estimate := &FeeEstimate{
+		FeeRates:     make(map[string]float64),
+		Fees:         make(map[string]models.Amount),
+		Targets:      feeEstimateTargets,
+		TypicalSize:  TypicalTransactionSize,
+		MinFeeRate:   limits.MinFeeRate,
+		MempoolSize:  len(pending),
+		MaxBlockSize: limits.MaxBlockSize,
+	}
+
+	// Block at which each pending transaction would be mined
+	blocks := make([]int, len(pending))
+	block, size, count := 1, 0, 1 // count starts at one for the coinbase
+	for i := range pending {
+		txSize := TransactionSize(&pending[i])
+		estimate.MempoolBytes += txSize
+		full := limits.MaxBlockSize > 0 && size+txSize > limits.MaxBlockSize
+		if limits.MaxBlockTransactions > 0 && count+1 > limits.MaxBlockTransactions {
+			full = true
+		}
+		if full {
+			block++
+			size, count = 0, 1
+		}
+		size += txSize
+		count++
+		blocks[i] = block
+	}
+
+	for name, target := range feeEstimateTargets {
+		rate := limits.MinFeeRate
+		if len(pending) > 0 && blocks[len(pending)-1] > target {
+			// Outbid the cheapest transaction that still makes it in
+			for i := len(pending) - 1; i >= 0; i-- {
+				if blocks[i] <= target {
+					rate = math.Max(rate, FeeRate(&pending[i]))
+					break
+				}
+			}
+		}
+		estimate.FeeRates[name] = rate
+		estimate.Fees[name] = FeeForSize(rate, TypicalTransactionSize)
+	}
+
+	return estimate, nil
+}
+
+// MempoolConfig controls which transactions are admitted, how long they
+// are kept and when a conflicting spend may replace them
+type MempoolConfig struct {
+	Expiry          time.Duration // pending transactions older than this are dropped
+	ReplacementBump float64       // fraction by which a replacement must raise the fee rate
+	MaxClockSkew    time.Duration // how far a signed timestamp may be from the server's clock
+}
+
+// DefaultMempoolConfig returns the mempool defaults
+func DefaultMempoolConfig() MempoolConfig {
+	return MempoolConfig{
+		Expiry:          72 * time.Hour,
+		ReplacementBump: 0.1,
+		MaxClockSkew:    5 * time.Minute,
+	}
+}
+
+// MempoolConfigFromEnv returns the defaults overridden by environment variables
+func MempoolConfigFromEnv() MempoolConfig {
+	cfg := DefaultMempoolConfig()
+	cfg.Expiry = time.Duration(envInt("MEMPOOL_EXPIRY_HOURS", int(cfg.Expiry/time.Hour))) * time.Hour
+	cfg.ReplacementBump = envFloat("MEMPOOL_REPLACEMENT_BUMP", cfg.ReplacementBump)
+	cfg.MaxClockSkew = time.Duration(envInt("MEMPOOL_MAX_CLOCK_SKEW_SECONDS", int(cfg.MaxClockSkew/time.Second))) * time.Second
+	return cfg
+}
+
+var (
+	ErrAlreadyPending  = errors.New("transaction is already pending")
+	ErrMempoolConflict = errors.New("transaction spends an output reserved by a pending transaction")
+	ErrReplacementFee  = errors.New("replacement must pay a higher fee rate and at least the fees of the transactions it replaces")
+	ErrNotReplaceable  = errors.New("transaction conflicts with a pending transaction of another sender")
+	errMempoolCoinbase = errors.New("coinbase transactions cannot be pending")
+)
+
+// Mempool holds the pending transactions and the outpoints they spend, so
+// that no two pending transactions reserve the same output
+type Mempool struct {
+	config   MempoolConfig
+	entries  map[string]models.Transaction // by txid
+	reserved map[string]string             // outpoint -> txid spending it
+	mu       sync.Mutex
+}
+
+// NewMempool returns an empty mempool
+func NewMempool(config MempoolConfig) *Mempool {
+	return &Mempool{
+		config:   config,
+		entries:  make(map[string]models.Transaction),
+		reserved: make(map[string]string),
+	}
+}
+
+// Add admits a transaction and reserves its inputs. A transaction that
+// spends outputs reserved by other pending transactions replaces them, and
+// anything spending their outputs, when it comes from the same sender,
+// raises the fee rate of each by ReplacementBump and pays at least their
+// combined fee. The replaced transactions are returned.
+func (m *Mempool) Add(tx *models.Transaction) ([]models.Transaction, error) {
+	if IsCoinbase(tx) {
+		return nil, errMempoolCoinbase
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.entries[tx.TxID]; ok {
+		return nil, ErrAlreadyPending
+	}
+
+	conflicts := m.conflicts(tx)
+	if len(conflicts) > 0 {
+		if m.config.ReplacementBump < 0 {
+			return nil, ErrMempoolConflict
+		}
+		var conflictFees models.Amount
+		rate := FeeRate(tx)
+		for _, txID := range conflicts {
+			old := m.entries[txID]
+			if old.SenderWalletID != tx.SenderWalletID {
+				return nil, ErrNotReplaceable
+			}
+			if rate < FeeRate(&old)*(1+m.config.ReplacementBump) || rate <= FeeRate(&old) {
+				return nil, ErrReplacementFee
+			}
+			conflictFees += old.Fee
+		}
+		if tx.Fee < conflictFees {
+			return nil, ErrReplacementFee
+		}
+	}
+
+	var replaced []models.Transaction
+	for _, txID := range conflicts {
+		replaced = append(replaced, m.remove(txID)...)
+	}
+	m.insert(*tx)
+
+	return replaced, nil
+}
+
+// Restore puts back transactions from a disconnected block without
+// replacement checks. Coinbases and transactions that now conflict are skipped.
+func (m *Mempool) Restore(txs []models.Transaction) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range txs {
+		if IsCoinbase(&txs[i]) {
+			continue
+		}
+		if _, ok := m.entries[txs[i].TxID]; ok || len(m.conflicts(&txs[i])) > 0 {
+			continue
+		}
+		m.insert(txs[i])
+	}
+}
+
+// Confirm drops transactions included in a connected block. Pending
+// transactions spending the same outputs can never confirm; they are
+// dropped too, with their descendants, and returned.
+func (m *Mempool) Confirm(txs []models.Transaction) []models.Transaction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var evicted []models.Transaction
+	for i := range txs {
+		tx := &txs[i]
+		if _, ok := m.entries[tx.TxID]; ok {
+			m.unreserve(m.entries[tx.TxID])
+			delete(m.entries, tx.TxID)
+		}
+		for _, input := range tx.InputUTXOs {
+			if txID, ok := m.reserved[outpointKey(input.TxID, input.OutputIndex)]; ok {
+				evicted = append(evicted, m.remove(txID)...)
+			}
+		}
+	}
+	return evicted
+}
+
+// Remove drops transactions and anything spending their outputs, returning
+// every transaction removed
+func (m *Mempool) Remove(txIDs ...string) []models.Transaction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var removed []models.Transaction
+	for _, txID := range txIDs {
+		removed = append(removed, m.remove(txID)...)
+	}
+	return removed
+}
+
+// Expire drops transactions older than the configured expiry, with their
+// descendants, and returns them. Allocations spend nothing, so they never
+// go stale and are kept.
+func (m *Mempool) Expire(now time.Time) []models.Transaction {
+	if m.config.Expiry <= 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var expired []models.Transaction
+	for txID, tx := range m.entries {
+		if now.Sub(tx.Timestamp) > m.config.Expiry && !IsAllocation(&tx) {
+			expired = append(expired, m.remove(txID)...)
+		}
+	}
+	return expired
+}
+
+// Rebuild replaces the mempool's contents with pending transactions loaded
+// from storage. Where stored transactions conflict, the highest fee rate
+// wins; coinbases and the losers are returned.
+func (m *Mempool) Rebuild(pending []models.Transaction) []models.Transaction {
+	SortByFeeRate(pending)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries = make(map[string]models.Transaction)
+	m.reserved = make(map[string]string)
+
+	var dropped []models.Transaction
+	for i := range pending {
+		tx := &pending[i]
+		if _, ok := m.entries[tx.TxID]; ok {
+			continue
+		}
+		if IsCoinbase(tx) || len(m.conflicts(tx)) > 0 {
+			dropped = append(dropped, *tx)
+			continue
+		}
+		m.insert(*tx)
+	}
+	return dropped
+}
+
+// Transactions returns the pending transactions, highest fee rate first
+func (m *Mempool) Transactions() []models.Transaction {
+	m.mu.Lock()
+	txs := make([]models.Transaction, 0, len(m.entries))
+	for _, tx := range m.entries {
+		txs = append(txs, tx)
+	}
+	m.mu.Unlock()
+
+	SortByFeeRate(txs)
+	return txs
+}
+
+// Config returns the mempool's configuration
+func (m *Mempool) Config() MempoolConfig {
+	return m.config
+}
+
+// IsReserved reports whether a pending transaction spends an output
+func (m *Mempool) IsReserved(txID string, outputIndex int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.reserved[outpointKey(txID, outputIndex)]
+	return ok
+}
+
+// Len returns the number of pending transactions
+func (m *Mempool) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// conflicts returns the pending transactions spending any of tx's inputs.
+// Callers hold m.mu.
+func (m *Mempool) conflicts(tx *models.Transaction) []string {
+	var txIDs []string
+	seen := make(map[string]bool)
+	for _, input := range tx.InputUTXOs {
+		txID, ok := m.reserved[outpointKey(input.TxID, input.OutputIndex)]
+		if ok && !seen[txID] {
+			seen[txID] = true
+			txIDs = append(txIDs, txID)
+		}
+	}
+	return txIDs
+}
+
+// insert adds tx and reserves its inputs. Callers hold m.mu.
+func (m *Mempool) insert(tx models.Transaction) {
+	m.entries[tx.TxID] = tx
+	for _, input := range tx.InputUTXOs {
+		m.reserved[outpointKey(input.TxID, input.OutputIndex)] = tx.TxID
+	}
+}
+
+// unreserve releases the inputs tx reserved. Callers hold m.mu.
+func (m *Mempool) unreserve(tx models.Transaction) {
+	for _, input := range tx.InputUTXOs {
+		key := outpointKey(input.TxID, input.OutputIndex)
+		if m.reserved[key] == tx.TxID {
+			delete(m.reserved, key)
+		}
+	}
+}
+
+// remove drops a transaction and, recursively, the pending transactions
+// spending its outputs. Callers hold m.mu.
+func (m *Mempool) remove(txID string) []models.Transaction {
+	tx, ok := m.entries[txID]
+	if !ok {
+		return nil
+	}
+	m.unreserve(tx)
+	delete(m.entries, txID)
+
+	removed := []models.Transaction{tx}
+	for _, output := range tx.OutputUTXOs {
+		if child, ok := m.reserved[outpointKey(tx.TxID, output.Index)]; ok {
+			removed = append(removed, m.remove(child)...)
+		}
+	}
+	return removed
+}
+
+// RebuildMempool loads the pending transactions from storage into the
+// mempool. Transactions that conflict with a better paying one, or have
+// expired, are marked rejected.
+func (s *BlockchainService) RebuildMempool(ctx context.Context) error {
+	pending, err := s.store.GetTransactionsByStatus(ctx, "pending")
+	if err != nil {
+		return err
+	}
+
+	dropped := s.mempool.Rebuild(pending)
+	dropped = append(dropped, s.mempool.Expire(time.Now())...)
+	return s.setRejected(ctx, dropped)
+}
+
+// GetMempool returns the pending transaction pool
+func (s *BlockchainService) GetMempool() *Mempool {
+	return s.mempool
+}
+
+// rejectTransactions drops transactions and their descendants from the
+// mempool and marks them all rejected
+func (s *BlockchainService) rejectTransactions(ctx context.Context, txIDs []string) error {
+	removed := s.mempool.Remove(txIDs...)
+	seen := make(map[string]bool)
+	for _, txID := range txIDs {
+		seen[txID] = true
+	}
+	for _, tx := range removed {
+		if !seen[tx.TxID] {
+			seen[tx.TxID] = true
+			txIDs = append(txIDs, tx.TxID)
+		}
+	}
+
+	if len(txIDs) == 0 {
+		return nil
+	}
+	return s.store.SetTransactionStatus(ctx, txIDs, "rejected", "")
+}
+
+// setRejected marks transactions already dropped from the mempool rejected
+func (s *BlockchainService) setRejected(ctx context.Context, txs []models.Transaction) error {
+	if len(txs) == 0 {
+		return nil
+	}
+	var txIDs []string
+	for _, tx := range txs {
+		txIDs = append(txIDs, tx.TxID)
+	}
+	return s.store.SetTransactionStatus(ctx, txIDs, "rejected", "")
+}
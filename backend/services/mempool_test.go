@@ -0,0 +1,319 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/models"
+	"backend/storage"
+)
+
+// spend builds an unsigned pending transaction spending the given
+// outpoints ("txid:index") into two outputs. The mempool does not check
+// signatures, so its tests do without them.
+func spend(txID, sender string, fee models.Amount, timestamp time.Time, outpoints ...string) models.Transaction {
+	tx := models.Transaction{
+		TxID:           txID,
+		SenderWalletID: sender,
+		Fee:            fee,
+		Timestamp:      timestamp,
+		Type:           "transfer",
+		OutputUTXOs: []models.UTXOOutput{
+			{WalletID: "receiver", Amount: models.Coin, Index: 0},
+			{WalletID: sender, Amount: models.Coin, Index: 1},
+		},
+	}
+	for _, outpoint := range outpoints {
+		txID, index, _ := strings.Cut(outpoint, ":")
+		n, _ := strconv.Atoi(index)
+		tx.InputUTXOs = append(tx.InputUTXOs, models.UTXOInput{TxID: txID, OutputIndex: n})
+	}
+	return tx
+}
+
+func txIDs(txs []models.Transaction) []string {
+	ids := make([]string, 0, len(txs))
+	for _, tx := range txs {
+		ids = append(ids, tx.TxID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestMempoolReplacement(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name        string
+		pending     []models.Transaction
+		replacement models.Transaction
+		err         error
+		replaced    []string
+	}{
+		{
+			name:        "higher fee rate and fee",
+			pending:     []models.Transaction{spend("old", "alice", 1000, now, "a:0")},
+			replacement: spend("new", "alice", 1200, now, "a:0"),
+			replaced:    []string{"old"},
+		},
+		{
+			name:        "fee bump below the minimum",
+			pending:     []models.Transaction{spend("old", "alice", 1000, now, "a:0")},
+			replacement: spend("new", "alice", 1050, now, "a:0"),
+			err:         ErrReplacementFee,
+		},
+		{
+			name:        "another sender's spend",
+			pending:     []models.Transaction{spend("old", "alice", 1000, now, "a:0")},
+			replacement: spend("new", "mallory", 5000, now, "a:0"),
+			err:         ErrNotReplaceable,
+		},
+		{
+			name: "several parents, outbidding each but not their combined fee",
+			pending: []models.Transaction{
+				spend("p1", "alice", 1000, now, "a:0"),
+				spend("p2", "alice", 1000, now, "b:0"),
+				spend("child", "receiver", 500, now, "p1:0"),
+			},
+			replacement: spend("new", "alice", 1900, now, "a:0", "b:0"),
+			err:         ErrReplacementFee,
+		},
+		{
+			name: "several parents and a descendant",
+			pending: []models.Transaction{
+				spend("p1", "alice", 1000, now, "a:0"),
+				spend("p2", "alice", 1000, now, "b:0"),
+				spend("child", "receiver", 500, now, "p1:0"),
+			},
+			replacement: spend("new", "alice", 2500, now, "a:0", "b:0"),
+			replaced:    []string{"child", "p1", "p2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMempool(DefaultMempoolConfig())
+			for i := range tt.pending {
+				if _, err := m.Add(&tt.pending[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			replaced, err := m.Add(&tt.replacement)
+			if err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if fmt.Sprint(txIDs(replaced)) != fmt.Sprint(tt.replaced) {
+				t.Fatalf("replaced %v, want %v", txIDs(replaced), tt.replaced)
+			}
+
+			// Refused replacements leave the mempool as it was
+			want := txIDs(tt.pending)
+			if err == nil {
+				want = []string{tt.replacement.TxID}
+			}
+			if got := txIDs(m.Transactions()); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("mempool = %v, want %v", got, want)
+			}
+			for _, input := range tt.replacement.InputUTXOs {
+				if !m.IsReserved(input.TxID, input.OutputIndex) {
+					t.Fatalf("input %s:%d is no longer reserved", input.TxID, input.OutputIndex)
+				}
+			}
+		})
+	}
+}
+
+func TestMempoolExpire(t *testing.T) {
+	cfg := DefaultMempoolConfig()
+	now := time.Now()
+	old := now.Add(-cfg.Expiry - time.Minute)
+
+	grant := models.Transaction{TxID: "allocation", ReceiverWalletID: "bob", Type: AllocationTransactionType, Timestamp: old}
+	tests := []struct {
+		name    string
+		pending []models.Transaction
+		expired []string
+	}{
+		{
+			name: "expired child of a pending parent",
+			pending: []models.Transaction{
+				spend("parent", "alice", 1000, now, "a:0"),
+				spend("child", "receiver", 1000, old, "parent:0"),
+			},
+			expired: []string{"child"},
+		},
+		{
+			name: "expired parent takes its child",
+			pending: []models.Transaction{
+				spend("parent", "alice", 1000, old, "a:0"),
+				spend("child", "receiver", 1000, now, "parent:0"),
+			},
+			expired: []string{"child", "parent"},
+		},
+		{
+			name:    "allocations never expire",
+			pending: []models.Transaction{grant, spend("stale", "alice", 1000, old, "a:0")},
+			expired: []string{"stale"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMempool(cfg)
+			for i := range tt.pending {
+				if _, err := m.Add(&tt.pending[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			expired := m.Expire(now)
+			if fmt.Sprint(txIDs(expired)) != fmt.Sprint(tt.expired) {
+				t.Fatalf("expired %v, want %v", txIDs(expired), tt.expired)
+			}
+			if got := m.Len(); got != len(tt.pending)-len(tt.expired) {
+				t.Fatalf("%d transactions left, want %d", got, len(tt.pending)-len(tt.expired))
+			}
+			for _, tx := range expired {
+				for _, input := range tx.InputUTXOs {
+					if m.IsReserved(input.TxID, input.OutputIndex) {
+						t.Fatalf("input %s:%d of expired %s is still reserved", input.TxID, input.OutputIndex, tx.TxID)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRebuildMempoolAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	cfg := DefaultMempoolConfig()
+	now := time.Now()
+
+	coinbase := models.Transaction{TxID: "coinbase", SenderWalletID: "system", Type: CoinbaseTransactionType, Timestamp: now}
+	stored := []models.Transaction{
+		spend("cheap", "alice", 1000, now, "a:0"),
+		spend("better", "alice", 5000, now, "a:0"),
+		spend("child", "receiver", 1000, now, "better:0"),
+		spend("expired", "bob", 1000, now.Add(-cfg.Expiry-time.Minute), "b:0"),
+		spend("confirmed", "carol", 1000, now, "c:0"),
+		coinbase,
+	}
+	for i := range stored {
+		stored[i].Status = "pending"
+		if stored[i].TxID == "confirmed" {
+			stored[i].Status = "confirmed"
+		}
+		if err := store.InsertTransaction(ctx, &stored[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A fresh service over the same store starts with an empty mempool
+	bc := NewBlockchainService(store, DefaultDifficultyConfig(), DefaultSubsidyConfig(), DefaultBlockLimits(), cfg, DefaultNetworkConfig())
+	if err := bc.RebuildMempool(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := txIDs(bc.GetMempool().Transactions()); fmt.Sprint(got) != "[better child]" {
+		t.Fatalf("mempool = %v, want [better child]", got)
+	}
+	for txID, want := range map[string]string{
+		"better":    "pending",
+		"child":     "pending",
+		"cheap":     "rejected",
+		"expired":   "rejected",
+		"coinbase":  "rejected",
+		"confirmed": "confirmed",
+	} {
+		tx, err := store.GetTransaction(ctx, txID)
+		if err != nil || tx.Status != want {
+			t.Fatalf("%s = %v, %v; want %s", txID, tx, err, want)
+		}
+	}
+}
+
+func TestSelectBlockTransactions(t *testing.T) {
+	ctx := context.Background()
+	alice, bob, carol := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	store := storage.NewMemoryStore()
+	var funding []models.UTXOInput
+	for i := 0; i < 4; i++ {
+		utxo := models.UTXO{TxID: "funding", OutputIndex: i, WalletID: alice.walletID, Amount: 10 * models.Coin}
+		if err := store.InsertUTXO(ctx, &utxo); err != nil {
+			t.Fatal(err)
+		}
+		funding = append(funding, models.UTXOInput{TxID: "funding", OutputIndex: i, Amount: utxo.Amount})
+	}
+
+	// The child pays the higher fee rate, so it is offered before the
+	// output it spends exists
+	parent := transfer(t, alice.walletID, alice, bob.walletID, funding[:1], 9*models.Coin)
+	child := transfer(t, bob.walletID, bob, carol.walletID, []models.UTXOInput{{TxID: parent.TxID, OutputIndex: 0, Amount: 9 * models.Coin}}, 7*models.Coin)
+	// Three transfers of the same size paying decreasing fees
+	var others []models.Transaction
+	for i, fee := range []models.Amount{3000, 2000, 1000} {
+		others = append(others, transfer(t, alice.walletID, alice, carol.walletID, funding[i+1:i+2], 10*models.Coin-fee))
+	}
+	orphan := transfer(t, alice.walletID, alice, carol.walletID, []models.UTXOInput{{TxID: "nowhere", OutputIndex: 0, Amount: models.Coin}}, models.Coin)
+
+	size := TransactionSize(&others[0])
+	tests := []struct {
+		name       string
+		limits     func(*BlockLimits)
+		candidates []models.Transaction
+		selected   []string
+		invalid    []string
+	}{
+		{
+			name:       "child before its parent",
+			candidates: []models.Transaction{child, parent},
+			selected:   []string{parent.TxID, child.TxID},
+		},
+		{
+			name:       "input no candidate creates",
+			candidates: []models.Transaction{orphan, parent},
+			selected:   []string{parent.TxID},
+			invalid:    []string{orphan.TxID},
+		},
+		{
+			name:       "full on size",
+			limits:     func(l *BlockLimits) { l.MaxBlockSize = 100 + 2*size + size/2 },
+			candidates: others,
+			selected:   []string{others[0].TxID, others[1].TxID},
+		},
+		{
+			name:       "full on transaction count",
+			limits:     func(l *BlockLimits) { l.MaxBlockTransactions = 2 },
+			candidates: others,
+			selected:   []string{others[0].TxID},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limits := DefaultBlockLimits()
+			if tt.limits != nil {
+				tt.limits(&limits)
+			}
+			bc := NewBlockchainService(store, DefaultDifficultyConfig(), DefaultSubsidyConfig(), limits, DefaultMempoolConfig(), DefaultNetworkConfig())
+
+			selected, invalid, err := bc.SelectBlockTransactions(ctx, tt.candidates, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, tx := range selected {
+				got = append(got, tx.TxID)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.selected) {
+				t.Fatalf("selected %v, want %v in order", got, tt.selected)
+			}
+			if fmt.Sprint(txIDs(invalid)) != fmt.Sprint(tt.invalid) {
+				t.Fatalf("invalid %v, want %v", txIDs(invalid), tt.invalid)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"backend/models"
+	"backend/storage"
+)
+
+// Merkle tree node prefixes. Hashing leaves and inner nodes under different
+// prefixes stops an inner node from being passed off as a leaf.
+const (
+	merkleLeafPrefix  = 0x00
+	merkleInnerPrefix = 0x01
+)
+
+// EmptyMerkleRoot is the merkle root of a block without transactions
+const EmptyMerkleRoot = "0"
+
+// MerkleProofStep is one sibling on the path from a leaf to the root
+type MerkleProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"` // sibling is the left operand
+}
+
+// MerkleProof proves that a transaction is included in a block
+type MerkleProof struct {
+	BlockHash  string            `json:"blockHash"`
+	Header     string            `json:"header"` // hex canonical block header
+	MerkleRoot string            `json:"merkleRoot"`
+	TxID       string            `json:"txId"`
+	Index      int               `json:"index"`
+	Siblings   []MerkleProofStep `json:"siblings"`
+}
+
+func merkleLeaf(txID string) []byte {
+	hash := sha256.Sum256(append([]byte{merkleLeafPrefix}, txID...))
+	return hash[:]
+}
+
+func merkleInner(left, right []byte) []byte {
+	data := make([]byte, 0, 1+len(left)+len(right))
+	data = append(data, merkleInnerPrefix)
+	data = append(data, left...)
+	data = append(data, right...)
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+// merkleLevels builds every level of the tree, leaves first.
+// A node without a sibling is carried up to the next level unchanged.
+func merkleLevels(txIDs []string) [][][]byte {
+	level := make([][]byte, len(txIDs))
+	for i, txID := range txIDs {
+		level[i] = merkleLeaf(txID)
+	}
+
+	levels := [][][]byte{level}
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, merkleInner(level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+// MerkleRoot computes the tagged merkle root of a list of tx IDs
+func MerkleRoot(txIDs []string) string {
+	if len(txIDs) == 0 {
+		return EmptyMerkleRoot
+	}
+	levels := merkleLevels(txIDs)
+	return hex.EncodeToString(levels[len(levels)-1][0])
+}
+
+// BuildMerkleProof returns the sibling path for the tx ID at index
+func BuildMerkleProof(txIDs []string, index int) ([]MerkleProofStep, error) {
+	if index < 0 || index >= len(txIDs) {
+		return nil, errors.New("transaction index out of range")
+	}
+
+	steps := []MerkleProofStep{}
+	for _, level := range merkleLevels(txIDs) {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			steps = append(steps, MerkleProofStep{
+				Hash: hex.EncodeToString(level[sibling]),
+				Left: sibling < index,
+			})
+		}
+		index /= 2
+	}
+	return steps, nil
+}
+
+// ComputeMerkleRootFromProof folds a sibling path over a tx ID
+func ComputeMerkleRootFromProof(txID string, steps []MerkleProofStep) (string, error) {
+	node := merkleLeaf(txID)
+	for _, step := range steps {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil || len(sibling) != sha256.Size {
+			return "", errors.New("invalid sibling hash")
+		}
+		if step.Left {
+			node = merkleInner(sibling, node)
+		} else {
+			node = merkleInner(node, sibling)
+		}
+	}
+	return hex.EncodeToString(node), nil
+}
+
+// VerifyMerkleProof checks a proof against a block header: the header must
+// hash to the proven block hash and carry valid proof-of-work, and the
+// sibling path must lead from the transaction to the header's merkle root
+func VerifyMerkleProof(header *models.Block, proof *MerkleProof) error {
+	if header.Version < TaggedMerkleBlockVersion {
+		return errors.New("block predates merkle proofs")
+	}
+
+	if BlockHash(header) != proof.BlockHash {
+		return errors.New("header does not match block hash")
+	}
+
+	if !MeetsDifficulty(proof.BlockHash, header.Difficulty) && header.Index > 0 {
+		return errors.New("header does not meet its difficulty")
+	}
+
+	root, err := ComputeMerkleRootFromProof(proof.TxID, proof.Siblings)
+	if err != nil {
+		return err
+	}
+	if root != header.MerkleRoot {
+		return errors.New("merkle path does not lead to the header's root")
+	}
+
+	return nil
+}
+
+// VerifyEncodedMerkleProof decodes the hex header carried by a proof and
+// verifies it. Anyone can mine a header at a low difficulty, so this only
+// shows the proof is consistent; see BlockchainService.VerifyMerkleProof.
+func VerifyEncodedMerkleProof(proof *MerkleProof) (*models.Block, error) {
+	raw, err := hex.DecodeString(proof.Header)
+	if err != nil {
+		return nil, errors.New("invalid header encoding")
+	}
+
+	header, err := DecodeBlockHeader(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return header, VerifyMerkleProof(header, proof)
+}
+
+// VerifyMerkleProof checks a proof and that its block is on this node's
+// main chain, with the difficulty the chain required of it
+func (s *BlockchainService) VerifyMerkleProof(ctx context.Context, proof *MerkleProof) error {
+	header, err := VerifyEncodedMerkleProof(proof)
+	if err != nil {
+		return err
+	}
+
+	block, err := s.store.GetBlockByHash(ctx, proof.BlockHash)
+	if err == storage.ErrNotFound {
+		return errors.New("block is not on the main chain")
+	}
+	if err != nil {
+		return err
+	}
+	if block.Status != "" && block.Status != models.BlockStatusMain {
+		return errors.New("block is not on the main chain")
+	}
+
+	if header.Index == 0 {
+		return nil
+	}
+	parent, err := s.store.GetBlockByHash(ctx, block.PreviousHash)
+	if err != nil {
+		return err
+	}
+	expected, err := s.requiredDifficulty(ctx, header, parent)
+	if err != nil {
+		return err
+	}
+	if header.Difficulty != expected {
+		return fmt.Errorf("header difficulty %d is not the %d the chain requires", header.Difficulty, expected)
+	}
+
+	return nil
+}
+
+// legacyMerkleRoot reproduces the untagged root used before block version 2
+func legacyMerkleRoot(txIDs []string) string {
+	if len(txIDs) == 0 {
+		return EmptyMerkleRoot
+	}
+
+	hashes := append([]string(nil), txIDs...)
+	for len(hashes) > 1 {
+		var newHashes []string
+		for i := 0; i < len(hashes); i += 2 {
+			if i+1 < len(hashes) {
+				combined := hashes[i] + hashes[i+1]
+				hash := sha256.Sum256([]byte(combined))
+				newHashes = append(newHashes, hex.EncodeToString(hash[:]))
+			} else {
+				newHashes = append(newHashes, hashes[i])
+			}
+		}
+		hashes = newHashes
+	}
+
+	return hashes[0]
+}
+
+// BlockMerkleRoot computes the merkle root of a block's transactions using
+// the rules of its version
+func BlockMerkleRoot(block *models.Block) string {
+	txIDs := make([]string, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		txIDs[i] = tx.TxID
+	}
+
+	if block.Version < TaggedMerkleBlockVersion {
+		return legacyMerkleRoot(txIDs)
+	}
+	return MerkleRoot(txIDs)
+}
+
+// GetMerkleProof builds an inclusion proof for a transaction in a block
+func (s *BlockchainService) GetMerkleProof(ctx context.Context, blockHash, txID string) (*MerkleProof, error) {
+	block, err := s.store.GetBlockByHash(ctx, blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if block.Version < TaggedMerkleBlockVersion {
+		return nil, errors.New("block predates merkle proofs")
+	}
+
+	index := -1
+	txIDs := make([]string, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		txIDs[i] = tx.TxID
+		if tx.TxID == txID {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, errors.New("transaction not in block")
+	}
+
+	steps, err := BuildMerkleProof(txIDs, index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MerkleProof{
+		BlockHash:  block.Hash,
+		Header:     hex.EncodeToString(EncodeBlockHeader(block)),
+		MerkleRoot: block.MerkleRoot,
+		TxID:       txID,
+		Index:      index,
+		Siblings:   steps,
+	}, nil
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"backend/models"
+)
+
+func TestVerifyMerkleProofAgainstChain(t *testing.T) {
+	ctx := context.Background()
+	bc, store := newTestChain(t)
+
+	genesis, err := bc.GetLatestBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := nextBlock(t, bc, genesis, "miner", genesis.Timestamp.Add(time.Minute))
+	if err := bc.AddBlock(ctx, block); err != nil {
+		t.Fatal(err)
+	}
+	proof, err := bc.GetMerkleProof(ctx, block.Hash, block.Transactions[0].TxID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.VerifyMerkleProof(ctx, proof); err != nil {
+		t.Fatalf("proof of a main-chain block: %v", err)
+	}
+
+	// A consistent proof for a block this node never accepted
+	forged := nextBlock(t, bc, genesis, "mallory", genesis.Timestamp.Add(2*time.Minute))
+	forgedProof := &MerkleProof{
+		BlockHash:  forged.Hash,
+		Header:     hex.EncodeToString(EncodeBlockHeader(forged)),
+		MerkleRoot: forged.MerkleRoot,
+		TxID:       forged.Transactions[0].TxID,
+	}
+	if _, err := VerifyEncodedMerkleProof(forgedProof); err != nil {
+		t.Fatalf("forged proof should be self-consistent: %v", err)
+	}
+	if err := bc.VerifyMerkleProof(ctx, forgedProof); err == nil {
+		t.Fatal("proof of an unknown block was accepted")
+	}
+
+	// The same proof once its block is no longer on the main chain
+	if err := store.UpdateBlock(ctx, block.Hash, map[string]interface{}{"status": models.BlockStatusStale}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.VerifyMerkleProof(ctx, proof); err == nil {
+		t.Fatal("proof of a stale block was accepted")
+	}
+}
@@ -2,171 +2,164 @@ package services
 
 import (
 	"context"
+	"encoding/binary"
 	"sync"
 	"time"
 
 	"backend/models"
+	"backend/storage"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type MiningService struct {
-	db          *mongo.Database
+	store       storage.ChainStore
 	blockchain  *BlockchainService
 	transaction *TransactionService
+	pow         PoWConfig
 	isMining    bool
+	currentJob  string // ID of the running background job, if any
+	jobs        map[string]*miningJob
 	mutex       sync.Mutex
 }
 
-func NewMiningService(db *mongo.Database, blockchain *BlockchainService, transaction *TransactionService) *MiningService {
+func NewMiningService(store storage.ChainStore, blockchain *BlockchainService, transaction *TransactionService, pow PoWConfig) *MiningService {
 	return &MiningService{
-		db:          db,
+		store:       store,
 		blockchain:  blockchain,
 		transaction: transaction,
+		pow:         pow,
 		isMining:    false,
+		jobs:        make(map[string]*miningJob),
 	}
 }
 
-// MineBlock mines pending transactions into a new block
-func (s *MiningService) MineBlock(ctx context.Context, minerWalletID string) (*models.Block, error) {
+// beginMining claims the miner, reporting false if it is already busy
+func (s *MiningService) beginMining() bool {
 	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	if s.isMining {
-		s.mutex.Unlock()
-		return nil, nil
+		return false
 	}
 	s.isMining = true
+	return true
+}
+
+func (s *MiningService) endMining() {
+	s.mutex.Lock()
+	s.isMining = false
+	s.currentJob = ""
 	s.mutex.Unlock()
+}
 
-	defer func() {
-		s.mutex.Lock()
-		s.isMining = false
-		s.mutex.Unlock()
-	}()
+// MineBlock mines pending transactions into a new block
+func (s *MiningService) MineBlock(ctx context.Context, minerWalletID string) (*models.Block, error) {
+	if !s.beginMining() {
+		return nil, nil
+	}
+	defer s.endMining()
 
+	return s.mineBlock(ctx, minerWalletID, nil)
+}
+
+// mineBlock builds a block template and searches for its proof-of-work.
+// progress, if not nil, receives the template and proof-of-work progress.
+func (s *MiningService) mineBlock(ctx context.Context, minerWalletID string, progress func(*models.Block, PoWProgress)) (*models.Block, error) {
 	// Get pending transactions
 	pendingTxs, err := s.transaction.GetPendingTransactions(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(pendingTxs) == 0 {
-		return nil, nil
-	}
-
 	// Get latest block
 	latestBlock, err := s.blockchain.GetLatestBlock(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	// Difficulty is retargeted from chain history
+	difficulty, err := s.blockchain.NextDifficulty(ctx, latestBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	// The block must be newer than the median time past, even if the
+	// clock is behind the chain
+	timestamp := time.Now()
+	median, err := s.blockchain.MedianTimePast(ctx, latestBlock)
+	if err != nil {
+		return nil, err
+	}
+	if timestamp.UnixMilli() <= median.UnixMilli() {
+		timestamp = median.Add(time.Millisecond)
+	}
+
 	// Create new block
 	newBlock := &models.Block{
 		ID:           primitive.NewObjectID(),
+		Version:      CurrentBlockVersion,
 		Index:        latestBlock.Index + 1,
-		Timestamp:    time.Now(),
-		Transactions: pendingTxs,
+		Timestamp:    timestamp,
 		PreviousHash: latestBlock.Hash,
 		Nonce:        0,
-		Difficulty:   s.blockchain.GetDifficulty(),
+		Difficulty:   difficulty,
 		Miner:        minerWalletID,
 		CreatedAt:    time.Now(),
 	}
 
-	// Calculate merkle root
-	newBlock.MerkleRoot = s.blockchain.CalculateMerkleRoot(pendingTxs)
+	// Reserve room for the header and coinbase, then fill the block
+	// highest fee rate first. Transactions that conflict with the chain
+	// or with each other are rejected. The placeholder coinbase carries a
+	// fee so that its reward output is counted even once subsidies end.
+	newBlock.Transactions = []models.Transaction{*s.blockchain.NewCoinbase(newBlock.Index, minerWalletID, 1)}
+	reserved := len(EncodeBlock(newBlock)) + binary.MaxVarintLen64
+	pendingTxs, invalidTxs, err := s.blockchain.SelectBlockTransactions(ctx, pendingTxs, reserved)
+	if err != nil {
+		return nil, err
+	}
+	if len(invalidTxs) > 0 {
+		var txIDs []string
+		for _, tx := range invalidTxs {
+			txIDs = append(txIDs, tx.TxID)
+		}
+		if err := s.blockchain.rejectTransactions(ctx, txIDs); err != nil {
+			return nil, err
+		}
+	}
 
-	// Perform Proof of Work
-	s.blockchain.ProofOfWork(newBlock)
+	if len(pendingTxs) == 0 {
+		return nil, nil
+	}
 
-	// Add block to chain
-	if err := s.blockchain.AddBlock(ctx, newBlock); err != nil {
-		return nil, err
+	// Pay the subsidy and collected fees to the miner
+	var fees models.Amount
+	for i := range pendingTxs {
+		fees += pendingTxs[i].Fee
 	}
+	coinbase := s.blockchain.NewCoinbase(newBlock.Index, minerWalletID, fees)
+	newBlock.Transactions = append([]models.Transaction{*coinbase}, pendingTxs...)
+
+	// Calculate merkle root
+	newBlock.MerkleRoot = s.blockchain.CalculateMerkleRoot(newBlock.Transactions)
 
-	// Confirm transactions
-	var txIDs []string
-	for _, tx := range pendingTxs {
-		txIDs = append(txIDs, tx.TxID)
+	// Perform Proof of Work
+	var report func(PoWProgress)
+	if progress != nil {
+		progress(newBlock, PoWProgress{})
+		report = func(p PoWProgress) { progress(newBlock, p) }
 	}
-	if err := s.transaction.ConfirmTransactions(ctx, txIDs, newBlock.Hash); err != nil {
+	if _, err := s.blockchain.ProofOfWork(ctx, newBlock, s.pow, report); err != nil {
 		return nil, err
 	}
 
-	// Mark UTXOs as spent and create new UTXOs
-	if err := s.processTransactionUTXOs(ctx, pendingTxs, newBlock.Hash); err != nil {
+	// Add block to chain; connecting it confirms its transactions
+	if err := s.blockchain.AddBlock(ctx, newBlock); err != nil {
 		return nil, err
 	}
 
 	return newBlock, nil
 }
 
-// processTransactionUTXOs handles UTXO updates after mining
-func (s *MiningService) processTransactionUTXOs(ctx context.Context, transactions []models.Transaction, blockHash string) error {
-	utxoCollection := s.db.Collection("utxos")
-	walletCollection := s.db.Collection("wallets")
-
-	for _, tx := range transactions {
-		// Mark input UTXOs as spent
-		for _, input := range tx.InputUTXOs {
-			_, err := utxoCollection.UpdateOne(ctx,
-				primitive.M{"tx_id": input.TxID, "output_index": input.OutputIndex},
-				primitive.M{"$set": primitive.M{
-					"is_spent":    true,
-					"spent_in_tx": tx.TxID,
-				}},
-			)
-			if err != nil {
-				return err
-			}
-		}
-
-		// Create output UTXOs
-		for _, output := range tx.OutputUTXOs {
-			newUTXO := models.UTXO{
-				ID:          primitive.NewObjectID(),
-				TxID:        tx.TxID,
-				OutputIndex: output.Index,
-				WalletID:    output.WalletID,
-				Amount:      output.Amount,
-				IsSpent:     false,
-				BlockHash:   blockHash,
-				CreatedAt:   time.Now(),
-			}
-			_, err := utxoCollection.InsertOne(ctx, newUTXO)
-			if err != nil {
-				return err
-			}
-
-			// Update cached balance
-			_, err = walletCollection.UpdateOne(ctx,
-				primitive.M{"wallet_id": output.WalletID},
-				primitive.M{"$inc": primitive.M{"cached_balance": output.Amount}},
-			)
-			if err != nil {
-				return err
-			}
-		}
-
-		// Update sender's cached balance (subtract spent amount)
-		if tx.SenderWalletID != "system" {
-			var totalSpent float64
-			for _, input := range tx.InputUTXOs {
-				totalSpent += input.Amount
-			}
-			_, err := walletCollection.UpdateOne(ctx,
-				primitive.M{"wallet_id": tx.SenderWalletID},
-				primitive.M{"$inc": primitive.M{"cached_balance": -totalSpent}},
-			)
-			if err != nil {
-				return err
-			}
-		}
-	}
-
-	return nil
-}
-
 // IsMining returns current mining status
 func (s *MiningService) IsMining() bool {
 	s.mutex.Lock()
@@ -186,11 +179,30 @@ func (s *MiningService) GetMiningStatus(ctx context.Context) (map[string]interfa
 		return nil, err
 	}
 
+	difficulty, err := s.blockchain.NextDifficulty(ctx, latestBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	subsidy := s.blockchain.GetSubsidyConfig()
+	nextHeight := latestBlock.Index + 1
+
+	s.mutex.Lock()
+	currentJob := s.currentJob
+	s.mutex.Unlock()
+
 	return map[string]interface{}{
 		"isMining":            s.IsMining(),
+		"currentJobId":        currentJob,
 		"pendingTransactions": len(pendingTxs),
-		"currentDifficulty":   s.blockchain.GetDifficulty(),
+		"currentDifficulty":   difficulty,
+		"targetBlockTime":     s.blockchain.GetDifficultyConfig().TargetBlockTime.Seconds(),
 		"latestBlockIndex":    latestBlock.Index,
 		"latestBlockHash":     latestBlock.Hash,
+		"miningWorkers":       s.pow.Workers,
+		"blockSubsidy":        subsidy.BlockSubsidy(nextHeight),
+		"nextHalvingHeight":   subsidy.NextHalving(nextHeight),
+		"issuedSupply":        subsidy.IssuedBefore(nextHeight),
+		"maxSupply":           subsidy.MaxSupply,
 	}, nil
 }
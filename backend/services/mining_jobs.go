@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Mining job states
+const (
+	MiningJobRunning   = "running"
+	MiningJobCompleted = "completed"
+	MiningJobFailed    = "failed"
+	MiningJobCancelled = "cancelled"
+)
+
+// MiningJobTimeout bounds how long a background mining job may run
+const MiningJobTimeout = 30 * time.Minute
+
+// miningJobRetention is how long finished jobs can still be looked up
+const miningJobRetention = time.Hour
+
+var (
+	ErrMiningInProgress  = errors.New("mining already in progress")
+	ErrMiningJobNotFound = errors.New("mining job not found")
+)
+
+// MiningJob is a snapshot of a background mining job
+type MiningJob struct {
+	ID            string        `json:"id"`
+	MinerWalletID string        `json:"minerWalletId"`
+	Status        string        `json:"status"`
+	BlockIndex    int64         `json:"blockIndex"`
+	Difficulty    int           `json:"difficulty"`
+	Transactions  int           `json:"transactions"`
+	Attempts      uint64        `json:"attempts"`
+	Nonce         int64         `json:"nonce"`
+	Hashrate      float64       `json:"hashrate"`   // hashes per second
+	ETASeconds    float64       `json:"etaSeconds"` // until the expected 16^difficulty attempts
+	BlockHash     string        `json:"blockHash,omitempty"`
+	Reward        models.Amount `json:"reward,omitempty"`
+	Error         string        `json:"error,omitempty"`
+	CreatedAt     time.Time     `json:"createdAt"`
+	FinishedAt    *time.Time    `json:"finishedAt,omitempty"`
+}
+
+// Done reports whether the job has finished
+func (j MiningJob) Done() bool {
+	return j.Status != MiningJobRunning
+}
+
+// miningJob is a running or finished job and its progress subscribers
+type miningJob struct {
+	mu          sync.Mutex
+	state       MiningJob
+	cancel      context.CancelFunc
+	subscribers map[chan MiningJob]struct{}
+}
+
+// update applies fn to the job state and notifies subscribers. Each
+// subscriber channel holds only the latest snapshot.
+func (j *miningJob) update(fn func(*MiningJob)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	fn(&j.state)
+	for ch := range j.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- j.state
+		if j.state.Done() {
+			close(ch)
+			delete(j.subscribers, ch)
+		}
+	}
+}
+
+func (j *miningJob) snapshot() MiningJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state
+}
+
+// StartMiningJob mines a block in the background and returns immediately.
+// onDone, if not nil, is called with the final job state.
+func (s *MiningService) StartMiningJob(minerWalletID string, onDone func(MiningJob)) (MiningJob, error) {
+	if !s.beginMining() {
+		return MiningJob{}, ErrMiningInProgress
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), MiningJobTimeout)
+	job := &miningJob{
+		state: MiningJob{
+			ID:            primitive.NewObjectID().Hex(),
+			MinerWalletID: minerWalletID,
+			Status:        MiningJobRunning,
+			CreatedAt:     time.Now(),
+		},
+		cancel:      cancel,
+		subscribers: make(map[chan MiningJob]struct{}),
+	}
+
+	s.mutex.Lock()
+	s.pruneJobs()
+	s.jobs[job.state.ID] = job
+	s.currentJob = job.state.ID
+	s.mutex.Unlock()
+
+	go func() {
+		defer s.endMining()
+		defer cancel()
+
+		block, err := s.mineBlock(ctx, minerWalletID, func(b *models.Block, p PoWProgress) {
+			job.update(func(state *MiningJob) {
+				state.BlockIndex = b.Index
+				state.Difficulty = b.Difficulty
+				state.Transactions = len(b.Transactions)
+				state.Attempts = p.Attempts
+				state.Nonce = p.Nonce
+				state.Hashrate = p.Hashrate
+				if p.Hashrate > 0 {
+					expected := math.Pow(16, float64(b.Difficulty))
+					state.ETASeconds = math.Max(expected-float64(p.Attempts), 0) / p.Hashrate
+				}
+			})
+		})
+
+		job.update(func(state *MiningJob) {
+			now := time.Now()
+			state.FinishedAt = &now
+			state.ETASeconds = 0
+			switch {
+			case errors.Is(err, context.Canceled):
+				state.Status = MiningJobCancelled
+			case err != nil:
+				state.Status = MiningJobFailed
+				state.Error = err.Error()
+			case block == nil:
+				state.Status = MiningJobFailed
+				state.Error = "no pending transactions to mine"
+			default:
+				state.Status = MiningJobCompleted
+				state.BlockIndex = block.Index
+				state.BlockHash = block.Hash
+				state.Nonce = block.Nonce
+				if IsCoinbase(&block.Transactions[0]) {
+					state.Reward = block.Transactions[0].Amount
+				}
+			}
+		})
+
+		if onDone != nil {
+			onDone(job.snapshot())
+		}
+	}()
+
+	return job.snapshot(), nil
+}
+
+// pruneJobs forgets jobs that finished long ago. Callers hold s.mutex.
+func (s *MiningService) pruneJobs() {
+	for id, job := range s.jobs {
+		state := job.snapshot()
+		if state.FinishedAt != nil && time.Since(*state.FinishedAt) > miningJobRetention {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+func (s *MiningService) getJob(id string) (*miningJob, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrMiningJobNotFound
+	}
+	return job, nil
+}
+
+// GetMiningJob returns the current state of a job
+func (s *MiningService) GetMiningJob(id string) (MiningJob, error) {
+	job, err := s.getJob(id)
+	if err != nil {
+		return MiningJob{}, err
+	}
+	return job.snapshot(), nil
+}
+
+// CancelMiningJob stops a running job. Cancelling a finished job has no effect.
+func (s *MiningService) CancelMiningJob(id string) (MiningJob, error) {
+	job, err := s.getJob(id)
+	if err != nil {
+		return MiningJob{}, err
+	}
+	job.cancel()
+	return job.snapshot(), nil
+}
+
+// SubscribeMiningJob returns a channel carrying the job's latest state. It
+// is closed after the final state is sent; call unsubscribe to stop early.
+func (s *MiningService) SubscribeMiningJob(id string) (updates <-chan MiningJob, unsubscribe func(), err error) {
+	job, err := s.getJob(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch := make(chan MiningJob, 1)
+	job.mu.Lock()
+	ch <- job.state
+	if job.state.Done() {
+		close(ch)
+	} else {
+		job.subscribers[ch] = struct{}{}
+	}
+	job.mu.Unlock()
+
+	unsubscribe = func() {
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		if _, ok := job.subscribers[ch]; ok {
+			delete(job.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe, nil
+}
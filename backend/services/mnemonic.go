@@ -0,0 +1,124 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"errors"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Recovery phrases follow BIP39: 128 to 256 bits of entropy and a
+// checksum of its SHA-256, written as words of the English list. The
+// wallet seed is the phrase stretched together with an optional
+// passphrase, so the same words with another passphrase open another
+// wallet.
+
+// DefaultMnemonicWords is the length of the phrases registration creates
+const DefaultMnemonicWords = 12
+
+//go:embed wordlist_english.txt
+var englishWordlist string
+
+var (
+	mnemonicWords = strings.Fields(englishWordlist)
+	mnemonicIndex = make(map[string]int, len(mnemonicWords))
+)
+
+func init() {
+	for i, word := range mnemonicWords {
+		mnemonicIndex[word] = i
+	}
+}
+
+var (
+	ErrInvalidMnemonic   = errors.New("recovery phrase must be 12, 15, 18, 21 or 24 words from the BIP39 English list")
+	ErrMnemonicChecksum  = errors.New("recovery phrase checksum does not match; check the words and their order")
+	ErrMnemonicMismatch  = errors.New("recovery phrase and passphrase do not belong to this wallet")
+	errMnemonicWordCount = errors.New("mnemonic word count must be 12, 15, 18, 21 or 24")
+)
+
+// NewMnemonic returns a random recovery phrase of the given number of words
+func NewMnemonic(words int) (string, error) {
+	if words < 12 || words > 24 || words%3 != 0 {
+		return "", errMnemonicWordCount
+	}
+
+	entropy := make([]byte, words*4/3)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", err
+	}
+	return entropyToMnemonic(entropy), nil
+}
+
+// ValidateMnemonic checks that a phrase is made of listed words and that
+// its checksum matches
+func ValidateMnemonic(mnemonic string) error {
+	words := strings.Fields(NormalizeMnemonic(mnemonic))
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return ErrInvalidMnemonic
+	}
+
+	// Each word holds 11 bits: the entropy followed by its checksum
+	bits := new(big.Int)
+	for _, word := range words {
+		index, ok := mnemonicIndex[word]
+		if !ok {
+			return ErrInvalidMnemonic
+		}
+		bits.Lsh(bits, 11)
+		bits.Or(bits, big.NewInt(int64(index)))
+	}
+
+	checksumBits := uint(len(words) / 3)
+	checksum := new(big.Int).And(bits, big.NewInt(1<<checksumBits-1))
+	entropy := bits.Rsh(bits, checksumBits).FillBytes(make([]byte, len(words)*4/3))
+
+	if mnemonicChecksum(entropy).Cmp(checksum) != 0 {
+		return ErrMnemonicChecksum
+	}
+	return nil
+}
+
+// NormalizeMnemonic lowercases a phrase and separates its words with
+// single spaces
+func NormalizeMnemonic(mnemonic string) string {
+	return strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+}
+
+// MnemonicToSeed returns the 64-byte wallet seed of a phrase and passphrase
+func MnemonicToSeed(mnemonic, passphrase string) []byte {
+	password := norm.NFKD.String(NormalizeMnemonic(mnemonic))
+	salt := norm.NFKD.String("mnemonic" + passphrase)
+	return pbkdf2.Key([]byte(password), []byte(salt), 2048, 64, sha512.New)
+}
+
+// entropyToMnemonic writes entropy and its checksum as words
+func entropyToMnemonic(entropy []byte) string {
+	checksumBits := uint(len(entropy) / 4)
+	bits := new(big.Int).SetBytes(entropy)
+	bits.Lsh(bits, checksumBits)
+	bits.Or(bits, mnemonicChecksum(entropy))
+
+	count := (len(entropy)*8 + int(checksumBits)) / 11
+	words := make([]string, count)
+	mask := big.NewInt(2047)
+	for i := count - 1; i >= 0; i-- {
+		words[i] = mnemonicWords[new(big.Int).And(bits, mask).Int64()]
+		bits.Rsh(bits, 11)
+	}
+	return strings.Join(words, " ")
+}
+
+// mnemonicChecksum returns the first len(entropy)/4 bits of the entropy's
+// SHA-256
+func mnemonicChecksum(entropy []byte) *big.Int {
+	hash := sha256.Sum256(entropy)
+	checksumBits := uint(len(entropy) / 4)
+	return big.NewInt(int64(hash[0] >> (8 - checksumBits)))
+}
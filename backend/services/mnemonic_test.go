@@ -0,0 +1,120 @@
+package services
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// bip39Vectors are from the BIP39 reference vectors, whose seeds use the
+// passphrase "TREZOR"
+var bip39Vectors = []struct {
+	entropy, mnemonic, seed string
+}{
+	{
+		"00000000000000000000000000000000",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+	},
+	{
+		"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+		"legal winner thank year wave sausage worth useful legal winner thank yellow",
+		"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607",
+	},
+	{
+		"80808080808080808080808080808080",
+		"letter advice cage absurd amount doctor acoustic avoid letter advice cage above",
+		"d71de856f81a8acc65e6fc851a38d4d7ec216fd0796d0a6827a3ad6ed5511a30fa280f12eb2e47ed2ac03b5c462a0358d18d69fe4f985ec81778c1b370b652a8",
+	},
+	{
+		"ffffffffffffffffffffffffffffffff",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+		"ac27495480225222079d7be181583751e86f571027b0497b5b5d11218e0a8a13332572917f0f8e5a589620c6f15b11c61dee327651a14c34e18231052e48c069",
+	},
+	{
+		"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+		"legal winner thank year wave sausage worth useful legal winner thank year wave sausage worth useful legal will",
+		"f2b94508732bcbacbcc020faefecfc89feafa6649a5491b8c952cede496c214a0c7b3c392d168748f2d4a612bada0753b52a1c7ac53c1e93abd5c6320b9e95dd",
+	},
+	{
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon art",
+		"bda85446c68413707090a52022edd26a1c9462295029f2e60cd7c4f2bbd3097170af7a4d73245cafa9c3cca8d561a7c3de6f5d4a10be8ed2a5e608d68f92fcc8",
+	},
+	{
+		"9e885d952ad362caeb4efe34a8e91bd2",
+		"ozone drill grab fiber curtain grace pudding thank cruise elder eight picnic",
+		"274ddc525802f7c828d8ef7ddbcdc5304e87ac3535913611fbbfa986d0c9e5476c91689f9c8a54fd55bd38606aa6a8595ad213d4c9c9f9aca3fb217069a41028",
+	},
+	{
+		"68a79eaca2324873eacc50cb9c6eca8cc68ea5d936f98787c60c7ebc74e6ce7c",
+		"hamster diagram private dutch cause delay private meat slide toddler razor book happy fancy gospel tennis maple dilemma loan word shrug inflict delay length",
+		"64c87cde7e12ecf6704ab95bb1408bef047c22db4cc7491c4271d170a1b213d20b385bc1588d9c7b38f1b39d415665b8a9030c9ec653d75e65f847d8fc1fc440",
+	},
+	{
+		"f585c11aec520db57dd353c69554b21a89b20fb0650966fa0a9d6f74fd989d8f",
+		"void come effort suffer camp survey warrior heavy shoot primary clutch crush open amazing screen patrol group space point ten exist slush involve unfold",
+		"01f5bced59dec48e362f2c45b5de68b9fd6c92c6634f44d6d40aab69056506f0e35524a518034ddc1192e1dacd32c1ed3eaa3c3b131c88ed8e7e54c49a5d0998",
+	},
+}
+
+func TestBIP39Vectors(t *testing.T) {
+	for _, v := range bip39Vectors {
+		entropy, _ := hex.DecodeString(v.entropy)
+		if got := entropyToMnemonic(entropy); got != v.mnemonic {
+			t.Fatalf("mnemonic of %s = %q, want %q", v.entropy, got, v.mnemonic)
+		}
+		if err := ValidateMnemonic(v.mnemonic); err != nil {
+			t.Fatalf("ValidateMnemonic(%q) = %v", v.mnemonic, err)
+		}
+		if got := hex.EncodeToString(MnemonicToSeed(v.mnemonic, "TREZOR")); got != v.seed {
+			t.Fatalf("seed of %q = %s, want %s", v.mnemonic, got, v.seed)
+		}
+	}
+}
+
+func TestValidateMnemonic(t *testing.T) {
+	about := bip39Vectors[0].mnemonic
+	tests := []struct {
+		name     string
+		mnemonic string
+		want     error
+	}{
+		{"upper case and spacing", "  " + strings.ToUpper(strings.ReplaceAll(about, " ", "\t ")) + "\n", nil},
+		{"wrong checksum", strings.Repeat("abandon ", 12), ErrMnemonicChecksum},
+		{"swapped words", "about " + strings.TrimSuffix(about, " about"), ErrMnemonicChecksum},
+		{"unlisted word", strings.Replace(about, "about", "aboot", 1), ErrInvalidMnemonic},
+		{"too few words", strings.TrimSuffix(about, " about"), ErrInvalidMnemonic},
+		{"empty", "", ErrInvalidMnemonic},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateMnemonic(tt.mnemonic); err != tt.want {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// Normalization must not change the seed
+	if string(MnemonicToSeed(strings.ToUpper(about), "")) != string(MnemonicToSeed(about, "")) {
+		t.Fatal("seed depends on the phrase's case")
+	}
+}
+
+func TestNewMnemonic(t *testing.T) {
+	for _, words := range []int{12, 15, 18, 21, 24} {
+		mnemonic, err := NewMnemonic(words)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(strings.Fields(mnemonic)); n != words {
+			t.Fatalf("NewMnemonic(%d) has %d words", words, n)
+		}
+		if err := ValidateMnemonic(mnemonic); err != nil {
+			t.Fatalf("NewMnemonic(%d) = %q is invalid: %v", words, mnemonic, err)
+		}
+	}
+	if _, err := NewMnemonic(13); err == nil {
+		t.Fatal("NewMnemonic(13) succeeded")
+	}
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"backend/models"
+)
+
+// DevnetChainID is the chain ID of the development network
+const DevnetChainID = "zakat-devnet"
+
+// NetworkConfig identifies the chain a node serves
+type NetworkConfig struct {
+	ChainID       string // signed into transactions so they cannot be replayed on another network
+	AddressPrefix string // starts every address, so addresses cannot be used on another network
+	Allocation    AllocationConfig
+}
+
+// AllocationConfig controls the coins the faucet grants each new wallet.
+// Allocations are transactions signed by the faucet key and mined like
+// any other, so every coin in circulation is accounted for on chain.
+// Consensus grants each wallet at most one allocation and stops granting
+// once MaxTotal has been allocated.
+type AllocationConfig struct {
+	Enabled         bool
+	Amount          models.Amount // granted to each new wallet
+	MaxTotal        models.Amount // granted across all wallets, ever
+	FaucetPublicKey string        // the only key allowed to sign allocations
+}
+
+// DefaultNetworkConfig returns the development network. It has no faucet
+// until one is configured.
+func DefaultNetworkConfig() NetworkConfig {
+	return NetworkConfig{
+		ChainID:       DevnetChainID,
+		AddressPrefix: "zkdev",
+		Allocation: AllocationConfig{
+			Enabled:  true,
+			Amount:   100 * models.Coin,
+			MaxTotal: 1000000 * models.Coin,
+		},
+	}
+}
+
+// NetworkConfigFromEnv returns the defaults overridden by environment
+// variables. Networks other than the devnet start with allocations
+// disabled, no faucet and the "zk" address prefix. The faucet's public
+// key is FAUCET_PUBLIC_KEY, or derived from FAUCET_PRIVATE_KEY.
+//
+// Only dev builds may leave CHAIN_ID unset to join the devnet, or enable
+// allocations without a faucet key, in which case allocations are
+// disabled.
+func NetworkConfigFromEnv() (NetworkConfig, error) {
+	chainID := os.Getenv("CHAIN_ID")
+	if chainID == "" && !devBuild {
+		return NetworkConfig{}, errors.New("CHAIN_ID is required outside a dev build")
+	}
+
+	cfg := DefaultNetworkConfig()
+	if chainID != "" && chainID != cfg.ChainID {
+		cfg = NetworkConfig{ChainID: chainID, AddressPrefix: "zk"}
+	}
+	if prefix := os.Getenv("ADDRESS_PREFIX"); prefix != "" {
+		cfg.AddressPrefix = strings.ToLower(prefix)
+	}
+	if enabled := os.Getenv("ALLOCATION_ENABLED"); enabled != "" {
+		cfg.Allocation.Enabled = enabled == "true"
+	}
+	cfg.Allocation.Amount = models.AmountFromFloat(envFloat("ALLOCATION_AMOUNT", cfg.Allocation.Amount.Float64()))
+	cfg.Allocation.MaxTotal = models.AmountFromFloat(envFloat("ALLOCATION_MAX_TOTAL", cfg.Allocation.MaxTotal.Float64()))
+
+	cfg.Allocation.FaucetPublicKey = os.Getenv("FAUCET_PUBLIC_KEY")
+	if cfg.Allocation.FaucetPublicKey == "" {
+		if key := FaucetKeyFromEnv(); key != "" {
+			cfg.Allocation.FaucetPublicKey = faucetPublicKey(key)
+			if cfg.Allocation.FaucetPublicKey == "" {
+				return NetworkConfig{}, errors.New("FAUCET_PRIVATE_KEY is not a valid private key")
+			}
+		}
+	}
+	if cfg.Allocation.Enabled && cfg.Allocation.FaucetPublicKey == "" {
+		if !devBuild {
+			return NetworkConfig{}, errors.New("allocations require FAUCET_PUBLIC_KEY or FAUCET_PRIVATE_KEY")
+		}
+		cfg.Allocation.Enabled = false
+	}
+	return cfg, nil
+}
+
+// GetNetworkConfig returns the network this node serves
+func (s *BlockchainService) GetNetworkConfig() NetworkConfig {
+	return s.network
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"backend/models"
+)
+
+// PoWConfig controls the proof-of-work search
+type PoWConfig struct {
+	Workers    int    // goroutines searching nonces in parallel
+	NonceSpace uint64 // nonces tried per timestamp, split between workers
+}
+
+// DefaultPoWConfig returns one worker per CPU and a 32-bit nonce space
+func DefaultPoWConfig() PoWConfig {
+	return PoWConfig{
+		Workers:    runtime.NumCPU(),
+		NonceSpace: 1 << 32,
+	}
+}
+
+// PoWConfigFromEnv returns the defaults overridden by environment variables
+func PoWConfigFromEnv() PoWConfig {
+	cfg := DefaultPoWConfig()
+	cfg.Workers = envInt("MINING_WORKERS", cfg.Workers)
+	return cfg
+}
+
+// PoWProgress reports how far a proof-of-work search has got
+type PoWProgress struct {
+	Attempts uint64        // hashes tried so far, across all workers
+	Nonce    int64         // nonce being tried by the first worker
+	Hashrate float64       // hashes per second
+	Elapsed  time.Duration // time since the search started
+	Workers  int           // goroutines searching
+}
+
+// powCheckInterval is how many nonces are tried between context checks
+const powCheckInterval = 4096
+
+// powProgressInterval is the minimum time between progress reports
+const powProgressInterval = 500 * time.Millisecond
+
+// powSolution is a nonce and timestamp that satisfy the difficulty
+type powSolution struct {
+	nonce     int64
+	timestamp time.Time
+	hash      string
+}
+
+// ProofOfWork searches for a nonce meeting the block's difficulty and
+// records the solution in the block. Workers split the nonce space; a
+// worker that exhausts its share rolls its copy of the timestamp forward
+// one millisecond and starts over, so no two workers hash the same header.
+// All workers stop as soon as one succeeds or ctx is done, in which case
+// ctx's error is returned. progress, if not nil, is called periodically
+// from a single goroutine.
+func (s *BlockchainService) ProofOfWork(ctx context.Context, block *models.Block, cfg PoWConfig, progress func(PoWProgress)) (PoWProgress, error) {
+	if block.Version == LegacyBlockVersion {
+		return s.legacyProofOfWork(ctx, block)
+	}
+
+	workers := cfg.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	span := cfg.NonceSpace / uint64(workers)
+	if span == 0 {
+		span = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var attempts uint64
+	var firstNonce int64
+	found := make(chan powSolution, 1)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			first := int64(uint64(w) * span)
+			if sol, ok := searchNonces(ctx, *block, first, span, &attempts, w == 0, &firstNonce); ok {
+				select {
+				case found <- sol:
+					cancel()
+				default:
+				}
+			}
+		}(w)
+	}
+
+	// Report progress until the workers stop
+	done := make(chan struct{})
+	var reporter sync.WaitGroup
+	stats := func() PoWProgress {
+		elapsed := time.Since(start)
+		total := atomic.LoadUint64(&attempts)
+		return PoWProgress{
+			Attempts: total,
+			Nonce:    atomic.LoadInt64(&firstNonce),
+			Hashrate: float64(total) / elapsed.Seconds(),
+			Elapsed:  elapsed,
+			Workers:  workers,
+		}
+	}
+	if progress != nil {
+		reporter.Add(1)
+		go func() {
+			defer reporter.Done()
+			ticker := time.NewTicker(powProgressInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					progress(stats())
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(done)
+	reporter.Wait()
+
+	select {
+	case sol := <-found:
+		block.Nonce = sol.nonce
+		block.Timestamp = sol.timestamp
+		block.Hash = sol.hash
+		return stats(), nil
+	default:
+		return stats(), ctx.Err()
+	}
+}
+
+// searchNonces tries nonces [first, first+span) on its own copy of the
+// header, rolling the timestamp whenever the range is used up
+func searchNonces(ctx context.Context, block models.Block, first int64, span uint64, attempts *uint64, track bool, current *int64) (powSolution, bool) {
+	for {
+		header := EncodeBlockHeader(&block)
+		nonceBytes := header[len(header)-8:]
+
+		var batch uint64
+		for i := uint64(0); i < span; i++ {
+			nonce := first + int64(i)
+			binary.BigEndian.PutUint64(nonceBytes, uint64(nonce))
+			hash := hashHeader(header)
+			if MeetsDifficulty(hash, block.Difficulty) {
+				atomic.AddUint64(attempts, batch+1)
+				return powSolution{nonce: nonce, timestamp: block.Timestamp, hash: hash}, true
+			}
+
+			batch++
+			if batch == powCheckInterval {
+				atomic.AddUint64(attempts, batch)
+				batch = 0
+				if track {
+					atomic.StoreInt64(current, nonce)
+				}
+				if ctx.Err() != nil {
+					return powSolution{}, false
+				}
+			}
+		}
+		atomic.AddUint64(attempts, batch)
+
+		block.Timestamp = block.Timestamp.Add(time.Millisecond)
+	}
+}
+
+// legacyProofOfWork mines version 0 blocks, whose hash covers the
+// timestamp only to the second, on a single goroutine
+func (s *BlockchainService) legacyProofOfWork(ctx context.Context, block *models.Block) (PoWProgress, error) {
+	start := time.Now()
+	for attempts := uint64(1); ; attempts++ {
+		block.Hash = s.CalculateHash(block)
+		if MeetsDifficulty(block.Hash, block.Difficulty) {
+			return PoWProgress{Attempts: attempts, Nonce: block.Nonce, Elapsed: time.Since(start), Workers: 1}, nil
+		}
+		if attempts%powCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return PoWProgress{Attempts: attempts, Nonce: block.Nonce, Elapsed: time.Since(start), Workers: 1}, err
+			}
+		}
+		block.Nonce++
+	}
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"backend/models"
+	"backend/storage"
+)
+
+// benchmarkDifficulty takes about 65,000 hashes per block on average
+const benchmarkDifficulty = 4
+
+func TestProofOfWorkMeetsDifficulty(t *testing.T) {
+	bc := NewBlockchainService(storage.NewMemoryStore(), DefaultDifficultyConfig(), DefaultSubsidyConfig(), DefaultBlockLimits(), DefaultMempoolConfig(), DefaultNetworkConfig())
+	for _, workers := range []int{1, 4} {
+		block := &models.Block{Version: CurrentBlockVersion, Index: 1, Timestamp: time.Unix(1700000000, 0), PreviousHash: "00", Difficulty: 3}
+		stats, err := bc.ProofOfWork(context.Background(), block, PoWConfig{Workers: workers, NonceSpace: 1 << 10}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.Hash != BlockHash(block) || !MeetsDifficulty(block.Hash, block.Difficulty) {
+			t.Fatalf("%d workers: hash %s is not a solution", workers, block.Hash)
+		}
+		if stats.Workers != workers {
+			t.Fatalf("stats report %d workers, want %d", stats.Workers, workers)
+		}
+	}
+}
+
+func TestProofOfWorkStopsOnCancel(t *testing.T) {
+	bc := NewBlockchainService(storage.NewMemoryStore(), DefaultDifficultyConfig(), DefaultSubsidyConfig(), DefaultBlockLimits(), DefaultMempoolConfig(), DefaultNetworkConfig())
+	block := &models.Block{Version: CurrentBlockVersion, Index: 1, Timestamp: time.Unix(1700000000, 0), Difficulty: 64}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := bc.ProofOfWork(ctx, block, PoWConfig{Workers: 2, NonceSpace: 1 << 32}, nil); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// BenchmarkMine mines blocks with an increasing number of workers. Each
+// run reports its hash rate and its speedup over a single worker.
+func BenchmarkMine(b *testing.B) {
+	bc := NewBlockchainService(storage.NewMemoryStore(), DefaultDifficultyConfig(), DefaultSubsidyConfig(), DefaultBlockLimits(), DefaultMempoolConfig(), DefaultNetworkConfig())
+
+	// Powers of two up to the CPU count, and at least up to four workers
+	var counts []int
+	for n := 1; n <= 4 || n < runtime.NumCPU(); n *= 2 {
+		counts = append(counts, n)
+	}
+	if n := counts[len(counts)-1]; n < runtime.NumCPU() {
+		counts = append(counts, runtime.NumCPU())
+	}
+
+	var baseline float64
+	for _, workers := range counts {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			cfg := PoWConfig{Workers: workers, NonceSpace: DefaultPoWConfig().NonceSpace}
+			var attempts uint64
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				block := &models.Block{
+					Version:      CurrentBlockVersion,
+					Index:        int64(i + 1),
+					Timestamp:    time.Unix(1700000000, 0),
+					PreviousHash: fmt.Sprintf("%064x", i),
+					Difficulty:   benchmarkDifficulty,
+				}
+				stats, err := bc.ProofOfWork(context.Background(), block, cfg, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				attempts += stats.Attempts
+			}
+
+			rate := float64(attempts) / time.Since(start).Seconds()
+			if workers == 1 {
+				baseline = rate
+			}
+			b.ReportMetric(rate, "hashes/s")
+			if baseline > 0 {
+				b.ReportMetric(rate/baseline, "speedup")
+			}
+		})
+	}
+}
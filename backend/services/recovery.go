@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+
+	"backend/models"
+	"backend/storage"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Recovery actions taken on an interrupted block
+const (
+	RecoveryCompleted = "completed" // the operation was finished
+	RecoveryUndone    = "undone"    // the operation's writes were reverted
+)
+
+// RecoveredBlock is a block whose interrupted connect or disconnect was repaired
+type RecoveredBlock struct {
+	Hash   string `json:"hash"`
+	Index  int64  `json:"index"`
+	Op     string `json:"op"`
+	Action string `json:"action"`
+}
+
+// RecoveryReport is the result of RecoverChain
+type RecoveryReport struct {
+	Atomic      bool             `json:"atomic"` // the store commits blocks atomically
+	Blocks      []RecoveredBlock `json:"blocks"`
+	Reorganized bool             `json:"reorganized"`
+	Height      int64            `json:"height"`
+	TipHash     string           `json:"tipHash"`
+}
+
+// RecoverChain repairs blocks left half connected or half disconnected by
+// a crash, which only stores without atomic commits can leave behind.
+// Interrupted connects are finished, unless the block was since marked
+// invalid, in which case they are undone; interrupted disconnects are
+// finished. Repairs are idempotent and recompute the cached balances of
+// the wallets involved. The main chain then moves to the valid branch
+// with the most work, in case a reorganization was cut short. Run it on
+// startup, before the mempool is rebuilt.
+func (s *BlockchainService) RecoverChain(ctx context.Context) (*RecoveryReport, error) {
+	s.chainMu.Lock()
+	defer s.chainMu.Unlock()
+
+	report := &RecoveryReport{Atomic: s.store.Atomic(ctx), Blocks: []RecoveredBlock{}}
+
+	pending, err := s.store.GetBlocksWithPendingOp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pending {
+		block := &pending[i]
+		action, err := s.recoverBlock(ctx, block)
+		if err != nil {
+			return nil, err
+		}
+		report.Blocks = append(report.Blocks, RecoveredBlock{
+			Hash:   block.Hash,
+			Index:  block.Index,
+			Op:     block.PendingOp,
+			Action: action,
+		})
+	}
+
+	tip, err := s.store.GetLatestBlock(ctx)
+	if err == storage.ErrNotFound {
+		return report, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stale, err := s.store.GetBlocksByStatus(ctx, models.BlockStatusStale)
+	if err != nil {
+		return nil, err
+	}
+	best := tip
+	for i := range stale {
+		if parseChainWork(&stale[i]).Cmp(parseChainWork(best)) > 0 {
+			best = &stale[i]
+		}
+	}
+	if best != tip {
+		if err := s.reorganize(ctx, tip, best); err != nil {
+			return nil, err
+		}
+		report.Reorganized = true
+		if tip, err = s.store.GetLatestBlock(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	report.Height = tip.Index
+	report.TipHash = tip.Hash
+	return report, nil
+}
+
+// recoverBlock repairs one interrupted block and returns the action taken
+func (s *BlockchainService) recoverBlock(ctx context.Context, block *models.Block) (string, error) {
+	if block.PendingOp == models.BlockOpConnect && block.Status != models.BlockStatusInvalid {
+		return RecoveryCompleted, s.store.RunAtomically(ctx, func(ctx context.Context) error {
+			return s.redoConnect(ctx, block)
+		})
+	}
+
+	status, action := models.BlockStatusStale, RecoveryCompleted
+	if block.PendingOp == models.BlockOpConnect {
+		status, action = models.BlockStatusInvalid, RecoveryUndone
+	}
+	return action, s.store.RunAtomically(ctx, func(ctx context.Context) error {
+		return s.undoConnect(ctx, block, status)
+	})
+}
+
+// redoConnect brings the store to the state connectBlock leaves, whatever
+// part of it was already written
+func (s *BlockchainService) redoConnect(ctx context.Context, block *models.Block) error {
+	wallets := make(map[string]bool)
+	var txIDs []string
+	for i := range block.Transactions {
+		tx := block.Transactions[i]
+		txIDs = append(txIDs, tx.TxID)
+
+		if _, err := s.store.GetTransaction(ctx, tx.TxID); err == storage.ErrNotFound {
+			tx.ID = primitive.NewObjectID()
+			tx.Status = "confirmed"
+			tx.BlockHash = block.Hash
+			if err := s.store.InsertTransaction(ctx, &tx); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+
+		if tx.SenderWalletID != "system" {
+			wallets[tx.SenderWalletID] = true
+		}
+		for _, input := range tx.InputUTXOs {
+			if err := s.store.MarkUTXOSpent(ctx, input.TxID, input.OutputIndex, tx.TxID); err != nil {
+				return err
+			}
+		}
+
+		for _, output := range tx.OutputUTXOs {
+			wallets[output.WalletID] = true
+			_, err := s.store.GetUTXO(ctx, tx.TxID, output.Index)
+			if err == storage.ErrNotFound {
+				err = s.store.InsertUTXO(ctx, newOutputUTXO(&tx, output, block.Hash))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(txIDs) > 0 {
+		if err := s.store.SetTransactionStatus(ctx, txIDs, "confirmed", block.Hash); err != nil {
+			return err
+		}
+	}
+	if err := s.refreshBalances(ctx, wallets); err != nil {
+		return err
+	}
+
+	return s.store.UpdateBlock(ctx, block.Hash, map[string]interface{}{
+		"status":     models.BlockStatusMain,
+		"pending_op": "",
+	})
+}
+
+// undoConnect reverts whatever part of a block's connect was written,
+// which is also how an interrupted disconnect is finished, and leaves the
+// block with status
+func (s *BlockchainService) undoConnect(ctx context.Context, block *models.Block, status string) error {
+	wallets := make(map[string]bool)
+	var txIDs []string
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+		txIDs = append(txIDs, tx.TxID)
+
+		for _, output := range tx.OutputUTXOs {
+			wallets[output.WalletID] = true
+		}
+		if err := s.store.DeleteUTXOsByTx(ctx, tx.TxID); err != nil {
+			return err
+		}
+
+		if tx.SenderWalletID != "system" {
+			wallets[tx.SenderWalletID] = true
+		}
+		for _, input := range tx.InputUTXOs {
+			utxo, err := s.store.GetUTXO(ctx, input.TxID, input.OutputIndex)
+			if err == storage.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if utxo.IsSpent && utxo.SpentInTx == tx.TxID {
+				if err := s.store.MarkUTXOUnspent(ctx, input.TxID, input.OutputIndex); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if len(txIDs) > 0 {
+		if err := s.store.SetTransactionStatus(ctx, txIDs, "pending", ""); err != nil {
+			return err
+		}
+	}
+	if err := s.refreshBalances(ctx, wallets); err != nil {
+		return err
+	}
+
+	return s.store.UpdateBlock(ctx, block.Hash, map[string]interface{}{
+		"status":     status,
+		"pending_op": "",
+	})
+}
+
+// refreshBalances recomputes the cached balances of wallets from their
+// unspent outputs
+func (s *BlockchainService) refreshBalances(ctx context.Context, wallets map[string]bool) error {
+	for walletID := range wallets {
+		utxos, err := s.store.GetUnspentUTXOs(ctx, walletID)
+		if err != nil {
+			return err
+		}
+		var balance models.Amount
+		for _, utxo := range utxos {
+			balance += utxo.Amount
+		}
+		if err := s.store.SetWalletBalance(ctx, walletID, balance); err != nil {
+			return err
+		}
+	}
+	return nil
+}
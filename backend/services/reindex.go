@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of UTXO discrepancy found by ReindexUTXOs
+const (
+	UTXOMissing    = "missing"    // the chain creates the output but it is not stored
+	UTXOUnexpected = "unexpected" // the output is stored but the chain never created it
+	UTXOMismatch   = "mismatch"   // the stored output differs from the replayed one
+	UTXODuplicate  = "duplicate"  // the output is stored more than once
+)
+
+// UTXODiscrepancy is a stored output that does not match the replayed chain
+type UTXODiscrepancy struct {
+	TxID        string       `json:"txId"`
+	OutputIndex int          `json:"outputIndex"`
+	Kind        string       `json:"kind"`
+	Expected    *models.UTXO `json:"expected,omitempty"`
+	Stored      *models.UTXO `json:"stored,omitempty"`
+}
+
+// BalanceDiscrepancy is a wallet whose cached balance does not match the
+// sum of its replayed unspent outputs
+type BalanceDiscrepancy struct {
+	WalletID string        `json:"walletId"`
+	Cached   models.Amount `json:"cached"`
+	Expected models.Amount `json:"expected"`
+}
+
+// ReindexReport is the result of ReindexUTXOs
+type ReindexReport struct {
+	Height         int64                `json:"height"`
+	TipHash        string               `json:"tipHash"`
+	BlocksReplayed int                  `json:"blocksReplayed"`
+	UTXOs          int                  `json:"utxos"` // outputs in the replayed set
+	UTXODiffs      []UTXODiscrepancy    `json:"utxoDiffs"`
+	BalanceDiffs   []BalanceDiscrepancy `json:"balanceDiffs"`
+	// UnknownInputs are outpoints spent on chain that neither the chain
+	// nor the genesis allocations create; run ValidateChain to find out why
+	UnknownInputs []string  `json:"unknownInputs"`
+	Applied       bool      `json:"applied"`
+	CheckedAt     time.Time `json:"checkedAt"`
+}
+
+// Consistent reports whether the store already matched the replayed chain
+func (r *ReindexReport) Consistent() bool {
+	return len(r.UTXODiffs) == 0 && len(r.BalanceDiffs) == 0
+}
+
+// ReindexUTXOs rebuilds the UTXO set and every wallet's cached balance by
+// replaying the main chain from genesis, starting from the genesis
+// allocations, and reports where the store disagrees. With apply set, the
+// outputs of every transaction with a discrepancy are rewritten and the
+// cached balances corrected; running it again is harmless. Blocks are
+// trusted as stored; ValidateChain checks them against the consensus
+// rules.
+func (s *BlockchainService) ReindexUTXOs(ctx context.Context, apply bool) (*ReindexReport, error) {
+	s.chainMu.Lock()
+	defer s.chainMu.Unlock()
+
+	report := &ReindexReport{
+		UTXODiffs:     []UTXODiscrepancy{},
+		BalanceDiffs:  []BalanceDiscrepancy{},
+		UnknownInputs: []string{},
+		CheckedAt:     time.Now(),
+	}
+
+	blocks, err := s.store.GetAllBlocks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(blocks) > 0 {
+		report.Height = blocks[0].Index
+		report.TipHash = blocks[0].Hash
+	}
+
+	stored, err := s.store.GetAllUTXOs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Genesis allocations are created outside of any block, so the stored
+	// ones are the starting point of the replay
+	expected := make(map[string]*models.UTXO)
+	var keys []string
+	for i := range stored {
+		utxo := stored[i]
+		key := outpointKey(utxo.TxID, utxo.OutputIndex)
+		if utxo.BlockHash != "genesis" || expected[key] != nil {
+			continue
+		}
+		utxo.IsSpent = false
+		utxo.SpentInTx = ""
+		expected[key] = &utxo
+		keys = append(keys, key)
+	}
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := &blocks[i]
+		for j := range block.Transactions {
+			tx := &block.Transactions[j]
+			for _, input := range tx.InputUTXOs {
+				key := outpointKey(input.TxID, input.OutputIndex)
+				utxo := expected[key]
+				if utxo == nil {
+					utxo = lostGenesisAllocation(input)
+					if utxo == nil {
+						report.UnknownInputs = append(report.UnknownInputs, key)
+						continue
+					}
+					expected[key] = utxo
+					keys = append(keys, key)
+				}
+				utxo.IsSpent = true
+				utxo.SpentInTx = tx.TxID
+			}
+			for _, output := range tx.OutputUTXOs {
+				key := outpointKey(tx.TxID, output.Index)
+				if expected[key] == nil {
+					keys = append(keys, key)
+				}
+				expected[key] = newOutputUTXO(tx, output, block.Hash)
+			}
+		}
+		report.BlocksReplayed++
+	}
+	report.UTXOs = len(keys)
+
+	// Compare the stored outputs against the replay, grouping the
+	// transactions whose outputs need rewriting
+	rewrite := make(map[string]bool)
+	found := make(map[string]bool)
+	for i := range stored {
+		utxo := &stored[i]
+		key := outpointKey(utxo.TxID, utxo.OutputIndex)
+		want := expected[key]
+
+		kind := ""
+		switch {
+		case want == nil:
+			kind = UTXOUnexpected
+		case found[key]:
+			kind = UTXODuplicate
+		case !sameUTXO(want, utxo):
+			kind = UTXOMismatch
+		}
+		if want != nil && !found[key] {
+			// Rewritten outputs keep their identity
+			want.ID = utxo.ID
+			want.CreatedAt = utxo.CreatedAt
+		}
+		found[key] = true
+		if kind == "" {
+			continue
+		}
+		report.UTXODiffs = append(report.UTXODiffs, UTXODiscrepancy{
+			TxID:        utxo.TxID,
+			OutputIndex: utxo.OutputIndex,
+			Kind:        kind,
+			Expected:    want,
+			Stored:      utxo,
+		})
+		rewrite[utxo.TxID] = true
+	}
+	for _, key := range keys {
+		if found[key] {
+			continue
+		}
+		want := expected[key]
+		report.UTXODiffs = append(report.UTXODiffs, UTXODiscrepancy{
+			TxID:        want.TxID,
+			OutputIndex: want.OutputIndex,
+			Kind:        UTXOMissing,
+			Expected:    want,
+		})
+		rewrite[want.TxID] = true
+	}
+
+	wallets, err := s.store.GetAllWallets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	balances := make(map[string]models.Amount)
+	for _, key := range keys {
+		if utxo := expected[key]; !utxo.IsSpent {
+			balances[utxo.WalletID] += utxo.Amount
+		}
+	}
+	for _, wallet := range wallets {
+		if wallet.CachedBalance != balances[wallet.WalletID] {
+			report.BalanceDiffs = append(report.BalanceDiffs, BalanceDiscrepancy{
+				WalletID: wallet.WalletID,
+				Cached:   wallet.CachedBalance,
+				Expected: balances[wallet.WalletID],
+			})
+		}
+	}
+	sort.Slice(report.BalanceDiffs, func(i, j int) bool {
+		return report.BalanceDiffs[i].WalletID < report.BalanceDiffs[j].WalletID
+	})
+
+	if !apply || report.Consistent() {
+		return report, nil
+	}
+
+	// Each transaction's outputs are replaced together, so on an atomic
+	// store an interrupted run leaves them either old or corrected
+	byTx := make(map[string][]*models.UTXO)
+	for _, key := range keys {
+		utxo := expected[key]
+		if rewrite[utxo.TxID] {
+			byTx[utxo.TxID] = append(byTx[utxo.TxID], utxo)
+		}
+	}
+	for txID := range rewrite {
+		err := s.store.RunAtomically(ctx, func(ctx context.Context) error {
+			if err := s.store.DeleteUTXOsByTx(ctx, txID); err != nil {
+				return err
+			}
+			for _, utxo := range byTx[txID] {
+				if err := s.store.InsertUTXO(ctx, utxo); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, diff := range report.BalanceDiffs {
+		if err := s.store.SetWalletBalance(ctx, diff.WalletID, diff.Expected); err != nil {
+			return nil, err
+		}
+	}
+
+	report.Applied = true
+	return report, nil
+}
+
+// lostGenesisAllocation rebuilds a genesis allocation that is spent on
+// chain but no longer stored, from the input spending it
+func lostGenesisAllocation(input models.UTXOInput) *models.UTXO {
+	walletID := strings.TrimPrefix(input.TxID, "genesis_")
+	if walletID == input.TxID || input.OutputIndex != 0 {
+		return nil
+	}
+	return &models.UTXO{
+		ID:          primitive.NewObjectID(),
+		TxID:        input.TxID,
+		OutputIndex: input.OutputIndex,
+		WalletID:    walletID,
+		Amount:      input.Amount,
+		BlockHash:   "genesis",
+		CreatedAt:   time.Now(),
+	}
+}
+
+// sameUTXO compares the fields of two outputs that the chain determines
+func sameUTXO(a, b *models.UTXO) bool {
+	return a.WalletID == b.WalletID &&
+		a.Amount == b.Amount &&
+		a.IsSpent == b.IsSpent &&
+		a.SpentInTx == b.SpentInTx &&
+		a.BlockHash == b.BlockHash
+}
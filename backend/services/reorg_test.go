@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"backend/models"
+	"backend/storage"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// forkFixture is a chain where alice's coinbase from block 1 is spent to
+// bob in block A1 of the main branch A1-A2
+type forkFixture struct {
+	bc       *BlockchainService
+	store    storage.ChainStore
+	funding  *models.Block
+	a1, a2   *models.Block
+	transfer models.Transaction
+	start    time.Time
+}
+
+func newForkFixture(t *testing.T) *forkFixture {
+	t.Helper()
+	ctx := context.Background()
+	bc, store := newTestChain(t)
+	genesis, err := bc.GetLatestBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &forkFixture{bc: bc, store: store, start: genesis.Timestamp}
+
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	f.funding = f.add(t, genesis, alice.walletID, time.Minute)
+
+	coinbase := f.funding.Transactions[0]
+	input := models.UTXOInput{TxID: coinbase.TxID, OutputIndex: 0, Amount: coinbase.Amount}
+	f.transfer = transfer(t, alice.walletID, alice, bob.walletID, []models.UTXOInput{input}, coinbase.Amount-1000)
+
+	f.a1 = f.add(t, f.funding, "miner-a", 2*time.Minute, f.transfer)
+	f.a2 = f.add(t, f.a1, "miner-a", 3*time.Minute)
+	return f
+}
+
+// add mines a block on parent, offset from genesis, and adds it to the chain
+func (f *forkFixture) add(t *testing.T, parent *models.Block, miner string, offset time.Duration, txs ...models.Transaction) *models.Block {
+	t.Helper()
+	block := nextBlock(t, f.bc, parent, miner, f.start.Add(offset), txs...)
+	if err := f.bc.AddBlock(context.Background(), block); err != nil {
+		t.Fatalf("adding block %d: %v", block.Index, err)
+	}
+	return block
+}
+
+func (f *forkFixture) status(t *testing.T, blocks ...*models.Block) []string {
+	t.Helper()
+	var statuses []string
+	for _, block := range blocks {
+		stored, err := f.store.GetBlockByHash(context.Background(), block.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		statuses = append(statuses, stored.Status)
+	}
+	return statuses
+}
+
+func (f *forkFixture) unspent(t *testing.T, walletID string) int {
+	t.Helper()
+	return len(mustUnspent(t, f.store, walletID))
+}
+
+func (f *forkFixture) txStatus(t *testing.T, txID string) string {
+	t.Helper()
+	tx, err := f.store.GetTransaction(context.Background(), txID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx.Status
+}
+
+func TestReorganizeToHeavierBranch(t *testing.T) {
+	ctx := context.Background()
+	f := newForkFixture(t)
+
+	// A competing branch without the transfer overtakes A1-A2 at B3
+	b1 := f.add(t, f.funding, "miner-b", 2*time.Minute+time.Second)
+	b2 := f.add(t, b1, "miner-b", 3*time.Minute+time.Second)
+	if got := f.status(t, f.a2, b2); got[0] != models.BlockStatusMain || got[1] != models.BlockStatusStale {
+		t.Fatalf("statuses before the reorg = %v; want the first branch kept on equal work", got)
+	}
+	b3 := f.add(t, b2, "miner-b", 4*time.Minute)
+
+	if tip, err := f.bc.GetLatestBlock(ctx); err != nil || tip.Hash != b3.Hash {
+		t.Fatalf("tip = %v, %v; want B3", tip, err)
+	}
+	want := []string{models.BlockStatusStale, models.BlockStatusStale, models.BlockStatusMain, models.BlockStatusMain, models.BlockStatusMain}
+	if got := f.status(t, f.a1, f.a2, b1, b2, b3); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("statuses of A1 A2 B1 B2 B3 = %v, want %v", got, want)
+	}
+
+	sender, receiver := f.transfer.SenderWalletID, f.transfer.ReceiverWalletID
+	if got := []int{f.unspent(t, sender), f.unspent(t, receiver), f.unspent(t, "miner-a"), f.unspent(t, "miner-b")}; fmt.Sprint(got) != "[1 0 0 3]" {
+		t.Fatalf("unspent outputs of alice, bob, miner-a, miner-b = %v, want [1 0 0 3]", got)
+	}
+
+	// The transfer goes back to the mempool; A's coinbases cannot
+	pending := f.bc.GetMempool().Transactions()
+	if len(pending) != 1 || pending[0].TxID != f.transfer.TxID || f.txStatus(t, f.transfer.TxID) != "pending" {
+		t.Fatalf("mempool = %v, transfer %s; want only the transfer, pending", pending, f.txStatus(t, f.transfer.TxID))
+	}
+	for _, block := range []*models.Block{f.a1, f.a2} {
+		if got := f.txStatus(t, block.Transactions[0].TxID); got != "rejected" {
+			t.Fatalf("coinbase of block %s is %s, want rejected", block.Hash, got)
+		}
+	}
+
+	report, err := f.bc.ValidateChain(ctx)
+	if err != nil || !report.Valid {
+		t.Fatalf("ValidateChain = %+v, %v", report, err)
+	}
+}
+
+func TestReorganizeRollsBackOnInvalidBlock(t *testing.T) {
+	ctx := context.Background()
+	f := newForkFixture(t)
+	mallory := newTestWallet(t)
+	missing := models.UTXOInput{TxID: "nowhere", OutputIndex: 0, Amount: models.Coin}
+	bad := transfer(t, mallory.walletID, mallory, mallory.walletID, []models.UTXOInput{missing}, models.Coin)
+
+	// B2 breaks a rule that only shows once it is connected
+	b1 := f.add(t, f.funding, "miner-b", 2*time.Minute+time.Second)
+	b2 := f.add(t, b1, "miner-b", 3*time.Minute+time.Second, bad)
+	b3 := nextBlock(t, f.bc, b2, "miner-b", f.start.Add(4*time.Minute))
+	err := f.bc.AddBlock(ctx, b3)
+	if got := ruleOf(err); got != RuleMissingInput {
+		t.Fatalf("AddBlock = %v, want rule %q", err, RuleMissingInput)
+	}
+
+	if tip, err := f.bc.GetLatestBlock(ctx); err != nil || tip.Hash != f.a2.Hash {
+		t.Fatalf("tip = %v, %v; want A2 restored", tip, err)
+	}
+	want := []string{models.BlockStatusMain, models.BlockStatusMain, models.BlockStatusStale, models.BlockStatusInvalid, models.BlockStatusInvalid}
+	if got := f.status(t, f.a1, f.a2, b1, b2, b3); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("statuses of A1 A2 B1 B2 B3 = %v, want %v", got, want)
+	}
+
+	sender, receiver := f.transfer.SenderWalletID, f.transfer.ReceiverWalletID
+	if got := []int{f.unspent(t, sender), f.unspent(t, receiver), f.unspent(t, "miner-a"), f.unspent(t, "miner-b")}; fmt.Sprint(got) != "[0 1 2 0]" {
+		t.Fatalf("unspent outputs of alice, bob, miner-a, miner-b = %v, want [0 1 2 0]", got)
+	}
+	if n := f.bc.GetMempool().Len(); n != 0 || f.txStatus(t, f.transfer.TxID) != "confirmed" {
+		t.Fatalf("mempool holds %d, transfer %s; want it confirmed again", n, f.txStatus(t, f.transfer.TxID))
+	}
+
+	b4 := nextBlock(t, f.bc, b3, "miner-b", f.start.Add(5*time.Minute))
+	if err := f.bc.AddBlock(ctx, b4); err == nil {
+		t.Fatal("accepted a block building on an invalid block")
+	}
+	report, err := f.bc.ValidateChain(ctx)
+	if err != nil || !report.Valid {
+		t.Fatalf("ValidateChain = %+v, %v", report, err)
+	}
+}
+
+func TestConnectOrphans(t *testing.T) {
+	ctx := context.Background()
+	bc, store := newTestChain(t)
+	genesis, err := bc.GetLatestBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x1 := nextBlock(t, bc, genesis, "miner", genesis.Timestamp.Add(time.Minute))
+	x2 := nextBlock(t, bc, x1, "miner", genesis.Timestamp.Add(2*time.Minute))
+	// X3 is not newer than the median time past, which only shows once
+	// its ancestors are known
+	x3 := nextBlock(t, bc, x2, "miner", genesis.Timestamp)
+
+	for _, orphan := range []*models.Block{x3, x2} {
+		if err := bc.AddBlock(ctx, orphan); err != nil {
+			t.Fatal(err)
+		}
+		if stored, err := store.GetBlockByHash(ctx, orphan.Hash); err != nil || stored.Status != models.BlockStatusOrphan {
+			t.Fatalf("block %d = %+v, %v; want it kept as an orphan", orphan.Index, stored, err)
+		}
+	}
+	if err := bc.AddBlock(ctx, x1); err != nil {
+		t.Fatal(err)
+	}
+
+	if tip, err := bc.GetLatestBlock(ctx); err != nil || tip.Hash != x2.Hash {
+		t.Fatalf("tip = %v, %v; want the adopted orphan X2", tip, err)
+	}
+	if stored, err := store.GetBlockByHash(ctx, x3.Hash); err != nil || stored.Status != models.BlockStatusInvalid {
+		t.Fatalf("X3 = %+v, %v; want it marked invalid", stored, err)
+	}
+	if n := len(mustUnspent(t, store, "miner")); n != 2 {
+		t.Fatalf("miner has %d outputs, want the coinbases of X1 and X2", n)
+	}
+}
+
+func TestPruneOrphans(t *testing.T) {
+	ctx := context.Background()
+	limits := DefaultBlockLimits()
+	limits.MaxOrphans = 2
+	cfg := DefaultDifficultyConfig()
+	cfg.InitialDifficulty = 1
+	store := storage.NewMemoryStore()
+	bc := NewBlockchainService(store, cfg, DefaultSubsidyConfig(), limits, DefaultMempoolConfig(), DefaultNetworkConfig())
+	if err := bc.InitializeGenesisBlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Orphans of unknown parents, received in order; the first has expired
+	now := time.Now()
+	received := []time.Time{now.Add(-2 * limits.OrphanExpiry), now.Add(-2 * time.Minute), now.Add(-time.Minute), now}
+	var orphans []*models.Block
+	for i, at := range received {
+		parent := &models.Block{Index: 5, Hash: fmt.Sprintf("%064x", i), Timestamp: now.Add(-time.Hour)}
+		orphan := nextBlock(t, bc, parent, "miner", now)
+		orphan.ID = primitive.NewObjectID() // AddBlock keeps the receive time below
+		orphan.CreatedAt = at
+		if err := bc.AddBlock(ctx, orphan); err != nil {
+			t.Fatal(err)
+		}
+		orphans = append(orphans, orphan)
+	}
+
+	kept, err := store.GetBlocksByStatus(ctx, models.BlockStatusOrphan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != limits.MaxOrphans {
+		t.Fatalf("%d orphans kept, want %d", len(kept), limits.MaxOrphans)
+	}
+	for _, orphan := range orphans[:2] {
+		if _, err := store.GetBlockByHash(ctx, orphan.Hash); err != storage.ErrNotFound {
+			t.Fatalf("orphan received at %v survived pruning: err = %v", orphan.CreatedAt, err)
+		}
+	}
+
+	low := nextBlock(t, bc, &models.Block{Index: 5, Hash: "low"}, "miner", now)
+	low.Difficulty = 0
+	low.Hash = BlockHash(low)
+	if err := bc.AddBlock(ctx, low); err == nil {
+		t.Fatal("accepted an orphan below the minimum difficulty")
+	}
+}
+
+func mustUnspent(t *testing.T, store storage.ChainStore, walletID string) []models.UTXO {
+	t.Helper()
+	utxos, err := store.GetUnspentUTXOs(context.Background(), walletID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return utxos
+}
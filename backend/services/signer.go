@@ -0,0 +1,316 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	secpecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// Wallets sign with one of several schemes. The scheme travels with the
+// public key: P-256 keys keep the uncompressed encoding wallets have
+// always had, and other keys start with a tag byte. Since a wallet ID is
+// the hash of its public key, an address commits to its key's scheme too,
+// and a signature is checked with the scheme its sender's key names.
+
+// KeyType is a signature scheme
+type KeyType byte
+
+const (
+	KeyTypeP256      KeyType = iota // ECDSA over NIST P-256 with SHA-256
+	KeyTypeSecp256k1                // ECDSA over secp256k1 with SHA-256, low S only
+	KeyTypeEd25519                  // Ed25519 over the message itself
+)
+
+// DefaultKeyType is the scheme of wallets that do not choose one
+const DefaultKeyType = KeyTypeP256
+
+// Tag bytes of public and private keys other than P-256. Neither is a
+// SEC1 point prefix, so tagged keys never read as untagged ones.
+const (
+	keyTagSecp256k1 = 0xe1
+	keyTagEd25519   = 0xed
+)
+
+var (
+	ErrUnknownKeyType   = errors.New("unknown key type")
+	ErrInvalidPublicKey = errors.New("invalid public key")
+)
+
+var keyTypeNames = map[KeyType]string{
+	KeyTypeP256:      "p256",
+	KeyTypeSecp256k1: "secp256k1",
+	KeyTypeEd25519:   "ed25519",
+}
+
+func (t KeyType) String() string {
+	if name, ok := keyTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("KeyType(%d)", byte(t))
+}
+
+// ParseKeyType returns the key type of a name such as "secp256k1". The
+// empty name is the default key type.
+func ParseKeyType(name string) (KeyType, error) {
+	if name == "" {
+		return DefaultKeyType, nil
+	}
+	for t, n := range keyTypeNames {
+		if n == name {
+			return t, nil
+		}
+	}
+	return 0, ErrUnknownKeyType
+}
+
+// Verifier checks signatures against one public key
+type Verifier interface {
+	KeyType() KeyType
+	// Bytes returns the encoded public key, which names its key type
+	Bytes() []byte
+	Verify(data, signature []byte) bool
+}
+
+// Signer signs with one private key
+type Signer interface {
+	Public() Verifier
+	Sign(data []byte) ([]byte, error)
+	// Bytes returns the encoded private key, which names its key type
+	Bytes() []byte
+}
+
+// GenerateSigner returns a new random key of a type
+func GenerateSigner(keyType KeyType) (Signer, error) {
+	switch keyType {
+	case KeyTypeP256:
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return &p256Signer{priv: priv}, nil
+	case KeyTypeSecp256k1:
+		priv, err := secp256k1.GeneratePrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		return &secp256k1Signer{priv: priv}, nil
+	case KeyTypeEd25519:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return ed25519Signer(priv), nil
+	}
+	return nil, ErrUnknownKeyType
+}
+
+// NewSigner returns the signer of a 32-byte private key of a type: the
+// scalar of ECDSA keys, the seed of Ed25519 ones
+func NewSigner(keyType KeyType, key []byte) (Signer, error) {
+	if len(key) != 32 {
+		return nil, errors.New("private key must be 32 bytes")
+	}
+	switch keyType {
+	case KeyTypeP256:
+		curve := elliptic.P256()
+		d := new(big.Int).SetBytes(key)
+		if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+			return nil, errors.New("invalid private key")
+		}
+		priv := &ecdsa.PrivateKey{D: d}
+		priv.Curve = curve
+		priv.X, priv.Y = curve.ScalarBaseMult(key)
+		return &p256Signer{priv: priv}, nil
+	case KeyTypeSecp256k1:
+		var scalar secp256k1.ModNScalar
+		if overflow := scalar.SetByteSlice(key); overflow || scalar.IsZero() {
+			return nil, errors.New("invalid private key")
+		}
+		return &secp256k1Signer{priv: secp256k1.NewPrivateKey(&scalar)}, nil
+	case KeyTypeEd25519:
+		return ed25519Signer(ed25519.NewKeyFromSeed(key)), nil
+	}
+	return nil, ErrUnknownKeyType
+}
+
+// ParsePrivateKey decodes a hex private key written by a Signer's Bytes
+func ParsePrivateKey(s string) (Signer, error) {
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid private key")
+	}
+	switch {
+	case len(raw) == 33 && raw[0] == keyTagSecp256k1:
+		return NewSigner(KeyTypeSecp256k1, raw[1:])
+	case len(raw) == 33 && raw[0] == keyTagEd25519:
+		return NewSigner(KeyTypeEd25519, raw[1:])
+	case len(raw) <= 32:
+		// P-256 keys are the bare scalar, without leading zeros
+		return NewSigner(KeyTypeP256, append(make([]byte, 32-len(raw)), raw...))
+	}
+	return nil, errors.New("invalid private key")
+}
+
+// ParsePublicKey decodes a public key written by a Verifier's Bytes
+func ParsePublicKey(raw []byte) (Verifier, error) {
+	switch {
+	case len(raw) == 65 && raw[0] == 4:
+		x, y := elliptic.Unmarshal(elliptic.P256(), raw)
+		if x == nil {
+			return nil, ErrInvalidPublicKey
+		}
+		return &p256Verifier{pub: &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}}, nil
+	case len(raw) == 34 && raw[0] == keyTagSecp256k1:
+		pub, err := secp256k1.ParsePubKey(raw[1:])
+		if err != nil {
+			return nil, ErrInvalidPublicKey
+		}
+		return &secp256k1Verifier{pub: pub}, nil
+	case len(raw) == 1+ed25519.PublicKeySize && raw[0] == keyTagEd25519:
+		return ed25519Verifier(raw[1:]), nil
+	}
+	return nil, ErrInvalidPublicKey
+}
+
+// PublicKeyFromHex decodes a hex public key, as wallets and transactions
+// carry them
+func PublicKeyFromHex(s string) (Verifier, error) {
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidPublicKey
+	}
+	return ParsePublicKey(raw)
+}
+
+// PublicKeyHex returns the hex public key of a verifier
+func PublicKeyHex(v Verifier) string {
+	return hex.EncodeToString(v.Bytes())
+}
+
+// p256Signer signs with ECDSA over P-256: r and s of a SHA-256 digest,
+// 32 bytes each
+type p256Signer struct {
+	priv *ecdsa.PrivateKey
+}
+
+func (s *p256Signer) Public() Verifier {
+	return &p256Verifier{pub: &s.priv.PublicKey}
+}
+
+func (s *p256Signer) Sign(data []byte) ([]byte, error) {
+	hash := sha256.Sum256(data)
+	r, ss, err := ecdsa.Sign(rand.Reader, s.priv, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	ss.FillBytes(signature[32:])
+	return signature, nil
+}
+
+func (s *p256Signer) Bytes() []byte {
+	return s.priv.D.Bytes()
+}
+
+type p256Verifier struct {
+	pub *ecdsa.PublicKey
+}
+
+func (v *p256Verifier) KeyType() KeyType { return KeyTypeP256 }
+
+func (v *p256Verifier) Bytes() []byte {
+	return elliptic.Marshal(v.pub.Curve, v.pub.X, v.pub.Y)
+}
+
+func (v *p256Verifier) Verify(data, signature []byte) bool {
+	if len(signature) != 64 {
+		return false
+	}
+	hash := sha256.Sum256(data)
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	return ecdsa.Verify(v.pub, hash[:], r, s)
+}
+
+// secp256k1Signer signs with ECDSA over secp256k1: r and s of a SHA-256
+// digest, 32 bytes each, with deterministic nonces and S in the lower half
+// of the order so that signatures cannot be altered
+type secp256k1Signer struct {
+	priv *secp256k1.PrivateKey
+}
+
+func (s *secp256k1Signer) Public() Verifier {
+	return &secp256k1Verifier{pub: s.priv.PubKey()}
+}
+
+func (s *secp256k1Signer) Sign(data []byte) ([]byte, error) {
+	hash := sha256.Sum256(data)
+	// A compact signature is a recovery byte followed by r and s
+	return secpecdsa.SignCompact(s.priv, hash[:], true)[1:], nil
+}
+
+func (s *secp256k1Signer) Bytes() []byte {
+	return append([]byte{keyTagSecp256k1}, s.priv.Serialize()...)
+}
+
+type secp256k1Verifier struct {
+	pub *secp256k1.PublicKey
+}
+
+func (v *secp256k1Verifier) KeyType() KeyType { return KeyTypeSecp256k1 }
+
+func (v *secp256k1Verifier) Bytes() []byte {
+	return append([]byte{keyTagSecp256k1}, v.pub.SerializeCompressed()...)
+}
+
+func (v *secp256k1Verifier) Verify(data, signature []byte) bool {
+	if len(signature) != 64 {
+		return false
+	}
+	var r, s secp256k1.ModNScalar
+	if r.SetByteSlice(signature[:32]) || s.SetByteSlice(signature[32:]) {
+		return false
+	}
+	if r.IsZero() || s.IsZero() || s.IsOverHalfOrder() {
+		return false
+	}
+	hash := sha256.Sum256(data)
+	return secpecdsa.NewSignature(&r, &s).Verify(hash[:], v.pub)
+}
+
+// ed25519Signer signs the message itself with Ed25519
+type ed25519Signer ed25519.PrivateKey
+
+func (s ed25519Signer) Public() Verifier {
+	return ed25519Verifier(ed25519.PrivateKey(s).Public().(ed25519.PublicKey))
+}
+
+func (s ed25519Signer) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(ed25519.PrivateKey(s), data), nil
+}
+
+func (s ed25519Signer) Bytes() []byte {
+	return append([]byte{keyTagEd25519}, ed25519.PrivateKey(s).Seed()...)
+}
+
+type ed25519Verifier ed25519.PublicKey
+
+func (v ed25519Verifier) KeyType() KeyType { return KeyTypeEd25519 }
+
+func (v ed25519Verifier) Bytes() []byte {
+	return append([]byte{keyTagEd25519}, v...)
+}
+
+func (v ed25519Verifier) Verify(data, signature []byte) bool {
+	return len(signature) == ed25519.SignatureSize && ed25519.Verify(ed25519.PublicKey(v), data, signature)
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+var keyTypes = []KeyType{KeyTypeP256, KeyTypeSecp256k1, KeyTypeEd25519}
+
+func TestSignerRoundTrip(t *testing.T) {
+	message := []byte("payload")
+	for _, keyType := range keyTypes {
+		t.Run(keyType.String(), func(t *testing.T) {
+			signer, err := GenerateSigner(keyType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signature, err := signer.Sign(message)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The public key names its type, so it parses to the same verifier
+			verifier, err := PublicKeyFromHex(PublicKeyHex(signer.Public()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if verifier.KeyType() != keyType {
+				t.Fatalf("parsed key type = %s, want %s", verifier.KeyType(), keyType)
+			}
+			if !verifier.Verify(message, signature) {
+				t.Fatal("signature does not verify")
+			}
+			if verifier.Verify([]byte("other payload"), signature) {
+				t.Fatal("signature verifies another message")
+			}
+			altered := append([]byte(nil), signature...)
+			altered[len(altered)-1] ^= 1
+			if verifier.Verify(message, altered) {
+				t.Fatal("altered signature verifies")
+			}
+			if verifier.Verify(message, signature[:len(signature)-1]) {
+				t.Fatal("truncated signature verifies")
+			}
+
+			// So does the private key
+			restored, err := ParsePrivateKey(hex.EncodeToString(signer.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if PublicKeyHex(restored.Public()) != PublicKeyHex(signer.Public()) {
+				t.Fatal("restored private key has another public key")
+			}
+
+			name, err := ParseKeyType(keyType.String())
+			if err != nil || name != keyType {
+				t.Fatalf("ParseKeyType(%q) = %v, %v", keyType.String(), name, err)
+			}
+		})
+	}
+}
+
+func TestSecp256k1RejectsHighS(t *testing.T) {
+	signer, err := GenerateSigner(KeyTypeSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := []byte("payload")
+	signature, err := signer.Sign(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s secp256k1.ModNScalar
+	s.SetByteSlice(signature[32:])
+	if s.IsOverHalfOrder() {
+		t.Fatal("signer produced a high S")
+	}
+
+	// N - S makes a signature ECDSA accepts for the same message, which
+	// would give the transaction another ID
+	s.Negate()
+	highS := s.Bytes()
+	high := append(append([]byte(nil), signature[:32]...), highS[:]...)
+	if signer.Public().Verify(message, high) {
+		t.Fatal("high-S signature verifies")
+	}
+}
+
+func TestParsePublicKeyRejectsCrossTypeKeys(t *testing.T) {
+	signers := make(map[KeyType]Signer)
+	for _, keyType := range keyTypes {
+		signer, err := GenerateSigner(keyType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers[keyType] = signer
+	}
+	p256 := signers[KeyTypeP256].Public().Bytes()
+	secp := signers[KeyTypeSecp256k1].Public().Bytes()
+	ed := signers[KeyTypeEd25519].Public().Bytes()
+	secpPub, err := secp256k1.ParsePubKey(secp[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	retag := func(tag byte, key []byte) []byte {
+		return append([]byte{tag}, key...)
+	}
+
+	tests := []struct {
+		name string
+		raw  []byte
+	}{
+		{"secp256k1 key tagged Ed25519", retag(keyTagEd25519, secp[1:])},
+		{"Ed25519 key tagged secp256k1", retag(keyTagSecp256k1, ed[1:])},
+		{"P-256 key tagged secp256k1", retag(keyTagSecp256k1, p256)},
+		{"P-256 key tagged Ed25519", retag(keyTagEd25519, p256)},
+		{"untagged secp256k1 key", secp[1:]},
+		{"untagged uncompressed secp256k1 key", secpPub.SerializeUncompressed()},
+		{"untagged Ed25519 key", ed[1:]},
+		{"unknown tag", retag(0xee, ed[1:])},
+		{"empty", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if v, err := ParsePublicKey(tt.raw); err != ErrInvalidPublicKey {
+				t.Fatalf("ParsePublicKey = %v, %v; want %v", v, err, ErrInvalidPublicKey)
+			}
+		})
+	}
+
+	// A signature verifies only under the scheme it was made with, even
+	// for the same private scalar
+	message := []byte("payload")
+	scalar := signers[KeyTypeSecp256k1].Bytes()[1:]
+	p256Signer, err := NewSigner(KeyTypeP256, scalar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := signers[KeyTypeSecp256k1].Sign(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p256Signer.Public().Verify(message, signature) {
+		t.Fatal("secp256k1 signature verifies under the P-256 key of the same scalar")
+	}
+	if bytes.Equal(p256Signer.Public().Bytes(), signers[KeyTypeSecp256k1].Public().Bytes()) {
+		t.Fatal("P-256 and secp256k1 keys of a scalar encode alike")
+	}
+}
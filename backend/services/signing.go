@@ -0,0 +1,303 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/models"
+	"backend/storage"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/scrypt"
+)
+
+// A signing passphrase locks a user's custodial keys: the key and seed are
+// sealed under a key derived from the passphrase with scrypt before they
+// are encrypted for storage, so neither the database nor the server's
+// master key alone can sign. The key is unlocked in memory for a single
+// signature.
+
+// MinPassphraseLength is the shortest signing passphrase accepted
+const MinPassphraseLength = 8
+
+// scrypt costs for new passphrases. They are stored with each user, so
+// raising them does not break existing locks.
+const (
+	scryptLogN       = 15
+	scryptR          = 8
+	scryptP          = 1
+	scryptSaltLength = 16
+)
+
+var (
+	ErrSigningLocked   = errors.New("wallet keys are locked by a signing passphrase")
+	ErrNoPassphrase    = errors.New("set a signing passphrase before the server can sign for you")
+	ErrWrongPassphrase = errors.New("wrong signing passphrase")
+	ErrWeakPassphrase  = fmt.Errorf("signing passphrase must be at least %d characters", MinPassphraseLength)
+)
+
+type SigningService struct {
+	store  storage.ChainStore
+	wallet *WalletService
+}
+
+func NewSigningService(store storage.ChainStore, wallet *WalletService) *SigningService {
+	return &SigningService{
+		store:  store,
+		wallet: wallet,
+	}
+}
+
+// SetPassphrase locks a user's keys with a signing passphrase, or changes
+// it when current unlocks the existing one. Users without a seed are given
+// one first, and the extended public key of ECDSA accounts is kept so that
+// addresses can still be derived while the seed is locked.
+func (s *SigningService) SetPassphrase(ctx context.Context, userID primitive.ObjectID, current, passphrase string) error {
+	if len(passphrase) < MinPassphraseLength {
+		return ErrWeakPassphrase
+	}
+
+	s.wallet.mu.Lock()
+	defer s.wallet.mu.Unlock()
+
+	user, err := s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	var privKey, seed string
+	if user.PassphraseKDF != "" {
+		key, err := derivePassphraseKey(user.PassphraseKDF, current)
+		if err != nil {
+			return err
+		}
+		if privKey, err = s.unlockValue(ctx, key, user.EncryptedPrivKey); err != nil {
+			return err
+		}
+		if seed, err = s.unlockValue(ctx, key, user.EncryptedSeed); err != nil {
+			return err
+		}
+	} else {
+		if _, err := s.wallet.receiveAccount(ctx, user, true); err != nil {
+			return err
+		}
+		if privKey, err = s.wallet.vault.Decrypt(ctx, user.EncryptedPrivKey); err != nil {
+			return err
+		}
+		if seed, err = s.wallet.vault.Decrypt(ctx, user.EncryptedSeed); err != nil {
+			return err
+		}
+	}
+
+	kdf, err := newPassphraseKDF()
+	if err != nil {
+		return err
+	}
+	key, err := derivePassphraseKey(kdf, passphrase)
+	if err != nil {
+		return err
+	}
+	if privKey, err = s.lockValue(ctx, key, privKey); err != nil {
+		return err
+	}
+	if seed, err = s.lockValue(ctx, key, seed); err != nil {
+		return err
+	}
+
+	return s.store.UpdateUser(ctx, user.ID, map[string]interface{}{
+		"encrypted_priv_key": privKey,
+		"encrypted_seed":     seed,
+		"passphrase_kdf":     kdf,
+		"updated_at":         time.Now(),
+	})
+}
+
+// SignTransaction unlocks the key of the transaction's sender, one of the
+// user's addresses, and signs the transaction with it
+func (s *SigningService) SignTransaction(ctx context.Context, userID primitive.ObjectID, tx *models.Transaction, passphrase string) error {
+	user, err := s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.PassphraseKDF == "" {
+		return ErrNoPassphrase
+	}
+
+	wallet, err := s.store.GetWalletByWalletID(ctx, tx.SenderWalletID)
+	if err != nil || wallet.UserID != user.ID {
+		return errors.New("sender wallet does not belong to you")
+	}
+
+	signer, err := s.unlockKey(ctx, user, wallet, passphrase)
+	if err != nil {
+		if errors.Is(err, ErrWrongPassphrase) {
+			s.logSigning(ctx, wallet.WalletID, "Signing attempted with a wrong passphrase", "failed")
+		}
+		return err
+	}
+
+	signature, err := signer.Sign([]byte(TransactionSigningPayload(tx)))
+	if err != nil {
+		return err
+	}
+	tx.Signature = hex.EncodeToString(signature)
+	tx.TxID = ComputeTxID(tx)
+	return nil
+}
+
+// unlockKey opens the passphrase lock and returns the private key of one
+// of the user's wallets: derived from the seed for the scheme its public
+// key names, or the stored key of a wallet created before HD derivation
+func (s *SigningService) unlockKey(ctx context.Context, user *models.User, wallet *models.Wallet, passphrase string) (Signer, error) {
+	key, err := derivePassphraseKey(user.PassphraseKDF, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	var signer Signer
+	if wallet.DerivationPath == "" {
+		if wallet.WalletID != user.WalletID {
+			return nil, errors.New("no key is stored for this wallet")
+		}
+		privStr, err := s.unlockValue(ctx, key, user.EncryptedPrivKey)
+		if err != nil {
+			return nil, err
+		}
+		if signer, err = ParsePrivateKey(privStr); err != nil {
+			return nil, err
+		}
+	} else {
+		pubKey, err := PublicKeyFromHex(wallet.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		seedHex, err := s.unlockValue(ctx, key, user.EncryptedSeed)
+		if err != nil {
+			return nil, err
+		}
+		seed, err := hex.DecodeString(seedHex)
+		if err != nil {
+			return nil, err
+		}
+		master, err := NewMasterKey(pubKey.KeyType(), seed)
+		if err != nil {
+			return nil, err
+		}
+		derived, err := master.Derive(wallet.DerivationPath)
+		if err != nil {
+			return nil, err
+		}
+		if signer, err = derived.Signer(); err != nil {
+			return nil, err
+		}
+	}
+
+	if PublicKeyHex(signer.Public()) != wallet.PublicKey {
+		return nil, errors.New("unlocked key does not match the wallet")
+	}
+	return signer, nil
+}
+
+// lockValue seals a key under the passphrase key, then encrypts it for
+// storage, so that master key rotation can rewrap it without the passphrase
+func (s *SigningService) lockValue(ctx context.Context, key []byte, plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	sealed, err := seal(key, plaintext)
+	if err != nil {
+		return "", err
+	}
+	return s.wallet.vault.Encrypt(ctx, sealed)
+}
+
+// unlockValue reverses lockValue. Keys locked before envelope encryption
+// have the passphrase seal outside the server's encryption instead.
+func (s *SigningService) unlockValue(ctx context.Context, key []byte, locked string) (string, error) {
+	if locked == "" {
+		return "", nil
+	}
+	if !IsEnvelope(locked) {
+		encrypted, err := openSealed(key, locked)
+		if err != nil {
+			return "", err
+		}
+		return s.wallet.vault.Decrypt(ctx, encrypted)
+	}
+
+	sealed, err := s.wallet.vault.Decrypt(ctx, locked)
+	if err != nil {
+		return "", err
+	}
+	return openSealed(key, sealed)
+}
+
+// logSigning records a signing event
+func (s *SigningService) logSigning(ctx context.Context, walletID, details, status string) {
+	log := models.SystemLog{
+		ID:        primitive.NewObjectID(),
+		Action:    "signing_unlock",
+		WalletID:  walletID,
+		Details:   details,
+		Status:    status,
+		Timestamp: time.Now(),
+	}
+
+	s.store.InsertSystemLog(ctx, &log)
+}
+
+// newPassphraseKDF returns the parameters for a new passphrase: the scrypt
+// costs and a random salt, as "scrypt$logN$r$p$salt"
+func newPassphraseKDF() (string, error) {
+	salt := make([]byte, scryptSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("scrypt$%d$%d$%d$%s", scryptLogN, scryptR, scryptP, base64.StdEncoding.EncodeToString(salt)), nil
+}
+
+// derivePassphraseKey derives the AES key a passphrase locks keys with
+func derivePassphraseKey(kdf, passphrase string) ([]byte, error) {
+	parts := strings.Split(kdf, "$")
+	if len(parts) != 5 || parts[0] != "scrypt" {
+		return nil, errors.New("unknown passphrase key derivation")
+	}
+	logN, err1 := strconv.Atoi(parts[1])
+	r, err2 := strconv.Atoi(parts[2])
+	p, err3 := strconv.Atoi(parts[3])
+	salt, err4 := base64.StdEncoding.DecodeString(parts[4])
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || logN < 1 || logN > 30 {
+		return nil, errors.New("invalid passphrase key derivation parameters")
+	}
+	return scrypt.Key([]byte(passphrase), salt, 1<<logN, r, p, 32)
+}
+
+// seal encrypts plaintext with AES-GCM under a passphrase key
+func seal(key []byte, plaintext string) (string, error) {
+	sealed, err := aesSeal(key, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openSealed decrypts a value sealed under a passphrase key, failing with
+// ErrWrongPassphrase when the key does not open it
+func openSealed(key []byte, sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := aesOpen(key, data)
+	if err != nil {
+		return "", ErrWrongPassphrase
+	}
+	return string(plaintext), nil
+}
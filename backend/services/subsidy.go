@@ -0,0 +1,148 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CoinbaseTransactionType marks the transaction that pays a block's miner
+const CoinbaseTransactionType = "mining_reward"
+
+// maxHalvings is the era after which the subsidy is zero
+const maxHalvings = 64
+
+// SubsidyConfig controls the new coins paid to miners. The subsidy halves
+// every HalvingInterval blocks and stops once MaxSupply has been issued.
+type SubsidyConfig struct {
+	InitialSubsidy  models.Amount // paid for each block in the first era
+	HalvingInterval int64         // blocks per era
+	MaxSupply       models.Amount // hard cap on coins issued by coinbases
+}
+
+// DefaultSubsidyConfig returns the subsidy defaults
+func DefaultSubsidyConfig() SubsidyConfig {
+	return SubsidyConfig{
+		InitialSubsidy:  50 * models.Coin,
+		HalvingInterval: 210000,
+		MaxSupply:       21000000 * models.Coin,
+	}
+}
+
+// SubsidyConfigFromEnv returns the defaults overridden by environment variables
+func SubsidyConfigFromEnv() SubsidyConfig {
+	cfg := DefaultSubsidyConfig()
+	cfg.InitialSubsidy = models.AmountFromFloat(envFloat("SUBSIDY_INITIAL", cfg.InitialSubsidy.Float64()))
+	cfg.HalvingInterval = int64(envInt("SUBSIDY_HALVING_INTERVAL", int(cfg.HalvingInterval)))
+	cfg.MaxSupply = models.AmountFromFloat(envFloat("SUBSIDY_MAX_SUPPLY", cfg.MaxSupply.Float64()))
+	return cfg
+}
+
+// envFloat reads a float environment variable, falling back to def
+func envFloat(key string, def float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// scheduledSubsidy returns the halving schedule's subsidy at height,
+// ignoring the supply cap. Genesis pays nothing.
+func (c SubsidyConfig) scheduledSubsidy(height int64) models.Amount {
+	if height <= 0 {
+		return 0
+	}
+	if c.HalvingInterval <= 0 {
+		return c.InitialSubsidy
+	}
+
+	halvings := (height - 1) / c.HalvingInterval
+	if halvings >= maxHalvings {
+		return 0
+	}
+	return c.InitialSubsidy >> halvings
+}
+
+// IssuedBefore returns the coins the schedule has issued in blocks below height
+func (c SubsidyConfig) IssuedBefore(height int64) models.Amount {
+	var issued models.Amount
+	for start := int64(1); start < height; {
+		end := height
+		if c.HalvingInterval > 0 && start+c.HalvingInterval < end {
+			end = start + c.HalvingInterval
+		}
+		issued += c.scheduledSubsidy(start) * models.Amount(end-start)
+		if issued >= c.MaxSupply {
+			return c.MaxSupply
+		}
+		start = end
+	}
+	return issued
+}
+
+// BlockSubsidy returns the new coins a block at height may create
+func (c SubsidyConfig) BlockSubsidy(height int64) models.Amount {
+	subsidy := c.scheduledSubsidy(height)
+	remaining := c.MaxSupply - c.IssuedBefore(height)
+	if remaining <= 0 {
+		return 0
+	}
+	if subsidy > remaining {
+		return remaining
+	}
+	return subsidy
+}
+
+// NextHalving returns the height of the first block of the next era
+func (c SubsidyConfig) NextHalving(height int64) int64 {
+	if c.HalvingInterval <= 0 {
+		return 0
+	}
+	return ((height-1)/c.HalvingInterval+1)*c.HalvingInterval + 1
+}
+
+// IsCoinbase reports whether tx is a coinbase transaction
+func IsCoinbase(tx *models.Transaction) bool {
+	return tx.Type == CoinbaseTransactionType && tx.SenderWalletID == "system" && len(tx.InputUTXOs) == 0
+}
+
+// NewCoinbase builds the transaction paying the subsidy and fees of the
+// block at height to minerWalletID
+func (s *BlockchainService) NewCoinbase(height int64, minerWalletID string, fees models.Amount) *models.Transaction {
+	reward := s.subsidy.BlockSubsidy(height) + fees
+
+	tx := &models.Transaction{
+		ID:               primitive.NewObjectID(),
+		Version:          CurrentTransactionVersion,
+		SenderWalletID:   "system",
+		ReceiverWalletID: minerWalletID,
+		Amount:           reward,
+		Note:             fmt.Sprintf("Block reward for block %d", height),
+		Timestamp:        time.Now(),
+		SenderPublicKey:  "system",
+		Signature:        "system",
+		InputUTXOs:       []models.UTXOInput{},
+		OutputUTXOs:      []models.UTXOOutput{},
+		Type:             CoinbaseTransactionType,
+		Status:           "pending",
+		Fee:              0,
+		ChainID:          s.network.ChainID,
+	}
+	if reward > 0 {
+		tx.OutputUTXOs = append(tx.OutputUTXOs, models.UTXOOutput{WalletID: minerWalletID, Amount: reward, Index: 0})
+	}
+	tx.TxID = ComputeTxID(tx)
+
+	return tx
+}
+
+// GetSubsidyConfig returns the block subsidy configuration
+func (s *BlockchainService) GetSubsidyConfig() SubsidyConfig {
+	return s.subsidy
+}
@@ -2,31 +2,27 @@ package services
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
-	"fmt"
+	"sort"
 	"time"
 
 	"backend/models"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"backend/storage"
 )
 
 type TransactionService struct {
-	db         *mongo.Database
+	store      storage.ChainStore
 	blockchain *BlockchainService
 	crypto     *CryptoService
+	coins      CoinSelectionConfig
 }
 
-func NewTransactionService(db *mongo.Database, blockchain *BlockchainService) *TransactionService {
+func NewTransactionService(store storage.ChainStore, blockchain *BlockchainService, coins CoinSelectionConfig) *TransactionService {
 	return &TransactionService{
-		db:         db,
+		store:      store,
 		blockchain: blockchain,
 		crypto:     NewCryptoService(),
+		coins:      coins,
 	}
 }
 
@@ -37,182 +33,230 @@ func (s *TransactionService) CreateTransaction(ctx context.Context, tx *models.T
 		return err
 	}
 
-	collection := s.db.Collection("transactions")
-	_, err := collection.InsertOne(ctx, tx)
-	return err
+	if !RequiresSignature(tx) {
+		return s.addPending(ctx, tx)
+	}
+
+	// Consume the sender's nonce so the signature cannot be submitted
+	// again, but only once the mempool has accepted the transaction
+	return s.admit(ctx, tx, func(ctx context.Context) error {
+		return s.store.AdvanceWalletNonce(ctx, tx.SenderWalletID, tx.Nonce)
+	})
 }
 
-// ValidateTransaction validates transaction signature and UTXOs
-func (s *TransactionService) ValidateTransaction(ctx context.Context, tx *models.Transaction) error {
-	// Verify sender wallet exists
-	walletCollection := s.db.Collection("wallets")
-	var wallet models.Wallet
-	err := walletCollection.FindOne(ctx, bson.M{"wallet_id": tx.SenderWalletID}).Decode(&wallet)
+// addPending admits a transaction to the mempool and stores it. Pending
+// transactions it replaces are marked rejected.
+func (s *TransactionService) addPending(ctx context.Context, tx *models.Transaction) error {
+	return s.admit(ctx, tx, nil)
+}
+
+// admit adds a transaction to the mempool, then stores it together with
+// the writes of also. Nothing is written if the mempool refuses it, and it
+// leaves the mempool again if the writes fail.
+func (s *TransactionService) admit(ctx context.Context, tx *models.Transaction, also func(ctx context.Context) error) error {
+	mempool := s.blockchain.GetMempool()
+	replaced, err := mempool.Add(tx)
 	if err != nil {
-		return errors.New("invalid sender wallet ID")
+		return err
 	}
 
-	// Verify receiver wallet exists (skip for system transactions)
-	if tx.Type != "mining_reward" {
-		err = walletCollection.FindOne(ctx, bson.M{"wallet_id": tx.ReceiverWalletID}).Decode(&wallet)
-		if err != nil {
-			return errors.New("invalid receiver wallet ID")
+	err = s.store.RunAtomically(ctx, func(ctx context.Context) error {
+		if also != nil {
+			if err := also(ctx); err != nil {
+				return err
+			}
 		}
+		return s.store.InsertTransaction(ctx, tx)
+	})
+	if err != nil {
+		mempool.Remove(tx.TxID)
+		mempool.Restore(replaced)
+		return err
 	}
 
-	// Verify digital signature
-	if tx.Type != "mining_reward" && tx.Type != "zakat_deduction" {
-		pubKey, err := s.crypto.StringToPublicKey(tx.SenderPublicKey)
-		if err != nil {
-			return errors.New("invalid public key")
-		}
+	return s.blockchain.setRejected(ctx, replaced)
+}
 
-		signedPayload := fmt.Sprintf("%s%s%.8f%s%s",
-			tx.SenderWalletID,
-			tx.ReceiverWalletID,
-			tx.Amount,
-			tx.Timestamp.Format(time.RFC3339),
-			tx.Note,
-		)
+// GetSpendableUTXOs returns a wallet's unspent outputs that no pending
+// transaction has reserved
+func (s *TransactionService) GetSpendableUTXOs(ctx context.Context, walletID string) ([]models.UTXO, error) {
+	utxos, err := s.store.GetUnspentUTXOs(ctx, walletID)
+	if err != nil {
+		return nil, err
+	}
 
-		if !s.crypto.VerifySignature(pubKey, signedPayload, tx.Signature) {
-			return errors.New("invalid digital signature")
+	mempool := s.blockchain.GetMempool()
+	spendable := utxos[:0]
+	for _, utxo := range utxos {
+		if !mempool.IsReserved(utxo.TxID, utxo.OutputIndex) {
+			spendable = append(spendable, utxo)
 		}
 	}
+	return spendable, nil
+}
 
-	// Verify UTXOs are not double-spent
-	for _, input := range tx.InputUTXOs {
-		utxoCollection := s.db.Collection("utxos")
-		var utxo models.UTXO
-		err := utxoCollection.FindOne(ctx, bson.M{
-			"tx_id":        input.TxID,
-			"output_index": input.OutputIndex,
-		}).Decode(&utxo)
+// ValidateTransaction validates transaction signature and UTXOs
+func (s *TransactionService) ValidateTransaction(ctx context.Context, tx *models.Transaction) error {
+	// Verify sender wallet exists
+	_, err := s.store.GetWalletByWalletID(ctx, tx.SenderWalletID)
+	if err != nil {
+		return errors.New("invalid sender wallet ID")
+	}
 
+	// Verify receiver wallet exists (skip for system transactions)
+	if tx.Type != "mining_reward" {
+		_, err = s.store.GetWalletByWalletID(ctx, tx.ReceiverWalletID)
 		if err != nil {
-			return errors.New("UTXO not found")
+			return errors.New("invalid receiver wallet ID")
 		}
+	}
 
-		if utxo.IsSpent {
-			return errors.New("double-spend detected: UTXO already spent")
-		}
+	// Verify the signature is fresh and for this chain, then verify it
+	if err := s.checkReplayProtection(tx); err != nil {
+		return err
+	}
+	if err := s.crypto.VerifyTransactionSignature(tx); err != nil {
+		return err
 	}
 
-	// Verify input amount >= output amount
-	var inputSum float64
-	for _, input := range tx.InputUTXOs {
-		inputSum += input.Amount
+	// Verify the inputs are the sender's unspent outputs, using the
+	// stored amounts rather than the claimed ones
+	_, inputSum, err := s.ResolveInputs(ctx, tx)
+	if err != nil {
+		return err
 	}
 
-	var outputSum float64
-	for _, output := range tx.OutputUTXOs {
-		outputSum += output.Amount
+	outputSum, err := checkOutputs(tx)
+	if err != nil {
+		return err
 	}
 
 	if inputSum < outputSum {
 		return errors.New("insufficient balance")
 	}
 
+	// Verify the fee is what the inputs leave over
+	if err := checkFee(tx, inputSum, outputSum); err != nil {
+		return err
+	}
+	// Unsigned zakat deductions must pay exactly what a block may include
+	if IsZakatDeduction(tx) {
+		if err := checkZakat(tx, inputSum); err != nil {
+			return err
+		}
+	}
+	if FeeRate(tx) < s.blockchain.GetBlockLimits().MinFeeRate {
+		return errors.New("fee rate below the mempool minimum")
+	}
+
 	return nil
 }
 
-// GetPendingTransactions returns all pending transactions
-func (s *TransactionService) GetPendingTransactions(ctx context.Context) ([]models.Transaction, error) {
-	collection := s.db.Collection("transactions")
-
-	cursor, err := collection.Find(ctx, bson.M{"status": "pending"})
-	if err != nil {
-		return nil, err
+// checkReplayProtection requires signed transactions to carry this chain's
+// ID, a positive nonce and a timestamp within the allowed clock skew
+func (s *TransactionService) checkReplayProtection(tx *models.Transaction) error {
+	if tx.Version >= ReplayProtectedTransactionVersion && tx.ChainID != s.blockchain.GetNetworkConfig().ChainID {
+		return errors.New("transaction was signed for another chain")
+	}
+	if !RequiresSignature(tx) {
+		return nil
 	}
-	defer cursor.Close(ctx)
 
-	var transactions []models.Transaction
-	if err := cursor.All(ctx, &transactions); err != nil {
-		return nil, err
+	if tx.Version < ReplayProtectedTransactionVersion {
+		return errors.New("transaction version does not support replay protection")
+	}
+	if tx.Nonce <= 0 {
+		return errors.New("nonce must be positive")
 	}
 
-	return transactions, nil
+	skew := time.Since(tx.Timestamp)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > s.blockchain.GetMempool().Config().MaxClockSkew {
+		return errors.New("transaction timestamp is outside the allowed clock skew")
+	}
+	return nil
 }
 
-// ConfirmTransactions marks transactions as confirmed
-func (s *TransactionService) ConfirmTransactions(ctx context.Context, txIDs []string, blockHash string) error {
-	collection := s.db.Collection("transactions")
-
-	_, err := collection.UpdateMany(ctx,
-		bson.M{"tx_id": bson.M{"$in": txIDs}},
-		bson.M{"$set": bson.M{
-			"status":     "confirmed",
-			"block_hash": blockHash,
-		}},
-	)
+// GetChainID returns the chain ID transactions must be signed for
+func (s *TransactionService) GetChainID() string {
+	return s.blockchain.GetNetworkConfig().ChainID
+}
 
-	return err
+// SigningInfo is what a client needs to sign a transfer sent with its
+// fields rather than as a raw transaction
+type SigningInfo struct {
+	WalletID            string `json:"walletId"` // the sender the nonce belongs to
+	ChainID             string `json:"chainId"`
+	Version             int    `json:"version"` // of the field-based signing payload
+	NextNonce           int64  `json:"nextNonce"`
+	ServerTime          int64  `json:"serverTime"` // unix milliseconds
+	MaxClockSkewSeconds int64  `json:"maxClockSkewSeconds"`
 }
 
-// GetTransactionHistory returns transaction history for a wallet
-func (s *TransactionService) GetTransactionHistory(ctx context.Context, walletID string, limit int64) ([]models.Transaction, error) {
-	collection := s.db.Collection("transactions")
-
-	opts := options.Find().
-		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
-		SetLimit(limit)
-
-	cursor, err := collection.Find(ctx, bson.M{
-		"$or": []bson.M{
-			{"sender_wallet_id": walletID},
-			{"receiver_wallet_id": walletID},
-		},
-		"status": "confirmed",
-	}, opts)
+// GetSigningInfo returns the chain ID, the wallet's next nonce and the
+// server's clock
+func (s *TransactionService) GetSigningInfo(ctx context.Context, walletID string) (*SigningInfo, error) {
+	wallet, err := s.store.GetWalletByWalletID(ctx, walletID)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
 
-	var transactions []models.Transaction
-	if err := cursor.All(ctx, &transactions); err != nil {
+	return &SigningInfo{
+		WalletID:            walletID,
+		ChainID:             s.blockchain.GetNetworkConfig().ChainID,
+		Version:             ReplayProtectedTransactionVersion,
+		NextNonce:           wallet.Nonce + 1,
+		ServerTime:          time.Now().UnixMilli(),
+		MaxClockSkewSeconds: int64(s.blockchain.GetMempool().Config().MaxClockSkew / time.Second),
+	}, nil
+}
+
+// GetPendingTransactions returns the mempool, highest fee rate first.
+// Expired transactions are dropped and marked rejected first.
+func (s *TransactionService) GetPendingTransactions(ctx context.Context) ([]models.Transaction, error) {
+	mempool := s.blockchain.GetMempool()
+	if err := s.blockchain.setRejected(ctx, mempool.Expire(time.Now())); err != nil {
 		return nil, err
 	}
 
-	return transactions, nil
+	return mempool.Transactions(), nil
 }
 
-// GenerateTxID generates a unique transaction ID
-func (s *TransactionService) GenerateTxID(senderID, receiverID string, amount float64, timestamp time.Time) string {
-	data := fmt.Sprintf("%s%s%.8f%s%d", senderID, receiverID, amount, timestamp.Format(time.RFC3339), time.Now().UnixNano())
-	hash := sha256.Sum256([]byte(data))
-	return hex.EncodeToString(hash[:])
+// ConfirmTransactions marks transactions as confirmed
+func (s *TransactionService) ConfirmTransactions(ctx context.Context, txIDs []string, blockHash string) error {
+	return s.store.SetTransactionStatus(ctx, txIDs, "confirmed", blockHash)
 }
 
-// CreateSystemTransaction creates a system transaction (mining reward, zakat)
-func (s *TransactionService) CreateSystemTransaction(ctx context.Context, txType string, receiverWalletID string, amount float64, note string) (*models.Transaction, error) {
-	timestamp := time.Now()
-	txID := s.GenerateTxID("system", receiverWalletID, amount, timestamp)
-
-	tx := &models.Transaction{
-		ID:               primitive.NewObjectID(),
-		TxID:             txID,
-		SenderWalletID:   "system",
-		ReceiverWalletID: receiverWalletID,
-		Amount:           amount,
-		Note:             note,
-		Timestamp:        timestamp,
-		SenderPublicKey:  "system",
-		Signature:        "system",
-		InputUTXOs:       []models.UTXOInput{},
-		OutputUTXOs: []models.UTXOOutput{
-			{WalletID: receiverWalletID, Amount: amount, Index: 0},
-		},
-		Type:   txType,
-		Status: "pending",
-		Fee:    0,
-	}
-
-	collection := s.db.Collection("transactions")
-	_, err := collection.InsertOne(ctx, tx)
-	if err != nil {
-		return nil, err
+// GetTransactionHistory returns transaction history for a wallet
+func (s *TransactionService) GetTransactionHistory(ctx context.Context, walletID string, limit int64) ([]models.Transaction, error) {
+	return s.store.GetTransactionHistory(ctx, walletID, limit)
+}
+
+// GetHistoryForWallets returns the newest confirmed transactions sent or
+// received by any of the given wallets, each listed once
+func (s *TransactionService) GetHistoryForWallets(ctx context.Context, walletIDs []string, limit int64) ([]models.Transaction, error) {
+	seen := make(map[string]bool)
+	var history []models.Transaction
+	for _, walletID := range walletIDs {
+		transactions, err := s.store.GetTransactionHistory(ctx, walletID, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range transactions {
+			if !seen[tx.TxID] {
+				seen[tx.TxID] = true
+				history = append(history, tx)
+			}
+		}
 	}
 
-	return tx, nil
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Timestamp.After(history[j].Timestamp)
+	})
+	if int64(len(history)) > limit {
+		history = history[:limit]
+	}
+	return history, nil
 }
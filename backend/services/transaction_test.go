@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"backend/models"
+)
+
+func TestCreateTransactionNonce(t *testing.T) {
+	ctx := context.Background()
+	bc, store := newTestChain(t)
+	s := NewTransactionService(store, bc, DefaultCoinSelectionConfig())
+
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	for _, w := range []testWallet{alice, bob} {
+		if err := store.InsertWallet(ctx, &models.Wallet{WalletID: w.walletID, PublicKey: w.pubKey}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.InsertUTXO(ctx, &models.UTXO{TxID: "funding", OutputIndex: 0, WalletID: alice.walletID, Amount: 10 * models.Coin}); err != nil {
+		t.Fatal(err)
+	}
+	funding := []models.UTXOInput{{TxID: "funding", OutputIndex: 0, Amount: 10 * models.Coin}}
+
+	nonce := func() int64 {
+		wallet, err := store.GetWalletByWalletID(ctx, alice.walletID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return wallet.Nonce
+	}
+
+	first := transfer(t, alice.walletID, alice, bob.walletID, funding, 9*models.Coin)
+	if err := s.CreateTransaction(ctx, &first); err != nil {
+		t.Fatal(err)
+	}
+	if got := nonce(); got != 1 {
+		t.Fatalf("nonce = %d after the first transfer, want 1", got)
+	}
+
+	// A double spend paying less fee is refused by the mempool and must
+	// leave the nonce it was signed with unused
+	refused := transfer(t, alice.walletID, alice, bob.walletID, funding, 9*models.Coin+models.Coin/2)
+	refused.Nonce = 2
+	sign(t, &refused, alice)
+	if err := s.CreateTransaction(ctx, &refused); err != ErrReplacementFee {
+		t.Fatalf("err = %v, want %v", err, ErrReplacementFee)
+	}
+	if got := nonce(); got != 1 {
+		t.Fatalf("nonce = %d after a refused transfer, want 1", got)
+	}
+	if _, err := store.GetTransaction(ctx, refused.TxID); err == nil {
+		t.Fatal("refused transfer was stored")
+	}
+
+	replacement := transfer(t, alice.walletID, alice, bob.walletID, funding, 8*models.Coin)
+	replacement.Nonce = 2
+	sign(t, &replacement, alice)
+	if err := s.CreateTransaction(ctx, &replacement); err != nil {
+		t.Fatalf("nonce 2 after a refused transfer: %v", err)
+	}
+	if got := nonce(); got != 2 {
+		t.Fatalf("nonce = %d after the replacement, want 2", got)
+	}
+}
+
+func TestCreateTransactionZakat(t *testing.T) {
+	ctx := context.Background()
+	bc, store := newTestChain(t)
+	s := NewTransactionService(store, bc, DefaultCoinSelectionConfig())
+
+	alice, mallory := newTestWallet(t), newTestWallet(t)
+	for _, walletID := range []string{alice.walletID, mallory.walletID, ZakatPoolWalletID} {
+		if err := store.InsertWallet(ctx, &models.Wallet{WalletID: walletID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.InsertUTXO(ctx, &models.UTXO{TxID: "funding", OutputIndex: 0, WalletID: alice.walletID, Amount: 10 * models.Coin}); err != nil {
+		t.Fatal(err)
+	}
+	funding := []models.UTXOInput{{TxID: "funding", OutputIndex: 0, Amount: 10 * models.Coin}}
+	zakat := ZakatAmount(10 * models.Coin)
+
+	// Deductions need no signature, so the mempool must refuse any that a
+	// block could not include
+	refused := []models.Transaction{
+		zakatDeduction(alice.walletID, funding, zakat, []models.UTXOOutput{
+			{WalletID: ZakatPoolWalletID, Amount: zakat, Index: 0},
+			{WalletID: mallory.walletID, Amount: 10*models.Coin - zakat, Index: 1},
+		}),
+		zakatDeduction(alice.walletID, funding, 10*models.Coin, []models.UTXOOutput{
+			{WalletID: ZakatPoolWalletID, Amount: 10 * models.Coin, Index: 0},
+		}),
+	}
+	for _, tx := range refused {
+		if err := s.CreateTransaction(ctx, &tx); ruleOf(err) != RuleZakat {
+			t.Fatalf("err = %v, want rule %q", err, RuleZakat)
+		}
+	}
+	if n := bc.GetMempool().Len(); n != 0 {
+		t.Fatalf("mempool holds %d refused deductions", n)
+	}
+
+	exact := zakatDeduction(alice.walletID, funding, zakat, zakatOutputs(alice.walletID, 10*models.Coin))
+	if err := s.CreateTransaction(ctx, &exact); err != nil {
+		t.Fatal(err)
+	}
+}
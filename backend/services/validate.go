@@ -0,0 +1,329 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"backend/models"
+)
+
+// Consensus rules reported by chain validation
+const (
+	RuleGenesis          = "genesis"
+	RuleChainLink        = "chain_link"
+	RuleBlockVersion     = "block_version"
+	RuleBlockHash        = "block_hash"
+	RuleDifficulty       = "difficulty"
+	RuleProofOfWork      = "proof_of_work"
+	RuleTimestamp        = "timestamp"
+	RuleMerkleRoot       = "merkle_root"
+	RuleTxID             = "tx_id"
+	RuleDuplicateTx      = "duplicate_tx"
+	RuleSignature        = "signature"
+	RuleMissingInput     = "missing_input"
+	RuleDoubleSpend      = "double_spend"
+	RuleInputOwner       = "input_owner"
+	RuleInputAmount      = "input_amount"
+	RuleOutputAmount     = "output_amount"
+	RuleConservation     = "conservation"
+	RuleUnfundedIssuance = "unfunded_issuance"
+	RuleCoinbase         = "coinbase"
+	RuleCoinbaseValue    = "coinbase_value"
+	RuleFee              = "fee"
+	RuleBlockSize        = "block_size"
+	RuleChainID          = "chain_id"
+	RuleAllocation       = "allocation"
+	RuleZakat            = "zakat"
+)
+
+// roundingTolerance is how far, in base units, a transaction's inputs,
+// outputs and fee may fail to balance. Legacy float amounts were rounded
+// to base units one at a time; fixed point amounts must balance exactly.
+func roundingTolerance(tx *models.Transaction) models.Amount {
+	return models.Amount(len(tx.LegacyAmounts))
+}
+
+// RuleError is a consensus rule violation
+type RuleError struct {
+	Rule    string
+	TxID    string
+	Message string
+}
+
+func (e *RuleError) Error() string {
+	return e.Message
+}
+
+func ruleError(rule, txID, message string) *RuleError {
+	return &RuleError{Rule: rule, TxID: txID, Message: message}
+}
+
+// ValidationFailure identifies the first rule a chain breaks
+type ValidationFailure struct {
+	BlockIndex int64  `json:"blockIndex"`
+	BlockHash  string `json:"blockHash"`
+	TxID       string `json:"txId,omitempty"`
+	Rule       string `json:"rule"`
+	Message    string `json:"message"`
+}
+
+// ValidationReport is the result of replaying the main chain
+type ValidationReport struct {
+	Valid               bool               `json:"valid"`
+	Height              int64              `json:"height"`
+	TipHash             string             `json:"tipHash"`
+	BlocksChecked       int                `json:"blocksChecked"`
+	TransactionsChecked int                `json:"transactionsChecked"`
+	Failure             *ValidationFailure `json:"failure,omitempty"`
+	CheckedAt           time.Time          `json:"checkedAt"`
+}
+
+// replayOutput is an output in the replayed UTXO set
+type replayOutput struct {
+	walletID string
+	amount   models.Amount
+	spent    bool
+}
+
+// ValidateChain replays the main chain from genesis against a fresh UTXO
+// set, checking every header, merkle root, signature, spend and amount.
+// The returned error is reserved for storage failures; rule violations are
+// reported in the ValidationReport.
+func (s *BlockchainService) ValidateChain(ctx context.Context) (*ValidationReport, error) {
+	s.chainMu.Lock()
+	defer s.chainMu.Unlock()
+
+	blocks, err := s.store.GetAllBlocks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ValidationReport{Valid: true, CheckedAt: time.Now()}
+	if len(blocks) == 0 {
+		return report, nil
+	}
+	report.Height = blocks[0].Index
+	report.TipHash = blocks[0].Hash
+
+	utxos, err := s.genesisAllocations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	allocations := newAllocationLedger()
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := &blocks[i]
+
+		var err error
+		if i == len(blocks)-1 {
+			err = s.validateGenesis(block)
+		} else {
+			err = s.ValidateBlockHeader(ctx, block, &blocks[i+1])
+		}
+		if err == nil {
+			err = s.replayBlock(block, utxos, seen, allocations)
+		}
+
+		if err != nil {
+			var rerr *RuleError
+			if !errors.As(err, &rerr) {
+				return nil, err
+			}
+			report.Valid = false
+			report.Failure = &ValidationFailure{
+				BlockIndex: block.Index,
+				BlockHash:  block.Hash,
+				TxID:       rerr.TxID,
+				Rule:       rerr.Rule,
+				Message:    rerr.Message,
+			}
+			return report, nil
+		}
+
+		report.BlocksChecked++
+		report.TransactionsChecked += len(block.Transactions)
+	}
+
+	return report, nil
+}
+
+// genesisAllocations seeds the replay with the initial balances of wallets
+// created before allocations were transactions, which exist outside of
+// any block
+func (s *BlockchainService) genesisAllocations(ctx context.Context) (map[string]*replayOutput, error) {
+	wallets, err := s.store.GetAllWallets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	utxos := make(map[string]*replayOutput)
+	for _, wallet := range wallets {
+		utxo, err := s.store.GetUTXO(ctx, "genesis_"+wallet.WalletID, 0)
+		if err != nil {
+			continue
+		}
+		utxos[outpointKey(utxo.TxID, utxo.OutputIndex)] = &replayOutput{
+			walletID: utxo.WalletID,
+			amount:   utxo.Amount,
+		}
+	}
+	return utxos, nil
+}
+
+func (s *BlockchainService) validateGenesis(block *models.Block) error {
+	if block.Index != 0 || block.PreviousHash != "0" {
+		return ruleError(RuleGenesis, "", "chain does not start at a genesis block")
+	}
+	if block.Hash != s.CalculateHash(block) {
+		return ruleError(RuleBlockHash, "", "invalid block hash")
+	}
+	return nil
+}
+
+// replayBlock checks a block's transactions against the replayed UTXO set
+// and applies them
+func (s *BlockchainService) replayBlock(block *models.Block, utxos map[string]*replayOutput, seen map[string]bool, allocations *allocationLedger) error {
+	if BlockMerkleRoot(block) != block.MerkleRoot {
+		return ruleError(RuleMerkleRoot, "", "merkle root does not match transactions")
+	}
+
+	if err := s.checkBlockLimits(block); err != nil {
+		return err
+	}
+
+	var fees, tolerance models.Amount
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		if IsCoinbase(tx) && i != 0 {
+			return ruleError(RuleCoinbase, tx.TxID, "coinbase must be the first transaction")
+		}
+		fee, err := s.replayChecked(tx, utxos, seen, allocations)
+		if err != nil {
+			return err
+		}
+		fees += fee
+		tolerance += roundingTolerance(tx)
+	}
+
+	// The coinbase may claim at most the block subsidy plus fees
+	if len(block.Transactions) > 0 && IsCoinbase(&block.Transactions[0]) {
+		coinbase := &block.Transactions[0]
+		var reward models.Amount
+		for _, output := range coinbase.OutputUTXOs {
+			reward += output.Amount
+		}
+		allowed := s.subsidy.BlockSubsidy(block.Index) + fees
+		if reward > allowed+tolerance {
+			return ruleError(RuleCoinbaseValue, coinbase.TxID, fmt.Sprintf("coinbase pays %s, subsidy and fees allow %s", reward, allowed))
+		}
+	}
+
+	return nil
+}
+
+// replayChecked verifies a transaction's ID and signature before replaying
+// it, and returns the fee it pays. Allocations are recorded in allocations.
+func (s *BlockchainService) replayChecked(tx *models.Transaction, utxos map[string]*replayOutput, seen map[string]bool, allocations *allocationLedger) (models.Amount, error) {
+	if tx.Version >= BinaryTransactionVersion && ComputeTxID(tx) != tx.TxID {
+		return 0, ruleError(RuleTxID, tx.TxID, "transaction ID does not match its encoding")
+	}
+	if seen[tx.TxID] {
+		return 0, ruleError(RuleDuplicateTx, tx.TxID, "transaction already included in an earlier block")
+	}
+	if tx.Version >= ReplayProtectedTransactionVersion && tx.ChainID != s.network.ChainID {
+		return 0, ruleError(RuleChainID, tx.TxID, "transaction was signed for another chain")
+	}
+
+	if err := s.crypto.VerifyTransactionSignature(tx); err != nil {
+		return 0, ruleError(RuleSignature, tx.TxID, err.Error())
+	}
+	if IsAllocation(tx) {
+		if err := s.checkAllocation(tx, allocations); err != nil {
+			return 0, err
+		}
+	}
+
+	fee, err := replayTransaction(tx, utxos)
+	if err != nil {
+		return 0, err
+	}
+	seen[tx.TxID] = true
+	if IsAllocation(tx) {
+		allocations.add(tx)
+	}
+	return fee, nil
+}
+
+// replayTransaction checks a transaction's spends and amounts, then spends
+// its inputs and adds its outputs to the replayed UTXO set. The set is
+// left untouched when a rule is broken. It returns the fee paid.
+func replayTransaction(tx *models.Transaction, utxos map[string]*replayOutput) (models.Amount, error) {
+	var inputSum models.Amount
+	spending := make(map[string]bool)
+	for _, input := range tx.InputUTXOs {
+		key := outpointKey(input.TxID, input.OutputIndex)
+		utxo, ok := utxos[key]
+		if !ok {
+			return 0, ruleError(RuleMissingInput, tx.TxID, fmt.Sprintf("input %s does not exist", key))
+		}
+		if utxo.spent || spending[key] {
+			return 0, ruleError(RuleDoubleSpend, tx.TxID, fmt.Sprintf("input %s is already spent", key))
+		}
+		if utxo.walletID != tx.SenderWalletID {
+			return 0, ruleError(RuleInputOwner, tx.TxID, fmt.Sprintf("input %s is not owned by the sender", key))
+		}
+		if utxo.amount != input.Amount {
+			return 0, ruleError(RuleInputAmount, tx.TxID, fmt.Sprintf("input %s claims %s, output holds %s", key, input.Amount, utxo.amount))
+		}
+		spending[key] = true
+		inputSum += utxo.amount
+	}
+
+	outputSum, err := checkOutputs(tx)
+	if err != nil {
+		return 0, err
+	}
+	for _, output := range tx.OutputUTXOs {
+		key := outpointKey(tx.TxID, output.Index)
+		if _, exists := utxos[key]; exists {
+			return 0, ruleError(RuleDuplicateTx, tx.TxID, fmt.Sprintf("output %s already exists", key))
+		}
+	}
+
+	if len(tx.InputUTXOs) == 0 {
+		if !IsCoinbase(tx) && !IsAllocation(tx) && outputSum > 0 {
+			return 0, ruleError(RuleUnfundedIssuance, tx.TxID, "only coinbases and allocations may create coins")
+		}
+	} else if outputSum > inputSum+roundingTolerance(tx) {
+		return 0, ruleError(RuleConservation, tx.TxID, fmt.Sprintf("outputs %s exceed inputs %s", outputSum, inputSum))
+	}
+
+	if err := checkFee(tx, inputSum, outputSum); err != nil {
+		return 0, err
+	}
+	if IsZakatDeduction(tx) {
+		if err := checkZakat(tx, inputSum); err != nil {
+			return 0, err
+		}
+	}
+
+	for key := range spending {
+		utxos[key].spent = true
+	}
+	for _, output := range tx.OutputUTXOs {
+		utxos[outpointKey(tx.TxID, output.Index)] = &replayOutput{walletID: output.WalletID, amount: output.Amount}
+	}
+
+	if outputSum > inputSum {
+		return 0, nil
+	}
+	return inputSum - outputSum, nil
+}
+
+// outpointKey identifies a transaction output
+func outpointKey(txID string, index int) string {
+	return fmt.Sprintf("%s:%d", txID, index)
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"backend/models"
+	"backend/storage"
+)
+
+// zakatDeduction builds an unsigned deduction from walletID spending
+// inputs with the given outputs
+func zakatDeduction(walletID string, inputs []models.UTXOInput, amount models.Amount, outputs []models.UTXOOutput) models.Transaction {
+	tx := models.Transaction{
+		Version:          CurrentTransactionVersion,
+		SenderWalletID:   walletID,
+		ReceiverWalletID: ZakatPoolWalletID,
+		Amount:           amount,
+		Timestamp:        time.UnixMilli(time.Now().UnixMilli()),
+		Signature:        "system_zakat",
+		InputUTXOs:       inputs,
+		OutputUTXOs:      outputs,
+		Type:             ZakatTransactionType,
+		ChainID:          DevnetChainID,
+	}
+	tx.TxID = ComputeTxID(&tx)
+	return tx
+}
+
+// allocation builds an allocation of amount to receiver signed by faucet
+func allocation(t *testing.T, faucet testWallet, receiver string, amount models.Amount) models.Transaction {
+	t.Helper()
+	tx := models.Transaction{
+		Version:          CurrentTransactionVersion,
+		SenderWalletID:   faucet.walletID,
+		ReceiverWalletID: receiver,
+		Amount:           amount,
+		Timestamp:        time.UnixMilli(time.Now().UnixMilli()),
+		SenderPublicKey:  faucet.pubKey,
+		OutputUTXOs:      []models.UTXOOutput{{WalletID: receiver, Amount: amount, Index: 0}},
+		Type:             AllocationTransactionType,
+		ChainID:          DevnetChainID,
+	}
+	sign(t, &tx, faucet)
+	return tx
+}
+
+func TestReplayBlockRules(t *testing.T) {
+	alice, bob, faucet := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	network := DefaultNetworkConfig()
+	network.Allocation = AllocationConfig{Enabled: true, Amount: 100 * models.Coin, MaxTotal: 300 * models.Coin, FaucetPublicKey: faucet.pubKey}
+	bc := NewBlockchainService(storage.NewMemoryStore(), DefaultDifficultyConfig(), DefaultSubsidyConfig(), DefaultBlockLimits(), DefaultMempoolConfig(), network)
+
+	funding := models.UTXOInput{TxID: "funding", OutputIndex: 0, Amount: 10 * models.Coin}
+	zakat := ZakatAmount(funding.Amount)
+	paid := transfer(t, alice.walletID, alice, bob.walletID, []models.UTXOInput{funding}, funding.Amount-1000)
+	coinbase := func(fees models.Amount) models.Transaction { return *bc.NewCoinbase(1, "miner", fees) }
+
+	tests := []struct {
+		name string
+		txs  []models.Transaction
+		rule string
+	}{
+		{
+			name: "coinbase claiming the subsidy and fees",
+			txs:  []models.Transaction{coinbase(1000), paid},
+		},
+		{
+			name: "coinbase claiming more than the subsidy and fees",
+			txs:  []models.Transaction{coinbase(1001), paid},
+			rule: RuleCoinbaseValue,
+		},
+		{
+			name: "coinbase after another transaction",
+			txs:  []models.Transaction{paid, coinbase(0)},
+			rule: RuleCoinbase,
+		},
+		{
+			name: "exact zakat with change",
+			txs:  []models.Transaction{coinbase(0), zakatDeduction(alice.walletID, []models.UTXOInput{funding}, zakat, zakatOutputs(alice.walletID, funding.Amount))},
+		},
+		{
+			name: "zakat below 2.5%",
+			txs: []models.Transaction{coinbase(0), zakatDeduction(alice.walletID, []models.UTXOInput{funding}, zakat-1, []models.UTXOOutput{
+				{WalletID: ZakatPoolWalletID, Amount: zakat - 1, Index: 0},
+				{WalletID: alice.walletID, Amount: funding.Amount - zakat + 1, Index: 1},
+			})},
+			rule: RuleZakat,
+		},
+		{
+			name: "zakat change paid to someone else",
+			txs: []models.Transaction{coinbase(0), zakatDeduction(alice.walletID, []models.UTXOInput{funding}, zakat, []models.UTXOOutput{
+				{WalletID: ZakatPoolWalletID, Amount: zakat, Index: 0},
+				{WalletID: bob.walletID, Amount: funding.Amount - zakat, Index: 1},
+			})},
+			rule: RuleZakat,
+		},
+		{
+			name: "allocation within the cap",
+			txs:  []models.Transaction{coinbase(0), allocation(t, faucet, bob.walletID, 100*models.Coin)},
+		},
+		{
+			name: "second allocation to a wallet",
+			txs:  []models.Transaction{coinbase(0), allocation(t, faucet, alice.walletID, 100*models.Coin)},
+			rule: RuleAllocation,
+		},
+		{
+			name: "allocation over the cap",
+			txs: []models.Transaction{
+				coinbase(0),
+				allocation(t, faucet, bob.walletID, 100*models.Coin),
+				allocation(t, faucet, "carol", 100*models.Coin),
+			},
+			rule: RuleAllocation,
+		},
+		{
+			name: "allocation not signed by the faucet",
+			txs:  []models.Transaction{coinbase(0), allocation(t, bob, bob.walletID, 100*models.Coin)},
+			rule: RuleAllocation,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			utxos := map[string]*replayOutput{
+				outpointKey("funding", 0): {walletID: alice.walletID, amount: funding.Amount},
+			}
+			// Alice and dave already hold allocations, leaving room for one more
+			allocations := newAllocationLedger()
+			allocations.wallets[alice.walletID] = true
+			allocations.wallets["dave"] = true
+			allocations.total = 200 * models.Coin
+
+			block := &models.Block{Version: CurrentBlockVersion, Index: 1, Transactions: tt.txs}
+			block.MerkleRoot = BlockMerkleRoot(block)
+			err := bc.replayBlock(block, utxos, make(map[string]bool), allocations)
+			if got := ruleOf(err); got != tt.rule {
+				t.Fatalf("rule = %q (%v), want %q", got, err, tt.rule)
+			}
+		})
+	}
+}
+
+func TestValidateChainReportsDoubleSpend(t *testing.T) {
+	ctx := context.Background()
+	bc, store := newTestChain(t)
+	genesis, err := bc.GetLatestBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, bob := newTestWallet(t), newTestWallet(t)
+
+	funding := nextBlock(t, bc, genesis, alice.walletID, genesis.Timestamp.Add(time.Minute))
+	if err := bc.AddBlock(ctx, funding); err != nil {
+		t.Fatal(err)
+	}
+	coinbase := funding.Transactions[0]
+	input := []models.UTXOInput{{TxID: coinbase.TxID, OutputIndex: 0, Amount: coinbase.Amount}}
+	spend := nextBlock(t, bc, funding, "miner", genesis.Timestamp.Add(2*time.Minute), transfer(t, alice.walletID, alice, bob.walletID, input, coinbase.Amount))
+	if err := bc.AddBlock(ctx, spend); err != nil {
+		t.Fatal(err)
+	}
+
+	// A block spending the same output again, written past AddBlock's checks
+	again := transfer(t, alice.walletID, alice, alice.walletID, input, coinbase.Amount-1)
+	bad := nextBlock(t, bc, spend, "miner", genesis.Timestamp.Add(3*time.Minute), again)
+	bad.Status = models.BlockStatusMain
+	bad.ChainWork = chainWorkAfter(spend, bad.Difficulty)
+	if err := store.InsertBlock(ctx, bad); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := bc.ValidateChain(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Valid || report.Failure == nil {
+		t.Fatalf("ValidateChain = %+v, want a failure", report)
+	}
+	if f := report.Failure; f.Rule != RuleDoubleSpend || f.BlockHash != bad.Hash || f.TxID != again.TxID {
+		t.Fatalf("failure = %+v, want %s by %s in block %s", f, RuleDoubleSpend, again.TxID, bad.Hash)
+	}
+	if report.BlocksChecked != 3 {
+		t.Fatalf("%d blocks checked before the failure, want 3", report.BlocksChecked)
+	}
+}
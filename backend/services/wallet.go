@@ -2,149 +2,730 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"backend/models"
+	"backend/storage"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-const DefaultWalletAmount = 100.0
-
 type WalletService struct {
-	db     *mongo.Database
-	crypto *CryptoService
+	store      storage.ChainStore
+	blockchain *BlockchainService
+	crypto     *CryptoService
+	vault      *KeyVault
+	hd         HDConfig
+	mu         sync.Mutex // serializes address derivation and writes of users' keys
 }
 
-func NewWalletService(db *mongo.Database) *WalletService {
+func NewWalletService(store storage.ChainStore, blockchain *BlockchainService, vault *KeyVault, hd HDConfig) *WalletService {
 	return &WalletService{
-		db:     db,
-		crypto: NewCryptoService(),
+		store:      store,
+		blockchain: blockchain,
+		crypto:     NewCryptoService(),
+		vault:      vault,
+		hd:         hd,
 	}
 }
 
-// CreateWallet creates a new wallet for a user
-func (s *WalletService) CreateWallet(ctx context.Context, userID primitive.ObjectID) (*models.Wallet, string, error) {
-	// Generate key pair
-	privateKey, publicKey, err := s.crypto.GenerateKeyPair()
+// AddressBalance is the balance held by one of a user's addresses
+type AddressBalance struct {
+	WalletID       string        `json:"walletId"`
+	Address        string        `json:"address"`
+	DerivationPath string        `json:"derivationPath,omitempty"`
+	Balance        models.Amount `json:"balance"`
+}
+
+// AddressScanReport is the result of ScanAddresses
+type AddressScanReport struct {
+	Scanned      int             `json:"scanned"` // addresses derived past the ones already shown
+	Found        []models.Wallet `json:"found"`   // addresses in use, now added to the user's wallet
+	AddressCount int             `json:"addressCount"`
+}
+
+// CreateWallet creates an HD wallet for a new user: the first receive
+// address derived from seed, or from a fresh random seed when it is nil,
+// with keys of the user's key type. The user's wallet, key and seed fields
+// are filled in for the caller to store.
+func (s *WalletService) CreateWallet(ctx context.Context, user *models.User, seed []byte) (*models.Wallet, error) {
+	keyType, err := ParseKeyType(user.KeyType)
 	if err != nil {
-		return nil, "", err
+		return nil, err
+	}
+	if seed == nil {
+		seed = make([]byte, hdSeedLength)
+		if _, err := rand.Read(seed); err != nil {
+			return nil, err
+		}
 	}
 
-	pubKeyStr := s.crypto.PublicKeyToString(publicKey)
-	privKeyStr := s.crypto.PrivateKeyToString(privateKey)
-	walletID := s.crypto.GenerateWalletID(pubKeyStr)
+	encryptedSeed, err := s.vault.Encrypt(ctx, hex.EncodeToString(seed))
+	if err != nil {
+		return nil, err
+	}
 
-	// Encrypt private key
-	encryptedPrivKey, err := s.crypto.EncryptPrivateKey(privKeyStr)
+	account, err := deriveAccount(keyType, seed)
 	if err != nil {
-		return nil, "", err
+		return nil, err
+	}
+	wallet, err := s.deriveWallet(user.ID, account, 0)
+	if err != nil {
+		return nil, err
 	}
 
-	wallet := &models.Wallet{
-		ID:            primitive.NewObjectID(),
-		UserID:        userID,
-		WalletID:      walletID,
-		PublicKey:     pubKeyStr,
-		CachedBalance: DefaultWalletAmount,
-		CreatedAt:     time.Now(),
-		UpdatedAt:     time.Now(),
-	}
-
-	collection := s.db.Collection("wallets")
-	_, err = collection.InsertOne(ctx, wallet)
-	if err != nil {
-		return nil, "", err
-	}
-
-	if DefaultWalletAmount > 0 {
-		initialUTXO := &models.UTXO{
-			ID:          primitive.NewObjectID(),
-			TxID:        "genesis_" + walletID,
-			OutputIndex: 0,
-			WalletID:    walletID,
-			Amount:      DefaultWalletAmount,
-			IsSpent:     false,
-			BlockHash:   "genesis",
-			CreatedAt:   time.Now(),
-		}
-		utxoCollection := s.db.Collection("utxos")
-		_, err = utxoCollection.InsertOne(ctx, initialUTXO)
+	// The first address's key is also kept on its own, as for wallets
+	// created before HD derivation
+	encryptedPrivKey, err := s.encryptFirstKey(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.store.InsertWallet(ctx, wallet); err != nil {
+		return nil, err
+	}
+	wallet.Address = s.blockchain.Address(wallet.WalletID)
+
+	user.WalletID = wallet.WalletID
+	user.PublicKey = wallet.PublicKey
+	user.EncryptedPrivKey = encryptedPrivKey
+	user.EncryptedSeed = encryptedSeed
+	user.AccountPublicKey = account.publicString()
+	user.AddressCount = 1
+
+	return wallet, nil
+}
+
+// NewAddress derives the user's next receive address. Users whose wallet
+// predates HD derivation are given a seed first; their original address
+// stays their primary one. Ed25519 addresses derive from the seed, so a
+// locked Ed25519 wallet fails with ErrSigningLocked.
+func (s *WalletService) NewAddress(ctx context.Context, userID primitive.ObjectID) (*models.Wallet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := s.receiveAccount(ctx, user, true)
+	if err != nil {
+		return nil, err
+	}
+
+	wallet, err := s.deriveWallet(user.ID, account, user.AddressCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.insertWallet(ctx, wallet); err != nil {
+		return nil, err
+	}
+
+	err = s.store.UpdateUser(ctx, user.ID, map[string]interface{}{
+		"address_count": user.AddressCount + 1,
+		"updated_at":    time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	wallet.Address = s.blockchain.Address(wallet.WalletID)
+	return wallet, nil
+}
+
+// ScanAddresses derives the addresses past the ones the user has been
+// shown and adds those that have received funds, together with every
+// address before them, stopping after the gap limit of consecutive unused
+// addresses. It finds funds sent to addresses derived elsewhere from the
+// same seed.
+func (s *WalletService) ScanAddresses(ctx context.Context, userID primitive.ObjectID) (*AddressScanReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := s.receiveAccount(ctx, user, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.scanAddresses(ctx, user, account, user.AddressCount)
+}
+
+// RestoreWallet rebuilds a user's wallet from its recovery phrase: the
+// seed and primary key are stored again, encrypted under the current key,
+// every address is derived again and the chain scanned for the ones in
+// use, and the cached balances are recomputed. The phrase must derive the
+// user's primary wallet. A signing passphrase is cleared with the keys it
+// protected, so restoring also recovers from a forgotten passphrase.
+func (s *WalletService) RestoreWallet(ctx context.Context, userID primitive.ObjectID, mnemonic, passphrase string) (*AddressScanReport, error) {
+	if err := ValidateMnemonic(mnemonic); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	keyType, err := ParseKeyType(user.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	seed := MnemonicToSeed(mnemonic, passphrase)
+	account, err := deriveAccount(keyType, seed)
+	if err != nil {
+		return nil, err
+	}
+	primary, err := s.deriveWallet(user.ID, account, 0)
+	if err != nil {
+		return nil, err
+	}
+	if primary.WalletID != user.WalletID {
+		return nil, ErrMnemonicMismatch
+	}
+
+	encryptedSeed, err := s.vault.Encrypt(ctx, hex.EncodeToString(seed))
+	if err != nil {
+		return nil, err
+	}
+	encryptedPrivKey, err := s.encryptFirstKey(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+	err = s.store.UpdateUser(ctx, user.ID, map[string]interface{}{
+		"encrypted_seed":     encryptedSeed,
+		"encrypted_priv_key": encryptedPrivKey,
+		"account_public_key": account.publicString(),
+		"passphrase_kdf":     "",
+		"mnemonic_backup":    true,
+		"updated_at":         time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := s.scanAddresses(ctx, user, account, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	wallets, err := s.store.GetWalletsByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, wallet := range wallets {
+		if err := s.UpdateCachedBalance(ctx, wallet.WalletID); err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// scanAddresses derives a user's addresses from index start on. Addresses
+// below the user's address count are stored again if missing; past it,
+// used addresses are added until the gap limit of unused ones is reached.
+func (s *WalletService) scanAddresses(ctx context.Context, user *models.User, account *receiveAccount, start int) (*AddressScanReport, error) {
+	report := &AddressScanReport{
+		Found:        []models.Wallet{},
+		AddressCount: user.AddressCount,
+	}
+
+	var unused []*models.Wallet
+	for index := start; len(unused) < s.hd.GapLimit; index++ {
+		wallet, err := s.deriveWallet(user.ID, account, index)
 		if err != nil {
-			return nil, "", err
+			return nil, err
+		}
+
+		if index < user.AddressCount {
+			if err := s.insertWallet(ctx, wallet); err != nil {
+				return nil, err
+			}
+			continue
 		}
+		report.Scanned++
+
+		used, err := s.addressUsed(ctx, wallet.WalletID)
+		if err != nil {
+			return nil, err
+		}
+		if !used {
+			unused = append(unused, wallet)
+			continue
+		}
+
+		// Addresses stay a contiguous range, so the unused ones before a
+		// used address are added too
+		for _, w := range append(unused, wallet) {
+			if err := s.insertWallet(ctx, w); err != nil {
+				return nil, err
+			}
+		}
+		unused = nil
+
+		wallet.Address = s.blockchain.Address(wallet.WalletID)
+		report.Found = append(report.Found, *wallet)
+		report.AddressCount = index + 1
 	}
 
-	return wallet, encryptedPrivKey, nil
+	if report.AddressCount > user.AddressCount {
+		err := s.store.UpdateUser(ctx, user.ID, map[string]interface{}{
+			"address_count": report.AddressCount,
+			"updated_at":    time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
 }
 
-// GetWalletByUserID retrieves wallet by user ID
-func (s *WalletService) GetWalletByUserID(ctx context.Context, userID primitive.ObjectID) (*models.Wallet, error) {
-	collection := s.db.Collection("wallets")
+// GetAddresses returns every address of a user, the primary one first
+func (s *WalletService) GetAddresses(ctx context.Context, userID primitive.ObjectID) ([]models.Wallet, error) {
+	wallets, err := s.store.GetWalletsByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range wallets {
+		wallets[i].Address = s.blockchain.Address(wallets[i].WalletID)
+	}
+	return wallets, nil
+}
 
-	var wallet models.Wallet
-	err := collection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&wallet)
+// GetWalletIDs returns the wallet IDs of every address of a user
+func (s *WalletService) GetWalletIDs(ctx context.Context, userID primitive.ObjectID) ([]string, error) {
+	wallets, err := s.store.GetWalletsByUserID(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
 
-	return &wallet, nil
+	walletIDs := make([]string, len(wallets))
+	for i, wallet := range wallets {
+		walletIDs[i] = wallet.WalletID
+	}
+	return walletIDs, nil
 }
 
-// GetWalletByWalletID retrieves wallet by wallet ID
-func (s *WalletService) GetWalletByWalletID(ctx context.Context, walletID string) (*models.Wallet, error) {
-	collection := s.db.Collection("wallets")
+// GetAddressBalances returns the balance of each of a user's addresses
+// and their total
+func (s *WalletService) GetAddressBalances(ctx context.Context, userID primitive.ObjectID) ([]AddressBalance, models.Amount, error) {
+	wallets, err := s.GetAddresses(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
 
-	var wallet models.Wallet
-	err := collection.FindOne(ctx, bson.M{"wallet_id": walletID}).Decode(&wallet)
+	balances := make([]AddressBalance, 0, len(wallets))
+	var total models.Amount
+	for _, wallet := range wallets {
+		balance, err := s.CalculateBalance(ctx, wallet.WalletID)
+		if err != nil {
+			return nil, 0, err
+		}
+		balances = append(balances, AddressBalance{
+			WalletID:       wallet.WalletID,
+			Address:        wallet.Address,
+			DerivationPath: wallet.DerivationPath,
+			Balance:        balance,
+		})
+		total += balance
+	}
+	return balances, total, nil
+}
+
+// GetUTXOsForUser returns the unspent outputs of every address of a user
+func (s *WalletService) GetUTXOsForUser(ctx context.Context, userID primitive.ObjectID) ([]models.UTXO, error) {
+	walletIDs, err := s.GetWalletIDs(ctx, userID)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, errors.New("invalid wallet ID")
+		return nil, err
+	}
+
+	utxos := []models.UTXO{}
+	for _, walletID := range walletIDs {
+		walletUTXOs, err := s.GetUTXOsForWallet(ctx, walletID)
+		if err != nil {
+			return nil, err
 		}
+		utxos = append(utxos, walletUTXOs...)
+	}
+	return utxos, nil
+}
+
+// KeyRotationReport is the result of RotateKeys
+type KeyRotationReport struct {
+	KeyVersion uint32   `json:"keyVersion"` // master key the keys are now encrypted under
+	Users      int      `json:"users"`
+	Rewrapped  int      `json:"rewrapped"`         // keys and seeds moved to the current master key
+	Current    int      `json:"current"`           // keys and seeds already under it
+	Skipped    []string `json:"skipped,omitempty"` // users whose keys could not be moved, with why
+}
+
+// RotateKeys moves every user's private key and seed to the current master
+// key. Only the data keys of envelopes are rewrapped, so rotation runs
+// while the server does; each user's keys are rewritten under the lock
+// that guards their other writes. Running it again continues where an
+// interrupted run stopped, and once nothing is skipped older master keys
+// can be retired.
+func (s *WalletService) RotateKeys(ctx context.Context) (*KeyRotationReport, error) {
+	version, err := s.vault.CurrentVersion(ctx)
+	if err != nil {
 		return nil, err
 	}
+	users, err := s.store.GetAllUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &KeyRotationReport{KeyVersion: version}
+	for _, user := range users {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		report.Users++
 
-	return &wallet, nil
+		rewrapped, current, err := s.rotateUserKeys(ctx, user.ID)
+		if err != nil {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("%s: %v", user.ID.Hex(), err))
+			continue
+		}
+		report.Rewrapped += rewrapped
+		report.Current += current
+	}
+	return report, nil
 }
 
-// ValidateWalletExists checks if wallet ID exists
-func (s *WalletService) ValidateWalletExists(ctx context.Context, walletID string) bool {
-	_, err := s.GetWalletByWalletID(ctx, walletID)
-	return err == nil
+// rotateUserKeys rewraps one user's stored key and seed, returning how
+// many were moved and how many were already current
+func (s *WalletService) rotateUserKeys(ctx context.Context, userID primitive.ObjectID) (int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	rewrapped, current := 0, 0
+	fields := make(map[string]interface{})
+	for _, field := range []struct {
+		name  string
+		value string
+	}{
+		{"encrypted_priv_key", user.EncryptedPrivKey},
+		{"encrypted_seed", user.EncryptedSeed},
+	} {
+		if field.value == "" {
+			continue
+		}
+		// Keys locked this way have the server's encryption inside the passphrase seal
+		if user.PassphraseKDF != "" && !IsEnvelope(field.value) {
+			return 0, 0, errors.New("keys were locked by a signing passphrase before envelope encryption; setting the passphrase again moves them")
+		}
+
+		rotated, changed, err := s.vault.Rewrap(ctx, field.value)
+		if err != nil {
+			return 0, 0, err
+		}
+		if !changed {
+			current++
+			continue
+		}
+		fields[field.name] = rotated
+		rewrapped++
+	}
+	if len(fields) == 0 {
+		return 0, current, nil
+	}
+
+	fields["updated_at"] = time.Now()
+	if err := s.store.UpdateUser(ctx, user.ID, fields); err != nil {
+		return 0, 0, err
+	}
+	return rewrapped, current, nil
 }
 
-// GetUTXOsForWallet retrieves all unspent UTXOs for a wallet
-func (s *WalletService) GetUTXOsForWallet(ctx context.Context, walletID string) ([]models.UTXO, error) {
-	collection := s.db.Collection("utxos")
+// OwnsWallet reports whether a wallet ID is one of a user's addresses
+func (s *WalletService) OwnsWallet(ctx context.Context, userID primitive.ObjectID, walletID string) bool {
+	wallet, err := s.store.GetWalletByWalletID(ctx, walletID)
+	return err == nil && wallet.UserID == userID
+}
 
-	cursor, err := collection.Find(ctx, bson.M{
-		"wallet_id": walletID,
-		"is_spent":  false,
+// receiveAccount returns the account a user's receive addresses derive
+// from. The extended public key of ECDSA accounts is computed and stored
+// from the seed the first time, so that their addresses can be derived
+// while the seed is locked. With create set, users without a seed are
+// given one.
+func (s *WalletService) receiveAccount(ctx context.Context, user *models.User, create bool) (*receiveAccount, error) {
+	if user.AccountPublicKey != "" {
+		public, err := ParseExtendedPublicKey(user.AccountPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		return &receiveAccount{keyType: public.keyType, public: public}, nil
+	}
+
+	keyType, err := ParseKeyType(user.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := s.userSeed(ctx, user, create)
+	if err != nil {
+		return nil, err
+	}
+	account, err := deriveAccount(keyType, seed)
+	if err != nil {
+		return nil, err
+	}
+	if account.public == nil {
+		return account, nil
+	}
+
+	err = s.store.UpdateUser(ctx, user.ID, map[string]interface{}{
+		"account_public_key": account.publicString(),
+		"updated_at":         time.Now(),
 	})
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
+	user.AccountPublicKey = account.publicString()
+	return account, nil
+}
+
+// userSeed decrypts a user's master seed. With create set, users without
+// one are given a new seed.
+func (s *WalletService) userSeed(ctx context.Context, user *models.User, create bool) ([]byte, error) {
+	if user.PassphraseKDF != "" {
+		return nil, ErrSigningLocked
+	}
+	if user.EncryptedSeed == "" {
+		if !create {
+			return nil, ErrNoSeed
+		}
 
-	var utxos []models.UTXO
-	if err := cursor.All(ctx, &utxos); err != nil {
+		seed := make([]byte, hdSeedLength)
+		if _, err := rand.Read(seed); err != nil {
+			return nil, err
+		}
+		encryptedSeed, err := s.vault.Encrypt(ctx, hex.EncodeToString(seed))
+		if err != nil {
+			return nil, err
+		}
+		err = s.store.UpdateUser(ctx, user.ID, map[string]interface{}{
+			"encrypted_seed": encryptedSeed,
+			"updated_at":     time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		user.EncryptedSeed = encryptedSeed
+		return seed, nil
+	}
+
+	seedHex, err := s.vault.Decrypt(ctx, user.EncryptedSeed)
+	if err != nil {
 		return nil, err
 	}
+	return hex.DecodeString(seedHex)
+}
 
-	return utxos, nil
+// receiveAccount is the branch a user's receive addresses derive from.
+// ECDSA accounts derive them from the extended public key alone; Ed25519
+// ones only have hardened children, which need the private key.
+type receiveAccount struct {
+	keyType KeyType
+	public  *ExtendedPublicKey
+	private *ExtendedKey
+}
+
+// deriveAccount derives the account of a seed for a key type
+func deriveAccount(keyType KeyType, seed []byte) (*receiveAccount, error) {
+	master, err := NewMasterKey(keyType, seed)
+	if err != nil {
+		return nil, err
+	}
+	private, err := master.Derive(AccountPath(keyType))
+	if err != nil {
+		return nil, err
+	}
+
+	account := &receiveAccount{keyType: keyType, private: private}
+	if keyType != KeyTypeEd25519 {
+		if account.public, err = private.Public(); err != nil {
+			return nil, err
+		}
+	}
+	return account, nil
+}
+
+// PublicString returns the extended public key stored for the account, or
+// "" for accounts without one
+func (a *receiveAccount) publicString() string {
+	if a.public == nil {
+		return ""
+	}
+	return a.public.String()
+}
+
+// key returns the public key of the index-th receive address
+func (a *receiveAccount) key(index int) (Verifier, error) {
+	if a.public != nil {
+		child, err := a.public.Child(receiveIndex(a.keyType, index))
+		if err != nil {
+			return nil, err
+		}
+		return child.Verifier()
+	}
+
+	signer, err := a.signer(index)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Public(), nil
+}
+
+// signer returns the private key of the index-th receive address
+func (a *receiveAccount) signer(index int) (Signer, error) {
+	if a.private == nil {
+		return nil, ErrSigningLocked
+	}
+	child, err := a.private.Child(receiveIndex(a.keyType, index))
+	if err != nil {
+		return nil, err
+	}
+	return child.Signer()
+}
+
+// encryptFirstKey encrypts the private key of an account's first address
+// for storage
+func (s *WalletService) encryptFirstKey(ctx context.Context, account *receiveAccount) (string, error) {
+	first, err := account.signer(0)
+	if err != nil {
+		return "", err
+	}
+	return s.vault.Encrypt(ctx, hex.EncodeToString(first.Bytes()))
+}
+
+// deriveWallet derives the index-th receive address of an account
+func (s *WalletService) deriveWallet(userID primitive.ObjectID, account *receiveAccount, index int) (*models.Wallet, error) {
+	key, err := account.key(index)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKeyStr := PublicKeyHex(key)
+	wallet := &models.Wallet{
+		ID:             primitive.NewObjectID(),
+		UserID:         userID,
+		WalletID:       s.crypto.GenerateWalletID(pubKeyStr),
+		PublicKey:      pubKeyStr,
+		DerivationPath: ReceivePath(account.keyType, index),
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+	return wallet, nil
+}
+
+// insertWallet stores a derived wallet unless it is already stored
+func (s *WalletService) insertWallet(ctx context.Context, wallet *models.Wallet) error {
+	if _, err := s.store.GetWalletByWalletID(ctx, wallet.WalletID); err == nil {
+		return nil
+	}
+	return s.store.InsertWallet(ctx, wallet)
+}
+
+// addressUsed reports whether a wallet ID has confirmed transactions or
+// unspent outputs
+func (s *WalletService) addressUsed(ctx context.Context, walletID string) (bool, error) {
+	history, err := s.store.GetTransactionHistory(ctx, walletID, 1)
+	if err != nil {
+		return false, err
+	}
+	if len(history) > 0 {
+		return true, nil
+	}
+
+	utxos, err := s.store.GetUnspentUTXOs(ctx, walletID)
+	if err != nil {
+		return false, err
+	}
+	return len(utxos) > 0, nil
+}
+
+// GetWalletByUserID retrieves a user's primary wallet
+func (s *WalletService) GetWalletByUserID(ctx context.Context, userID primitive.ObjectID) (*models.Wallet, error) {
+	wallet, err := s.store.GetWalletByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	wallet.Address = s.blockchain.Address(wallet.WalletID)
+	return wallet, nil
+}
+
+// GetWalletByWalletID retrieves wallet by wallet ID
+func (s *WalletService) GetWalletByWalletID(ctx context.Context, walletID string) (*models.Wallet, error) {
+	wallet, err := s.store.GetWalletByWalletID(ctx, walletID)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return nil, errors.New("invalid wallet ID")
+		}
+		return nil, err
+	}
+
+	wallet.Address = s.blockchain.Address(wallet.WalletID)
+	return wallet, nil
+}
+
+// ResolveAddress returns the wallet ID of an address that a user entered
+func (s *WalletService) ResolveAddress(address string) (string, error) {
+	return s.blockchain.ParseAddress(address)
+}
+
+// ResolveLegacyAddress is ResolveAddress that also accepts a bare wallet
+// ID, for lookups of wallets by the IDs issued before addresses
+func (s *WalletService) ResolveLegacyAddress(address string) (string, error) {
+	return s.blockchain.ParseLegacyAddress(address)
+}
+
+// Address returns the address of a wallet ID
+func (s *WalletService) Address(walletID string) string {
+	return s.blockchain.Address(walletID)
+}
+
+// ValidateWalletExists checks if wallet ID exists
+func (s *WalletService) ValidateWalletExists(ctx context.Context, walletID string) bool {
+	_, err := s.GetWalletByWalletID(ctx, walletID)
+	return err == nil
+}
+
+// GetUTXOsForWallet retrieves all unspent UTXOs for a wallet
+func (s *WalletService) GetUTXOsForWallet(ctx context.Context, walletID string) ([]models.UTXO, error) {
+	return s.store.GetUnspentUTXOs(ctx, walletID)
 }
 
 // CalculateBalance calculates balance from UTXOs
-func (s *WalletService) CalculateBalance(ctx context.Context, walletID string) (float64, error) {
+func (s *WalletService) CalculateBalance(ctx context.Context, walletID string) (models.Amount, error) {
 	utxos, err := s.GetUTXOsForWallet(ctx, walletID)
 	if err != nil {
 		return 0, err
 	}
 
-	var balance float64
+	var balance models.Amount
 	for _, utxo := range utxos {
 		balance += utxo.Amount
 	}
@@ -159,36 +740,17 @@ func (s *WalletService) UpdateCachedBalance(ctx context.Context, walletID string
 		return err
 	}
 
-	collection := s.db.Collection("wallets")
-	_, err = collection.UpdateOne(ctx,
-		bson.M{"wallet_id": walletID},
-		bson.M{"$set": bson.M{
-			"cached_balance": balance,
-			"updated_at":     time.Now(),
-		}},
-	)
-
-	return err
+	return s.store.SetWalletBalance(ctx, walletID, balance)
 }
 
 // CreateUTXO creates a new UTXO
 func (s *WalletService) CreateUTXO(ctx context.Context, utxo *models.UTXO) error {
-	collection := s.db.Collection("utxos")
-	_, err := collection.InsertOne(ctx, utxo)
-	return err
+	return s.store.InsertUTXO(ctx, utxo)
 }
 
 // MarkUTXOAsSpent marks a UTXO as spent
 func (s *WalletService) MarkUTXOAsSpent(ctx context.Context, txID string, outputIndex int, spentInTx string) error {
-	collection := s.db.Collection("utxos")
-	_, err := collection.UpdateOne(ctx,
-		bson.M{"tx_id": txID, "output_index": outputIndex},
-		bson.M{"$set": bson.M{
-			"is_spent":    true,
-			"spent_in_tx": spentInTx,
-		}},
-	)
-	return err
+	return s.store.MarkUTXOSpent(ctx, txID, outputIndex, spentInTx)
 }
 
 // GetCryptoService returns the crypto service
@@ -198,18 +760,5 @@ func (s *WalletService) GetCryptoService() *CryptoService {
 
 // GetAllWallets returns all wallets (for zakat processing)
 func (s *WalletService) GetAllWallets(ctx context.Context) ([]models.Wallet, error) {
-	collection := s.db.Collection("wallets")
-
-	cursor, err := collection.Find(ctx, bson.M{})
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(ctx)
-
-	var wallets []models.Wallet
-	if err := cursor.All(ctx, &wallets); err != nil {
-		return nil, err
-	}
-
-	return wallets, nil
+	return s.store.GetAllWallets(ctx)
 }
@@ -2,29 +2,77 @@ package services
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"backend/models"
+	"backend/storage"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 const (
-	ZakatRate         = 0.025 // 2.5%
-	ZakatPoolWalletID = "zakat_pool_wallet_00000000000000000000"
+	ZakatRatePerMille    = 25 // 2.5%, rounded down to a base unit
+	ZakatPoolWalletID    = "zakat_pool_wallet_00000000000000000000"
+	ZakatTransactionType = "zakat_deduction"
 )
 
+// IsZakatDeduction reports whether tx is a zakat deduction. Deductions
+// are issued by the server and not signed by their sender, so consensus
+// only accepts ones that pay the exact zakat on their inputs to the pool.
+func IsZakatDeduction(tx *models.Transaction) bool {
+	return tx.Type == ZakatTransactionType
+}
+
+// ZakatAmount returns the zakat due on amount
+func ZakatAmount(amount models.Amount) models.Amount {
+	return amount.MulFrac(ZakatRatePerMille, 1000)
+}
+
+// checkZakat requires a zakat deduction to pay the zakat on its inputs to
+// the zakat pool as output 0, return the rest to its sender as output 1,
+// and pay no fee
+func checkZakat(tx *models.Transaction, inputSum models.Amount) error {
+	zakat := ZakatAmount(inputSum)
+	if len(tx.InputUTXOs) == 0 || zakat <= 0 {
+		return ruleError(RuleZakat, tx.TxID, "zakat deduction spends no inputs zakat is due on")
+	}
+	if tx.ReceiverWalletID != ZakatPoolWalletID || tx.Amount != zakat || tx.Fee != 0 {
+		return ruleError(RuleZakat, tx.TxID, fmt.Sprintf("zakat deduction must pay exactly %s to the zakat pool without a fee", zakat))
+	}
+
+	expected := zakatOutputs(tx.SenderWalletID, inputSum)
+	if len(tx.OutputUTXOs) != len(expected) {
+		return ruleError(RuleZakat, tx.TxID, "zakat deduction may only pay the pool and return change")
+	}
+	for i, output := range tx.OutputUTXOs {
+		if output != expected[i] {
+			return ruleError(RuleZakat, tx.TxID, "zakat deduction may only pay the pool and return change")
+		}
+	}
+	return nil
+}
+
+// zakatOutputs returns the outputs of a deduction from walletID spending
+// inputSum: the zakat to the pool, then the rest back to the wallet
+func zakatOutputs(walletID string, inputSum models.Amount) []models.UTXOOutput {
+	zakat := ZakatAmount(inputSum)
+	outputs := []models.UTXOOutput{{WalletID: ZakatPoolWalletID, Amount: zakat, Index: 0}}
+	if change := inputSum - zakat; change > 0 {
+		outputs = append(outputs, models.UTXOOutput{WalletID: walletID, Amount: change, Index: 1})
+	}
+	return outputs
+}
+
 type ZakatService struct {
-	db          *mongo.Database
+	store       storage.ChainStore
 	transaction *TransactionService
 	blockchain  *BlockchainService
 }
 
-func NewZakatService(db *mongo.Database, transaction *TransactionService, blockchain *BlockchainService) *ZakatService {
+func NewZakatService(store storage.ChainStore, transaction *TransactionService, blockchain *BlockchainService) *ZakatService {
 	return &ZakatService{
-		db:          db,
+		store:       store,
 		transaction: transaction,
 		blockchain:  blockchain,
 	}
@@ -32,83 +80,44 @@ func NewZakatService(db *mongo.Database, transaction *TransactionService, blockc
 
 // ProcessMonthlyZakat processes zakat deduction for all wallets
 func (s *ZakatService) ProcessMonthlyZakat(ctx context.Context) error {
-	walletCollection := s.db.Collection("wallets")
-	userCollection := s.db.Collection("users")
-
-	// Get all wallets with balance > 0
-	cursor, err := walletCollection.Find(ctx, bson.M{"cached_balance": bson.M{"$gt": 0}})
+	wallets, err := s.store.GetAllWallets(ctx)
 	if err != nil {
 		return err
 	}
-	defer cursor.Close(ctx)
-
-	var wallets []models.Wallet
-	if err := cursor.All(ctx, &wallets); err != nil {
-		return err
-	}
 
 	for _, wallet := range wallets {
-		// Calculate zakat amount (2.5%)
-		zakatAmount := wallet.CachedBalance * ZakatRate
-
-		if zakatAmount <= 0 {
+		// Skip wallets without a positive balance
+		if wallet.CachedBalance <= 0 {
 			continue
 		}
 
-		// Get wallet UTXOs
-		utxoCollection := s.db.Collection("utxos")
-		utxoCursor, err := utxoCollection.Find(ctx, bson.M{
-			"wallet_id": wallet.WalletID,
-			"is_spent":  false,
-		})
+		// Zakat is due on the spendable balance, which the deduction spends
+		// in full; coins reserved by pending transactions are deducted next
+		// time
+		utxos, err := s.transaction.GetSpendableUTXOs(ctx, wallet.WalletID)
 		if err != nil {
 			continue
 		}
-
-		var utxos []models.UTXO
-		if err := utxoCursor.All(ctx, &utxos); err != nil {
-			utxoCursor.Close(ctx)
-			continue
-		}
-		utxoCursor.Close(ctx)
-
-		// Create input UTXOs
-		var inputUTXOs []models.UTXOInput
-		var totalInput float64
+		var balance models.Amount
+		inputs := make([]models.UTXOInput, 0, len(utxos))
 		for _, utxo := range utxos {
-			inputUTXOs = append(inputUTXOs, models.UTXOInput{
-				TxID:        utxo.TxID,
-				OutputIndex: utxo.OutputIndex,
-				Amount:      utxo.Amount,
-			})
-			totalInput += utxo.Amount
-			if totalInput >= zakatAmount {
-				break
-			}
+			balance += utxo.Amount
+			inputs = append(inputs, models.UTXOInput{TxID: utxo.TxID, OutputIndex: utxo.OutputIndex, Amount: utxo.Amount})
 		}
 
-		// Calculate change
-		change := totalInput - zakatAmount
+		// Calculate zakat amount (2.5%)
+		zakatAmount := ZakatAmount(balance)
 
-		// Create output UTXOs
-		outputUTXOs := []models.UTXOOutput{
-			{WalletID: ZakatPoolWalletID, Amount: zakatAmount, Index: 0},
-		}
-		if change > 0 {
-			outputUTXOs = append(outputUTXOs, models.UTXOOutput{
-				WalletID: wallet.WalletID,
-				Amount:   change,
-				Index:    1,
-			})
+		if zakatAmount <= 0 {
+			continue
 		}
 
 		// Create zakat transaction
 		timestamp := time.Now()
-		txID := s.transaction.GenerateTxID(wallet.WalletID, ZakatPoolWalletID, zakatAmount, timestamp)
 
 		tx := &models.Transaction{
 			ID:               primitive.NewObjectID(),
-			TxID:             txID,
+			Version:          CurrentTransactionVersion,
 			SenderWalletID:   wallet.WalletID,
 			ReceiverWalletID: ZakatPoolWalletID,
 			Amount:           zakatAmount,
@@ -116,15 +125,17 @@ func (s *ZakatService) ProcessMonthlyZakat(ctx context.Context) error {
 			Timestamp:        timestamp,
 			SenderPublicKey:  wallet.PublicKey,
 			Signature:        "system_zakat",
-			InputUTXOs:       inputUTXOs,
-			OutputUTXOs:      outputUTXOs,
-			Type:             "zakat_deduction",
+			InputUTXOs:       inputs,
+			OutputUTXOs:      zakatOutputs(wallet.WalletID, balance),
+			Type:             ZakatTransactionType,
 			Status:           "pending",
 			Fee:              0,
+			ChainID:          s.blockchain.GetNetworkConfig().ChainID,
 		}
+		txID := ComputeTxID(tx)
+		tx.TxID = txID
 
-		txCollection := s.db.Collection("transactions")
-		if _, err := txCollection.InsertOne(ctx, tx); err != nil {
+		if err := s.transaction.addPending(ctx, tx); err != nil {
 			continue
 		}
 
@@ -135,14 +146,12 @@ func (s *ZakatService) ProcessMonthlyZakat(ctx context.Context) error {
 			TxID:   txID,
 		}
 
-		_, err = userCollection.UpdateOne(ctx,
-			bson.M{"wallet_id": wallet.WalletID},
-			bson.M{
-				"$push": bson.M{"zakat_tracking": zakatRecord},
-				"$set":  bson.M{"updated_at": time.Now()},
-			},
-		)
-		if err != nil {
+		// Records are kept on the user, found by their primary wallet
+		recordWalletID := wallet.WalletID
+		if user, err := s.store.GetUserByID(ctx, wallet.UserID); err == nil {
+			recordWalletID = user.WalletID
+		}
+		if err := s.store.AddZakatRecord(ctx, recordWalletID, zakatRecord); err != nil {
 			continue
 		}
 
@@ -155,10 +164,7 @@ func (s *ZakatService) ProcessMonthlyZakat(ctx context.Context) error {
 
 // GetZakatHistory returns zakat history for a user
 func (s *ZakatService) GetZakatHistory(ctx context.Context, walletID string) ([]models.ZakatRecord, error) {
-	userCollection := s.db.Collection("users")
-
-	var user models.User
-	err := userCollection.FindOne(ctx, bson.M{"wallet_id": walletID}).Decode(&user)
+	user, err := s.store.GetUserByWalletID(ctx, walletID)
 	if err != nil {
 		return nil, err
 	}
@@ -167,9 +173,7 @@ func (s *ZakatService) GetZakatHistory(ctx context.Context, walletID string) ([]
 }
 
 // logZakatDeduction logs the zakat deduction event
-func (s *ZakatService) logZakatDeduction(ctx context.Context, walletID string, amount float64, txID string) {
-	logCollection := s.db.Collection("system_logs")
-
+func (s *ZakatService) logZakatDeduction(ctx context.Context, walletID string, amount models.Amount, txID string) {
 	log := models.SystemLog{
 		ID:        primitive.NewObjectID(),
 		Action:    "zakat_deduction",
@@ -179,9 +183,8 @@ func (s *ZakatService) logZakatDeduction(ctx context.Context, walletID string, a
 		Timestamp: time.Now(),
 	}
 
-	logCollection.InsertOne(ctx, log)
+	s.store.InsertSystemLog(ctx, &log)
 
-	txLogCollection := s.db.Collection("transaction_logs")
 	txLog := models.TransactionLog{
 		ID:        primitive.NewObjectID(),
 		TxID:      txID,
@@ -193,5 +196,5 @@ func (s *ZakatService) logZakatDeduction(ctx context.Context, walletID string, a
 		Timestamp: time.Now(),
 	}
 
-	txLogCollection.InsertOne(ctx, txLog)
+	s.store.InsertTransactionLog(ctx, &txLog)
 }
@@ -0,0 +1,580 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Journal operations recorded by FileStore
+const (
+	opInsertBlock          = "insert_block"
+	opUpdateBlock          = "update_block"
+	opDeleteBlock          = "delete_block"
+	opInsertTransaction    = "insert_transaction"
+	opSetTransactionStatus = "set_transaction_status"
+	opInsertUTXO           = "insert_utxo"
+	opMarkUTXOSpent        = "mark_utxo_spent"
+	opMarkUTXOUnspent      = "mark_utxo_unspent"
+	opDeleteUTXOsByTx      = "delete_utxos_by_tx"
+	opInsertWallet         = "insert_wallet"
+	opIncrementBalance     = "increment_wallet_balance"
+	opSetBalance           = "set_wallet_balance"
+	opAdvanceNonce         = "advance_wallet_nonce"
+	opInsertUser           = "insert_user"
+	opUpdateUser           = "update_user"
+	opAddBeneficiary       = "add_beneficiary"
+	opRemoveBeneficiary    = "remove_beneficiary"
+	opAddZakatRecord       = "add_zakat_record"
+	opInsertSystemLog      = "insert_system_log"
+	opInsertTransactionLog = "insert_transaction_log"
+	opBatch                = "batch" // records committed together by RunAtomically
+)
+
+// journalRecord is one entry of the append-only journal file
+type journalRecord struct {
+	Op   string      `bson:"op"`
+	Data interface{} `bson:"data"`
+}
+
+type storedRecord struct {
+	Op   string   `bson:"op"`
+	Data bson.Raw `bson:"data"`
+}
+
+// journalBatch collects the records written inside RunAtomically
+type journalBatch struct {
+	records []bson.Raw
+}
+
+type blockUpdateArgs struct {
+	Hash   string                 `bson:"hash"`
+	Fields map[string]interface{} `bson:"fields"`
+}
+
+type txStatusArgs struct {
+	TxIDs     []string `bson:"tx_ids"`
+	Status    string   `bson:"status"`
+	BlockHash string   `bson:"block_hash"`
+}
+
+type utxoSpentArgs struct {
+	TxID        string `bson:"tx_id"`
+	OutputIndex int    `bson:"output_index"`
+	SpentInTx   string `bson:"spent_in_tx"`
+}
+
+type walletBalanceArgs struct {
+	WalletID string        `bson:"wallet_id"`
+	Amount   models.Amount `bson:"amount"`
+}
+
+type walletNonceArgs struct {
+	WalletID string `bson:"wallet_id"`
+	Nonce    int64  `bson:"nonce"`
+}
+
+type userUpdateArgs struct {
+	UserID primitive.ObjectID     `bson:"user_id"`
+	Fields map[string]interface{} `bson:"fields"`
+}
+
+type beneficiaryArgs struct {
+	UserID        primitive.ObjectID `bson:"user_id"`
+	Beneficiary   models.Beneficiary `bson:"beneficiary"`
+	BeneficiaryID primitive.ObjectID `bson:"beneficiary_id"`
+}
+
+type zakatRecordArgs struct {
+	WalletID string             `bson:"wallet_id"`
+	Record   models.ZakatRecord `bson:"record"`
+}
+
+// FileStore is an embedded ChainStore that keeps its state in memory and
+// persists every mutation to an append-only journal of BSON records.
+// The journal is replayed when the store is opened.
+type FileStore struct {
+	*MemoryStore
+	mu    sync.Mutex
+	file  *os.File
+	batch sync.Mutex // held by RunAtomically; other writers wait for it
+}
+
+// OpenFileStore opens or creates the journal at path and replays it
+func OpenFileStore(ctx context.Context, path string) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{
+		MemoryStore: NewMemoryStore(),
+		file:        file,
+	}
+
+	if err := s.replay(ctx); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Close closes the journal file
+func (s *FileStore) Close(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// replay reads every journal record and applies it to the in-memory state.
+// A truncated trailing record, left by a crash mid-write, is discarded.
+func (s *FileStore) replay(ctx context.Context) error {
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(s.file)
+	var offset int64
+	for {
+		raw, err := readDocument(reader)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			if err := s.file.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var record storedRecord
+		if err := bson.Unmarshal(raw, &record); err != nil {
+			return fmt.Errorf("corrupt journal record at offset %d: %w", offset, err)
+		}
+		if err := s.apply(ctx, record); err != nil {
+			return fmt.Errorf("replay %s at offset %d: %w", record.Op, offset, err)
+		}
+		offset += int64(len(raw))
+	}
+
+	_, err := s.file.Seek(0, io.SeekEnd)
+	return err
+}
+
+// readDocument reads one length-prefixed BSON document
+func readDocument(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	size := int(binary.LittleEndian.Uint32(header[:]))
+	if size < len(header) {
+		return nil, errors.New("invalid journal record length")
+	}
+
+	doc := make([]byte, size)
+	copy(doc, header[:])
+	if _, err := io.ReadFull(r, doc[len(header):]); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return doc, nil
+}
+
+// apply replays a single journal record against the in-memory state
+func (s *FileStore) apply(ctx context.Context, record storedRecord) error {
+	m := s.MemoryStore
+	switch record.Op {
+	case opBatch:
+		var records []storedRecord
+		if err := bson.UnmarshalValue(bson.TypeArray, record.Data, &records); err != nil {
+			return err
+		}
+		for _, r := range records {
+			if err := s.apply(ctx, r); err != nil {
+				return err
+			}
+		}
+		return nil
+	case opInsertBlock:
+		var block models.Block
+		if err := bson.Unmarshal(record.Data, &block); err != nil {
+			return err
+		}
+		return m.InsertBlock(ctx, &block)
+	case opUpdateBlock:
+		var args blockUpdateArgs
+		if err := bson.Unmarshal(record.Data, &args); err != nil {
+			return err
+		}
+		return m.UpdateBlock(ctx, args.Hash, args.Fields)
+	case opDeleteBlock:
+		var args blockUpdateArgs
+		if err := bson.Unmarshal(record.Data, &args); err != nil {
+			return err
+		}
+		return m.DeleteBlock(ctx, args.Hash)
+	case opInsertTransaction:
+		var tx models.Transaction
+		if err := bson.Unmarshal(record.Data, &tx); err != nil {
+			return err
+		}
+		return m.InsertTransaction(ctx, &tx)
+	case opSetTransactionStatus:
+		var args txStatusArgs
+		if err := bson.Unmarshal(record.Data, &args); err != nil {
+			return err
+		}
+		return m.SetTransactionStatus(ctx, args.TxIDs, args.Status, args.BlockHash)
+	case opInsertUTXO:
+		var utxo models.UTXO
+		if err := bson.Unmarshal(record.Data, &utxo); err != nil {
+			return err
+		}
+		return m.InsertUTXO(ctx, &utxo)
+	case opMarkUTXOSpent:
+		var args utxoSpentArgs
+		if err := bson.Unmarshal(record.Data, &args); err != nil {
+			return err
+		}
+		return m.MarkUTXOSpent(ctx, args.TxID, args.OutputIndex, args.SpentInTx)
+	case opMarkUTXOUnspent:
+		var args utxoSpentArgs
+		if err := bson.Unmarshal(record.Data, &args); err != nil {
+			return err
+		}
+		return m.MarkUTXOUnspent(ctx, args.TxID, args.OutputIndex)
+	case opDeleteUTXOsByTx:
+		var args utxoSpentArgs
+		if err := bson.Unmarshal(record.Data, &args); err != nil {
+			return err
+		}
+		return m.DeleteUTXOsByTx(ctx, args.TxID)
+	case opInsertWallet:
+		var wallet models.Wallet
+		if err := bson.Unmarshal(record.Data, &wallet); err != nil {
+			return err
+		}
+		return m.InsertWallet(ctx, &wallet)
+	case opIncrementBalance:
+		var args walletBalanceArgs
+		if err := bson.Unmarshal(record.Data, &args); err != nil {
+			return err
+		}
+		return m.IncrementWalletBalance(ctx, args.WalletID, args.Amount)
+	case opSetBalance:
+		var args walletBalanceArgs
+		if err := bson.Unmarshal(record.Data, &args); err != nil {
+			return err
+		}
+		return m.SetWalletBalance(ctx, args.WalletID, args.Amount)
+	case opAdvanceNonce:
+		var args walletNonceArgs
+		if err := bson.Unmarshal(record.Data, &args); err != nil {
+			return err
+		}
+		return m.AdvanceWalletNonce(ctx, args.WalletID, args.Nonce)
+	case opInsertUser:
+		var user models.User
+		if err := bson.Unmarshal(record.Data, &user); err != nil {
+			return err
+		}
+		return m.InsertUser(ctx, &user)
+	case opUpdateUser:
+		var args userUpdateArgs
+		if err := bson.Unmarshal(record.Data, &args); err != nil {
+			return err
+		}
+		return m.UpdateUser(ctx, args.UserID, args.Fields)
+	case opAddBeneficiary:
+		var args beneficiaryArgs
+		if err := bson.Unmarshal(record.Data, &args); err != nil {
+			return err
+		}
+		return m.AddBeneficiary(ctx, args.UserID, args.Beneficiary)
+	case opRemoveBeneficiary:
+		var args beneficiaryArgs
+		if err := bson.Unmarshal(record.Data, &args); err != nil {
+			return err
+		}
+		return m.RemoveBeneficiary(ctx, args.UserID, args.BeneficiaryID)
+	case opAddZakatRecord:
+		var args zakatRecordArgs
+		if err := bson.Unmarshal(record.Data, &args); err != nil {
+			return err
+		}
+		return m.AddZakatRecord(ctx, args.WalletID, args.Record)
+	case opInsertSystemLog:
+		var log models.SystemLog
+		if err := bson.Unmarshal(record.Data, &log); err != nil {
+			return err
+		}
+		return m.InsertSystemLog(ctx, &log)
+	case opInsertTransactionLog:
+		var log models.TransactionLog
+		if err := bson.Unmarshal(record.Data, &log); err != nil {
+			return err
+		}
+		return m.InsertTransactionLog(ctx, &log)
+	}
+	return fmt.Errorf("unknown journal operation %q", record.Op)
+}
+
+// write appends a record to the journal and applies it in memory.
+// The journal write happens first so that replay reproduces the same state.
+func (s *FileStore) write(ctx context.Context, op string, data interface{}) error {
+	return s.writeIf(ctx, op, data, nil)
+}
+
+// writeIf journals and applies a record only if check, run under the
+// journal lock, succeeds. Conditional updates use it so that a record that
+// would fail is never written and replay cannot fail on it. A record that
+// fails to apply is cut from the journal again. Inside RunAtomically the
+// record is applied and joins the batch only if it applied.
+func (s *FileStore) writeIf(ctx context.Context, op string, data interface{}, check func() error) error {
+	raw, err := bson.Marshal(journalRecord{Op: op, Data: data})
+	if err != nil {
+		return err
+	}
+	var record storedRecord
+	if err := bson.Unmarshal(raw, &record); err != nil {
+		return err
+	}
+
+	batch, inBatch := ctx.Value(atomicKey{}).(*journalBatch)
+	if !inBatch {
+		s.batch.Lock()
+		defer s.batch.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if check != nil {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+
+	if inBatch {
+		if err := s.apply(ctx, record); err != nil {
+			return err
+		}
+		batch.records = append(batch.records, raw)
+		return nil
+	}
+
+	offset, err := s.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if err := s.appendRecord(raw); err != nil {
+		return s.truncate(offset, err)
+	}
+	if err := s.apply(ctx, record); err != nil {
+		return s.truncate(offset, err)
+	}
+	return nil
+}
+
+// appendRecord writes a record to the journal and syncs it. Callers hold s.mu.
+func (s *FileStore) appendRecord(raw []byte) error {
+	if _, err := s.file.Write(raw); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// truncate cuts the journal back to offset after a failed write, so replay
+// never meets the record, and returns cause. Callers hold s.mu.
+func (s *FileStore) truncate(offset int64, cause error) error {
+	if err := s.file.Truncate(offset); err != nil {
+		return fmt.Errorf("%v; truncating the journal: %w", cause, err)
+	}
+	if _, err := s.file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("%v; truncating the journal: %w", cause, err)
+	}
+	return cause
+}
+
+// RunAtomically applies fn's writes in memory as they are made and
+// journals them as a single record once fn succeeds. A crash before that
+// record is complete loses the whole batch on replay. If fn fails, the
+// in-memory state is restored. Other writers wait until fn returns.
+func (s *FileStore) RunAtomically(ctx context.Context, fn func(ctx context.Context) error) error {
+	if inAtomic(ctx) {
+		return fn(ctx)
+	}
+
+	s.batch.Lock()
+	defer s.batch.Unlock()
+
+	state := s.MemoryStore.snapshot()
+	batch := &journalBatch{}
+	if err := fn(context.WithValue(ctx, atomicKey{}, batch)); err != nil {
+		s.MemoryStore.restore(state)
+		return err
+	}
+	if len(batch.records) == 0 {
+		return nil
+	}
+
+	raw, err := bson.Marshal(journalRecord{Op: opBatch, Data: batch.records})
+	if err == nil {
+		s.mu.Lock()
+		err = s.appendRecord(raw)
+		s.mu.Unlock()
+	}
+	if err != nil {
+		s.MemoryStore.restore(state)
+		return err
+	}
+	return nil
+}
+
+// InsertBlock stores a block
+func (s *FileStore) InsertBlock(ctx context.Context, block *models.Block) error {
+	if block.ID.IsZero() {
+		block.ID = primitive.NewObjectID()
+	}
+	return s.write(ctx, opInsertBlock, block)
+}
+
+// UpdateBlock sets the given fields on a block
+func (s *FileStore) UpdateBlock(ctx context.Context, hash string, fields map[string]interface{}) error {
+	return s.write(ctx, opUpdateBlock, blockUpdateArgs{Hash: hash, Fields: fields})
+}
+
+// DeleteBlock removes a block
+func (s *FileStore) DeleteBlock(ctx context.Context, hash string) error {
+	return s.write(ctx, opDeleteBlock, blockUpdateArgs{Hash: hash})
+}
+
+// InsertTransaction stores a transaction
+func (s *FileStore) InsertTransaction(ctx context.Context, tx *models.Transaction) error {
+	if tx.ID.IsZero() {
+		tx.ID = primitive.NewObjectID()
+	}
+	return s.write(ctx, opInsertTransaction, tx)
+}
+
+// SetTransactionStatus updates status and block hash of the given transactions
+func (s *FileStore) SetTransactionStatus(ctx context.Context, txIDs []string, status, blockHash string) error {
+	return s.write(ctx, opSetTransactionStatus, txStatusArgs{TxIDs: txIDs, Status: status, BlockHash: blockHash})
+}
+
+// InsertUTXO stores a transaction output
+func (s *FileStore) InsertUTXO(ctx context.Context, utxo *models.UTXO) error {
+	if utxo.ID.IsZero() {
+		utxo.ID = primitive.NewObjectID()
+	}
+	return s.write(ctx, opInsertUTXO, utxo)
+}
+
+// MarkUTXOSpent marks an output as spent by the given transaction
+func (s *FileStore) MarkUTXOSpent(ctx context.Context, txID string, outputIndex int, spentInTx string) error {
+	return s.write(ctx, opMarkUTXOSpent, utxoSpentArgs{TxID: txID, OutputIndex: outputIndex, SpentInTx: spentInTx})
+}
+
+// MarkUTXOUnspent reverts an output to unspent
+func (s *FileStore) MarkUTXOUnspent(ctx context.Context, txID string, outputIndex int) error {
+	return s.write(ctx, opMarkUTXOUnspent, utxoSpentArgs{TxID: txID, OutputIndex: outputIndex})
+}
+
+// DeleteUTXOsByTx removes every output created by a transaction
+func (s *FileStore) DeleteUTXOsByTx(ctx context.Context, txID string) error {
+	return s.write(ctx, opDeleteUTXOsByTx, utxoSpentArgs{TxID: txID})
+}
+
+// InsertWallet stores a wallet
+func (s *FileStore) InsertWallet(ctx context.Context, wallet *models.Wallet) error {
+	if wallet.ID.IsZero() {
+		wallet.ID = primitive.NewObjectID()
+	}
+	return s.write(ctx, opInsertWallet, wallet)
+}
+
+// IncrementWalletBalance adds delta to a wallet's cached balance
+func (s *FileStore) IncrementWalletBalance(ctx context.Context, walletID string, delta models.Amount) error {
+	return s.write(ctx, opIncrementBalance, walletBalanceArgs{WalletID: walletID, Amount: delta})
+}
+
+// AdvanceWalletNonce raises a wallet's last used nonce
+func (s *FileStore) AdvanceWalletNonce(ctx context.Context, walletID string, nonce int64) error {
+	return s.writeIf(ctx, opAdvanceNonce, walletNonceArgs{WalletID: walletID, Nonce: nonce}, func() error {
+		wallet, err := s.MemoryStore.GetWalletByWalletID(ctx, walletID)
+		if err != nil {
+			return err
+		}
+		if nonce <= wallet.Nonce {
+			return ErrStaleNonce
+		}
+		return nil
+	})
+}
+
+// SetWalletBalance overwrites a wallet's cached balance
+func (s *FileStore) SetWalletBalance(ctx context.Context, walletID string, balance models.Amount) error {
+	return s.write(ctx, opSetBalance, walletBalanceArgs{WalletID: walletID, Amount: balance})
+}
+
+// InsertUser stores a user
+func (s *FileStore) InsertUser(ctx context.Context, user *models.User) error {
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	return s.write(ctx, opInsertUser, user)
+}
+
+// UpdateUser sets the given fields on a user
+func (s *FileStore) UpdateUser(ctx context.Context, userID primitive.ObjectID, fields map[string]interface{}) error {
+	return s.write(ctx, opUpdateUser, userUpdateArgs{UserID: userID, Fields: fields})
+}
+
+// AddBeneficiary appends a beneficiary to a user
+func (s *FileStore) AddBeneficiary(ctx context.Context, userID primitive.ObjectID, beneficiary models.Beneficiary) error {
+	return s.write(ctx, opAddBeneficiary, beneficiaryArgs{UserID: userID, Beneficiary: beneficiary})
+}
+
+// RemoveBeneficiary removes a beneficiary from a user
+func (s *FileStore) RemoveBeneficiary(ctx context.Context, userID, beneficiaryID primitive.ObjectID) error {
+	return s.write(ctx, opRemoveBeneficiary, beneficiaryArgs{UserID: userID, BeneficiaryID: beneficiaryID})
+}
+
+// AddZakatRecord appends a zakat record to the user owning a wallet
+func (s *FileStore) AddZakatRecord(ctx context.Context, walletID string, record models.ZakatRecord) error {
+	return s.write(ctx, opAddZakatRecord, zakatRecordArgs{WalletID: walletID, Record: record})
+}
+
+// InsertSystemLog stores a system log entry
+func (s *FileStore) InsertSystemLog(ctx context.Context, log *models.SystemLog) error {
+	if log.ID.IsZero() {
+		log.ID = primitive.NewObjectID()
+	}
+	return s.write(ctx, opInsertSystemLog, log)
+}
+
+// InsertTransactionLog stores a transaction log entry
+func (s *FileStore) InsertTransactionLog(ctx context.Context, log *models.TransactionLog) error {
+	if log.ID.IsZero() {
+		log.ID = primitive.NewObjectID()
+	}
+	return s.write(ctx, opInsertTransactionLog, log)
+}
@@ -0,0 +1,735 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore is a ChainStore that keeps everything in process memory.
+// It is used for hermetic runs and as the base of FileStore.
+type MemoryStore struct {
+	mu              sync.RWMutex
+	batch           sync.Mutex // held by RunAtomically; other writers wait for it
+	blocks          []models.Block
+	transactions    []models.Transaction
+	utxos           []models.UTXO
+	wallets         []models.Wallet
+	users           []models.User
+	systemLogs      []models.SystemLog
+	transactionLogs []models.TransactionLog
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// atomicKey marks a context inside RunAtomically
+type atomicKey struct{}
+
+func inAtomic(ctx context.Context) bool {
+	return ctx.Value(atomicKey{}) != nil
+}
+
+// lockWrite makes a write outside RunAtomically wait for a running fn,
+// and returns the function that releases it. fn's own writes pass
+// through, as RunAtomically already holds the lock for them.
+func (s *MemoryStore) lockWrite(ctx context.Context) func() {
+	if inAtomic(ctx) {
+		return func() {}
+	}
+	s.batch.Lock()
+	return s.batch.Unlock
+}
+
+// memoryState is a copy of a MemoryStore's contents
+type memoryState struct {
+	blocks          []models.Block
+	transactions    []models.Transaction
+	utxos           []models.UTXO
+	wallets         []models.Wallet
+	users           []models.User
+	systemLogs      []models.SystemLog
+	transactionLogs []models.TransactionLog
+}
+
+// snapshot copies the store's contents. Documents are updated by
+// replacing their fields, so copying the slices is enough.
+func (s *MemoryStore) snapshot() *memoryState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &memoryState{
+		blocks:          append([]models.Block(nil), s.blocks...),
+		transactions:    append([]models.Transaction(nil), s.transactions...),
+		utxos:           append([]models.UTXO(nil), s.utxos...),
+		wallets:         append([]models.Wallet(nil), s.wallets...),
+		users:           append([]models.User(nil), s.users...),
+		systemLogs:      append([]models.SystemLog(nil), s.systemLogs...),
+		transactionLogs: append([]models.TransactionLog(nil), s.transactionLogs...),
+	}
+}
+
+// restore replaces the store's contents with a snapshot
+func (s *MemoryStore) restore(state *memoryState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocks = state.blocks
+	s.transactions = state.transactions
+	s.utxos = state.utxos
+	s.wallets = state.wallets
+	s.users = state.users
+	s.systemLogs = state.systemLogs
+	s.transactionLogs = state.transactionLogs
+}
+
+// RunAtomically runs fn and restores the store's contents if it fails.
+// Other writers wait until fn returns, so a rollback undoes only fn's
+// writes.
+func (s *MemoryStore) RunAtomically(ctx context.Context, fn func(ctx context.Context) error) error {
+	if inAtomic(ctx) {
+		return fn(ctx)
+	}
+
+	s.batch.Lock()
+	defer s.batch.Unlock()
+
+	state := s.snapshot()
+	if err := fn(context.WithValue(ctx, atomicKey{}, true)); err != nil {
+		s.restore(state)
+		return err
+	}
+	return nil
+}
+
+// Atomic reports true: failed RunAtomically calls leave no trace
+func (s *MemoryStore) Atomic(ctx context.Context) bool {
+	return true
+}
+
+// Close is a no-op for the in-memory backend
+func (s *MemoryStore) Close(ctx context.Context) error {
+	return nil
+}
+
+// CountBlocks returns the number of stored blocks
+func (s *MemoryStore) CountBlocks(ctx context.Context) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(len(s.blocks)), nil
+}
+
+// InsertBlock stores a block
+func (s *MemoryStore) InsertBlock(ctx context.Context, block *models.Block) error {
+	defer s.lockWrite(ctx)()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if block.ID.IsZero() {
+		block.ID = primitive.NewObjectID()
+	}
+	s.blocks = append(s.blocks, *block)
+	return nil
+}
+
+// isMainChain reports whether a block belongs to the main chain.
+// Blocks stored before statuses existed have none and count as main.
+func isMainChain(block *models.Block) bool {
+	switch block.Status {
+	case models.BlockStatusStale, models.BlockStatusOrphan, models.BlockStatusInvalid:
+		return false
+	}
+	return true
+}
+
+// UpdateBlock sets the given fields on a block
+func (s *MemoryStore) UpdateBlock(ctx context.Context, hash string, fields map[string]interface{}) error {
+	defer s.lockWrite(ctx)()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.blocks {
+		if s.blocks[i].Hash == hash {
+			return setFields(&s.blocks[i], fields)
+		}
+	}
+	return nil
+}
+
+// DeleteBlock removes a block
+func (s *MemoryStore) DeleteBlock(ctx context.Context, hash string) error {
+	defer s.lockWrite(ctx)()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.blocks[:0]
+	for _, block := range s.blocks {
+		if block.Hash != hash {
+			kept = append(kept, block)
+		}
+	}
+	s.blocks = kept
+	return nil
+}
+
+// GetLatestBlock returns the main-chain block with the highest index
+func (s *MemoryStore) GetLatestBlock(ctx context.Context) (*models.Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var latest *models.Block
+	for i := range s.blocks {
+		if !isMainChain(&s.blocks[i]) {
+			continue
+		}
+		if latest == nil || s.blocks[i].Index > latest.Index {
+			latest = &s.blocks[i]
+		}
+	}
+	if latest == nil {
+		return nil, ErrNotFound
+	}
+	block := *latest
+	return &block, nil
+}
+
+// GetBlockByHash returns a block by its hash
+func (s *MemoryStore) GetBlockByHash(ctx context.Context, hash string) (*models.Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, block := range s.blocks {
+		if block.Hash == hash {
+			return &block, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetAllBlocks returns all main-chain blocks, newest first
+func (s *MemoryStore) GetAllBlocks(ctx context.Context) ([]models.Block, error) {
+	return s.filterBlocks(isMainChain), nil
+}
+
+// GetBlocksByStatus returns blocks with the given status, newest first
+func (s *MemoryStore) GetBlocksByStatus(ctx context.Context, status string) ([]models.Block, error) {
+	return s.filterBlocks(func(b *models.Block) bool { return b.Status == status }), nil
+}
+
+// GetBlocksWithPendingOp returns blocks whose connect or disconnect has not completed
+func (s *MemoryStore) GetBlocksWithPendingOp(ctx context.Context) ([]models.Block, error) {
+	return s.filterBlocks(func(b *models.Block) bool { return b.PendingOp != "" }), nil
+}
+
+// GetBlocksByPreviousHash returns the children of a block
+func (s *MemoryStore) GetBlocksByPreviousHash(ctx context.Context, previousHash string) ([]models.Block, error) {
+	return s.filterBlocks(func(b *models.Block) bool { return b.PreviousHash == previousHash }), nil
+}
+
+// filterBlocks returns matching blocks sorted by index, newest first
+func (s *MemoryStore) filterBlocks(match func(*models.Block) bool) []models.Block {
+	s.mu.RLock()
+	var blocks []models.Block
+	for i := range s.blocks {
+		if match(&s.blocks[i]) {
+			blocks = append(blocks, s.blocks[i])
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].Index > blocks[j].Index
+	})
+	return blocks
+}
+
+// InsertTransaction stores a transaction
+func (s *MemoryStore) InsertTransaction(ctx context.Context, tx *models.Transaction) error {
+	defer s.lockWrite(ctx)()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tx.ID.IsZero() {
+		tx.ID = primitive.NewObjectID()
+	}
+	s.transactions = append(s.transactions, *tx)
+	return nil
+}
+
+// GetTransaction returns a transaction by its tx ID
+func (s *MemoryStore) GetTransaction(ctx context.Context, txID string) (*models.Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, tx := range s.transactions {
+		if tx.TxID == txID {
+			return &tx, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetTransactionsByStatus returns transactions with the given status
+func (s *MemoryStore) GetTransactionsByStatus(ctx context.Context, status string) ([]models.Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var transactions []models.Transaction
+	for _, tx := range s.transactions {
+		if tx.Status == status {
+			transactions = append(transactions, tx)
+		}
+	}
+	return transactions, nil
+}
+
+// GetTransactionsByType returns transactions of the given type and status
+func (s *MemoryStore) GetTransactionsByType(ctx context.Context, txType, status string) ([]models.Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var transactions []models.Transaction
+	for _, tx := range s.transactions {
+		if tx.Type == txType && tx.Status == status {
+			transactions = append(transactions, tx)
+		}
+	}
+	return transactions, nil
+}
+
+// SetTransactionStatus updates status and block hash of the given transactions
+func (s *MemoryStore) SetTransactionStatus(ctx context.Context, txIDs []string, status, blockHash string) error {
+	defer s.lockWrite(ctx)()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make(map[string]bool, len(txIDs))
+	for _, id := range txIDs {
+		ids[id] = true
+	}
+	for i := range s.transactions {
+		if ids[s.transactions[i].TxID] {
+			s.transactions[i].Status = status
+			s.transactions[i].BlockHash = blockHash
+		}
+	}
+	return nil
+}
+
+// GetTransactionHistory returns confirmed transactions for a wallet
+func (s *MemoryStore) GetTransactionHistory(ctx context.Context, walletID string, limit int64) ([]models.Transaction, error) {
+	s.mu.RLock()
+	var transactions []models.Transaction
+	for _, tx := range s.transactions {
+		if tx.Status == "confirmed" && (tx.SenderWalletID == walletID || tx.ReceiverWalletID == walletID) {
+			transactions = append(transactions, tx)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return transactions[i].Timestamp.After(transactions[j].Timestamp)
+	})
+	if limit > 0 && int64(len(transactions)) > limit {
+		transactions = transactions[:limit]
+	}
+	return transactions, nil
+}
+
+// InsertUTXO stores a transaction output
+func (s *MemoryStore) InsertUTXO(ctx context.Context, utxo *models.UTXO) error {
+	defer s.lockWrite(ctx)()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if utxo.ID.IsZero() {
+		utxo.ID = primitive.NewObjectID()
+	}
+	s.utxos = append(s.utxos, *utxo)
+	return nil
+}
+
+// GetUTXO returns the output identified by tx ID and index
+func (s *MemoryStore) GetUTXO(ctx context.Context, txID string, outputIndex int) (*models.UTXO, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, utxo := range s.utxos {
+		if utxo.TxID == txID && utxo.OutputIndex == outputIndex {
+			return &utxo, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetUnspentUTXOs returns all unspent outputs owned by a wallet
+func (s *MemoryStore) GetUnspentUTXOs(ctx context.Context, walletID string) ([]models.UTXO, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var utxos []models.UTXO
+	for _, utxo := range s.utxos {
+		if utxo.WalletID == walletID && !utxo.IsSpent {
+			utxos = append(utxos, utxo)
+		}
+	}
+	return utxos, nil
+}
+
+// GetAllUTXOs returns every stored output, spent or not
+func (s *MemoryStore) GetAllUTXOs(ctx context.Context) ([]models.UTXO, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]models.UTXO(nil), s.utxos...), nil
+}
+
+// MarkUTXOSpent marks an output as spent by the given transaction
+func (s *MemoryStore) MarkUTXOSpent(ctx context.Context, txID string, outputIndex int, spentInTx string) error {
+	defer s.lockWrite(ctx)()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.utxos {
+		if s.utxos[i].TxID == txID && s.utxos[i].OutputIndex == outputIndex {
+			s.utxos[i].IsSpent = true
+			s.utxos[i].SpentInTx = spentInTx
+			break
+		}
+	}
+	return nil
+}
+
+// MarkUTXOUnspent reverts an output to unspent
+func (s *MemoryStore) MarkUTXOUnspent(ctx context.Context, txID string, outputIndex int) error {
+	defer s.lockWrite(ctx)()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.utxos {
+		if s.utxos[i].TxID == txID && s.utxos[i].OutputIndex == outputIndex {
+			s.utxos[i].IsSpent = false
+			s.utxos[i].SpentInTx = ""
+			break
+		}
+	}
+	return nil
+}
+
+// DeleteUTXOsByTx removes every output created by a transaction
+func (s *MemoryStore) DeleteUTXOsByTx(ctx context.Context, txID string) error {
+	defer s.lockWrite(ctx)()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.utxos[:0]
+	for _, utxo := range s.utxos {
+		if utxo.TxID != txID {
+			kept = append(kept, utxo)
+		}
+	}
+	s.utxos = kept
+	return nil
+}
+
+// InsertWallet stores a wallet
+func (s *MemoryStore) InsertWallet(ctx context.Context, wallet *models.Wallet) error {
+	defer s.lockWrite(ctx)()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if wallet.ID.IsZero() {
+		wallet.ID = primitive.NewObjectID()
+	}
+	s.wallets = append(s.wallets, *wallet)
+	return nil
+}
+
+// GetWalletByWalletID returns a wallet by its wallet ID
+func (s *MemoryStore) GetWalletByWalletID(ctx context.Context, walletID string) (*models.Wallet, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, wallet := range s.wallets {
+		if wallet.WalletID == walletID {
+			return &wallet, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetWalletByUserID returns the first wallet created for a user
+func (s *MemoryStore) GetWalletByUserID(ctx context.Context, userID primitive.ObjectID) (*models.Wallet, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, wallet := range s.wallets {
+		if wallet.UserID == userID {
+			return &wallet, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetWalletsByUserID returns every wallet of a user, oldest first
+func (s *MemoryStore) GetWalletsByUserID(ctx context.Context, userID primitive.ObjectID) ([]models.Wallet, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var wallets []models.Wallet
+	for _, wallet := range s.wallets {
+		if wallet.UserID == userID {
+			wallets = append(wallets, wallet)
+		}
+	}
+	return wallets, nil
+}
+
+// GetAllWallets returns every wallet
+func (s *MemoryStore) GetAllWallets(ctx context.Context) ([]models.Wallet, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]models.Wallet(nil), s.wallets...), nil
+}
+
+// IncrementWalletBalance adds delta to a wallet's cached balance
+func (s *MemoryStore) IncrementWalletBalance(ctx context.Context, walletID string, delta models.Amount) error {
+	defer s.lockWrite(ctx)()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.wallets {
+		if s.wallets[i].WalletID == walletID {
+			s.wallets[i].CachedBalance += delta
+			break
+		}
+	}
+	return nil
+}
+
+// AdvanceWalletNonce raises a wallet's last used nonce
+func (s *MemoryStore) AdvanceWalletNonce(ctx context.Context, walletID string, nonce int64) error {
+	defer s.lockWrite(ctx)()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.wallets {
+		if s.wallets[i].WalletID == walletID {
+			if nonce <= s.wallets[i].Nonce {
+				return ErrStaleNonce
+			}
+			s.wallets[i].Nonce = nonce
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// SetWalletBalance overwrites a wallet's cached balance
+func (s *MemoryStore) SetWalletBalance(ctx context.Context, walletID string, balance models.Amount) error {
+	defer s.lockWrite(ctx)()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.wallets {
+		if s.wallets[i].WalletID == walletID {
+			s.wallets[i].CachedBalance = balance
+			s.wallets[i].UpdatedAt = time.Now()
+			break
+		}
+	}
+	return nil
+}
+
+// InsertUser stores a user
+func (s *MemoryStore) InsertUser(ctx context.Context, user *models.User) error {
+	defer s.lockWrite(ctx)()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	s.users = append(s.users, *user)
+	return nil
+}
+
+// GetUserByID returns a user by ID
+func (s *MemoryStore) GetUserByID(ctx context.Context, userID primitive.ObjectID) (*models.User, error) {
+	return s.findUser(func(u *models.User) bool { return u.ID == userID })
+}
+
+// GetUserByEmail returns a user by email
+func (s *MemoryStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	return s.findUser(func(u *models.User) bool { return u.Email == email })
+}
+
+// GetUserByWalletID returns the user owning a wallet
+func (s *MemoryStore) GetUserByWalletID(ctx context.Context, walletID string) (*models.User, error) {
+	return s.findUser(func(u *models.User) bool { return u.WalletID == walletID })
+}
+
+// GetAllUsers returns every user
+func (s *MemoryStore) GetAllUsers(ctx context.Context) ([]models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]models.User(nil), s.users...), nil
+}
+
+func (s *MemoryStore) findUser(match func(*models.User) bool) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := range s.users {
+		if match(&s.users[i]) {
+			user := s.users[i]
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// updateUser applies fn to the first user matching match
+func (s *MemoryStore) updateUser(match func(*models.User) bool, fn func(*models.User) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.users {
+		if match(&s.users[i]) {
+			return fn(&s.users[i])
+		}
+	}
+	return nil
+}
+
+// UpdateUser sets the given fields on a user
+func (s *MemoryStore) UpdateUser(ctx context.Context, userID primitive.ObjectID, fields map[string]interface{}) error {
+	defer s.lockWrite(ctx)()
+	return s.updateUser(func(u *models.User) bool { return u.ID == userID }, func(u *models.User) error {
+		return setFields(u, fields)
+	})
+}
+
+// AddBeneficiary appends a beneficiary to a user
+func (s *MemoryStore) AddBeneficiary(ctx context.Context, userID primitive.ObjectID, beneficiary models.Beneficiary) error {
+	defer s.lockWrite(ctx)()
+	return s.updateUser(func(u *models.User) bool { return u.ID == userID }, func(u *models.User) error {
+		u.Beneficiaries = append(u.Beneficiaries, beneficiary)
+		u.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+// RemoveBeneficiary removes a beneficiary from a user
+func (s *MemoryStore) RemoveBeneficiary(ctx context.Context, userID, beneficiaryID primitive.ObjectID) error {
+	defer s.lockWrite(ctx)()
+	return s.updateUser(func(u *models.User) bool { return u.ID == userID }, func(u *models.User) error {
+		var kept []models.Beneficiary
+		for _, b := range u.Beneficiaries {
+			if b.ID != beneficiaryID {
+				kept = append(kept, b)
+			}
+		}
+		u.Beneficiaries = kept
+		u.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+// AddZakatRecord appends a zakat record to the user owning a wallet
+func (s *MemoryStore) AddZakatRecord(ctx context.Context, walletID string, record models.ZakatRecord) error {
+	defer s.lockWrite(ctx)()
+	return s.updateUser(func(u *models.User) bool { return u.WalletID == walletID }, func(u *models.User) error {
+		u.ZakatTracking = append(u.ZakatTracking, record)
+		u.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+// InsertSystemLog stores a system log entry
+func (s *MemoryStore) InsertSystemLog(ctx context.Context, log *models.SystemLog) error {
+	defer s.lockWrite(ctx)()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if log.ID.IsZero() {
+		log.ID = primitive.NewObjectID()
+	}
+	s.systemLogs = append(s.systemLogs, *log)
+	return nil
+}
+
+// InsertTransactionLog stores a transaction log entry
+func (s *MemoryStore) InsertTransactionLog(ctx context.Context, log *models.TransactionLog) error {
+	defer s.lockWrite(ctx)()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if log.ID.IsZero() {
+		log.ID = primitive.NewObjectID()
+	}
+	s.transactionLogs = append(s.transactionLogs, *log)
+	return nil
+}
+
+// GetSystemLogs returns the most recent system logs
+func (s *MemoryStore) GetSystemLogs(ctx context.Context, limit int64) ([]models.SystemLog, error) {
+	s.mu.RLock()
+	logs := append([]models.SystemLog(nil), s.systemLogs...)
+	s.mu.RUnlock()
+
+	sort.SliceStable(logs, func(i, j int) bool {
+		return logs[i].Timestamp.After(logs[j].Timestamp)
+	})
+	if limit > 0 && int64(len(logs)) > limit {
+		logs = logs[:limit]
+	}
+	return logs, nil
+}
+
+// GetTransactionLogs returns the most recent transaction logs for a wallet
+func (s *MemoryStore) GetTransactionLogs(ctx context.Context, walletID string, limit int64) ([]models.TransactionLog, error) {
+	s.mu.RLock()
+	var logs []models.TransactionLog
+	for _, log := range s.transactionLogs {
+		if log.WalletID == walletID {
+			logs = append(logs, log)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.SliceStable(logs, func(i, j int) bool {
+		return logs[i].Timestamp.After(logs[j].Timestamp)
+	})
+	if limit > 0 && int64(len(logs)) > limit {
+		logs = logs[:limit]
+	}
+	return logs, nil
+}
+
+// setFields applies a $set-style update, keyed by bson field names, to doc
+func setFields(doc interface{}, fields map[string]interface{}) error {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	var m bson.M
+	if err := bson.Unmarshal(raw, &m); err != nil {
+		return err
+	}
+	for k, v := range fields {
+		m[k] = v
+	}
+
+	raw, err = bson.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	// Decode into a copy so a field of the wrong type leaves doc untouched
+	updated := reflect.New(reflect.TypeOf(doc).Elem())
+	if err := bson.Unmarshal(raw, updated.Interface()); err != nil {
+		return err
+	}
+	reflect.ValueOf(doc).Elem().Set(updated.Elem())
+	return nil
+}
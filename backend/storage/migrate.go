@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AmountMigration reports what MigrateAmounts rewrote
+type AmountMigration struct {
+	Documents          map[string]int `json:"documents"`          // rewritten, by collection
+	LegacyTransactions int            `json:"legacyTransactions"` // kept their exact float amounts
+}
+
+// amountFields lists the fields of each collection that hold amounts
+var amountFields = map[string][]string{
+	TransactionsCollection:    {"amount", "fee", "input_utxos.amount", "output_utxos.amount"},
+	BlocksCollection:          {"transactions.amount", "transactions.fee", "transactions.input_utxos.amount", "transactions.output_utxos.amount"},
+	UTXOsCollection:           {"amount"},
+	WalletsCollection:         {"cached_balance"},
+	UsersCollection:           {"zakat_tracking.amount"},
+	TransactionLogsCollection: {"amount"},
+}
+
+// MigrateAmounts rewrites amounts stored as float coins, from before
+// amounts were fixed point, as int64 base units. Documents are decoded
+// through the models, which round the floats and keep the exact values of
+// transactions whose ID and signature depend on them. Only amount fields
+// are written, and documents without float amounts are left alone, so the
+// migration can be run again safely.
+func (s *MongoStore) MigrateAmounts(ctx context.Context) (*AmountMigration, error) {
+	report := &AmountMigration{Documents: make(map[string]int)}
+
+	for collection, fields := range amountFields {
+		var legacy []bson.M
+		for _, field := range fields {
+			legacy = append(legacy, bson.M{field: bson.M{"$type": "double"}})
+		}
+
+		cursor, err := s.db.Collection(collection).Find(ctx, bson.M{"$or": legacy})
+		if err != nil {
+			return nil, err
+		}
+
+		for cursor.Next(ctx) {
+			update, legacyTxs, err := migratedAmounts(collection, cursor)
+			if err != nil {
+				cursor.Close(ctx)
+				return nil, err
+			}
+			id := cursor.Current.Lookup("_id")
+			if _, err := s.db.Collection(collection).UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": update}); err != nil {
+				cursor.Close(ctx)
+				return nil, err
+			}
+			report.Documents[collection]++
+			report.LegacyTransactions += legacyTxs
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// migratedAmounts decodes the cursor's document and returns its amount
+// fields re-encoded, and how many transactions in it kept legacy amounts
+func migratedAmounts(collection string, cursor *mongo.Cursor) (bson.M, int, error) {
+	switch collection {
+	case TransactionsCollection:
+		var tx models.Transaction
+		if err := cursor.Decode(&tx); err != nil {
+			return nil, 0, err
+		}
+		return transactionAmounts(&tx), legacyCount(tx), nil
+	case BlocksCollection:
+		var block models.Block
+		if err := cursor.Decode(&block); err != nil {
+			return nil, 0, err
+		}
+		return bson.M{"transactions": block.Transactions}, legacyCount(block.Transactions...), nil
+	case UTXOsCollection:
+		var utxo models.UTXO
+		if err := cursor.Decode(&utxo); err != nil {
+			return nil, 0, err
+		}
+		return bson.M{"amount": utxo.Amount}, 0, nil
+	case WalletsCollection:
+		var wallet models.Wallet
+		if err := cursor.Decode(&wallet); err != nil {
+			return nil, 0, err
+		}
+		return bson.M{"cached_balance": wallet.CachedBalance}, 0, nil
+	case UsersCollection:
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return nil, 0, err
+		}
+		return bson.M{"zakat_tracking": user.ZakatTracking}, 0, nil
+	default:
+		var log models.TransactionLog
+		if err := cursor.Decode(&log); err != nil {
+			return nil, 0, err
+		}
+		return bson.M{"amount": log.Amount}, 0, nil
+	}
+}
+
+func transactionAmounts(tx *models.Transaction) bson.M {
+	update := bson.M{
+		"amount":       tx.Amount,
+		"fee":          tx.Fee,
+		"input_utxos":  tx.InputUTXOs,
+		"output_utxos": tx.OutputUTXOs,
+	}
+	if tx.LegacyAmounts != nil {
+		update["legacy_amounts"] = tx.LegacyAmounts
+	}
+	return update
+}
+
+func legacyCount(txs ...models.Transaction) int {
+	n := 0
+	for _, tx := range txs {
+		if tx.LegacyAmounts != nil {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// migrate applies what MigrateAmounts writes for a transaction document
+func migrate(t *testing.T, raw bson.Raw) bson.Raw {
+	t.Helper()
+	var tx models.Transaction
+	if err := bson.Unmarshal(raw, &tx); err != nil {
+		t.Fatal(err)
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	update := transactionAmounts(&tx)
+	for i := range doc {
+		if value, ok := update[doc[i].Key]; ok {
+			doc[i].Value = value
+			delete(update, doc[i].Key)
+		}
+	}
+	for key, value := range update {
+		doc = append(doc, bson.E{Key: key, Value: value})
+	}
+
+	migrated, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return migrated
+}
+
+func TestMigrateTransactionAmounts(t *testing.T) {
+	legacy, err := bson.Marshal(bson.D{
+		{Key: "tx_id", Value: "legacy"},
+		{Key: "amount", Value: 0.123456789},
+		{Key: "fee", Value: 0.01},
+		{Key: "input_utxos", Value: bson.A{bson.D{{Key: "tx_id", Value: "funding"}, {Key: "output_index", Value: 0}, {Key: "amount", Value: 1.0}}}},
+		{Key: "output_utxos", Value: bson.A{bson.D{{Key: "wallet_id", Value: "bob"}, {Key: "index", Value: 0}, {Key: "amount", Value: 0.123456789}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	once := migrate(t, legacy)
+	for _, path := range [][]string{{"amount"}, {"fee"}, {"input_utxos", "0", "amount"}, {"output_utxos", "0", "amount"}} {
+		if value := once.Lookup(path...); value.Type != bson.TypeInt64 {
+			t.Fatalf("%v is %s after migrating, want int64", path, value.Type)
+		}
+	}
+
+	var before, after models.Transaction
+	if err := bson.Unmarshal(legacy, &before); err != nil {
+		t.Fatal(err)
+	}
+	if err := bson.Unmarshal(once, &after); err != nil {
+		t.Fatal(err)
+	}
+	if after.Amount != before.Amount || after.Fee != before.Fee || after.OutputUTXOs[0] != before.OutputUTXOs[0] {
+		t.Fatalf("migrated %+v, want the amounts of %+v", after, before)
+	}
+	// The ID and signature were computed over the exact floats
+	if len(after.LegacyAmounts) != 4 || after.LegacyAmounts[0] != 0.123456789 {
+		t.Fatalf("legacy amounts = %v, want the exact floats kept", after.LegacyAmounts)
+	}
+
+	if twice := migrate(t, once); !bytes.Equal(twice, once) {
+		t.Fatalf("migrating again changed the document:\n%v\n%v", once, twice)
+	}
+}
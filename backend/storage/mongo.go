@@ -0,0 +1,540 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection names used by the MongoDB backend
+const (
+	BlocksCollection          = "blocks"
+	TransactionsCollection    = "transactions"
+	UTXOsCollection           = "utxos"
+	WalletsCollection         = "wallets"
+	UsersCollection           = "users"
+	SystemLogsCollection      = "system_logs"
+	TransactionLogsCollection = "transaction_logs"
+)
+
+// MongoStore is a ChainStore backed by a MongoDB database
+type MongoStore struct {
+	db *mongo.Database
+
+	atomicMu      sync.Mutex
+	atomicChecked bool
+	atomic        bool // the server supports multi-document transactions
+}
+
+func NewMongoStore(db *mongo.Database) *MongoStore {
+	return &MongoStore{db: db}
+}
+
+// Database returns the underlying MongoDB database
+func (s *MongoStore) Database() *mongo.Database {
+	return s.db
+}
+
+// Close disconnects the MongoDB client
+func (s *MongoStore) Close(ctx context.Context) error {
+	return s.db.Client().Disconnect(ctx)
+}
+
+// Atomic reports whether the server supports multi-document transactions,
+// which needs a replica set or sharded cluster. A standalone server does not.
+func (s *MongoStore) Atomic(ctx context.Context) bool {
+	s.atomicMu.Lock()
+	defer s.atomicMu.Unlock()
+	if s.atomicChecked {
+		return s.atomic
+	}
+
+	var reply struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := s.db.RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&reply); err != nil {
+		return false // ask again next time
+	}
+	s.atomic = reply.SetName != "" || reply.Msg == "isdbgrid"
+	s.atomicChecked = true
+	return s.atomic
+}
+
+// RunAtomically runs fn in a multi-document transaction when the server
+// supports them, and directly otherwise
+func (s *MongoStore) RunAtomically(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil || !s.Atomic(ctx) {
+		return fn(ctx)
+	}
+
+	session, err := s.db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+func (s *MongoStore) findOne(ctx context.Context, collection string, filter interface{}, out interface{}, opts ...*options.FindOneOptions) error {
+	err := s.db.Collection(collection).FindOne(ctx, filter, opts...).Decode(out)
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *MongoStore) findAll(ctx context.Context, collection string, filter interface{}, out interface{}, opts ...*options.FindOptions) error {
+	cursor, err := s.db.Collection(collection).Find(ctx, filter, opts...)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	return cursor.All(ctx, out)
+}
+
+func (s *MongoStore) insert(ctx context.Context, collection string, doc interface{}) error {
+	_, err := s.db.Collection(collection).InsertOne(ctx, doc)
+	return err
+}
+
+// CountBlocks returns the number of stored blocks
+func (s *MongoStore) CountBlocks(ctx context.Context) (int64, error) {
+	return s.db.Collection(BlocksCollection).CountDocuments(ctx, bson.M{})
+}
+
+// InsertBlock stores a block
+func (s *MongoStore) InsertBlock(ctx context.Context, block *models.Block) error {
+	return s.insert(ctx, BlocksCollection, block)
+}
+
+// mainChainFilter matches main-chain blocks, including those stored
+// before block statuses existed
+var mainChainFilter = bson.M{"status": bson.M{"$nin": []string{
+	models.BlockStatusStale,
+	models.BlockStatusOrphan,
+	models.BlockStatusInvalid,
+}}}
+
+// UpdateBlock sets the given fields on a block
+func (s *MongoStore) UpdateBlock(ctx context.Context, hash string, fields map[string]interface{}) error {
+	_, err := s.db.Collection(BlocksCollection).UpdateOne(ctx,
+		bson.M{"hash": hash},
+		bson.M{"$set": fields},
+	)
+	return err
+}
+
+// DeleteBlock removes a block
+func (s *MongoStore) DeleteBlock(ctx context.Context, hash string) error {
+	_, err := s.db.Collection(BlocksCollection).DeleteOne(ctx, bson.M{"hash": hash})
+	return err
+}
+
+// GetLatestBlock returns the main-chain block with the highest index
+func (s *MongoStore) GetLatestBlock(ctx context.Context) (*models.Block, error) {
+	opts := options.FindOne().SetSort(bson.D{{Key: "index", Value: -1}})
+	var block models.Block
+	if err := s.findOne(ctx, BlocksCollection, mainChainFilter, &block, opts); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+// GetBlockByHash returns a block by its hash
+func (s *MongoStore) GetBlockByHash(ctx context.Context, hash string) (*models.Block, error) {
+	var block models.Block
+	if err := s.findOne(ctx, BlocksCollection, bson.M{"hash": hash}, &block); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+// GetAllBlocks returns all main-chain blocks, newest first
+func (s *MongoStore) GetAllBlocks(ctx context.Context) ([]models.Block, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "index", Value: -1}})
+	var blocks []models.Block
+	if err := s.findAll(ctx, BlocksCollection, mainChainFilter, &blocks, opts); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// GetBlocksByStatus returns blocks with the given status, newest first
+func (s *MongoStore) GetBlocksByStatus(ctx context.Context, status string) ([]models.Block, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "index", Value: -1}})
+	var blocks []models.Block
+	if err := s.findAll(ctx, BlocksCollection, bson.M{"status": status}, &blocks, opts); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// GetBlocksWithPendingOp returns blocks whose connect or disconnect has not completed
+func (s *MongoStore) GetBlocksWithPendingOp(ctx context.Context) ([]models.Block, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "index", Value: 1}})
+	var blocks []models.Block
+	filter := bson.M{"pending_op": bson.M{"$exists": true, "$ne": ""}}
+	if err := s.findAll(ctx, BlocksCollection, filter, &blocks, opts); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// GetBlocksByPreviousHash returns the children of a block
+func (s *MongoStore) GetBlocksByPreviousHash(ctx context.Context, previousHash string) ([]models.Block, error) {
+	var blocks []models.Block
+	if err := s.findAll(ctx, BlocksCollection, bson.M{"previous_hash": previousHash}, &blocks); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// InsertTransaction stores a transaction
+func (s *MongoStore) InsertTransaction(ctx context.Context, tx *models.Transaction) error {
+	return s.insert(ctx, TransactionsCollection, tx)
+}
+
+// GetTransaction returns a transaction by its tx ID
+func (s *MongoStore) GetTransaction(ctx context.Context, txID string) (*models.Transaction, error) {
+	var tx models.Transaction
+	if err := s.findOne(ctx, TransactionsCollection, bson.M{"tx_id": txID}, &tx); err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+// GetTransactionsByStatus returns transactions with the given status
+func (s *MongoStore) GetTransactionsByStatus(ctx context.Context, status string) ([]models.Transaction, error) {
+	var transactions []models.Transaction
+	if err := s.findAll(ctx, TransactionsCollection, bson.M{"status": status}, &transactions); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// GetTransactionsByType returns transactions of the given type and status
+func (s *MongoStore) GetTransactionsByType(ctx context.Context, txType, status string) ([]models.Transaction, error) {
+	var transactions []models.Transaction
+	if err := s.findAll(ctx, TransactionsCollection, bson.M{"type": txType, "status": status}, &transactions); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// SetTransactionStatus updates status and block hash of the given transactions
+func (s *MongoStore) SetTransactionStatus(ctx context.Context, txIDs []string, status, blockHash string) error {
+	_, err := s.db.Collection(TransactionsCollection).UpdateMany(ctx,
+		bson.M{"tx_id": bson.M{"$in": txIDs}},
+		bson.M{"$set": bson.M{
+			"status":     status,
+			"block_hash": blockHash,
+		}},
+	)
+	return err
+}
+
+// GetTransactionHistory returns confirmed transactions for a wallet
+func (s *MongoStore) GetTransactionHistory(ctx context.Context, walletID string, limit int64) ([]models.Transaction, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
+		SetLimit(limit)
+
+	var transactions []models.Transaction
+	err := s.findAll(ctx, TransactionsCollection, bson.M{
+		"$or": []bson.M{
+			{"sender_wallet_id": walletID},
+			{"receiver_wallet_id": walletID},
+		},
+		"status": "confirmed",
+	}, &transactions, opts)
+	if err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// InsertUTXO stores a transaction output
+func (s *MongoStore) InsertUTXO(ctx context.Context, utxo *models.UTXO) error {
+	return s.insert(ctx, UTXOsCollection, utxo)
+}
+
+// GetUTXO returns the output identified by tx ID and index
+func (s *MongoStore) GetUTXO(ctx context.Context, txID string, outputIndex int) (*models.UTXO, error) {
+	var utxo models.UTXO
+	err := s.findOne(ctx, UTXOsCollection, bson.M{
+		"tx_id":        txID,
+		"output_index": outputIndex,
+	}, &utxo)
+	if err != nil {
+		return nil, err
+	}
+	return &utxo, nil
+}
+
+// GetUnspentUTXOs returns all unspent outputs owned by a wallet
+func (s *MongoStore) GetUnspentUTXOs(ctx context.Context, walletID string) ([]models.UTXO, error) {
+	var utxos []models.UTXO
+	err := s.findAll(ctx, UTXOsCollection, bson.M{
+		"wallet_id": walletID,
+		"is_spent":  false,
+	}, &utxos)
+	if err != nil {
+		return nil, err
+	}
+	return utxos, nil
+}
+
+// GetAllUTXOs returns every stored output, spent or not
+func (s *MongoStore) GetAllUTXOs(ctx context.Context) ([]models.UTXO, error) {
+	var utxos []models.UTXO
+	if err := s.findAll(ctx, UTXOsCollection, bson.M{}, &utxos); err != nil {
+		return nil, err
+	}
+	return utxos, nil
+}
+
+// MarkUTXOSpent marks an output as spent by the given transaction
+func (s *MongoStore) MarkUTXOSpent(ctx context.Context, txID string, outputIndex int, spentInTx string) error {
+	_, err := s.db.Collection(UTXOsCollection).UpdateOne(ctx,
+		bson.M{"tx_id": txID, "output_index": outputIndex},
+		bson.M{"$set": bson.M{
+			"is_spent":    true,
+			"spent_in_tx": spentInTx,
+		}},
+	)
+	return err
+}
+
+// MarkUTXOUnspent reverts an output to unspent
+func (s *MongoStore) MarkUTXOUnspent(ctx context.Context, txID string, outputIndex int) error {
+	_, err := s.db.Collection(UTXOsCollection).UpdateOne(ctx,
+		bson.M{"tx_id": txID, "output_index": outputIndex},
+		bson.M{
+			"$set":   bson.M{"is_spent": false},
+			"$unset": bson.M{"spent_in_tx": ""},
+		},
+	)
+	return err
+}
+
+// DeleteUTXOsByTx removes every output created by a transaction
+func (s *MongoStore) DeleteUTXOsByTx(ctx context.Context, txID string) error {
+	_, err := s.db.Collection(UTXOsCollection).DeleteMany(ctx, bson.M{"tx_id": txID})
+	return err
+}
+
+// InsertWallet stores a wallet
+func (s *MongoStore) InsertWallet(ctx context.Context, wallet *models.Wallet) error {
+	return s.insert(ctx, WalletsCollection, wallet)
+}
+
+// GetWalletByWalletID returns a wallet by its wallet ID
+func (s *MongoStore) GetWalletByWalletID(ctx context.Context, walletID string) (*models.Wallet, error) {
+	var wallet models.Wallet
+	if err := s.findOne(ctx, WalletsCollection, bson.M{"wallet_id": walletID}, &wallet); err != nil {
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+// GetWalletByUserID returns the first wallet created for a user
+func (s *MongoStore) GetWalletByUserID(ctx context.Context, userID primitive.ObjectID) (*models.Wallet, error) {
+	var wallet models.Wallet
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	if err := s.findOne(ctx, WalletsCollection, bson.M{"user_id": userID}, &wallet, opts); err != nil {
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+// GetWalletsByUserID returns every wallet of a user, oldest first
+func (s *MongoStore) GetWalletsByUserID(ctx context.Context, userID primitive.ObjectID) ([]models.Wallet, error) {
+	var wallets []models.Wallet
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	if err := s.findAll(ctx, WalletsCollection, bson.M{"user_id": userID}, &wallets, opts); err != nil {
+		return nil, err
+	}
+	return wallets, nil
+}
+
+// GetAllWallets returns every wallet
+func (s *MongoStore) GetAllWallets(ctx context.Context) ([]models.Wallet, error) {
+	var wallets []models.Wallet
+	if err := s.findAll(ctx, WalletsCollection, bson.M{}, &wallets); err != nil {
+		return nil, err
+	}
+	return wallets, nil
+}
+
+// IncrementWalletBalance adds delta to a wallet's cached balance
+func (s *MongoStore) IncrementWalletBalance(ctx context.Context, walletID string, delta models.Amount) error {
+	_, err := s.db.Collection(WalletsCollection).UpdateOne(ctx,
+		bson.M{"wallet_id": walletID},
+		bson.M{"$inc": bson.M{"cached_balance": delta}},
+	)
+	return err
+}
+
+// AdvanceWalletNonce raises a wallet's last used nonce
+func (s *MongoStore) AdvanceWalletNonce(ctx context.Context, walletID string, nonce int64) error {
+	result, err := s.db.Collection(WalletsCollection).UpdateOne(ctx,
+		bson.M{
+			"wallet_id": walletID,
+			"$or": bson.A{
+				bson.M{"nonce": bson.M{"$lt": nonce}},
+				bson.M{"nonce": bson.M{"$exists": false}},
+			},
+		},
+		bson.M{"$set": bson.M{"nonce": nonce}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrStaleNonce
+	}
+	return nil
+}
+
+// SetWalletBalance overwrites a wallet's cached balance
+func (s *MongoStore) SetWalletBalance(ctx context.Context, walletID string, balance models.Amount) error {
+	_, err := s.db.Collection(WalletsCollection).UpdateOne(ctx,
+		bson.M{"wallet_id": walletID},
+		bson.M{"$set": bson.M{
+			"cached_balance": balance,
+			"updated_at":     time.Now(),
+		}},
+	)
+	return err
+}
+
+// InsertUser stores a user
+func (s *MongoStore) InsertUser(ctx context.Context, user *models.User) error {
+	return s.insert(ctx, UsersCollection, user)
+}
+
+// GetUserByID returns a user by ID
+func (s *MongoStore) GetUserByID(ctx context.Context, userID primitive.ObjectID) (*models.User, error) {
+	return s.getUser(ctx, bson.M{"_id": userID})
+}
+
+// GetUserByEmail returns a user by email
+func (s *MongoStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	return s.getUser(ctx, bson.M{"email": email})
+}
+
+// GetUserByWalletID returns the user owning a wallet
+func (s *MongoStore) GetUserByWalletID(ctx context.Context, walletID string) (*models.User, error) {
+	return s.getUser(ctx, bson.M{"wallet_id": walletID})
+}
+
+// GetAllUsers returns every user
+func (s *MongoStore) GetAllUsers(ctx context.Context) ([]models.User, error) {
+	var users []models.User
+	if err := s.findAll(ctx, UsersCollection, bson.M{}, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (s *MongoStore) getUser(ctx context.Context, filter bson.M) (*models.User, error) {
+	var user models.User
+	if err := s.findOne(ctx, UsersCollection, filter, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdateUser sets the given fields on a user
+func (s *MongoStore) UpdateUser(ctx context.Context, userID primitive.ObjectID, fields map[string]interface{}) error {
+	_, err := s.db.Collection(UsersCollection).UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$set": fields},
+	)
+	return err
+}
+
+// AddBeneficiary appends a beneficiary to a user
+func (s *MongoStore) AddBeneficiary(ctx context.Context, userID primitive.ObjectID, beneficiary models.Beneficiary) error {
+	_, err := s.db.Collection(UsersCollection).UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{
+			"$push": bson.M{"beneficiaries": beneficiary},
+			"$set":  bson.M{"updated_at": time.Now()},
+		},
+	)
+	return err
+}
+
+// RemoveBeneficiary removes a beneficiary from a user
+func (s *MongoStore) RemoveBeneficiary(ctx context.Context, userID, beneficiaryID primitive.ObjectID) error {
+	_, err := s.db.Collection(UsersCollection).UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{
+			"$pull": bson.M{"beneficiaries": bson.M{"_id": beneficiaryID}},
+			"$set":  bson.M{"updated_at": time.Now()},
+		},
+	)
+	return err
+}
+
+// AddZakatRecord appends a zakat record to the user owning a wallet
+func (s *MongoStore) AddZakatRecord(ctx context.Context, walletID string, record models.ZakatRecord) error {
+	_, err := s.db.Collection(UsersCollection).UpdateOne(ctx,
+		bson.M{"wallet_id": walletID},
+		bson.M{
+			"$push": bson.M{"zakat_tracking": record},
+			"$set":  bson.M{"updated_at": time.Now()},
+		},
+	)
+	return err
+}
+
+// InsertSystemLog stores a system log entry
+func (s *MongoStore) InsertSystemLog(ctx context.Context, log *models.SystemLog) error {
+	return s.insert(ctx, SystemLogsCollection, log)
+}
+
+// InsertTransactionLog stores a transaction log entry
+func (s *MongoStore) InsertTransactionLog(ctx context.Context, log *models.TransactionLog) error {
+	return s.insert(ctx, TransactionLogsCollection, log)
+}
+
+// GetSystemLogs returns the most recent system logs
+func (s *MongoStore) GetSystemLogs(ctx context.Context, limit int64) ([]models.SystemLog, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
+		SetLimit(limit)
+
+	var logs []models.SystemLog
+	if err := s.findAll(ctx, SystemLogsCollection, bson.M{}, &logs, opts); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// GetTransactionLogs returns the most recent transaction logs for a wallet
+func (s *MongoStore) GetTransactionLogs(ctx context.Context, walletID string, limit int64) ([]models.TransactionLog, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
+		SetLimit(limit)
+
+	var logs []models.TransactionLog
+	if err := s.findAll(ctx, TransactionLogsCollection, bson.M{"wallet_id": walletID}, &logs, opts); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
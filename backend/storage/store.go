@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrNotFound is returned when a lookup matches no document
+var ErrNotFound = errors.New("not found")
+
+// ErrStaleNonce is returned when a wallet nonce does not advance
+var ErrStaleNonce = errors.New("nonce already used")
+
+// BlockStore persists blocks of the chain
+type BlockStore interface {
+	CountBlocks(ctx context.Context) (int64, error)
+	InsertBlock(ctx context.Context, block *models.Block) error
+	// UpdateBlock sets the given fields, keyed by their bson names
+	UpdateBlock(ctx context.Context, hash string, fields map[string]interface{}) error
+	// DeleteBlock removes a block, such as an orphan dropped from the pool
+	DeleteBlock(ctx context.Context, hash string) error
+	// GetLatestBlock returns the tip of the main chain
+	GetLatestBlock(ctx context.Context) (*models.Block, error)
+	GetBlockByHash(ctx context.Context, hash string) (*models.Block, error)
+	// GetAllBlocks returns main-chain blocks sorted by index, newest first
+	GetAllBlocks(ctx context.Context) ([]models.Block, error)
+	// GetBlocksByStatus returns blocks with the given status, newest first
+	GetBlocksByStatus(ctx context.Context, status string) ([]models.Block, error)
+	GetBlocksByPreviousHash(ctx context.Context, previousHash string) ([]models.Block, error)
+	// GetBlocksWithPendingOp returns blocks whose connect or disconnect
+	// has not completed
+	GetBlocksWithPendingOp(ctx context.Context) ([]models.Block, error)
+}
+
+// TransactionStore persists pending and confirmed transactions
+type TransactionStore interface {
+	InsertTransaction(ctx context.Context, tx *models.Transaction) error
+	GetTransaction(ctx context.Context, txID string) (*models.Transaction, error)
+	GetTransactionsByStatus(ctx context.Context, status string) ([]models.Transaction, error)
+	// GetTransactionsByType returns transactions of the given type and status
+	GetTransactionsByType(ctx context.Context, txType, status string) ([]models.Transaction, error)
+	SetTransactionStatus(ctx context.Context, txIDs []string, status, blockHash string) error
+	// GetTransactionHistory returns confirmed transactions sent or received by a wallet, newest first
+	GetTransactionHistory(ctx context.Context, walletID string, limit int64) ([]models.Transaction, error)
+}
+
+// UTXOStore persists unspent and spent transaction outputs
+type UTXOStore interface {
+	InsertUTXO(ctx context.Context, utxo *models.UTXO) error
+	GetUTXO(ctx context.Context, txID string, outputIndex int) (*models.UTXO, error)
+	GetUnspentUTXOs(ctx context.Context, walletID string) ([]models.UTXO, error)
+	// GetAllUTXOs returns every stored output, spent or not
+	GetAllUTXOs(ctx context.Context) ([]models.UTXO, error)
+	MarkUTXOSpent(ctx context.Context, txID string, outputIndex int, spentInTx string) error
+	MarkUTXOUnspent(ctx context.Context, txID string, outputIndex int) error
+	// DeleteUTXOsByTx removes every output created by a transaction
+	DeleteUTXOsByTx(ctx context.Context, txID string) error
+}
+
+// WalletStore persists wallets and their cached balances
+type WalletStore interface {
+	InsertWallet(ctx context.Context, wallet *models.Wallet) error
+	GetWalletByWalletID(ctx context.Context, walletID string) (*models.Wallet, error)
+	// GetWalletByUserID returns the first wallet created for a user
+	GetWalletByUserID(ctx context.Context, userID primitive.ObjectID) (*models.Wallet, error)
+	// GetWalletsByUserID returns every wallet of a user, oldest first
+	GetWalletsByUserID(ctx context.Context, userID primitive.ObjectID) ([]models.Wallet, error)
+	GetAllWallets(ctx context.Context) ([]models.Wallet, error)
+	IncrementWalletBalance(ctx context.Context, walletID string, delta models.Amount) error
+	SetWalletBalance(ctx context.Context, walletID string, balance models.Amount) error
+	// AdvanceWalletNonce records nonce as the wallet's last used nonce,
+	// failing with ErrStaleNonce unless it is greater than the current one
+	AdvanceWalletNonce(ctx context.Context, walletID string, nonce int64) error
+}
+
+// UserStore persists user accounts
+type UserStore interface {
+	InsertUser(ctx context.Context, user *models.User) error
+	GetUserByID(ctx context.Context, userID primitive.ObjectID) (*models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	GetUserByWalletID(ctx context.Context, walletID string) (*models.User, error)
+	GetAllUsers(ctx context.Context) ([]models.User, error)
+	// UpdateUser sets the given fields, keyed by their bson names
+	UpdateUser(ctx context.Context, userID primitive.ObjectID, fields map[string]interface{}) error
+	AddBeneficiary(ctx context.Context, userID primitive.ObjectID, beneficiary models.Beneficiary) error
+	RemoveBeneficiary(ctx context.Context, userID, beneficiaryID primitive.ObjectID) error
+	AddZakatRecord(ctx context.Context, walletID string, record models.ZakatRecord) error
+}
+
+// LogStore persists system and transaction audit logs
+type LogStore interface {
+	InsertSystemLog(ctx context.Context, log *models.SystemLog) error
+	InsertTransactionLog(ctx context.Context, log *models.TransactionLog) error
+	GetSystemLogs(ctx context.Context, limit int64) ([]models.SystemLog, error)
+	GetTransactionLogs(ctx context.Context, walletID string, limit int64) ([]models.TransactionLog, error)
+}
+
+// ChainStore is the complete storage backend used by the services
+type ChainStore interface {
+	BlockStore
+	TransactionStore
+	UTXOStore
+	WalletStore
+	UserStore
+	LogStore
+	// RunAtomically runs fn so that the writes it makes through the
+	// context it is given are committed together, or not at all if fn
+	// fails. Calls nested in fn join the outer one. fn may be retried, so
+	// it must not have side effects outside the store. Backends that
+	// cannot commit atomically run fn directly; see Atomic.
+	RunAtomically(ctx context.Context, fn func(ctx context.Context) error) error
+	// Atomic reports whether RunAtomically is all-or-nothing
+	Atomic(ctx context.Context) bool
+	Close(ctx context.Context) error
+}
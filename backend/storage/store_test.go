@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"backend/models"
+)
+
+// backends returns a fresh store of each kind that runs without MongoDB.
+// The file store is reopened before reads, so its results come from
+// replaying the journal.
+func backends(t *testing.T) map[string]func(write func(ChainStore)) ChainStore {
+	return map[string]func(write func(ChainStore)) ChainStore{
+		"memory": func(write func(ChainStore)) ChainStore {
+			store := NewMemoryStore()
+			write(store)
+			return store
+		},
+		"file": func(write func(ChainStore)) ChainStore {
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), "chain.db")
+			store, err := OpenFileStore(ctx, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			write(store)
+			store.Close(ctx)
+
+			reopened, err := OpenFileStore(ctx, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { reopened.Close(ctx) })
+			return reopened
+		},
+	}
+}
+
+func TestBlockRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	genesis := models.Block{Index: 0, Hash: "genesis", Timestamp: time.Unix(1700000000, 0).UTC(), Status: models.BlockStatusMain}
+	tip := models.Block{
+		Index:        1,
+		Hash:         "tip",
+		PreviousHash: "genesis",
+		Timestamp:    time.Unix(1700000060, 0).UTC(),
+		Difficulty:   3,
+		MerkleRoot:   "root",
+		Transactions: []models.Transaction{{TxID: "coinbase", Amount: 50 * models.Coin}},
+		Status:       models.BlockStatusMain,
+	}
+	orphan := models.Block{Index: 7, Hash: "orphan", PreviousHash: "unknown", Status: models.BlockStatusOrphan}
+
+	for name, open := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			store := open(func(store ChainStore) {
+				for _, block := range []models.Block{genesis, tip, orphan} {
+					block := block
+					if err := store.InsertBlock(ctx, &block); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := store.UpdateBlock(ctx, "tip", map[string]interface{}{"chain_work": "0x10"}); err != nil {
+					t.Fatal(err)
+				}
+			})
+
+			got, err := store.GetBlockByHash(ctx, "tip")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Index != tip.Index || got.PreviousHash != tip.PreviousHash || got.MerkleRoot != tip.MerkleRoot || got.Difficulty != tip.Difficulty {
+				t.Fatalf("GetBlockByHash = %+v, want %+v", got, tip)
+			}
+			if !got.Timestamp.Equal(tip.Timestamp) {
+				t.Fatalf("timestamp = %v, want %v", got.Timestamp, tip.Timestamp)
+			}
+			if len(got.Transactions) != 1 || got.Transactions[0].TxID != "coinbase" || got.Transactions[0].Amount != 50*models.Coin {
+				t.Fatalf("transactions = %+v", got.Transactions)
+			}
+			if got.ChainWork != "0x10" {
+				t.Fatalf("chain work = %q, want the updated value", got.ChainWork)
+			}
+
+			latest, err := store.GetLatestBlock(ctx)
+			if err != nil || latest.Hash != "tip" {
+				t.Fatalf("GetLatestBlock = %v, %v; want the main-chain tip", latest, err)
+			}
+			all, err := store.GetAllBlocks(ctx)
+			if err != nil || len(all) != 2 || all[0].Hash != "tip" || all[1].Hash != "genesis" {
+				t.Fatalf("GetAllBlocks = %v, %v; want the main chain newest first", all, err)
+			}
+			children, err := store.GetBlocksByPreviousHash(ctx, "genesis")
+			if err != nil || len(children) != 1 || children[0].Hash != "tip" {
+				t.Fatalf("GetBlocksByPreviousHash = %v, %v", children, err)
+			}
+			if _, err := store.GetBlockByHash(ctx, "missing"); err != ErrNotFound {
+				t.Fatalf("missing block: err = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestTransactionRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	sent := models.Transaction{
+		TxID:             "sent",
+		SenderWalletID:   "alice",
+		ReceiverWalletID: "bob",
+		Amount:           3 * models.Coin,
+		Fee:              1000,
+		Timestamp:        time.Unix(1700000000, 0).UTC(),
+		InputUTXOs:       []models.UTXOInput{{TxID: "funding", OutputIndex: 0, Amount: 5 * models.Coin}},
+		OutputUTXOs: []models.UTXOOutput{
+			{WalletID: "bob", Amount: 3 * models.Coin, Index: 0},
+			{WalletID: "alice", Amount: 2*models.Coin - 1000, Index: 1},
+		},
+		Type:   "transfer",
+		Status: "pending",
+	}
+	other := models.Transaction{TxID: "other", SenderWalletID: "carol", ReceiverWalletID: "dave", Type: "transfer", Status: "pending"}
+
+	for name, open := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			store := open(func(store ChainStore) {
+				for _, tx := range []models.Transaction{sent, other} {
+					tx := tx
+					if err := store.InsertTransaction(ctx, &tx); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := store.SetTransactionStatus(ctx, []string{"sent"}, "confirmed", "tip"); err != nil {
+					t.Fatal(err)
+				}
+			})
+
+			got, err := store.GetTransaction(ctx, "sent")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.SenderWalletID != sent.SenderWalletID || got.ReceiverWalletID != sent.ReceiverWalletID || got.Amount != sent.Amount || got.Fee != sent.Fee {
+				t.Fatalf("GetTransaction = %+v, want %+v", got, sent)
+			}
+			if len(got.InputUTXOs) != 1 || got.InputUTXOs[0] != sent.InputUTXOs[0] {
+				t.Fatalf("inputs = %+v, want %+v", got.InputUTXOs, sent.InputUTXOs)
+			}
+			if len(got.OutputUTXOs) != 2 || got.OutputUTXOs[1] != sent.OutputUTXOs[1] {
+				t.Fatalf("outputs = %+v, want %+v", got.OutputUTXOs, sent.OutputUTXOs)
+			}
+			if got.Status != "confirmed" || got.BlockHash != "tip" {
+				t.Fatalf("status = %q in %q, want confirmed in tip", got.Status, got.BlockHash)
+			}
+
+			pending, err := store.GetTransactionsByStatus(ctx, "pending")
+			if err != nil || len(pending) != 1 || pending[0].TxID != "other" {
+				t.Fatalf("GetTransactionsByStatus = %v, %v", pending, err)
+			}
+			for _, wallet := range []string{"alice", "bob"} {
+				history, err := store.GetTransactionHistory(ctx, wallet, 10)
+				if err != nil || len(history) != 1 || history[0].TxID != "sent" {
+					t.Fatalf("history of %s = %v, %v", wallet, history, err)
+				}
+			}
+			if _, err := store.GetTransaction(ctx, "missing"); err != ErrNotFound {
+				t.Fatalf("missing transaction: err = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestRunAtomicallyRollsBackOnlyItsOwnWrites(t *testing.T) {
+	ctx := context.Background()
+	for name, open := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			store := open(func(ChainStore) {})
+			failed := errors.New("fn failed")
+
+			done := make(chan error, 1)
+			err := store.RunAtomically(ctx, func(ctx context.Context) error {
+				if err := store.InsertBlock(ctx, &models.Block{Hash: "rolled-back"}); err != nil {
+					return err
+				}
+				// Another caller writes while fn is running
+				go func() {
+					done <- store.InsertSystemLog(context.Background(), &models.SystemLog{Action: "concurrent"})
+				}()
+				time.Sleep(20 * time.Millisecond)
+				return failed
+			})
+			if err != failed {
+				t.Fatalf("RunAtomically = %v, want %v", err, failed)
+			}
+			if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := store.GetBlockByHash(ctx, "rolled-back"); err != ErrNotFound {
+				t.Fatalf("fn's write survived the rollback: err = %v", err)
+			}
+			logs, err := store.GetSystemLogs(ctx, 10)
+			if err != nil || len(logs) != 1 || logs[0].Action != "concurrent" {
+				t.Fatalf("concurrent write = %v, %v; want it kept", logs, err)
+			}
+		})
+	}
+}
+
+func TestFileStoreReopensAfterFailedWrite(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "chain.db")
+	store, err := OpenFileStore(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.InsertBlock(ctx, &models.Block{Hash: "tip", Index: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The index field cannot hold a string, so applying the update fails
+	bad := map[string]interface{}{"index": "one"}
+	if err := store.UpdateBlock(ctx, "tip", bad); err == nil {
+		t.Fatal("UpdateBlock with a mistyped field succeeded")
+	}
+	// A callback that ignores the failure must not commit it either
+	err = store.RunAtomically(ctx, func(ctx context.Context) error {
+		store.UpdateBlock(ctx, "tip", bad)
+		return store.UpdateBlock(ctx, "tip", map[string]interface{}{"chain_work": "0x10"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.InsertSystemLog(ctx, &models.SystemLog{Action: "after"}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close(ctx)
+
+	reopened, err := OpenFileStore(ctx, path)
+	if err != nil {
+		t.Fatalf("reopening after a failed write: %v", err)
+	}
+	defer reopened.Close(ctx)
+
+	block, err := reopened.GetBlockByHash(ctx, "tip")
+	if err != nil || block.Index != 1 || block.ChainWork != "0x10" {
+		t.Fatalf("block = %+v, %v; want the failed updates dropped and the rest kept", block, err)
+	}
+	if logs, err := reopened.GetSystemLogs(ctx, 10); err != nil || len(logs) != 1 {
+		t.Fatalf("logs = %v, %v; want the write after the failure kept", logs, err)
+	}
+}
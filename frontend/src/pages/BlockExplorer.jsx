@@ -4,11 +4,16 @@ import { useState, useEffect } from "react"
 import { Box, Typography, Accordion, AccordionSummary, AccordionDetails, Grid, Chip, Skeleton } from "@mui/material"
 import { ExpandMore as ExpandMoreIcon } from "@mui/icons-material"
 import Card from "../components/ui/Card"
-import { blockAPI } from "../services/api"
+import Input from "../components/ui/Input"
+import Button from "../components/ui/Button"
+import { blockAPI, addressAPI } from "../services/api"
 
 export default function BlockExplorer() {
   const [blocks, setBlocks] = useState([])
   const [loading, setLoading] = useState(true)
+  const [address, setAddress] = useState("")
+  const [lookup, setLookup] = useState(null)
+  const [lookupError, setLookupError] = useState("")
 
   useEffect(() => {
     const fetchBlocks = async () => {
@@ -25,6 +30,18 @@ export default function BlockExplorer() {
 
   const formatDate = (date) => new Date(date).toLocaleString()
 
+  const handleLookup = async (e) => {
+    e.preventDefault()
+    setLookup(null)
+    setLookupError("")
+    try {
+      const { data } = await addressAPI.lookup(address.trim())
+      setLookup(data)
+    } catch (err) {
+      setLookupError(err.response?.data?.error || "Address lookup failed")
+    }
+  }
+
   return (
     <Box>
       <Typography variant="h4" gutterBottom fontWeight={700}>
@@ -69,6 +86,36 @@ export default function BlockExplorer() {
         </Grid>
       </Card>
 
+      <Card title="Address Lookup" sx={{ mb: 3 }}>
+        <form onSubmit={handleLookup}>
+          <Box display="flex" gap={2}>
+            <Input
+              label="Address or Wallet ID"
+              value={address}
+              onChange={(e) => setAddress(e.target.value)}
+              error={lookupError}
+              size="small"
+            />
+            <Button type="submit" variant="contained" disabled={!address.trim()}>
+              Look up
+            </Button>
+          </Box>
+        </form>
+        {lookup && (
+          <Box mt={2}>
+            <Typography variant="body2" sx={{ wordBreak: "break-all", fontFamily: "monospace" }}>
+              {lookup.address || lookup.walletId}
+            </Typography>
+            <Typography variant="body2" color="text.secondary" sx={{ wordBreak: "break-all" }}>
+              Wallet ID: {lookup.walletId}
+            </Typography>
+            <Typography variant="body1" fontWeight={600}>
+              Balance: {lookup.balance}
+            </Typography>
+          </Box>
+        )}
+      </Card>
+
       {loading ? (
         [1, 2, 3].map((i) => <Skeleton key={i} height={100} sx={{ mb: 2 }} />)
       ) : blocks.length === 0 ? (
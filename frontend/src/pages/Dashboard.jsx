@@ -95,10 +95,10 @@ export default function Dashboard() {
           <Card title="Wallet Info">
             <Box mb={2}>
               <Typography variant="body2" color="text.secondary">
-                Wallet ID
+                Address
               </Typography>
               <Typography variant="body1" sx={{ wordBreak: "break-all" }}>
-                {user?.walletId || "-"}
+                {user?.address || user?.walletId || "-"}
               </Typography>
             </Box>
             <Box>
@@ -130,14 +130,16 @@ export default function Dashboard() {
                   borderColor="divider"
                 >
                   <Box>
-                    <Typography variant="body2">{tx.direction === "sent" ? "Sent" : "Received"}</Typography>
+                    <Typography variant="body2">
+                      {tx.direction === "sent" ? "Sent" : tx.direction === "internal" ? "Between your addresses" : "Received"}
+                    </Typography>
                     <Typography variant="caption" color="text.secondary">
                       {new Date(tx.timestamp).toLocaleDateString()}
                     </Typography>
                   </Box>
                   <Chip
-                    label={`${tx.direction === "sent" ? "-" : "+"}${tx.amount.toFixed(4)}`}
-                    color={tx.direction === "sent" ? "error" : "success"}
+                    label={`${tx.direction === "sent" ? "-" : tx.direction === "internal" ? "" : "+"}${tx.amount.toFixed(4)}`}
+                    color={tx.direction === "sent" ? "error" : tx.direction === "internal" ? "default" : "success"}
                     size="small"
                   />
                 </Box>
@@ -1,6 +1,6 @@
 "use client"
 
-import { useState, useEffect } from "react"
+import { useState, useEffect, useRef } from "react"
 import { Box, Typography, Grid, Alert, CircularProgress, LinearProgress } from "@mui/material"
 import Card from "../components/ui/Card"
 import Button from "../components/ui/Button"
@@ -12,6 +12,8 @@ export default function Mining() {
   const [mining, setMining] = useState(false)
   const [result, setResult] = useState(null)
   const [loading, setLoading] = useState(true)
+  const [job, setJob] = useState(null)
+  const pollRef = useRef(null)
 
   const fetchStatus = async () => {
     try {
@@ -27,20 +29,62 @@ export default function Mining() {
   useEffect(() => {
     fetchStatus()
     const interval = setInterval(fetchStatus, 10000)
-    return () => clearInterval(interval)
+    return () => {
+      clearInterval(interval)
+      clearInterval(pollRef.current)
+    }
   }, [])
 
+  const finishJob = (finished) => {
+    clearInterval(pollRef.current)
+    setMining(false)
+    if (finished.status === "completed") {
+      setResult({ success: true, data: { block: { index: finished.blockIndex, hash: finished.blockHash } } })
+    } else if (finished.status === "cancelled") {
+      setResult({ success: false, error: "Mining cancelled" })
+    } else {
+      setResult({ success: false, error: finished.error || "Mining failed" })
+    }
+    fetchStatus()
+  }
+
+  const pollJob = (id) => {
+    clearInterval(pollRef.current)
+    pollRef.current = setInterval(async () => {
+      try {
+        const { data } = await miningAPI.getJob(id)
+        setJob(data)
+        if (data.status !== "running") {
+          finishJob(data)
+        }
+      } catch (error) {
+        clearInterval(pollRef.current)
+        setMining(false)
+        setResult({ success: false, error: error.response?.data?.error || "Mining failed" })
+      }
+    }, 1000)
+  }
+
   const handleMine = async () => {
     setMining(true)
     setResult(null)
     try {
       const { data } = await miningAPI.mine()
-      setResult({ success: true, data })
-      fetchStatus()
+      setJob(data.job)
+      pollJob(data.jobId)
     } catch (error) {
       setResult({ success: false, error: error.response?.data?.error || "Mining failed" })
+      setMining(false)
+    }
+  }
+
+  const handleCancel = async () => {
+    if (!job) return
+    try {
+      await miningAPI.cancelJob(job.id)
+    } catch (error) {
+      console.error("Failed to cancel mining job:", error)
     }
-    setMining(false)
   }
 
   return (
@@ -115,6 +159,15 @@ export default function Mining() {
                   Mining in progress... (Proof of Work)
                 </Typography>
                 <LinearProgress />
+                {job && (
+                  <Typography variant="caption" color="text.secondary" display="block" mt={1}>
+                    Block #{job.blockIndex} | Nonce {job.nonce} | {Math.round(job.hashrate || 0).toLocaleString()} H/s | ETA{" "}
+                    {Math.round(job.etaSeconds || 0)}s
+                  </Typography>
+                )}
+                <Button variant="outlined" color="error" onClick={handleCancel} sx={{ mt: 1 }}>
+                  Cancel
+                </Button>
               </Box>
             )}
 
@@ -2,13 +2,14 @@
 
 import { useState } from "react"
 import { Link, useNavigate } from "react-router-dom"
-import { Box, Typography, Alert, Paper } from "@mui/material"
+import { Box, Typography, Alert, Paper, FormControlLabel, Checkbox, MenuItem } from "@mui/material"
 import Button from "../components/ui/Button"
 import Input from "../components/ui/Input"
 import { authAPI } from "../services/api"
 
 export default function Register() {
-  const [formData, setFormData] = useState({ email: "", fullName: "", cnic: "" })
+  const [formData, setFormData] = useState({ email: "", fullName: "", cnic: "", mnemonic: true, passphrase: "", keyType: "p256" })
+  const [mnemonic, setMnemonic] = useState("")
   const [loading, setLoading] = useState(false)
   const [error, setError] = useState("")
   const [success, setSuccess] = useState(false)
@@ -23,9 +24,14 @@ export default function Register() {
     setLoading(true)
     setError("")
     try {
-      await authAPI.register(formData)
+      const res = await authAPI.register(formData)
       setSuccess(true)
-      setTimeout(() => navigate("/login"), 2000)
+      if (res.data.mnemonic) {
+        // Shown once; the user continues after writing it down
+        setMnemonic(res.data.mnemonic)
+      } else {
+        setTimeout(() => navigate("/login"), 2000)
+      }
     } catch (err) {
       setError(err.response?.data?.error || "Registration failed")
     }
@@ -47,11 +53,24 @@ export default function Register() {
             {error}
           </Alert>
         )}
-        {success && (
+        {success && !mnemonic && (
           <Alert severity="success" sx={{ mb: 2 }}>
             Registration successful! Redirecting to login...
           </Alert>
         )}
+        {mnemonic && (
+          <Alert severity="warning" sx={{ mb: 2 }}>
+            <Typography variant="body2" fontWeight={600} mb={1}>
+              Write down your recovery phrase. It will not be shown again.
+            </Typography>
+            <Typography variant="body2" sx={{ fontFamily: "monospace", mb: 2 }}>
+              {mnemonic}
+            </Typography>
+            <Button variant="contained" onClick={() => navigate("/login")}>
+              I have saved it, continue to login
+            </Button>
+          </Alert>
+        )}
 
         <form onSubmit={handleSubmit}>
           <Input
@@ -79,6 +98,39 @@ export default function Register() {
             required
             sx={{ mb: 2 }}
           />
+          <Input
+            select
+            label="Signature Scheme"
+            name="keyType"
+            value={formData.keyType}
+            onChange={handleChange}
+            helperText="The key type is part of your address and cannot be changed later"
+            sx={{ mb: 2 }}
+          >
+            <MenuItem value="p256">P-256 (default)</MenuItem>
+            <MenuItem value="secp256k1">secp256k1</MenuItem>
+            <MenuItem value="ed25519">Ed25519</MenuItem>
+          </Input>
+          <FormControlLabel
+            control={
+              <Checkbox
+                checked={formData.mnemonic}
+                onChange={(e) => setFormData({ ...formData, mnemonic: e.target.checked })}
+              />
+            }
+            label="Back up my wallet with a recovery phrase"
+            sx={{ mb: 1 }}
+          />
+          {formData.mnemonic && (
+            <Input
+              label="Passphrase (optional)"
+              name="passphrase"
+              type="password"
+              value={formData.passphrase}
+              onChange={handleChange}
+              sx={{ mb: 2 }}
+            />
+          )}
           <Button type="submit" variant="contained" fullWidth loading={loading}>
             Register
           </Button>
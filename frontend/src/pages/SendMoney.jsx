@@ -1,20 +1,27 @@
 "use client"
 
-import { useState, useContext } from "react"
+import { useState, useContext, useEffect } from "react"
 import { Box, Typography, Alert, Grid } from "@mui/material"
 import Card from "../components/ui/Card"
 import Button from "../components/ui/Button"
 import Input from "../components/ui/Input"
-import { transactionAPI } from "../services/api"
+import { transactionAPI, addressAPI, authAPI } from "../services/api"
 import { AuthContext } from "../context/AuthContext"
-import { sha256 } from "../services/crypto"
 
 export default function SendMoney() {
   const { user } = useContext(AuthContext)
-  const [formData, setFormData] = useState({ receiverWalletId: "", amount: "", note: "" })
+  const [formData, setFormData] = useState({ receiverWalletId: "", amount: "", note: "", passphrase: "" })
   const [loading, setLoading] = useState(false)
   const [error, setError] = useState("")
   const [success, setSuccess] = useState("")
+  const [protectedKeys, setProtectedKeys] = useState(true)
+
+  useEffect(() => {
+    authAPI
+      .getProfile()
+      .then(({ data }) => setProtectedKeys(data.passphraseProtected))
+      .catch(() => {})
+  }, [])
 
   const handleChange = (e) => {
     setFormData({ ...formData, [e.target.name]: e.target.value })
@@ -27,24 +34,18 @@ export default function SendMoney() {
     setSuccess("")
 
     try {
-      const timestamp = new Date().toISOString()
-      const amount = Number.parseFloat(formData.amount)
-
-      // Create payload for signing
-      const payload = `${user.walletId}${formData.receiverWalletId}${amount.toFixed(8)}${timestamp}${formData.note}`
-
-      // Generate signature (simplified - in production use proper key from secure storage)
-      const signature = await sha256(payload)
+      // Check the address, then let the server sign with the key the passphrase unlocks
+      const { data: receiver } = await addressAPI.lookup(formData.receiverWalletId.trim())
 
       await transactionAPI.send({
-        receiverWalletId: formData.receiverWalletId,
-        amount: amount,
+        receiverWalletId: receiver.address,
+        amount: Number.parseFloat(formData.amount),
         note: formData.note,
-        signature: signature,
+        passphrase: formData.passphrase,
       })
 
       setSuccess("Transaction submitted successfully! It will be confirmed after mining.")
-      setFormData({ receiverWalletId: "", amount: "", note: "" })
+      setFormData({ receiverWalletId: "", amount: "", note: "", passphrase: "" })
     } catch (err) {
       setError(err.response?.data?.error || "Transaction failed")
     }
@@ -76,13 +77,13 @@ export default function SendMoney() {
 
             <form onSubmit={handleSubmit}>
               <Input
-                label="Receiver Wallet ID"
+                label="Receiver Address"
                 name="receiverWalletId"
                 value={formData.receiverWalletId}
                 onChange={handleChange}
                 required
                 sx={{ mb: 2 }}
-                placeholder="Enter receiver's wallet ID"
+                placeholder="Enter receiver's address"
               />
               <Input
                 label="Amount"
@@ -103,6 +104,20 @@ export default function SendMoney() {
                 rows={3}
                 sx={{ mb: 2 }}
               />
+              <Input
+                label="Signing Passphrase"
+                name="passphrase"
+                type="password"
+                value={formData.passphrase}
+                onChange={handleChange}
+                required
+                sx={{ mb: 2 }}
+              />
+              {!protectedKeys && (
+                <Alert severity="warning" sx={{ mb: 2 }}>
+                  Set a signing passphrase on the Wallet page before sending.
+                </Alert>
+              )}
               <Button type="submit" variant="contained" fullWidth loading={loading} size="large">
                 Send Transaction
               </Button>
@@ -114,15 +129,15 @@ export default function SendMoney() {
           <Card title="Your Wallet">
             <Box mb={2}>
               <Typography variant="body2" color="text.secondary">
-                Your Wallet ID
+                Your Address
               </Typography>
               <Typography variant="body1" sx={{ wordBreak: "break-all", fontFamily: "monospace" }}>
-                {user?.walletId}
+                {user?.address || user?.walletId}
               </Typography>
             </Box>
             <Alert severity="info">
-              Transactions are added to the pending pool and confirmed after mining. Each transaction is digitally
-              signed and verified using your public key.
+              Transactions are added to the pending pool and confirmed after mining. Your key is unlocked with your
+              signing passphrase only to sign each transaction, which is verified using your public key.
             </Alert>
           </Card>
         </Grid>
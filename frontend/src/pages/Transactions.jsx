@@ -83,13 +83,17 @@ export default function Transactions() {
                 transactions.map((tx, index) => (
                   <TableRow key={index}>
                     <TableCell>
-                      <Chip label={tx.direction} color={tx.direction === "sent" ? "error" : "success"} size="small" />
+                      <Chip
+                        label={tx.direction}
+                        color={tx.direction === "sent" ? "error" : tx.direction === "internal" ? "default" : "success"}
+                        size="small"
+                      />
                     </TableCell>
                     <TableCell sx={{ fontFamily: "monospace", fontSize: "0.8rem" }}>
-                      {tx.direction === "sent" ? truncate(tx.receiverWalletId) : truncate(tx.senderWalletId)}
+                      {tx.direction === "received" ? truncate(tx.senderWalletId) : truncate(tx.receiverWalletId)}
                     </TableCell>
                     <TableCell align="right" sx={{ fontWeight: 600 }}>
-                      {tx.direction === "sent" ? "-" : "+"}
+                      {tx.direction === "sent" ? "-" : tx.direction === "internal" ? "" : "+"}
                       {tx.amount.toFixed(4)}
                     </TableCell>
                     <TableCell>
@@ -1,8 +1,9 @@
 "use client"
 
 import { useState, useEffect, useContext } from "react"
-import { Box, Typography, Grid, Divider, Chip, Skeleton } from "@mui/material"
+import { Box, Typography, Grid, Divider, Chip, Skeleton, Button, Stack } from "@mui/material"
 import Card from "../components/ui/Card"
+import Input from "../components/ui/Input"
 import { walletAPI, authAPI } from "../services/api"
 import { AuthContext } from "../context/AuthContext"
 
@@ -10,19 +11,27 @@ export default function Wallet() {
   const { user } = useContext(AuthContext)
   const [wallet, setWallet] = useState(null)
   const [utxos, setUtxos] = useState([])
+  const [addresses, setAddresses] = useState([])
+  const [addressMessage, setAddressMessage] = useState("")
+  const [restoreForm, setRestoreForm] = useState({ mnemonic: "", passphrase: "" })
+  const [restoreMessage, setRestoreMessage] = useState("")
+  const [passphraseForm, setPassphraseForm] = useState({ currentPassphrase: "", passphrase: "" })
+  const [passphraseMessage, setPassphraseMessage] = useState("")
   const [profile, setProfile] = useState(null)
   const [loading, setLoading] = useState(true)
 
   useEffect(() => {
     const fetchData = async () => {
       try {
-        const [walletRes, utxoRes, profileRes] = await Promise.all([
+        const [walletRes, utxoRes, profileRes, addressRes] = await Promise.all([
           walletAPI.getWallet(),
           walletAPI.getUTXOs(),
           authAPI.getProfile(),
+          walletAPI.getAddresses(),
         ])
         setWallet(walletRes.data)
         setUtxos(utxoRes.data.utxos || [])
+        setAddresses(addressRes.data.addresses || [])
         setProfile(profileRes.data)
       } catch (error) {
         console.error("Failed to fetch wallet data:", error)
@@ -32,6 +41,54 @@ export default function Wallet() {
     fetchData()
   }, [])
 
+  const refreshAddresses = async () => {
+    const res = await walletAPI.getAddresses()
+    setAddresses(res.data.addresses || [])
+  }
+
+  const handleNewAddress = async () => {
+    try {
+      const res = await walletAPI.newAddress()
+      setAddressMessage(`New address ${res.data.address}`)
+      await refreshAddresses()
+    } catch (error) {
+      setAddressMessage(error.response?.data?.error || "Failed to derive address")
+    }
+  }
+
+  const handleScan = async () => {
+    try {
+      const res = await walletAPI.scanAddresses()
+      setAddressMessage(`Scanned ${res.data.scanned} addresses, found ${res.data.found.length} in use`)
+      await refreshAddresses()
+    } catch (error) {
+      setAddressMessage(error.response?.data?.error || "Failed to scan addresses")
+    }
+  }
+
+  const handleRestore = async () => {
+    try {
+      const res = await walletAPI.restore(restoreForm)
+      setRestoreMessage(`Restored ${res.data.addresses.length} addresses holding ${res.data.balance.toFixed(4)} COIN`)
+      setRestoreForm({ mnemonic: "", passphrase: "" })
+      setProfile({ ...profile, passphraseProtected: false })
+      await refreshAddresses()
+    } catch (error) {
+      setRestoreMessage(error.response?.data?.error || "Failed to restore wallet")
+    }
+  }
+
+  const handleSetPassphrase = async () => {
+    try {
+      await walletAPI.setPassphrase(passphraseForm)
+      setPassphraseMessage("Signing passphrase saved")
+      setPassphraseForm({ currentPassphrase: "", passphrase: "" })
+      setProfile({ ...profile, passphraseProtected: true })
+    } catch (error) {
+      setPassphraseMessage(error.response?.data?.error || "Failed to set signing passphrase")
+    }
+  }
+
   return (
     <Box>
       <Typography variant="h4" gutterBottom fontWeight={700}>
@@ -90,6 +147,17 @@ export default function Wallet() {
               [1, 2, 3].map((i) => <Skeleton key={i} height={40} sx={{ mb: 1 }} />)
             ) : (
               <>
+                <Box mb={2}>
+                  <Typography variant="body2" color="text.secondary">
+                    Address
+                  </Typography>
+                  <Typography
+                    variant="body1"
+                    sx={{ wordBreak: "break-all", fontFamily: "monospace", fontSize: "0.85rem" }}
+                  >
+                    {wallet?.address}
+                  </Typography>
+                </Box>
                 <Box mb={2}>
                   <Typography variant="body2" color="text.secondary">
                     Wallet ID
@@ -104,7 +172,7 @@ export default function Wallet() {
                 <Divider sx={{ my: 2 }} />
                 <Box mb={2}>
                   <Typography variant="body2" color="text.secondary">
-                    Public Key
+                    Public Key{profile?.keyType && ` (${profile.keyType})`}
                   </Typography>
                   <Typography
                     variant="body2"
@@ -116,10 +184,10 @@ export default function Wallet() {
                 <Divider sx={{ my: 2 }} />
                 <Box>
                   <Typography variant="body2" color="text.secondary">
-                    Cached Balance
+                    Balance Across All Addresses
                   </Typography>
                   <Typography variant="h5" fontWeight={600} color="primary">
-                    {wallet?.cachedBalance?.toFixed(4) || "0.0000"} COIN
+                    {addresses.reduce((sum, addr) => sum + (addr.balance || 0), 0).toFixed(4)} COIN
                   </Typography>
                 </Box>
               </>
@@ -127,6 +195,105 @@ export default function Wallet() {
           </Card>
         </Grid>
 
+        <Grid item xs={12}>
+          <Card title="Receive Addresses">
+            <Stack direction="row" spacing={2} mb={2}>
+              <Button variant="contained" onClick={handleNewAddress}>
+                New Address
+              </Button>
+              <Button variant="outlined" onClick={handleScan}>
+                Scan for Funds
+              </Button>
+            </Stack>
+            {addressMessage && (
+              <Typography variant="body2" color="text.secondary" mb={2}>
+                {addressMessage}
+              </Typography>
+            )}
+            {addresses.map((addr) => (
+              <Box key={addr.walletId} p={2} mb={1} bgcolor="background.default" borderRadius={2}>
+                <Grid container spacing={2}>
+                  <Grid item xs={12} sm={9}>
+                    <Typography variant="body2" sx={{ wordBreak: "break-all", fontFamily: "monospace", fontSize: "0.8rem" }}>
+                      {addr.address}
+                    </Typography>
+                    <Typography variant="caption" color="text.secondary">
+                      {addr.derivationPath || "Original key"}
+                    </Typography>
+                  </Grid>
+                  <Grid item xs={12} sm={3}>
+                    <Typography variant="body1" fontWeight={600} color="secondary">
+                      {addr.balance?.toFixed(4)} COIN
+                    </Typography>
+                  </Grid>
+                </Grid>
+              </Box>
+            ))}
+          </Card>
+        </Grid>
+
+        <Grid item xs={12}>
+          <Card title="Signing Passphrase">
+            <Typography variant="body2" color="text.secondary" mb={2}>
+              {profile?.passphraseProtected
+                ? "Your keys are locked with a signing passphrase, which is needed to send funds."
+                : "Set a passphrase to lock your keys. The server can only sign your transactions with it."}
+            </Typography>
+            {profile?.passphraseProtected && (
+              <Input
+                label="Current passphrase"
+                type="password"
+                value={passphraseForm.currentPassphrase}
+                onChange={(e) => setPassphraseForm({ ...passphraseForm, currentPassphrase: e.target.value })}
+                sx={{ mb: 2 }}
+              />
+            )}
+            <Input
+              label="New passphrase"
+              type="password"
+              value={passphraseForm.passphrase}
+              onChange={(e) => setPassphraseForm({ ...passphraseForm, passphrase: e.target.value })}
+              sx={{ mb: 2 }}
+            />
+            <Button variant="contained" onClick={handleSetPassphrase} disabled={passphraseForm.passphrase.length < 8}>
+              {profile?.passphraseProtected ? "Change Passphrase" : "Set Passphrase"}
+            </Button>
+            {passphraseMessage && (
+              <Typography variant="body2" color="text.secondary" mt={2}>
+                {passphraseMessage}
+              </Typography>
+            )}
+          </Card>
+        </Grid>
+
+        <Grid item xs={12}>
+          <Card title="Restore from Recovery Phrase">
+            <Input
+              label="Recovery phrase"
+              value={restoreForm.mnemonic}
+              onChange={(e) => setRestoreForm({ ...restoreForm, mnemonic: e.target.value })}
+              multiline
+              minRows={2}
+              sx={{ mb: 2 }}
+            />
+            <Input
+              label="Passphrase (if you set one)"
+              type="password"
+              value={restoreForm.passphrase}
+              onChange={(e) => setRestoreForm({ ...restoreForm, passphrase: e.target.value })}
+              sx={{ mb: 2 }}
+            />
+            <Button variant="contained" onClick={handleRestore} disabled={!restoreForm.mnemonic}>
+              Restore and Rescan
+            </Button>
+            {restoreMessage && (
+              <Typography variant="body2" color="text.secondary" mt={2}>
+                {restoreMessage}
+              </Typography>
+            )}
+          </Card>
+        </Grid>
+
         <Grid item xs={12}>
           <Card title="Unspent Transaction Outputs (UTXOs)">
             {loading ? (
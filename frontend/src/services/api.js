@@ -32,6 +32,11 @@ export const walletAPI = {
   getWallet: () => api.get("/wallet"),
   getBalance: () => api.get("/wallet/balance"),
   getUTXOs: () => api.get("/wallet/utxos"),
+  getAddresses: () => api.get("/wallet/addresses"),
+  newAddress: () => api.post("/wallet/addresses"),
+  scanAddresses: () => api.post("/wallet/addresses/scan"),
+  restore: (data) => api.post("/wallet/restore", data),
+  setPassphrase: (data) => api.post("/wallet/passphrase", data),
   addBeneficiary: (data) => api.post("/wallet/beneficiaries", data),
   removeBeneficiary: (id) => api.delete(`/wallet/beneficiaries/${id}`),
   getBeneficiaries: () => api.get("/wallet/beneficiaries"),
@@ -40,14 +45,19 @@ export const walletAPI = {
 // Transaction API
 export const transactionAPI = {
   send: (data) => api.post("/transactions/send", data),
+  build: (data) => api.post("/transactions/build", data),
+  submit: (data) => api.post("/transactions/submit", data),
   getHistory: () => api.get("/transactions/history"),
   getPending: () => api.get("/transactions/pending"),
+  getSigningInfo: (from) => api.get("/transactions/signing-info", { params: from ? { from } : {} }),
 }
 
 // Mining API
 export const miningAPI = {
   mine: () => api.post("/mining/mine"),
   getStatus: () => api.get("/mining/status"),
+  getJob: (id) => api.get(`/mining/jobs/${id}`),
+  cancelJob: (id) => api.delete(`/mining/jobs/${id}`),
 }
 
 // Block Explorer API
@@ -57,6 +67,11 @@ export const blockAPI = {
   getByHash: (hash) => api.get(`/blocks/${hash}`),
 }
 
+// Address API
+export const addressAPI = {
+  lookup: (address) => api.get(`/address/${encodeURIComponent(address)}`),
+}
+
 // Zakat API
 export const zakatAPI = {
   getHistory: () => api.get("/zakat/history"),
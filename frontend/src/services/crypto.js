@@ -1,7 +1,8 @@
 import { ec as EC } from "elliptic"
 
-// Choose the curve matching your backend. Common options:
-// "p256" (prime256v1) or "secp256k1" (Ethereum/Bitcoin style)
+// Wallets sign with P-256, secp256k1 or Ed25519, chosen at registration.
+// This signs for P-256 wallets; secp256k1 public keys carry a 0xe1 tag and
+// need low-S signatures, and Ed25519 needs a different library.
 const ec = new EC("p256")
 
 // Async SHA-256 hash function
@@ -34,7 +35,9 @@ export const signTransaction = async (privateKeyHex, payload) => {
   }
 }
 
-// Create transaction payload for signing
-export const createSignPayload = (senderID, receiverID, amount, timestamp, note = "") => {
-  return `${senderID}${receiverID}${amount.toFixed(8)}${timestamp}${note}`
+// Create transaction payload for signing. timestamp is in unix milliseconds;
+// chainId, version and nonce come from /transactions/signing-info, asked
+// with ?from= when sending from an address other than the primary one.
+export const createSignPayload = ({ chainId, version, senderID, receiverID, amount, fee = 0, nonce, timestamp, note = "" }) => {
+  return [chainId, version, senderID, receiverID, amount.toFixed(8), fee.toFixed(8), nonce, timestamp, note].join("|")
 }